@@ -0,0 +1,53 @@
+// Package addressbook reads the addresses to check from an address book, and labels verified contacts in it.
+package addressbook
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const requestTimeout = time.Second * 30
+
+// Contact is an entry of an address book with its email addresses.
+type Contact struct {
+	// ID identifies the contact in its address book.
+	ID     string
+	Name   string
+	Emails []string
+
+	// etag and card are the version and vCard of a CardDAV contact, needed to update it
+	etag string
+	card string
+}
+
+// Book is an address book.
+type Book interface {
+	// Contacts returns all contacts that have at least one email address.
+	Contacts(ctx context.Context) ([]Contact, error)
+	// Label tags contacts with label, e.g. a group or category named "verified".
+	Label(ctx context.Context, contacts []Contact, label string) error
+}
+
+// do sends req and returns the response body, failing on anything but a status in the 2xx range.
+func do(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read response")
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.Errorf("%s %s returned %s", req.Method, req.URL.Path, resp.Status)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,190 @@
+package addressbook
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// addressbookQuery asks for the vCard and version of every contact, RFC 6352 section 8.6.
+const addressbookQuery = `<?xml version="1.0" encoding="utf-8"?>
+<C:addressbook-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:carddav">
+  <D:prop><D:getetag/><C:address-data/></D:prop>
+</C:addressbook-query>`
+
+// CardDAV is an address book on a CardDAV server. Labels are added to the CATEGORIES of a vCard.
+type CardDAV struct {
+	url      *url.URL
+	username string
+	password string
+	client   *http.Client
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Status      string `xml:"DAV: status"`
+			ETag        string `xml:"DAV: prop>getetag"`
+			AddressData string `xml:"urn:ietf:params:xml:ns:carddav prop>address-data"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// NewCardDAV returns the address book collection at addressBookURL, authenticating with basic auth
+// when username is set.
+func NewCardDAV(addressBookURL, username, password string) (*CardDAV, error) {
+	u, err := url.Parse(addressBookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.Errorf("invalid carddav url '%s'", addressBookURL)
+	}
+
+	return &CardDAV{
+		url:      u,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+func (b *CardDAV) request(ctx context.Context, method, target, body string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, strings.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create carddav request")
+	}
+
+	if b.username != "" {
+		req.SetBasicAuth(b.username, b.password)
+	}
+
+	return req, nil
+}
+
+// Contacts returns the contacts of the address book that have an email address.
+func (b *CardDAV) Contacts(ctx context.Context) ([]Contact, error) {
+	req, err := b.request(ctx, "REPORT", b.url.String(), addressbookQuery)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	body, err := do(b.client, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list carddav contacts")
+	}
+
+	var status multistatus
+	if err := xml.NewDecoder(bytes.NewReader(body)).Decode(&status); err != nil {
+		return nil, errors.Wrap(err, "could not parse carddav response")
+	}
+
+	var contacts []Contact
+	for _, response := range status.Responses {
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") || propstat.AddressData == "" {
+				continue
+			}
+
+			href, err := b.url.Parse(response.Href)
+			if err != nil {
+				continue
+			}
+
+			contact := Contact{ID: href.String(), etag: propstat.ETag, card: propstat.AddressData}
+			for _, line := range unfoldVCard(propstat.AddressData) {
+				switch name, value := vCardProperty(line); name {
+				case "FN":
+					contact.Name = value
+				case "EMAIL":
+					contact.Emails = append(contact.Emails, value)
+				}
+			}
+
+			if len(contact.Emails) > 0 {
+				contacts = append(contacts, contact)
+			}
+		}
+	}
+
+	return contacts, nil
+}
+
+// Label adds label to the categories of every contact. A contact changed since it was read is not overwritten.
+func (b *CardDAV) Label(ctx context.Context, contacts []Contact, label string) error {
+	for _, contact := range contacts {
+		req, err := b.request(ctx, http.MethodPut, contact.ID, addCategory(contact.card, label))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/vcard; charset=utf-8")
+		if contact.etag != "" {
+			req.Header.Set("If-Match", contact.etag)
+		}
+
+		if _, err := do(b.client, req); err != nil {
+			return errors.Wrapf(err, "could not label %s", contact.Name)
+		}
+	}
+
+	return nil
+}
+
+// unfoldVCard returns the logical lines of a vCard, joining continuation lines as in RFC 6350 section 3.2.
+func unfoldVCard(card string) (lines []string) {
+	for _, line := range strings.Split(strings.ReplaceAll(card, "\r\n", "\n"), "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// vCardProperty splits a content line into its uppercased name, without group or parameters, and its value.
+func vCardProperty(line string) (name, value string) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return "", ""
+	}
+
+	name = strings.SplitN(line[:colon], ";", 2)[0]
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+
+	return strings.ToUpper(name), strings.TrimSpace(line[colon+1:])
+}
+
+// addCategory returns card with category added to its CATEGORIES property, which is created when missing.
+func addCategory(card, category string) string {
+	lines := unfoldVCard(strings.TrimRight(card, "\r\n"))
+
+	for i, line := range lines {
+		name, value := vCardProperty(line)
+		if name != "CATEGORIES" {
+			continue
+		}
+
+		for _, existing := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), category) {
+				return card
+			}
+		}
+
+		lines[i] = line + "," + category
+		return strings.Join(lines, "\r\n") + "\r\n"
+	}
+
+	// END:VCARD is the last line
+	end := lines[len(lines)-1]
+	lines = append(lines[:len(lines)-1], "CATEGORIES:"+category, end)
+
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
@@ -0,0 +1,182 @@
+package addressbook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	googlePeopleAPI = "https://people.googleapis.com/v1/"
+	// googleMaxMembers is the maximum number of contacts added to a group in a single request
+	googleMaxMembers = 1000
+)
+
+// Google is the Google Contacts of a user, read through the People API. Labels are contact groups.
+type Google struct {
+	token    string
+	endpoint string
+	client   *http.Client
+}
+
+type googleContactGroup struct {
+	ResourceName string `json:"resourceName,omitempty"`
+	Name         string `json:"name"`
+}
+
+// NewGoogle returns the contacts of the user that token, an OAuth 2.0 access token with the
+// https://www.googleapis.com/auth/contacts scope, was issued to.
+func NewGoogle(token string) *Google {
+	return &Google{
+		token:    token,
+		endpoint: googlePeopleAPI,
+		client:   &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// call sends in as JSON to a People API method and decodes the response into out, both are optional.
+func (g *Google) call(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	target := g.endpoint + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, &body)
+	if err != nil {
+		return errors.Wrap(err, "could not create people api request")
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	contents, err := do(g.client, req)
+	if err != nil {
+		return err
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return errors.Wrap(json.Unmarshal(contents, out), "could not parse people api response")
+}
+
+// Contacts returns the contacts of the user that have an email address.
+func (g *Google) Contacts(ctx context.Context) ([]Contact, error) {
+	var contacts []Contact
+
+	query := url.Values{"personFields": {"names,emailAddresses"}, "pageSize": {"1000"}}
+	for {
+		var page struct {
+			Connections []struct {
+				ResourceName string `json:"resourceName"`
+				Names        []struct {
+					DisplayName string `json:"displayName"`
+				} `json:"names"`
+				EmailAddresses []struct {
+					Value string `json:"value"`
+				} `json:"emailAddresses"`
+			} `json:"connections"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+
+		if err := g.call(ctx, http.MethodGet, "people/me/connections", query, nil, &page); err != nil {
+			return nil, errors.Wrap(err, "could not list google contacts")
+		}
+
+		for _, person := range page.Connections {
+			contact := Contact{ID: person.ResourceName}
+			if len(person.Names) > 0 {
+				contact.Name = person.Names[0].DisplayName
+			}
+			for _, email := range person.EmailAddresses {
+				contact.Emails = append(contact.Emails, email.Value)
+			}
+
+			if len(contact.Emails) > 0 {
+				contacts = append(contacts, contact)
+			}
+		}
+
+		if page.NextPageToken == "" {
+			return contacts, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// Label adds contacts to the contact group named label, creating the group when it does not exist yet.
+func (g *Google) Label(ctx context.Context, contacts []Contact, label string) error {
+	group, err := g.contactGroup(ctx, label)
+	if err != nil {
+		return err
+	}
+
+	for len(contacts) > 0 {
+		batch := contacts
+		if len(batch) > googleMaxMembers {
+			batch = batch[:googleMaxMembers]
+		}
+		contacts = contacts[len(batch):]
+
+		var modify struct {
+			ResourceNamesToAdd []string `json:"resourceNamesToAdd"`
+		}
+		for _, contact := range batch {
+			modify.ResourceNamesToAdd = append(modify.ResourceNamesToAdd, contact.ID)
+		}
+
+		if err := g.call(ctx, http.MethodPost, group+"/members:modify", nil, modify, nil); err != nil {
+			return errors.Wrapf(err, "could not add contacts to group %s", label)
+		}
+	}
+
+	return nil
+}
+
+// contactGroup returns the resource name of the contact group called name, creating it if needed.
+func (g *Google) contactGroup(ctx context.Context, name string) (string, error) {
+	query := url.Values{"pageSize": {"1000"}}
+	for {
+		var page struct {
+			ContactGroups []googleContactGroup `json:"contactGroups"`
+			NextPageToken string               `json:"nextPageToken"`
+		}
+
+		if err := g.call(ctx, http.MethodGet, "contactGroups", query, nil, &page); err != nil {
+			return "", errors.Wrap(err, "could not list contact groups")
+		}
+
+		for _, group := range page.ContactGroups {
+			if strings.EqualFold(group.Name, name) {
+				return group.ResourceName, nil
+			}
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+
+	var created googleContactGroup
+	create := struct {
+		ContactGroup googleContactGroup `json:"contactGroup"`
+	}{googleContactGroup{Name: name}}
+
+	if err := g.call(ctx, http.MethodPost, "contactGroups", nil, create, &created); err != nil {
+		return "", errors.Wrapf(err, "could not create contact group %s", name)
+	}
+
+	return created.ResourceName, nil
+}
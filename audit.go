@@ -0,0 +1,54 @@
+package mailcheck
+
+import (
+	"net"
+	"time"
+)
+
+// Auditor records every probe sent to a mail server, independent of the results, e.g. to keep an audit trail
+// for compliance. RecordProbe is called concurrently.
+type Auditor interface {
+	RecordProbe(record ProbeRecord)
+}
+
+// ProbeRecord is a single attempt at probing an address, including the ones that failed to connect.
+type ProbeRecord struct {
+	Time time.Time `json:"time"`
+	// MX and Port are the mail server probed, or the smarthost, empty when none could be connected to.
+	MX   string `json:"mx,omitempty"`
+	Port int    `json:"port,omitempty"`
+	// Recipient is the address checked, which is a random one for catch-all probes.
+	Recipient string `json:"recipient"`
+	// SourceIP, HELO and MailFrom are who the probe came from.
+	SourceIP string `json:"source_ip,omitempty"`
+	HELO     string `json:"helo,omitempty"`
+	MailFrom string `json:"mail_from,omitempty"`
+	// Exchanges are the commands sent during the probe and the replies to them, including those of connecting
+	// when the session was not reused.
+	Exchanges []Exchange `json:"exchanges"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// audit hands the probe of recipient that started at start to Options.Auditor. client is nil when connecting failed.
+func (c *Checker) audit(start time.Time, recipient string, client *smtpClient, exchanges []Exchange, err error) {
+	record := ProbeRecord{
+		Time:      start.UTC(),
+		Recipient: recipient,
+		Exchanges: append([]Exchange{}, exchanges...),
+	}
+
+	if client != nil {
+		record.MX, record.Port = client.mx, client.port
+		record.HELO, record.MailFrom = client.identity.HELO, client.identity.MailFrom
+
+		if addr, ok := client.conn.LocalAddr().(*net.TCPAddr); ok {
+			record.SourceIP = addr.IP.String()
+		}
+	}
+
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	c.options.Auditor.RecordProbe(record)
+}
@@ -0,0 +1,151 @@
+package mailcheck
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+)
+
+const (
+	// myIPHost resolves to the address of whoever asks, when asked to an OpenDNS resolver
+	myIPHost      = "myip.opendns.com"
+	myIPDNSServer = "208.67.222.222"
+)
+
+// DefaultBlocklists are the DNSBLs checked by CheckBlocklists when no zones are given.
+var DefaultBlocklists = []string{
+	"zen.spamhaus.org",
+	"b.barracudacentral.org",
+	"bl.spamcop.net",
+}
+
+// DefaultDomainBlocklists are the domain blocklists checked by CheckDomainBlocklists when no zones are given.
+var DefaultDomainBlocklists = []string{
+	"dbl.spamhaus.org",
+	"multi.surbl.org",
+}
+
+// BlocklistResult is the listing status of an address on a single DNSBL.
+type BlocklistResult struct {
+	Zone   string `json:"zone"`
+	Listed bool   `json:"listed"`
+	// Codes are the 127.0.0.0/8 return codes of the DNSBL, which tell why an address is listed.
+	Codes []string `json:"codes,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// EgressIP returns the public address this host connects to the internet from.
+func (c *Checker) EgressIP(ctx context.Context) (net.IP, error) {
+	addresses, err := newResolver(c.dialer, myIPDNSServer, nil).LookupHost(ctx, myIPHost)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not determine egress ip")
+	}
+
+	ip := net.ParseIP(addresses[0])
+	if ip == nil {
+		return nil, errors.Errorf("invalid egress ip '%s'", addresses[0])
+	}
+
+	return ip, nil
+}
+
+// CheckBlocklists looks up ip on every DNSBL zone, DefaultBlocklists when zones is empty.
+func (c *Checker) CheckBlocklists(ctx context.Context, ip net.IP, zones []string) []BlocklistResult {
+	if len(zones) == 0 {
+		zones = DefaultBlocklists
+	}
+
+	results := make([]BlocklistResult, len(zones))
+	for i, zone := range zones {
+		results[i] = c.lookupBlocklist(ctx, reverseIP(ip)+"."+zone, zone)
+	}
+
+	return results
+}
+
+// CheckDomainBlocklists looks up domain on every domain blocklist zone, DefaultDomainBlocklists when zones is empty.
+// Outcomes without errors are cached per domain for the lifetime of the Checker.
+func (c *Checker) CheckDomainBlocklists(ctx context.Context, domain string, zones []string) []BlocklistResult {
+	if len(zones) == 0 {
+		zones = DefaultDomainBlocklists
+	}
+
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	key := domain + " " + strings.Join(zones, ",")
+
+	c.domainListingsMu.Lock()
+	results, ok := c.domainListings[key]
+	c.domainListingsMu.Unlock()
+
+	if ok {
+		return results
+	}
+
+	conclusive := true
+	results = make([]BlocklistResult, len(zones))
+	for i, zone := range zones {
+		results[i] = c.lookupBlocklist(ctx, domain+"."+zone, zone)
+
+		// SURBL answers 127.0.0.1 to queries it refuses, it never lists with that code
+		if len(results[i].Codes) == 1 && results[i].Codes[0] == "127.0.0.1" {
+			results[i] = BlocklistResult{Zone: zone, Error: "query refused by blocklist (127.0.0.1)"}
+		}
+
+		conclusive = conclusive && results[i].Error == ""
+	}
+
+	if conclusive {
+		c.domainListingsMu.Lock()
+		c.domainListings[key] = results
+		c.domainListingsMu.Unlock()
+	}
+
+	return results
+}
+
+// lookupBlocklist queries name, an address or domain prefixed to zone, on a DNS based blocklist.
+func (c *Checker) lookupBlocklist(ctx context.Context, name, zone string) BlocklistResult {
+	result := BlocklistResult{Zone: zone}
+
+	addresses, err := c.resolver.LookupHost(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return result
+		}
+
+		result.Error = err.Error()
+		return result
+	}
+
+	for _, address := range addresses {
+		// 127.255.255.0/24 signals a refused query, e.g. Spamhaus blocking public resolvers
+		if strings.HasPrefix(address, "127.255.255.") {
+			result.Error = fmt.Sprintf("query refused by blocklist (%s)", address)
+			return result
+		}
+
+		result.Codes = append(result.Codes, address)
+	}
+
+	result.Listed = len(result.Codes) > 0
+	return result
+}
+
+// reverseIP returns the address in the reversed notation used by DNSBLs,
+// dotted octets for IPv4 and dotted nibbles for IPv6.
+func reverseIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+
+	ip16 := ip.To16()
+	nibbles := make([]string, 0, 32)
+	for i := len(ip16) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, fmt.Sprintf("%x", ip16[i]&0x0f), fmt.Sprintf("%x", ip16[i]>>4))
+	}
+
+	return strings.Join(nibbles, ".")
+}
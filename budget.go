@@ -0,0 +1,77 @@
+package mailcheck
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// StageBudget limits the time a single check spends in each stage, retries included, so that one slow or
+// tarpitting mail server cannot take up the whole time available for an address. A zero limit leaves the
+// stage to the context. Connecting and the TLS handshake share the sum of their limits, since the
+// handshake is part of connecting.
+type StageBudget struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	SMTP    time.Duration
+}
+
+// limit returns the budget of stage, zero when unlimited.
+func (b StageBudget) limit(stage string) time.Duration {
+	switch stage {
+	case StageDNS:
+		return b.DNS
+	case StageConnect:
+		return b.Connect
+	case StageTLS:
+		return b.TLS
+	case StageSMTP:
+		return b.SMTP
+	default:
+		return 0
+	}
+}
+
+// context returns ctx limited to what is left of the budget of the limited stages after spent, unlimited when
+// none of stages is limited. The returned function releases the context and reports whether the budget ran out.
+func (b StageBudget) context(ctx context.Context, spent map[string]time.Duration, stages ...string) (context.Context, func() bool) {
+	limited := false
+	var left time.Duration
+	for _, stage := range stages {
+		if b.limit(stage) == 0 {
+			continue
+		}
+
+		limited = true
+		if remaining := b.limit(stage) - spent[stage]; remaining > 0 {
+			left += remaining
+		}
+	}
+
+	if !limited {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, func() bool { cancel(); return false }
+	}
+
+	stageCtx, cancel := context.WithTimeout(ctx, left)
+	return stageCtx, func() bool {
+		exhausted := ctx.Err() == nil && stageCtx.Err() == context.DeadlineExceeded
+		cancel()
+		return exhausted
+	}
+}
+
+// budgetError is the error of a check that ran out of the budget of stage.
+func budgetError(stage string) error {
+	return errors.Errorf("%s stage ran out of its time budget", stage)
+}
+
+// spend adds d to the time spent in stage.
+func (r *Result) spend(stage string, d time.Duration) {
+	if r.Durations == nil {
+		r.Durations = map[string]time.Duration{}
+	}
+
+	r.Durations[stage] += d
+}
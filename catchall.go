@@ -0,0 +1,46 @@
+package mailcheck
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// IsCatchAll reports whether servers accept mail for any address at domain, by probing an address that cannot
+// exist. Conclusive outcomes are cached per domain for the lifetime of the Checker.
+func (c *Checker) IsCatchAll(ctx context.Context, domain string, servers []MailServer) (bool, error) {
+	domain = strings.ToLower(domain)
+
+	c.catchAllMu.Lock()
+	catchAll, ok := c.catchAll[domain]
+	c.catchAllMu.Unlock()
+
+	if ok {
+		return catchAll, nil
+	}
+
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return false, errors.Wrap(err, "could not generate address")
+	}
+
+	probe := Result{Attempts: map[string]int{}}
+	c.VerifyMailbox(ctx, &probe, "no-such-user-"+hex.EncodeToString(random)+"@"+domain, servers)
+
+	switch probe.Verdict {
+	case VerdictValid:
+		catchAll = true
+	case VerdictInvalid:
+		catchAll = false
+	default:
+		return false, errors.Errorf("inconclusive probe: %s %s", probe.Reason, probe.Error)
+	}
+
+	c.catchAllMu.Lock()
+	c.catchAll[domain] = catchAll
+	c.catchAllMu.Unlock()
+
+	return catchAll, nil
+}
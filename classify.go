@@ -0,0 +1,97 @@
+package mailcheck
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+)
+
+// DefaultRoleAccounts are local parts that belong to a function rather than a person.
+var DefaultRoleAccounts = []string{
+	"abuse", "accounting", "accounts", "admin", "administrator", "billing", "careers", "contact",
+	"do-not-reply", "donotreply", "enquiries", "feedback", "finance", "hello", "help", "hostmaster",
+	"hr", "info", "inquiries", "jobs", "legal", "mailer-daemon", "marketing", "media", "newsletter",
+	"no-reply", "noreply", "office", "orders", "postmaster", "press", "privacy", "root", "sales",
+	"security", "service", "support", "team", "webmaster",
+}
+
+// freeProviderDomains are consumer mail providers where anyone can sign up.
+var freeProviderDomains = map[string]bool{
+	"aol.com": true, "gmail.com": true, "googlemail.com": true, "gmx.at": true, "gmx.com": true,
+	"gmx.de": true, "gmx.net": true, "hotmail.be": true, "hotmail.co.uk": true, "hotmail.com": true,
+	"hotmail.fr": true, "icloud.com": true, "live.be": true, "live.com": true, "mac.com": true,
+	"mail.com": true, "mail.ru": true, "me.com": true, "msn.com": true, "outlook.be": true,
+	"outlook.com": true, "proton.me": true, "protonmail.com": true, "rocketmail.com": true,
+	"skynet.be": true, "telenet.be": true, "tutanota.com": true, "web.de": true, "yahoo.co.uk": true,
+	"yahoo.com": true, "yahoo.fr": true, "yandex.com": true, "yandex.ru": true, "ymail.com": true,
+	"zoho.com": true,
+}
+
+// disposableDomains offer throwaway mailboxes that live for minutes or hours.
+var disposableDomains = map[string]bool{
+	"10minutemail.com": true, "discard.email": true, "dispostable.com": true, "emailondeck.com": true,
+	"fakeinbox.com": true, "getnada.com": true, "grr.la": true, "guerrillamail.biz": true,
+	"guerrillamail.com": true, "guerrillamail.net": true, "guerrillamail.org": true, "mailinator.com": true,
+	"maildrop.cc": true, "mailnesia.com": true, "mintemail.com": true, "mohmal.com": true,
+	"mytemp.email": true, "sharklasers.com": true, "spam4.me": true, "temp-mail.org": true,
+	"tempmail.net": true, "tempr.email": true, "throwawaymail.com": true, "trashmail.com": true,
+	"yopmail.com": true,
+}
+
+// Classification describes the kind of address, regardless of whether it exists.
+type Classification struct {
+	// Role is set for addresses of a function, like info@ or sales@, rather than a person.
+	Role bool `json:"role"`
+	// FreeProvider is set for consumer mail providers, as opposed to corporate domains.
+	FreeProvider bool `json:"free_provider"`
+}
+
+// Classify tells whether email is a role account and whether it is hosted by a free mail provider.
+func (c *Checker) Classify(email string) (classification Classification) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return classification
+	}
+
+	// sales+newsletter@ is as much a role account as sales@
+	local := strings.ToLower(email[:at])
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+
+	classification.Role = c.roleAccounts[local]
+	classification.FreeProvider = freeProviderDomains[canonicalHost(email[at+1:])]
+
+	return classification
+}
+
+// IsDisposable tells whether domain hands out disposable mailboxes.
+func IsDisposable(domain string) bool {
+	return disposableDomains[canonicalHost(domain)]
+}
+
+// LoadRoleAccounts reads a file with one role account local part per line, ignoring blank lines and # comments.
+func LoadRoleAccounts(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open role list")
+	}
+	defer file.Close()
+
+	var roles []string
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		if line = strings.TrimSpace(line); line != "" {
+			roles = append(roles, line)
+		}
+	}
+
+	return roles, errors.Wrap(scanner.Err(), "could not read role list")
+}
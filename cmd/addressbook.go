@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"github.com/hazcod/mailcheck"
+	"github.com/hazcod/mailcheck/addressbook"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+)
+
+const (
+	envCardDAVPassword   = "CARDDAV_PASSWORD"
+	envGoogleAccessToken = "GOOGLE_ACCESS_TOKEN"
+)
+
+// openAddressBook returns the address book selected on the command line, nil when there is none.
+func openAddressBook(carddavURL, carddavUser string, googleContacts bool) (addressbook.Book, error) {
+	switch {
+	case carddavURL != "" && googleContacts:
+		return nil, errors.New("-carddav and -google-contacts cannot be combined")
+	case carddavURL != "":
+		book, err := addressbook.NewCardDAV(carddavURL, carddavUser, os.Getenv(envCardDAVPassword))
+		if err != nil {
+			return nil, err
+		}
+		return book, nil
+	case googleContacts:
+		token := os.Getenv(envGoogleAccessToken)
+		if token == "" {
+			return nil, errors.Errorf("-google-contacts needs an access token in %s", envGoogleAccessToken)
+		}
+		return addressbook.NewGoogle(token), nil
+	}
+
+	return nil, nil
+}
+
+// labelVerified labels the contacts of which every address was checked and found valid.
+// Verdicts are keyed by the addresses as normalized with normalize.
+func labelVerified(ctx context.Context, book addressbook.Book, contacts []addressbook.Contact, verdicts map[string]mailcheck.Verdict,
+	normalize func(string) string, label string) {
+	var verified []addressbook.Contact
+
+	for _, contact := range contacts {
+		valid := true
+		for _, email := range contact.Emails {
+			if verdicts[normalize(email)] != mailcheck.VerdictValid {
+				valid = false
+				break
+			}
+		}
+
+		if valid {
+			verified = append(verified, contact)
+		}
+	}
+
+	if len(verified) == 0 {
+		return
+	}
+
+	if err := book.Label(ctx, verified, label); err != nil {
+		log.Error(err)
+		return
+	}
+
+	log.Infof("labeled %d of %d contacts as %s", len(verified), len(contacts), label)
+}
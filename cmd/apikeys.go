@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"github.com/hazcod/mailcheck/config"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKeyContextKey holds the api key of a request in its context.
+type apiKeyContextKey struct{}
+
+// apiKeys authenticates the requests to the HTTP API and enforces the limits of their keys.
+// Usage is kept in memory, so it starts over when the server restarts.
+type apiKeys struct {
+	mu   sync.Mutex
+	keys []*apiKey
+}
+
+// apiKey is a configured key along with its usage.
+type apiKey struct {
+	config.APIKey
+
+	// tokens and refilled are the request budget of the rate limit, a token bucket holding a minute of requests
+	tokens   float64
+	refilled time.Time
+	// day is the UTC date used counts the addresses of
+	day  string
+	used int
+	// requests and checked count everything since the server started
+	requests int
+	checked  int
+}
+
+// apiUsage is the usage of a key as reported to clients.
+type apiUsage struct {
+	Name          string `json:"name"`
+	RatePerMinute int    `json:"rate_per_minute,omitempty"`
+	DailyQuota    int    `json:"daily_quota,omitempty"`
+	// Today and Remaining are the addresses checked today and the ones left, Remaining is omitted without quota
+	Today     int  `json:"today"`
+	Remaining *int `json:"remaining,omitempty"`
+	// Requests and Checked are the totals since the server started
+	Requests int `json:"requests"`
+	Checked  int `json:"checked"`
+}
+
+func newAPIKeys(keys []config.APIKey) *apiKeys {
+	k := &apiKeys{}
+	for _, key := range keys {
+		k.keys = append(k.keys, &apiKey{APIKey: key, tokens: float64(key.RatePerMinute), refilled: time.Now()})
+	}
+
+	return k
+}
+
+// wrap makes next only serve requests with a valid key that is within its rate limit.
+// Without keys every request is served.
+func (k *apiKeys) wrap(next http.Handler) http.Handler {
+	if len(k.keys) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := k.authenticate(r)
+		if key == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "missing or unknown api key")
+			return
+		}
+
+		if wait, ok := k.allow(key, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded, try again later")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+// authenticate returns the key sent as bearer token or in the X-API-Key header, nil when it is unknown.
+func (k *apiKeys) authenticate(r *http.Request) *apiKey {
+	secret := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		secret = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	if secret == "" {
+		return nil
+	}
+
+	for _, key := range k.keys {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(key.Key)) == 1 {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// allow counts a request against the rate limit of key, it returns how long to wait when there is none left.
+func (k *apiKeys) allow(key *apiKey, now time.Time) (wait time.Duration, ok bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key.requests++
+
+	if key.RatePerMinute == 0 {
+		return 0, true
+	}
+
+	rate := float64(key.RatePerMinute) / time.Minute.Seconds()
+	key.tokens = math.Min(float64(key.RatePerMinute), key.tokens+now.Sub(key.refilled).Seconds()*rate)
+	key.refilled = now
+
+	if key.tokens < 1 {
+		return time.Duration((1 - key.tokens) / rate * float64(time.Second)), false
+	}
+
+	key.tokens--
+	return 0, true
+}
+
+// charge counts n addresses or domains against the daily quota of the key of r. When it has too few left,
+// it answers r and returns false.
+func (k *apiKeys) charge(w http.ResponseWriter, r *http.Request, n int) bool {
+	key, ok := r.Context().Value(apiKeyContextKey{}).(*apiKey)
+	if !ok {
+		return true
+	}
+
+	now := time.Now().UTC()
+
+	k.mu.Lock()
+	if day := now.Format("2006-01-02"); key.day != day {
+		key.day, key.used = day, 0
+	}
+
+	remaining := key.DailyQuota - key.used
+	if key.DailyQuota > 0 && n > remaining {
+		k.mu.Unlock()
+
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(midnight.Sub(now).Seconds()))))
+		writeError(w, http.StatusTooManyRequests, fmt.Sprintf("daily quota exceeded, %d of %d left for today", remaining, key.DailyQuota))
+		log.Debugf("api key %s exceeded its daily quota", key.Name)
+		return false
+	}
+
+	key.used += n
+	key.checked += n
+	k.mu.Unlock()
+
+	return true
+}
+
+// usage returns the usage of key.
+func (k *apiKeys) usage(key *apiKey) apiUsage {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	usage := apiUsage{
+		Name:          key.Name,
+		RatePerMinute: key.RatePerMinute,
+		DailyQuota:    key.DailyQuota,
+		Requests:      key.requests,
+		Checked:       key.checked,
+	}
+
+	if key.day == time.Now().UTC().Format("2006-01-02") {
+		usage.Today = key.used
+	}
+
+	if key.DailyQuota > 0 {
+		remaining := key.DailyQuota - usage.Today
+		usage.Remaining = &remaining
+	}
+
+	return usage
+}
+
+// handleUsage serves GET /v1/usage with the usage of the key of the request, or of every key for admin keys.
+func (s *server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	key, ok := r.Context().Value(apiKeyContextKey{}).(*apiKey)
+	if !ok {
+		writeError(w, http.StatusNotFound, "usage is only accounted with api keys")
+		return
+	}
+
+	if !key.Admin {
+		writeJSON(w, http.StatusOK, s.keys.usage(key))
+		return
+	}
+
+	response := struct {
+		Keys []apiUsage `json:"keys"`
+	}{}
+	for _, other := range s.keys.keys {
+		response.Keys = append(response.Keys, s.keys.usage(other))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/hazcod/mailcheck/config"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyQuota(t *testing.T) {
+	keys := newAPIKeys([]config.APIKey{{Name: "marketing", Key: "secret", DailyQuota: 3}, {Name: "ops", Key: "admin-secret", Admin: true}})
+	s := &server{keys: keys}
+
+	// the handler charges as many addresses as the request asks for
+	check := keys.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		if keys.charge(w, r, n) {
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	usage := keys.wrap(http.HandlerFunc(s.handleUsage))
+
+	request := func(handler http.Handler, target, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	if res := request(check, "/v1/check?n=1", "unknown"); res.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unknown key to be refused, got %d", res.Code)
+	}
+
+	if res := request(check, "/v1/check?n=2", "secret"); res.Code != http.StatusOK {
+		t.Fatalf("expected the first addresses to be within the quota, got %d %s", res.Code, res.Body)
+	}
+
+	// a request is refused as a whole when it asks for more than is left
+	res := request(check, "/v1/check?n=2", "secret")
+	if res.Code != http.StatusTooManyRequests || !strings.Contains(res.Body.String(), "1 of 3 left") {
+		t.Errorf("expected the quota to be exceeded, got %d %s", res.Code, res.Body)
+	}
+	if wait, err := strconv.Atoi(res.Header().Get("Retry-After")); err != nil || wait < 1 || wait > 24*60*60 {
+		t.Errorf("expected to be told to retry after midnight UTC, got %q", res.Header().Get("Retry-After"))
+	}
+
+	if res := request(check, "/v1/check?n=1", "secret"); res.Code != http.StatusOK {
+		t.Errorf("expected the last address of the quota to be allowed, got %d %s", res.Code, res.Body)
+	}
+
+	var own apiUsage
+	if err := json.Unmarshal(request(usage, "/v1/usage", "secret").Body.Bytes(), &own); err != nil {
+		t.Fatal(err)
+	}
+	if own.Name != "marketing" || own.Today != 3 || own.Remaining == nil || *own.Remaining != 0 || own.Checked != 3 {
+		t.Errorf("expected the quota to be used up, got %+v", own)
+	}
+
+	// admin keys see the usage of every key, and have no quota of their own
+	if res := request(check, "/v1/check?n=100", "admin-secret"); res.Code != http.StatusOK {
+		t.Errorf("expected a key without quota to be allowed, got %d %s", res.Code, res.Body)
+	}
+	var all struct {
+		Keys []apiUsage `json:"keys"`
+	}
+	if err := json.Unmarshal(request(usage, "/v1/usage", "admin-secret").Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Keys) != 2 || all.Keys[0].Today != 3 || all.Keys[1].Today != 100 || all.Keys[1].Remaining != nil {
+		t.Errorf("expected the usage of both keys, got %+v", all.Keys)
+	}
+
+	// the quota starts over every day
+	keys.mu.Lock()
+	keys.keys[0].day = time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	keys.mu.Unlock()
+
+	if res := request(check, "/v1/check?n=3", "secret"); res.Code != http.StatusOK {
+		t.Errorf("expected a fresh quota the next day, got %d %s", res.Code, res.Body)
+	}
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	keys := newAPIKeys([]config.APIKey{{Name: "marketing", Key: "secret", RatePerMinute: 2}})
+	key := keys.keys[0]
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if _, ok := keys.allow(key, now); !ok {
+			t.Fatalf("expected request %d to be within the rate limit", i+1)
+		}
+	}
+
+	wait, ok := keys.allow(key, now)
+	if ok || wait < time.Second*29 || wait > time.Second*30 {
+		t.Errorf("expected to wait 30s for the next request, got %s", wait)
+	}
+
+	// tokens come back at the rate of the limit
+	if _, ok := keys.allow(key, now.Add(time.Second*30)); !ok {
+		t.Error("expected a request to be allowed once a token came back")
+	}
+	if _, ok := keys.allow(key, now.Add(time.Second*31)); ok {
+		t.Error("expected the next request to wait again")
+	}
+
+	if usage := keys.usage(key); usage.Requests != 5 {
+		t.Errorf("expected every request to be counted, got %d", usage.Requests)
+	}
+}
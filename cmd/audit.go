@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/hazcod/mailcheck"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// auditSyslog is the -audit-log value that sends the audit log to syslog, on this host or at syslog://host:port.
+const auditSyslog = "syslog"
+
+// auditLog writes every probe as a line of JSON, to a file that is only ever appended to or to syslog.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// openAuditLog opens the audit log at target, a file or syslog.
+func openAuditLog(target string) (*auditLog, error) {
+	if target == auditSyslog || strings.HasPrefix(target, auditSyslog+"://") {
+		w, err := openSyslog(strings.TrimPrefix(strings.TrimPrefix(target, auditSyslog), "://"))
+		if err != nil {
+			return nil, errors.Wrap(err, "could not connect to syslog")
+		}
+		return &auditLog{w: w}, nil
+	}
+
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open audit log")
+	}
+
+	return &auditLog{w: file}, nil
+}
+
+// RecordProbe implements mailcheck.Auditor.
+func (a *auditLog) RecordProbe(record mailcheck.ProbeRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("could not encode audit record: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// a single write keeps lines whole, also when several processes append to the same file
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		log.Errorf("could not write audit record: %v", err)
+	}
+}
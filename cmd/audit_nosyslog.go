@@ -0,0 +1,14 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"io"
+)
+
+// openSyslog fails, there is no syslog on this platform.
+func openSyslog(string) (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
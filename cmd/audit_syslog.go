@@ -0,0 +1,19 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// openSyslog connects to the syslog daemon at addr over udp, or on this host when addr is empty.
+func openSyslog(addr string) (io.Writer, error) {
+	network := ""
+	if addr != "" {
+		network = "udp"
+	}
+
+	return syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_AUTH, "mailcheck")
+}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/hazcod/mailcheck"
+	"github.com/hazcod/mailcheck/addressbook"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// batchFlags are the flags of the batch subcommand on top of the global flags.
+type batchFlags struct {
+	*globalFlags
+
+	input           string
+	pushgateway     string
+	pushgatewayJob  string
+	metricsTextfile string
+	blcheck         bool
+	abortIfListed   bool
+	preflight       bool
+	abortOnSender   bool
+	breakerRate     float64
+	breakerWindow   int
+	breakerAction   string
+	carddavURL      string
+	carddavUser     string
+	googleContacts  bool
+	label           string
+	strict          bool
+	progressEvery   time.Duration
+	checkpoint      string
+	resume          bool
+	skipIfVerified  time.Duration
+	foldAliases     bool
+	report          string
+	quarantine      string
+	passes          int
+	passDelay       time.Duration
+	export          string
+	exportFile      string
+	exportList      string
+	exportMinScore  int
+	prefetchWorkers int
+}
+
+// requeueReasons are the unknown outcomes checked again in a later pass. Sender issues are left out,
+// they are better handled by the circuit breaker than by waiting.
+var requeueReasons = map[mailcheck.Reason]bool{
+	mailcheck.ReasonTemporary:    true,
+	mailcheck.ReasonUnreachable:  true,
+	mailcheck.ReasonDNSError:     true,
+	mailcheck.ReasonDNSFailed:    true,
+	mailcheck.ReasonUnrecognized: true,
+}
+
+func newBatchCommand(std streams) *ffcli.Command {
+	flags := flag.NewFlagSet("mailcheck batch", flag.ContinueOnError)
+	b := &batchFlags{globalFlags: newGlobalFlags(flags, std)}
+
+	flags.StringVar(&b.input, "input", "", "file with the addresses to check, one per line")
+	flags.StringVar(&b.pushgateway, "pushgateway", "", "prometheus pushgateway url to push the metrics of the run to")
+	flags.StringVar(&b.pushgatewayJob, "pushgateway-job", "mailcheck", "job name to push metrics under")
+	flags.StringVar(&b.metricsTextfile, "metrics-textfile", "", "path of a node exporter textfile to write the metrics of the run to")
+	flags.BoolVar(&b.blcheck, "blcheck", true, "check our egress ip against DNSBLs before checking more than one address")
+	flags.BoolVar(&b.abortIfListed, "abort-if-listed", false, "do not start a batch when our egress ip is blocklisted")
+	flags.BoolVar(&b.preflight, "preflight", true, "check that our helo name, reverse DNS and SPF record match our egress ip before checking more than one address")
+	flags.BoolVar(&b.abortOnSender, "abort-on-sender-issues", false, "do not start a batch when the preflight finds our sender identity misconfigured")
+	flags.Float64Var(&b.breakerRate, "max-sender-issue-rate", 50, "percentage of unknown:sender_issue results that stops a batch, 0 to disable")
+	flags.IntVar(&b.breakerWindow, "breaker-window", 20, "number of results to see before judging the sender issue rate")
+	flags.StringVar(&b.breakerAction, "breaker-action", breakerAbort, "what to do when the sender issue rate is exceeded: abort or pause")
+	flags.StringVar(&b.carddavURL, "carddav", "", "url of a CardDAV address book to check the contacts of, the password is read from "+envCardDAVPassword)
+	flags.StringVar(&b.carddavUser, "carddav-user", "", "username for the CardDAV address book")
+	flags.BoolVar(&b.googleContacts, "google-contacts", false, "check the Google Contacts of the user whose access token is in "+envGoogleAccessToken)
+	flags.StringVar(&b.label, "label", "", "label contacts of the address book whose addresses are all valid, e.g. verified")
+	flags.BoolVar(&b.strict, "strict", false, "treat unknown results as invalid in the exit code")
+	flags.StringVar(&b.checkpoint, "checkpoint", "", "file to record the completed addresses in, to be able to resume an interrupted run")
+	flags.BoolVar(&b.resume, "resume", false, "skip the addresses completed according to -checkpoint")
+	flags.DurationVar(&b.skipIfVerified, "skip-if-verified-within", 0, "write the results recorded in -db for addresses found valid or invalid within this duration instead of checking them, 0 to check all")
+	flags.BoolVar(&b.foldAliases, "fold-aliases", false, "treat addresses a provider delivers to the same mailbox, like j.doe+news@gmail.com and jdoe@gmail.com, as duplicates")
+	flags.StringVar(&b.report, "report", "", "file to write the summary of the run to, as HTML when it ends in .html and as JSON otherwise")
+	flags.StringVar(&b.quarantine, "quarantine", "", "file to append the likely spamtraps found with -spamtraps to, one address per line")
+	flags.IntVar(&b.passes, "passes", 1, "number of passes, addresses that are unknown because of greylisting, timeouts or unclear replies are checked again in the next")
+	flags.DurationVar(&b.passDelay, "pass-delay", 5*time.Minute, "delay before the second pass, it doubles for every pass after it")
+	flags.StringVar(&b.export, "export", "", "hand the addresses scoring at least -export-min-score to a sending platform: mailchimp, sendgrid or hubspot")
+	flags.StringVar(&b.exportFile, "export-file", "", "file to write the -export addresses to in the import format of the platform, instead of calling its api")
+	flags.StringVar(&b.exportList, "export-list", "", "mailchimp audience id, or comma separated sendgrid list ids, to import the -export addresses into")
+	flags.IntVar(&b.exportMinScore, "export-min-score", 80, "minimum score of the addresses to -export")
+	flags.IntVar(&b.prefetchWorkers, "prefetch-workers", 16, "number of domains to look up the mail servers of at a time before every pass, 0 to look them up per address")
+	flags.DurationVar(&b.progressEvery, "progress-interval", 0, "interval of JSON status lines on stderr when stdout is not a terminal, 0 for none")
+
+	return &ffcli.Command{
+		Name:       "batch",
+		ShortUsage: "mailcheck batch [flags] [<email> ...]",
+		ShortHelp:  "check a list of addresses, with safeguards and metrics for long runs",
+		LongHelp: "Checks the addresses given as arguments, read from -input and from an address book.\n" +
+			"Addresses are checked one at a time, the concurrency is per domain: -race-mx mail servers are dialed\n" +
+			"at once, -max-rcpt-per-session addresses at the same domain are grouped to share an SMTP session and\n" +
+			"-rate-per-domain caps the probes per minute. SIGHUP reloads them from race_mx, max_rcpt_per_session\n" +
+			"and rate_per_domain in the limits of -config, for the next address and the grouping of the next pass.",
+		FlagSet: flags,
+		Exec:    b.run,
+	}
+}
+
+// run implements the batch subcommand.
+func (b *batchFlags) run(ctx context.Context, emails []string) error {
+	book, err := openAddressBook(b.carddavURL, b.carddavUser, b.googleContacts)
+	if err != nil {
+		return usage(err)
+	}
+
+	if b.input != "" {
+		addresses, err := readAddresses(b.input)
+		if err != nil {
+			return usage(err)
+		}
+		emails = append(emails, addresses...)
+	}
+
+	if len(emails) == 0 && book == nil {
+		return flag.ErrHelp
+	}
+
+	breaker, err := newCircuitBreaker(b.breakerRate, b.breakerWindow, b.breakerAction)
+	if err != nil {
+		return usage(err)
+	}
+
+	results, err := b.results()
+	if err != nil {
+		return err
+	}
+
+	if b.resume && b.checkpoint == "" {
+		return usage(errors.New("-resume needs a -checkpoint"))
+	}
+
+	if b.passes < 1 {
+		return usage(errors.New("-passes must be at least 1"))
+	}
+
+	if b.quarantine != "" && !b.spamtraps {
+		return usage(errors.New("-quarantine needs -spamtraps"))
+	}
+
+	if b.skipIfVerified > 0 && b.db == "" {
+		return usage(errors.New("-skip-if-verified-within needs a -db"))
+	}
+
+	var state *checkpoint
+	if b.checkpoint != "" {
+		if state, err = openCheckpoint(b.checkpoint, b.resume); err != nil {
+			return usage(err)
+		}
+		defer state.Close()
+	}
+
+	platform, err := openExport(b.export, b.exportList, b.exportFile)
+	if err != nil {
+		return usage(err)
+	}
+	var exported []string
+
+	var quarantine *os.File
+	if b.quarantine != "" {
+		if quarantine, err = os.OpenFile(b.quarantine, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+			return usage(errors.Wrap(err, "could not open quarantine"))
+		}
+		defer quarantine.Close()
+	}
+
+	// progress is drawn on the terminal the results are written to, and is hidden by -quiet
+	showProgress := log.IsLevelEnabled(log.InfoLevel)
+	terminal := showProgress && isTerminal(b.std.stdout) && isTerminal(b.std.stderr)
+
+	checker, err := b.checker()
+	if err != nil {
+		return err
+	}
+	defer checker.Close()
+
+	db, err := b.store()
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	ctx, cancel := b.withTimeoutTotal(ctx)
+	defer cancel()
+
+	var contacts []addressbook.Contact
+	if book != nil {
+		if contacts, err = book.Contacts(ctx); err != nil {
+			return err
+		}
+
+		for _, contact := range contacts {
+			emails = append(emails, contact.Emails...)
+		}
+
+		log.Debugf("checking the addresses of %d contacts", len(contacts))
+	}
+
+	normalize := mailcheck.Normalize
+	if b.foldAliases {
+		normalize = mailcheck.NormalizeAlias
+	}
+
+	var duplicates int
+	if emails, duplicates = mailcheck.Deduplicate(emails, normalize); duplicates > 0 {
+		log.Infof("folded %d duplicate addresses, %d remain", duplicates, len(emails))
+	}
+
+	metrics := newRunMetrics()
+	summary := newRunReport()
+	verdicts := map[string]mailcheck.Verdict{}
+	status := &exitStatus{strict: b.strict}
+
+	// addresses completed by a previous run count towards the outcome, but are not reported again
+	if state != nil && b.resume {
+		remaining := make([]string, 0, len(emails))
+		for _, email := range emails {
+			entry, ok := state.completed(email)
+			if !ok {
+				remaining = append(remaining, email)
+				continue
+			}
+
+			verdicts[email] = entry.Verdict
+			status.record(mailcheck.Result{Email: email, Verdict: entry.Verdict, Reason: entry.Reason})
+		}
+
+		log.Infof("resuming, %d of %d addresses were completed before", len(emails)-len(remaining), len(emails))
+		emails = remaining
+	}
+
+	// addresses verified recently are not checked again, their recorded results are written instead
+	if db != nil && b.skipIfVerified > 0 {
+		remaining := make([]string, 0, len(emails))
+		for _, email := range emails {
+			record, ok, err := recentVerification(ctx, db, email, b.skipIfVerified)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				remaining = append(remaining, email)
+				continue
+			}
+
+			res := record.Result()
+			res.Email = email
+			if err := results.Write(res); err != nil {
+				return err
+			}
+
+			verdicts[email] = res.Verdict
+			status.record(res)
+		}
+
+		log.Infof("skipping %d of %d addresses verified within %s", len(emails)-len(remaining), len(emails), b.skipIfVerified)
+		emails = remaining
+	}
+
+	if b.blcheck && len(emails) > 1 && selfCheck(ctx, checker) && b.abortIfListed {
+		log.Error("not starting, our ip is blocklisted")
+		return exitCode(exitBlocked)
+	}
+
+	// a smarthost sends from its own address, which is not ours to check
+	if b.preflight && b.smarthost == "" && len(emails) > 1 && !senderPreflight(ctx, checker) && b.abortOnSender {
+		log.Error("not starting, our sender identity is misconfigured")
+		return exitCode(exitUsage)
+	}
+
+	checked := 0
+
+	interval := b.progressEvery
+	if !showProgress {
+		interval = 0
+	}
+	progress := newProgress(b.std.stderr, terminal, interval, len(emails))
+
+	controlCtx, stopControl := context.WithCancel(ctx)
+	defer stopControl()
+	b.control(controlCtx, checker, progress)
+
+	pending, delay := emails, b.passDelay
+
+passes:
+	for pass := 1; pass <= b.passes && len(pending) > 0; pass++ {
+		if pass > 1 {
+			progress.clear()
+			log.Infof("pass %d of %d: checking %d unknown addresses again in %s", pass, b.passes, len(pending), delay)
+
+			select {
+			case <-ctx.Done():
+				break passes
+			case <-time.After(delay):
+			}
+
+			delay *= 2
+		}
+
+		// addresses at the same domain are checked back to back so they can share a session, with the limits
+		// in effect, which -config may set and SIGHUP reload
+		if checker.Limits().MaxRcptPerSession > 1 {
+			pending = mailcheck.GroupByDomain(pending)
+		}
+
+		if b.prefetchWorkers > 0 {
+			prefetchMailServers(ctx, checker, pending, b.prefetchWorkers)
+		}
+
+		var requeued []string
+		for _, email := range pending {
+			if ctx.Err() != nil {
+				break passes
+			}
+
+			started := time.Now()
+			addressCtx, cancelAddress := context.WithTimeout(ctx, b.timeoutPerAddress)
+			res := checker.Check(addressCtx, email)
+			cancelAddress()
+			elapsed := time.Since(started)
+
+			// an interrupted check has no verdict
+			if ctx.Err() != nil {
+				break passes
+			}
+
+			// the result only counts after the last pass, before that the address is checked again
+			if pass < b.passes && res.Verdict == mailcheck.VerdictUnknown && requeueReasons[res.Reason] {
+				log.Debugf("%s is %s:%s, checking it again in the next pass", email, res.Verdict, res.Reason)
+				requeued = append(requeued, email)
+
+				// wait at least as long as the server asked
+				var temporary *mailcheck.ErrTemporary
+				if errors.As(res.Err, &temporary) && temporary.RetryAfter > delay {
+					delay = temporary.RetryAfter
+				}
+				continue
+			}
+
+			checked++
+			metrics.record(res)
+			summary.record(res, elapsed)
+			verdicts[email] = res.Verdict
+			log.WithFields(attemptFields(res.Attempts)).Debugf("%s is %s", email, res.Verdict)
+
+			progress.clear()
+			if err := report(results, res, b.transcript, db); err != nil {
+				return err
+			}
+			progress.record(res)
+
+			if state != nil {
+				if err := state.record(email, res); err != nil {
+					return err
+				}
+			}
+
+			if platform != nil && res.Score >= b.exportMinScore {
+				exported = append(exported, res.Email)
+			}
+
+			if quarantine != nil && res.Spamtrap != nil && res.Spamtrap.Likely {
+				if _, err := fmt.Fprintln(quarantine, res.Email); err != nil {
+					return errors.Wrap(err, "could not write to quarantine")
+				}
+			}
+
+			status.record(res)
+
+			if breaker.record(res) && !breaker.proceed() {
+				log.Errorf("stopped after %d of %d addresses: %.0f%% were rejected because of the sender, check whether our IP is blocked",
+					checked, len(emails), breaker.rate())
+				status.blocked = true
+				break passes
+			}
+		}
+
+		pending = requeued
+	}
+
+	progress.stop()
+
+	if ctx.Err() != nil {
+		// the addresses left unchecked are unknown
+		log.Warnf("stopped after %d of %d addresses: %v", checked, len(emails), ctx.Err())
+		status.unknown = true
+	}
+
+	metrics.dnsCache = checker.DNSCacheStats()
+	summary.dnsCache = metrics.dnsCache
+	checker.Close()
+
+	if book != nil && b.label != "" && ctx.Err() == nil {
+		labelVerified(ctx, book, contacts, verdicts, normalize, b.label)
+	}
+
+	if platform != nil {
+		if err := export(platform, b.exportFile, exported); err != nil {
+			log.Error(err)
+		}
+	}
+
+	exportMetrics(metrics, b.pushgateway, b.pushgatewayJob, b.metricsTextfile)
+
+	report := summary.report()
+	if showProgress {
+		report.writeText(b.std.stderr)
+	}
+
+	if b.report != "" {
+		if err := report.writeFile(b.report); err != nil {
+			log.Error(err)
+		}
+	}
+
+	return status.err()
+}
+
+// prefetchMailServers looks up the mail servers of the domains of emails up front, warning about the domains
+// that failed, whose addresses are reported as unknown:dns_failed without being checked.
+func prefetchMailServers(ctx context.Context, checker *mailcheck.Checker, emails []string, workers int) {
+	started := time.Now()
+	failures := checker.PrefetchMailServers(ctx, emails, workers)
+	if ctx.Err() != nil {
+		return
+	}
+
+	domains := make([]string, 0, len(failures))
+	for domain, err := range failures {
+		domains = append(domains, domain)
+		log.Debugf("could not prefetch the mail servers of %s: %v", domain, err)
+	}
+	sort.Strings(domains)
+
+	log.Debugf("prefetched mail servers in %s", time.Since(started).Round(time.Millisecond))
+	if len(domains) > 0 {
+		log.Warnf("could not look up the mail servers of %d domains, skipping their addresses: %s", len(domains), strings.Join(domains, ", "))
+	}
+}
+
+// readAddresses reads a file with one address per line, ignoring blank lines and # comments.
+func readAddresses(path string) (emails []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open input")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if email, ok := addressLine(scanner.Text()); ok {
+			emails = append(emails, email)
+		}
+	}
+
+	return emails, errors.Wrap(scanner.Err(), "could not read input")
+}
+
+// streamAddresses calls check for every address read from r as soon as its line arrives, until the end of the input.
+func streamAddresses(ctx context.Context, r io.Reader, check func(email string) error) error {
+	lines := make(chan string)
+	var readErr error
+
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr = scanner.Err()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case line, ok := <-lines:
+			if !ok {
+				return errors.Wrap(readErr, "could not read input")
+			}
+
+			if email, ok := addressLine(line); ok {
+				if err := check(email); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// addressLine returns the address on an input line, blank lines and # comments have none.
+func addressLine(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", false
+	}
+
+	return line, true
+}
+
+// exportMetrics pushes the metrics of the run and writes them to a textfile, when configured.
+func exportMetrics(metrics *runMetrics, gatewayURL, job, textfile string) {
+	if gatewayURL != "" {
+		// the run context may be cancelled already, pushing the partial results is still useful
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		if err := metrics.push(ctx, gatewayURL, job); err != nil {
+			log.Error(err)
+		}
+	}
+
+	if textfile != "" {
+		if err := metrics.writeTextfile(textfile); err != nil {
+			log.Error(err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/hazcod/mailcheck"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+func newBlcheckCommand(std streams) *ffcli.Command {
+	flags := flag.NewFlagSet("mailcheck blcheck", flag.ContinueOnError)
+	ipFlag := flags.String("ip", "", "address to check instead of our egress IP")
+	zonesFlag := flags.String("zones", "", "comma separated DNSBL zones, Spamhaus ZEN, Barracuda and SpamCop by default")
+	output := flags.String("output", outputText, "result format written to stdout: text or json")
+	timeout := flags.Duration("timeout", time.Second*30, "maximum time for all lookups")
+
+	return &ffcli.Command{
+		Name:       "blcheck",
+		ShortUsage: "mailcheck blcheck [flags]",
+		ShortHelp:  "check whether our egress IP is on a DNS blocklist",
+		LongHelp:   "Looks up our egress IP, or -ip, on DNS blocklists. The exit code is 4 when it is listed.",
+		FlagSet:    flags,
+		Exec: func(ctx context.Context, _ []string) error {
+			return runBlcheck(ctx, std.stdout, *ipFlag, *zonesFlag, *output, *timeout)
+		},
+	}
+}
+
+// runBlcheck implements the blcheck subcommand, checking our egress IP against DNSBLs.
+func runBlcheck(ctx context.Context, stdout io.Writer, ipFlag, zones, output string, timeout time.Duration) error {
+	results, err := newResultWriter(stdout, output)
+	if err != nil {
+		return usage(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	checker := mailcheck.New(mailcheck.Options{})
+
+	ip := net.ParseIP(ipFlag)
+	if ip == nil {
+		if ipFlag != "" {
+			return usage(errors.Errorf("invalid ip '%s'", ipFlag))
+		}
+
+		if ip, err = checker.EgressIP(ctx); err != nil {
+			return err
+		}
+	}
+
+	listed := false
+	for _, result := range checker.CheckBlocklists(ctx, ip, splitList(zones)) {
+		status := "not listed"
+		switch {
+		case result.Error != "":
+			status = "error"
+		case result.Listed:
+			status, listed = "listed", true
+		}
+
+		line := struct {
+			IP string `json:"ip"`
+			mailcheck.BlocklistResult
+		}{ip.String(), result}
+
+		if err := results.writeLine(line, ip.String(), result.Zone, status, strings.Join(result.Codes, ","), result.Error); err != nil {
+			return err
+		}
+	}
+
+	if listed {
+		return exitCode(exitBlocked)
+	}
+
+	return nil
+}
+
+// selfCheck looks up our egress IP on the default DNSBLs and reports whether it is listed.
+func selfCheck(ctx context.Context, checker *mailcheck.Checker) (listed bool) {
+	ip, err := checker.EgressIP(ctx)
+	if err != nil {
+		log.Warnf("skipping blocklist self-check: %v", err)
+		return false
+	}
+
+	for _, result := range checker.CheckBlocklists(ctx, ip, nil) {
+		switch {
+		case result.Error != "":
+			log.Debugf("could not check %s on %s: %s", ip, result.Zone, result.Error)
+		case result.Listed:
+			log.Warnf("our ip %s is listed on %s (%s), results will be skewed by rejections", ip, result.Zone, strings.Join(result.Codes, ","))
+			listed = true
+		}
+	}
+
+	return listed
+}
+
+// splitList splits a comma separated list, ignoring empty entries.
+func splitList(list string) (entries []string) {
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
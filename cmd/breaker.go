@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/hazcod/mailcheck"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+)
+
+const (
+	breakerAbort = "abort"
+	breakerPause = "pause"
+)
+
+// circuitBreaker trips when too many of the results so far are unknown because of our own sender identity,
+// e.g. a blocklisted IP, in which case continuing a large run only wastes time and reputation.
+type circuitBreaker struct {
+	// maxRate is the percentage of sender issues that trips the breaker, 0 disables it
+	maxRate float64
+	// window is the number of results needed before the rate is judged
+	window int
+	action string
+
+	total        int
+	senderIssues int
+}
+
+func newCircuitBreaker(maxRate float64, window int, action string) (*circuitBreaker, error) {
+	if action != breakerAbort && action != breakerPause {
+		return nil, errors.Errorf("unknown circuit breaker action '%s'", action)
+	}
+
+	if maxRate < 0 || maxRate > 100 {
+		return nil, errors.New("circuit breaker rate must be a percentage")
+	}
+
+	return &circuitBreaker{maxRate: maxRate, window: window, action: action}, nil
+}
+
+// record counts res and reports whether the breaker tripped.
+func (b *circuitBreaker) record(res mailcheck.Result) bool {
+	if b.maxRate == 0 {
+		return false
+	}
+
+	b.total++
+	if res.Verdict == mailcheck.VerdictUnknown && res.Reason == mailcheck.ReasonSenderIssue {
+		b.senderIssues++
+	}
+
+	return b.total >= b.window && b.rate() > b.maxRate
+}
+
+func (b *circuitBreaker) rate() float64 {
+	return float64(b.senderIssues) / float64(b.total) * 100
+}
+
+// proceed decides what to do after the breaker tripped. Pausing asks on the terminal whether to continue,
+// after which the breaker is disabled for the rest of the run.
+func (b *circuitBreaker) proceed() bool {
+	if b.action != breakerPause {
+		return false
+	}
+
+	// the terminal rather than stdin, which may be carrying input
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+
+	_, _ = fmt.Fprintf(tty, "%.0f%% of %d results were rejected because of the sender, continue anyway? [y/N] ", b.rate(), b.total)
+
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		return false
+	}
+
+	b.maxRate = 0
+	return true
+}
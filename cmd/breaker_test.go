@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"github.com/hazcod/mailcheck"
+	"github.com/hazcod/mailcheck/mailchecktest"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCircuitBreakerTrips(t *testing.T) {
+	if _, err := newCircuitBreaker(50, 4, "retry"); err == nil {
+		t.Error("expected an unknown action to be refused")
+	}
+	if _, err := newCircuitBreaker(150, 4, breakerAbort); err == nil {
+		t.Error("expected a rate over 100% to be refused")
+	}
+
+	breaker, err := newCircuitBreaker(50, 4, breakerAbort)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocked := mailcheck.Result{Verdict: mailcheck.VerdictUnknown, Reason: mailcheck.ReasonSenderIssue}
+	greylisted := mailcheck.Result{Verdict: mailcheck.VerdictUnknown, Reason: mailcheck.ReasonTemporary}
+	valid := mailcheck.Result{Verdict: mailcheck.VerdictValid}
+
+	// the rate is only judged once the window is full
+	for i, res := range []mailcheck.Result{blocked, blocked, blocked} {
+		if breaker.record(res) {
+			t.Fatalf("expected the breaker not to trip on result %d, before the window is full", i+1)
+		}
+	}
+
+	// other unknown results do not count, exactly half is not over the rate
+	for _, res := range []mailcheck.Result{greylisted, valid, valid} {
+		breaker.record(res)
+	}
+	if breaker.rate() != 50 {
+		t.Errorf("expected half the results to be sender issues, got %.0f%%", breaker.rate())
+	}
+
+	if !breaker.record(blocked) {
+		t.Errorf("expected the breaker to trip at %.0f%% sender issues", breaker.rate())
+	}
+
+	// aborting does not ask whether to go on
+	if breaker.proceed() {
+		t.Error("expected an aborting breaker not to proceed")
+	}
+
+	disabled, err := newCircuitBreaker(0, 1, breakerAbort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disabled.record(blocked) {
+		t.Error("expected a disabled breaker never to trip")
+	}
+}
+
+func TestBatchStopsWhenBlocked(t *testing.T) {
+	smtp, flags := lab(t)
+	smtp.SetMailFromReply(mailchecktest.Reply{Code: 554, Message: "5.7.1 Service unavailable; Client host blocked using zen.spamhaus.org"})
+
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := ioutil.WriteFile(input, []byte("a@lab.test\nb@lab.test\nc@lab.test\nd@lab.test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, stderr := runMailcheck(context.Background(), "",
+		append([]string{"batch", "-output", "json", "-input", input, "-blcheck=false", "-preflight=false",
+			"-breaker-window", "2"}, flags...)...)
+	if code != exitBlocked {
+		t.Errorf("expected exit code %d, got %d: %s", exitBlocked, code, stderr)
+	}
+
+	if got := emails(jsonRecords(t, stdout)); strings.Join(got, ",") != "a@lab.test,b@lab.test" {
+		t.Errorf("expected the run to stop once the window was full, got %v", got)
+	}
+	if !strings.Contains(stderr, "stopped after 2 of 4 addresses") {
+		t.Errorf("expected the stop to be logged, got %s", stderr)
+	}
+}
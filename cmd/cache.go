@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/hazcod/mailcheck"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// envAPIKey holds the api key sent to the -server of the cache subcommands.
+const envAPIKey = "MAILCHECK_API_KEY"
+
+// cacheStats is what is known about the caches: the DNS cache of a running server and the verifications in
+// its or a given database.
+type cacheStats struct {
+	DNSCache *mailcheck.DNSCacheStats `json:"dns_cache,omitempty"`
+	History  *mailcheck.StoreStats    `json:"history,omitempty"`
+}
+
+// cachePurged is how much a purge of the caches dropped.
+type cachePurged struct {
+	DNSAnswers    int   `json:"dns_answers"`
+	Verifications int64 `json:"verifications"`
+}
+
+// cacheFlags are the flags of the cache subcommands.
+type cacheFlags struct {
+	std       streams
+	db        string
+	server    string
+	output    string
+	olderThan time.Duration
+}
+
+// register defines the flags shared by the cache subcommands on flags.
+func (c *cacheFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.db, "db", "", "database written by -db: a SQLite file or a postgres:// url")
+	flags.StringVar(&c.server, "server", "", "url of a running mailcheck serve, or unix:///path/to.sock, with the api key in "+envAPIKey)
+	flags.StringVar(&c.output, "output", outputText, "result format written to stdout: text or json")
+}
+
+func newCacheCommand(std streams) *ffcli.Command {
+	stats := &cacheFlags{std: std}
+	statsFlags := flag.NewFlagSet("mailcheck cache stats", flag.ContinueOnError)
+	stats.register(statsFlags)
+
+	purge := &cacheFlags{std: std}
+	purgeFlags := flag.NewFlagSet("mailcheck cache purge", flag.ContinueOnError)
+	purge.register(purgeFlags)
+	purgeFlags.DurationVar(&purge.olderThan, "older-than", 0, "delete the verifications older than this duration, needed with -db")
+
+	return &ffcli.Command{
+		Name:       "cache",
+		ShortUsage: "mailcheck cache <stats|purge> [flags]",
+		ShortHelp:  "inspect or clear the DNS cache of a server and the recorded verifications",
+		LongHelp: "The DNS cache lives as long as the process, so only the one of a running mailcheck serve can be\n" +
+			"inspected or cleared, with -server. The verifications recorded with -db are the lasting cache.",
+		FlagSet: flag.NewFlagSet("mailcheck cache", flag.ContinueOnError),
+		Subcommands: []*ffcli.Command{
+			{
+				Name:       "stats",
+				ShortUsage: "mailcheck cache stats [-server <url>] [-db <dsn>] [flags]",
+				ShortHelp:  "show the use of the DNS cache of a server and the number of recorded verifications",
+				FlagSet:    statsFlags,
+				Exec:       stats.stats,
+			},
+			{
+				Name:       "purge",
+				ShortUsage: "mailcheck cache purge [-server <url>] [-db <dsn> -older-than <duration>] [flags]",
+				ShortHelp:  "clear the DNS cache of a server and delete old verifications",
+				LongHelp: "Clears the DNS cache of -server, and with -older-than deletes the verifications older than it from\n" +
+					"the database of the server. With -db the verifications are deleted from that database instead.",
+				FlagSet: purgeFlags,
+				Exec:    purge.purge,
+			},
+		},
+		Exec: func(context.Context, []string) error {
+			return flag.ErrHelp
+		},
+	}
+}
+
+// stats implements the cache stats subcommand.
+func (c *cacheFlags) stats(ctx context.Context, _ []string) error {
+	if c.db == "" && c.server == "" {
+		return flag.ErrHelp
+	}
+
+	results, err := newResultWriter(c.std.stdout, c.output)
+	if err != nil {
+		return usage(err)
+	}
+
+	var stats cacheStats
+	if c.server != "" {
+		if err := cacheRequest(ctx, c.server, http.MethodGet, nil, &stats); err != nil {
+			return err
+		}
+	}
+
+	if c.db != "" {
+		db, err := openExistingStore(c.db)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		history, err := db.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		stats.History = &history
+	}
+
+	var fields []string
+	if dns := stats.DNSCache; dns != nil {
+		fields = append(fields, fmt.Sprintf("dns cache: %d answers, %d hits of which %d negative, %d misses",
+			dns.Entries, dns.Hits, dns.NegativeHits, dns.Misses))
+	}
+	if history := stats.History; history != nil {
+		fields = append(fields, fmt.Sprintf("history: %d verifications", history.Verifications))
+
+		var verdicts []string
+		for verdict, count := range history.Verdicts {
+			verdicts = append(verdicts, fmt.Sprintf("%s %d", verdict, count))
+		}
+		sort.Strings(verdicts)
+		fields = append(fields, strings.Join(verdicts, ", "))
+
+		if history.Verifications > 0 {
+			fields = append(fields, history.Oldest.Format(time.RFC3339)+" to "+history.Newest.Format(time.RFC3339))
+		}
+	}
+
+	return results.writeLine(stats, fields...)
+}
+
+// purge implements the cache purge subcommand.
+func (c *cacheFlags) purge(ctx context.Context, _ []string) error {
+	if c.db == "" && c.server == "" {
+		return flag.ErrHelp
+	}
+
+	if c.olderThan < 0 || (c.db != "" && c.olderThan == 0) {
+		return usage(errors.New("-older-than must be a positive duration with -db, such as 720h"))
+	}
+
+	results, err := newResultWriter(c.std.stdout, c.output)
+	if err != nil {
+		return usage(err)
+	}
+
+	var purged cachePurged
+	if c.server != "" {
+		query := url.Values{}
+		if c.olderThan > 0 && c.db == "" {
+			query.Set("older_than", c.olderThan.String())
+		}
+
+		if err := cacheRequest(ctx, c.server, http.MethodDelete, query, &purged); err != nil {
+			return err
+		}
+	}
+
+	if c.db != "" {
+		db, err := openExistingStore(c.db)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		if purged.Verifications, err = db.Purge(ctx, time.Now().Add(-c.olderThan)); err != nil {
+			return err
+		}
+	}
+
+	return results.writeLine(purged, fmt.Sprintf("purged %d dns answers", purged.DNSAnswers),
+		fmt.Sprintf("%d verifications", purged.Verifications))
+}
+
+// cacheRequest sends a request for /v1/cache to server, the url of a running mailcheck serve or unix:// followed
+// by the path of its socket, and decodes the response into v.
+func cacheRequest(ctx context.Context, server, method string, query url.Values, v interface{}) error {
+	client := &http.Client{Timeout: time.Second * 30}
+
+	base := strings.TrimSuffix(server, "/")
+	if strings.HasPrefix(server, unixScheme) {
+		path := strings.TrimPrefix(server, unixScheme)
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}
+		base = "http://mailcheck"
+	} else if parsed, err := url.Parse(base); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return usage(errors.Errorf("invalid server '%s', expected an http or https url or unix:///path/to.sock", server))
+	}
+
+	target := base + "/v1/cache"
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return errors.Wrap(err, "could not build request")
+	}
+	if key := os.Getenv(envAPIKey); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not reach server")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&apiErr)
+		return errors.Errorf("server answered %s: %s", res.Status, apiErr.Error)
+	}
+
+	return errors.Wrap(json.NewDecoder(res.Body).Decode(v), "invalid response")
+}
+
+// handleCache serves GET /v1/cache with the use of the DNS cache and the verifications in the database, and
+// DELETE /v1/cache, which clears the DNS cache and with older_than deletes the verifications older than it.
+// Clearing affects every client and deletes history, so it takes an admin key, without api keys nobody can.
+func (s *server) handleCache(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		dns := s.checker.DNSCacheStats()
+		stats := cacheStats{DNSCache: &dns}
+
+		if s.store != nil {
+			history, err := s.store.Stats(r.Context())
+			if err != nil {
+				log.Errorf("could not count verifications: %v", err)
+				writeError(w, http.StatusInternalServerError, "could not count verifications")
+				return
+			}
+			stats.History = &history
+		}
+
+		writeJSON(w, http.StatusOK, stats)
+
+	case http.MethodDelete:
+		if key, ok := r.Context().Value(apiKeyContextKey{}).(*apiKey); !ok || !key.Admin {
+			writeError(w, http.StatusForbidden, "clearing the cache takes an admin key, see api_keys in -config")
+			return
+		}
+
+		var olderThan time.Duration
+		if value := r.URL.Query().Get("older_than"); value != "" {
+			var err error
+			if olderThan, err = time.ParseDuration(value); err != nil || olderThan <= 0 {
+				writeError(w, http.StatusBadRequest, "older_than must be a positive duration, such as 720h")
+				return
+			}
+
+			if s.store == nil {
+				writeError(w, http.StatusBadRequest, "no verifications are recorded, the server runs without -db")
+				return
+			}
+		}
+
+		purged := cachePurged{DNSAnswers: s.checker.PurgeDNSCache()}
+
+		if olderThan > 0 {
+			var err error
+			if purged.Verifications, err = s.store.Purge(r.Context(), time.Now().Add(-olderThan)); err != nil {
+				log.Errorf("could not purge verifications: %v", err)
+				writeError(w, http.StatusInternalServerError, "could not purge verifications")
+				return
+			}
+		}
+
+		log.Infof("purged %d dns answers and %d verifications", purged.DNSAnswers, purged.Verifications)
+		writeJSON(w, http.StatusOK, purged)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "use GET or DELETE")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/hazcod/mailcheck"
+	"github.com/hazcod/mailcheck/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheNeedsAdminKey(t *testing.T) {
+	checker := mailcheck.New(mailcheck.Options{})
+	defer checker.Close()
+	s := &server{checker: checker}
+
+	request := func(handler http.Handler, method, key string) int {
+		req := httptest.NewRequest(method, "/v1/cache", nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	// without api keys anyone reaching the server could wipe the history
+	open := newAPIKeys(nil).wrap(http.HandlerFunc(s.handleCache))
+	if code := request(open, http.MethodGet, ""); code != http.StatusOK {
+		t.Errorf("expected the stats to be served without api keys, got %d", code)
+	}
+	if code := request(open, http.MethodDelete, ""); code != http.StatusForbidden {
+		t.Errorf("expected clearing to be refused without api keys, got %d", code)
+	}
+
+	keyed := newAPIKeys([]config.APIKey{{Name: "ops", Key: "admin-secret", Admin: true}, {Name: "marketing", Key: "secret"}}).
+		wrap(http.HandlerFunc(s.handleCache))
+	if code := request(keyed, http.MethodDelete, "secret"); code != http.StatusForbidden {
+		t.Errorf("expected clearing to be refused with a key that is not admin, got %d", code)
+	}
+	if code := request(keyed, http.MethodDelete, "admin-secret"); code != http.StatusOK {
+		t.Errorf("expected an admin key to clear the cache, got %d", code)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	log "github.com/sirupsen/logrus"
+)
+
+// stdinArg in place of an address reads addresses from stdin.
+const stdinArg = "-"
+
+func newCheckCommand(std streams) *ffcli.Command {
+	flags := flag.NewFlagSet("mailcheck check", flag.ContinueOnError)
+	global := newGlobalFlags(flags, std)
+	strict := flags.Bool("strict", false, "treat unknown results as invalid in the exit code")
+
+	return &ffcli.Command{
+		Name:       "check",
+		ShortUsage: "mailcheck check [flags] <email|-> ...",
+		ShortHelp:  "check one or more addresses",
+		LongHelp: "Checks the given addresses in order, - reads them from stdin one per line. The exit code is 0 when all are valid, 1 when any is invalid,\n" +
+			"2 when any is unknown, 3 on a usage error and 4 when our IP appears blocked.",
+		FlagSet: flags,
+		Exec: func(ctx context.Context, emails []string) error {
+			return runCheck(ctx, global, *strict, emails)
+		},
+	}
+}
+
+// runCheck implements the check subcommand.
+func runCheck(ctx context.Context, global *globalFlags, strict bool, emails []string) error {
+	if len(emails) == 0 {
+		return flag.ErrHelp
+	}
+
+	results, err := global.results()
+	if err != nil {
+		return err
+	}
+
+	checker, err := global.checker()
+	if err != nil {
+		return err
+	}
+	defer checker.Close()
+
+	db, err := global.store()
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	ctx, cancel := global.withTimeoutTotal(ctx)
+	defer cancel()
+
+	status := &exitStatus{strict: strict}
+	var checked int
+	check := func(email string) error {
+		addressCtx, cancel := context.WithTimeout(ctx, global.timeoutPerAddress)
+		res := checker.Check(addressCtx, email)
+		cancel()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.WithFields(attemptFields(res.Attempts)).Debugf("%s is %s", email, res.Verdict)
+
+		if err := report(results, res, global.transcript, db); err != nil {
+			return err
+		}
+
+		status.record(res)
+		checked++
+		return nil
+	}
+
+	for _, arg := range emails {
+		if arg != stdinArg {
+			err = check(arg)
+		} else {
+			// addresses are checked as they arrive, so mailcheck can be a stage in a pipeline
+			err = streamAddresses(ctx, global.std.stdin, check)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		// the addresses left unchecked are unknown
+		log.Warnf("stopped after %d addresses: %v", checked, ctx.Err())
+		status.unknown = true
+	} else if err != nil {
+		return err
+	}
+
+	return status.err()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/hazcod/mailcheck"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+)
+
+// checkpoint records the addresses a batch run completed, one JSON line each, so an interrupted run can be resumed.
+type checkpoint struct {
+	file *os.File
+	done map[string]checkpointEntry
+}
+
+type checkpointEntry struct {
+	Email   string            `json:"email"`
+	Verdict mailcheck.Verdict `json:"verdict"`
+	Reason  mailcheck.Reason  `json:"reason,omitempty"`
+}
+
+// openCheckpoint opens the checkpoint at path. When resuming, the addresses it holds are completed already,
+// otherwise it is started afresh.
+func openCheckpoint(path string, resume bool) (*checkpoint, error) {
+	flags := os.O_RDWR | os.O_CREATE | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open checkpoint")
+	}
+
+	c := &checkpoint{file: file, done: map[string]checkpointEntry{}}
+	if err := c.load(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// load reads the completed addresses.
+func (c *checkpoint) load() error {
+	reader := bufio.NewReader(c.file)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a run killed halfway through writing leaves an incomplete last line, it is checked again
+			if len(line) > 0 {
+				log.Warn("ignoring incomplete last line of checkpoint")
+				return errors.Wrap(c.terminate(), "could not repair checkpoint")
+			}
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not read checkpoint")
+		}
+
+		// an incomplete line repaired by an earlier resume
+		var entry checkpointEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			log.Warnf("ignoring invalid line of checkpoint: %v", err)
+			continue
+		}
+		c.done[entry.Email] = entry
+	}
+}
+
+// terminate ends an incomplete last line so the next entry starts on a line of its own.
+func (c *checkpoint) terminate() error {
+	_, err := c.file.Write([]byte("\n"))
+	return err
+}
+
+// completed returns the entry of email when a previous run completed it.
+func (c *checkpoint) completed(email string) (checkpointEntry, bool) {
+	entry, ok := c.done[email]
+	return entry, ok
+}
+
+// record adds the result for email, in a single write so an entry is either there or incomplete.
+func (c *checkpoint) record(email string, res mailcheck.Result) error {
+	line, err := json.Marshal(checkpointEntry{Email: email, Verdict: res.Verdict, Reason: res.Reason})
+	if err != nil {
+		return errors.Wrap(err, "could not encode checkpoint entry")
+	}
+
+	_, err = c.file.Write(append(line, '\n'))
+	return errors.Wrap(err, "could not write checkpoint")
+}
+
+func (c *checkpoint) Close() error {
+	return c.file.Close()
+}
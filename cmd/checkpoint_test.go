@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"github.com/hazcod/mailcheck"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.state")
+
+	state, err := openCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for email, verdict := range map[string]mailcheck.Verdict{"a@example.com": mailcheck.VerdictValid, "b@example.com": mailcheck.VerdictInvalid} {
+		if err := state.record(email, mailcheck.Result{Verdict: verdict}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the run is killed halfway through writing an entry
+	if _, err := state.file.WriteString(`{"email":"c@exa`); err != nil {
+		t.Fatal(err)
+	}
+	_ = state.Close()
+
+	state, err = openCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := state.completed("b@example.com"); !ok || entry.Verdict != mailcheck.VerdictInvalid {
+		t.Errorf("expected b@example.com to be completed as invalid, got %v", entry)
+	}
+	if _, ok := state.completed("c@example.com"); ok {
+		t.Error("expected the incomplete entry to be checked again")
+	}
+	if err := state.record("c@example.com", mailcheck.Result{Verdict: mailcheck.VerdictUnknown}); err != nil {
+		t.Fatal(err)
+	}
+	_ = state.Close()
+
+	// the repaired line is skipped, the entry after it is on a line of its own
+	state, err = openCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if _, ok := state.completed(email); !ok {
+			t.Errorf("expected %s to be completed", email)
+		}
+	}
+	_ = state.Close()
+
+	// without resuming, the run starts afresh
+	state, err = openCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	if _, ok := state.completed("a@example.com"); ok {
+		t.Error("expected a fresh checkpoint")
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Errorf("expected the checkpoint to be emptied, got %v", info)
+	}
+}
+
+func TestBatchResume(t *testing.T) {
+	smtp, flags := lab(t)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	if err := ioutil.WriteFile(input, []byte("valid@lab.test\nnobody@lab.test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// an earlier run completed nobody@lab.test
+	path := filepath.Join(dir, "run.state")
+	state, err := openCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.record("nobody@lab.test", mailcheck.Result{Verdict: mailcheck.VerdictInvalid, Reason: mailcheck.ReasonUserUnknown}); err != nil {
+		t.Fatal(err)
+	}
+	_ = state.Close()
+
+	code, stdout, stderr := runMailcheck(context.Background(), "",
+		append([]string{"batch", "-output", "json", "-input", input, "-blcheck=false", "-preflight=false",
+			"-checkpoint", path, "-resume"}, flags...)...)
+
+	// the completed address counts towards the exit code, but is neither probed nor reported again
+	if code != exitInvalid {
+		t.Errorf("expected exit code %d, got %d: %s", exitInvalid, code, stderr)
+	}
+	if got := emails(jsonRecords(t, stdout)); strings.Join(got, ",") != "valid@lab.test" {
+		t.Errorf("expected only the address left to check, got %v", got)
+	}
+	for _, command := range smtp.Commands() {
+		if strings.Contains(command, "nobody@lab.test") {
+			t.Errorf("expected the completed address not to be probed, got %s", command)
+		}
+	}
+
+	state, err = openCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+
+	if entry, ok := state.completed("valid@lab.test"); !ok || entry.Verdict != mailcheck.VerdictValid {
+		t.Errorf("expected the checked address to be recorded as valid, got %v", entry)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultProbesPerMinute is the default limit of probes per domain, low enough not to bother anyone's mail server
+	defaultProbesPerMinute = 10
+
+	consentNotice = `mailcheck verifies addresses by starting to deliver mail to the mail servers of their domains.
+Only check addresses you have a legitimate reason to, such as your own lists, and keep the rate limits in place
+when probing servers you do not operate: aggressive probing gets your IP blocklisted and may breach the terms of
+mail providers. Pass -i-own-this-list to skip this notice and the default rate limit for lists you are responsible for.
+`
+)
+
+// consentPath is the file recording that the notice was acknowledged.
+func consentPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errors.Wrap(err, "could not locate configuration directory")
+	}
+
+	return filepath.Join(dir, "mailcheck", "consent"), nil
+}
+
+// ensureConsent asks once, on the terminal, to acknowledge how mailcheck should be used before it probes any server.
+func ensureConsent() error {
+	path, err := consentPath()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	// the terminal rather than stdin, which may be carrying input
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return errors.New("mailcheck has to be acknowledged once on a terminal before probing, or run with -i-own-this-list")
+	}
+	defer tty.Close()
+
+	_, _ = fmt.Fprintf(tty, "%s\nType yes to acknowledge: ", consentNotice)
+
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	if !strings.EqualFold(strings.TrimSpace(answer), "yes") {
+		return errors.New("not acknowledged")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "could not record consent")
+	}
+
+	return errors.Wrap(ioutil.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0600), "could not record consent")
+}
+
+// probesPerMinute returns the rate limit to use, lifting the default for owned lists unless a limit was given.
+func probesPerMinute(flags *flag.FlagSet, limit int, owned bool) int {
+	if !owned {
+		return limit
+	}
+
+	explicit := false
+	flags.Visit(func(f *flag.Flag) {
+		explicit = explicit || f.Name == "rate-per-domain"
+	})
+
+	if explicit {
+		return limit
+	}
+
+	return 0
+}
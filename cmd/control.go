@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/mailcheck"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// batchStats is what a running batch dumps on dumpSignal.
+type batchStats struct {
+	progressStatus
+	Elapsed  float64                 `json:"elapsed_seconds"`
+	Limits   mailcheck.Limits        `json:"limits"`
+	DNSCache mailcheck.DNSCacheStats `json:"dns_cache"`
+}
+
+// control lets a running batch be controlled with signals until ctx is done: dumpSignal writes its progress and
+// statistics to stderr, debugSignal toggles debug logging and reloadSignal reloads the limits from -config.
+func (b *batchFlags) control(ctx context.Context, checker *mailcheck.Checker, progress *progress) {
+	if dumpSignal == nil {
+		return
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, dumpSignal, debugSignal, reloadSignal)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		// the level to return to when debug logging is toggled off
+		level := log.GetLevel()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signals:
+				switch sig {
+				case dumpSignal:
+					dumpStats(b.std.stderr, checker, progress)
+				case debugSignal:
+					if log.IsLevelEnabled(log.DebugLevel) {
+						log.Infof("%s, debug logging off", sig)
+						log.SetLevel(level)
+					} else {
+						level = log.GetLevel()
+						log.SetLevel(log.DebugLevel)
+						log.Infof("%s, debug logging on", sig)
+					}
+				case reloadSignal:
+					if err := b.reloadLimits(checker); err != nil {
+						log.Errorf("%s, could not reload limits: %v", sig, err)
+						continue
+					}
+					limits := checker.Limits()
+					log.Infof("%s, reloaded limits: %d probes per minute per domain, racing %d mail servers, %d recipients per session",
+						sig, limits.ProbesPerMinute, limits.RaceMailServers, limits.MaxRcptPerSession)
+				}
+			}
+		}
+	}()
+}
+
+// dumpStats writes the progress and statistics of the run to w as a JSON line.
+func dumpStats(w io.Writer, checker *mailcheck.Checker, progress *progress) {
+	status, started := progress.snapshot()
+
+	line, err := json.Marshal(batchStats{
+		progressStatus: status,
+		Elapsed:        time.Since(started).Round(time.Millisecond).Seconds(),
+		Limits:         checker.Limits(),
+		DNSCache:       checker.DNSCacheStats(),
+	})
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	progress.clear()
+	_, _ = fmt.Fprintln(w, string(line))
+}
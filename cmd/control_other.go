@@ -0,0 +1,15 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"os"
+)
+
+// there are no signals to control a running batch with on these platforms
+var (
+	dumpSignal   os.Signal
+	debugSignal  os.Signal
+	reloadSignal os.Signal
+)
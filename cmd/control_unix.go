@@ -0,0 +1,16 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// the signals controlling a running batch
+var (
+	dumpSignal   os.Signal = syscall.SIGUSR1
+	debugSignal  os.Signal = syscall.SIGUSR2
+	reloadSignal os.Signal = syscall.SIGHUP
+)
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"github.com/hazcod/mailcheck"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// emailRegex loosely matches addresses wherever they appear in results and transcripts.
+var emailRegex = regexp.MustCompile(`[^\s<>@"':;,()\[\]]+@[A-Za-z0-9.-]+`)
+
+func newExportCorpusCommand(std streams) *ffcli.Command {
+	flags := flag.NewFlagSet("mailcheck export-corpus", flag.ContinueOnError)
+	dir := flags.String("transcripts", "", "directory with transcripts written by -transcript")
+	since := flags.Duration("since", time.Hour*24*7, "only export transcripts written within this duration")
+	verdicts := flags.String("verdicts", string(mailcheck.VerdictUnknown), "comma separated verdicts considered problematic")
+	salt := flags.String("salt", "", "salt for hashing, random by default so hashes cannot be matched across exports")
+
+	return &ffcli.Command{
+		Name:       "export-corpus",
+		ShortUsage: "mailcheck export-corpus -transcripts <dir> [flags]",
+		ShortHelp:  "bundle anonymized transcripts for bug reports",
+		FlagSet:    flags,
+		Exec: func(context.Context, []string) error {
+			return runExportCorpus(std.stdout, *dir, *since, *verdicts, *salt)
+		},
+	}
+}
+
+// runExportCorpus implements the export-corpus subcommand, which bundles recent problematic transcripts
+// for attaching to bug reports. Local parts of addresses are replaced by salted hashes so no mailbox
+// can be identified, while domains and mail server hostnames are kept to be able to reproduce.
+func runExportCorpus(stdout io.Writer, dir string, since time.Duration, verdicts, salt string) error {
+	if dir == "" {
+		return flag.ErrHelp
+	}
+
+	if salt == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return err
+		}
+		salt = hex.EncodeToString(random)
+	}
+
+	problematic := map[mailcheck.Verdict]bool{}
+	for _, verdict := range splitList(verdicts) {
+		problematic[mailcheck.Verdict(verdict)] = true
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "could not read transcripts")
+	}
+
+	results, err := newResultWriter(stdout, outputJSON)
+	if err != nil {
+		return err
+	}
+
+	exported := 0
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" || time.Since(file.ModTime()) > since {
+			continue
+		}
+
+		res, err := readTranscript(filepath.Join(dir, file.Name()))
+		if err != nil {
+			log.Warnf("skipping %s: %v", file.Name(), err)
+			continue
+		}
+
+		if !problematic[res.Verdict] {
+			continue
+		}
+
+		if err := results.Write(anonymize(res, salt)); err != nil {
+			return err
+		}
+		exported++
+	}
+
+	log.Infof("exported %d transcripts", exported)
+	return nil
+}
+
+func readTranscript(path string) (res mailcheck.Result, err error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return res, errors.Wrap(err, "could not read transcript")
+	}
+
+	return res, errors.Wrap(json.Unmarshal(contents, &res), "could not parse transcript")
+}
+
+// anonymize hashes the local part of every address in res.
+func anonymize(res mailcheck.Result, salt string) mailcheck.Result {
+	hash := func(text string) string {
+		return emailRegex.ReplaceAllStringFunc(text, func(email string) string {
+			at := strings.LastIndex(email, "@")
+			sum := sha256.Sum256([]byte(salt + strings.ToLower(email[:at])))
+			return "anon-" + hex.EncodeToString(sum[:6]) + email[at:]
+		})
+	}
+
+	res.Email = hash(res.Email)
+	res.Suggestion = hash(res.Suggestion)
+	res.Error = hash(res.Error)
+	res.Response = hash(res.Response)
+
+	transcript := make([]mailcheck.Exchange, len(res.Transcript))
+	for i, exchange := range res.Transcript {
+		exchange.Command = hash(exchange.Command)
+		exchange.Response = hash(exchange.Response)
+		exchange.Error = hash(exchange.Error)
+		transcript[i] = exchange
+	}
+	res.Transcript = transcript
+
+	return res
+}
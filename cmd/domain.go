@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/hazcod/mailcheck"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"strings"
+)
+
+func newDomainCommand(std streams) *ffcli.Command {
+	flags := flag.NewFlagSet("mailcheck domain", flag.ContinueOnError)
+	global := newGlobalFlags(flags, std)
+
+	return &ffcli.Command{
+		Name:       "domain",
+		ShortUsage: "mailcheck domain [flags] <domain> ...",
+		ShortHelp:  "show how domains handle mail",
+		LongHelp: "Looks up the mail servers and SPF and DMARC records of every domain, and tells whether it is\n" +
+			"disposable or a free provider. At -level smtp and deep it also probes whether the domain is catch-all\n" +
+			"and with -dane verifies its mail servers against their TLSA records, which only domain checks do.",
+		FlagSet: flags,
+		Exec: func(ctx context.Context, domains []string) error {
+			return runDomain(ctx, global, domains)
+		},
+	}
+}
+
+// runDomain implements the domain subcommand.
+func runDomain(ctx context.Context, global *globalFlags, domains []string) error {
+	if len(domains) == 0 {
+		return flag.ErrHelp
+	}
+
+	results, err := global.results()
+	if err != nil {
+		return err
+	}
+
+	checker, err := global.checker()
+	if err != nil {
+		return err
+	}
+	defer checker.Close()
+
+	ctx, cancel := global.withTimeoutTotal(ctx)
+	defer cancel()
+
+	for _, domain := range domains {
+		domainCtx, cancel := context.WithTimeout(ctx, global.timeoutPerAddress)
+		res := checker.CheckDomain(domainCtx, domain)
+		cancel()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		suggestion := ""
+		if res.Suggestion != "" {
+			suggestion = "did you mean " + res.Suggestion + "?"
+		}
+
+		if err := results.writeLine(res, res.Domain, strings.Join(res.MX, ","), strings.Join(domainKinds(res), ","), res.Error, suggestion); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// domainKinds lists what sets the domain of res apart, as in the text output.
+func domainKinds(res mailcheck.DomainResult) (kinds []string) {
+	if res.CatchAll != nil && *res.CatchAll {
+		kinds = append(kinds, "catch_all")
+	}
+	if res.Disposable {
+		kinds = append(kinds, "disposable")
+	}
+	if res.FreeProvider {
+		kinds = append(kinds, "free_provider")
+	}
+	if res.Auth != nil && res.Auth.SPF {
+		kinds = append(kinds, "spf")
+	}
+	if res.Auth != nil && res.Auth.DMARC {
+		kinds = append(kinds, "dmarc")
+	}
+	if res.Provider != "" {
+		kinds = append(kinds, string(res.Provider))
+	}
+	if res.DNSSEC != "" {
+		kinds = append(kinds, dnssecKind(res.DNSSEC))
+	}
+	if dane := daneKind(res.DANE); dane != "" {
+		kinds = append(kinds, dane)
+	}
+	// a single mail server with a bad certificate flags the domain
+	var worst mailcheck.TLSCertificate
+	for _, cert := range res.TLS {
+		worst.Expired = worst.Expired || cert.Expired
+		worst.SelfSigned = worst.SelfSigned || cert.SelfSigned
+	}
+	kinds = append(kinds, tlsKinds(worst)...)
+
+	return kinds
+}
+
+// daneKind summarizes the DANE outcome of the mail servers of a domain: dane when every protected server
+// passed, dane_failed when any failed, empty when none is protected.
+func daneKind(results []mailcheck.DANEResult) string {
+	kind := ""
+	for _, res := range results {
+		switch {
+		case !res.Protected:
+		case !res.Valid:
+			return "dane_failed"
+		default:
+			kind = "dane"
+		}
+	}
+
+	return kind
+}
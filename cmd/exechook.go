@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/hazcod/mailcheck"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// execHook runs a program for every result, with the result as JSON on stdin and its outline in the environment.
+// Checks wait for it, so it should be quick or hand its work off.
+type execHook struct {
+	path    string
+	timeout time.Duration
+	// stderr receives anything the hook prints
+	stderr io.Writer
+}
+
+// run runs the hook for res, logging a failure. Anything the hook prints goes to stderr, stdout holds the results.
+func (h execHook) run(res mailcheck.Result) {
+	if err := h.exec(res); err != nil {
+		log.Warnf("exec hook failed for %s: %v", res.Email, err)
+	}
+}
+
+func (h execHook) exec(res mailcheck.Result) error {
+	input, err := json.Marshal(res)
+	if err != nil {
+		return errors.Wrap(err, "could not encode result")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = h.stderr
+	cmd.Stderr = h.stderr
+	cmd.Env = append(os.Environ(),
+		"MAILCHECK_EMAIL="+res.Email,
+		"MAILCHECK_VERDICT="+string(res.Verdict),
+		"MAILCHECK_REASON="+string(res.Reason),
+		"MAILCHECK_SCORE="+strconv.Itoa(res.Score),
+	)
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return errors.Errorf("timed out after %s", h.timeout)
+		}
+		return err
+	}
+
+	return nil
+}
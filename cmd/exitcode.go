@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hazcod/mailcheck"
+)
+
+// The exit codes are a contract with scripts, they cannot change.
+const (
+	// exitValid means every address is valid.
+	exitValid = 0
+	// exitInvalid means at least one address is invalid, or unknown with -strict.
+	exitInvalid = 1
+	// exitUnknown means at least one address could not be verified, or the run could not complete.
+	exitUnknown = 2
+	// exitUsage means the command line or the configuration is wrong.
+	exitUsage = 3
+	// exitBlocked means our IP appears to be blocked, so the results cannot be trusted.
+	exitBlocked = 4
+)
+
+// exitCode is returned by a command to end the process with that code, without logging an error.
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit code %d", int(c))
+}
+
+// usageError is a mistake on the command line or in the configuration.
+type usageError struct {
+	error
+}
+
+func (e usageError) Unwrap() error {
+	return e.error
+}
+
+// usage marks err as a usage error, nil stays nil.
+func usage(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return usageError{err}
+}
+
+// exitStatus derives the exit code of a run from its results.
+type exitStatus struct {
+	// strict counts unknown results as invalid
+	strict  bool
+	invalid bool
+	unknown bool
+	blocked bool
+}
+
+func (s *exitStatus) record(res mailcheck.Result) {
+	switch {
+	case res.Reason == mailcheck.ReasonSenderIssue:
+		s.blocked = true
+	case res.Verdict == mailcheck.VerdictInvalid, res.Verdict == mailcheck.VerdictUnknown && s.strict:
+		s.invalid = true
+	case res.Verdict == mailcheck.VerdictUnknown:
+		s.unknown = true
+	}
+}
+
+// err returns the exit code of the run as an error, nil when every address is valid.
+// A blocked IP outweighs invalid addresses, which outweigh unknown ones.
+func (s *exitStatus) err() error {
+	switch {
+	case s.blocked:
+		return exitCode(exitBlocked)
+	case s.invalid:
+		return exitCode(exitInvalid)
+	case s.unknown:
+		return exitCode(exitUnknown)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"github.com/hazcod/mailcheck/esp"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+const (
+	envMailchimpAPIKey    = "MAILCHIMP_API_KEY"
+	envSendGridAPIKey     = "SENDGRID_API_KEY"
+	envHubSpotAccessToken = "HUBSPOT_ACCESS_TOKEN"
+)
+
+// openExport returns the platform selected with -export, nil when there is none. Without file the addresses are
+// imported through the API of the platform, which takes the credentials from the environment.
+func openExport(name, list, file string) (esp.Platform, error) {
+	var platform esp.Platform
+	var secret, env string
+
+	switch name {
+	case "":
+		return nil, nil
+	case "mailchimp":
+		secret, env = os.Getenv(envMailchimpAPIKey), envMailchimpAPIKey
+		if file == "" && list == "" {
+			return nil, errors.New("importing into mailchimp needs the audience id in -export-list")
+		}
+		platform = esp.NewMailchimp(secret, list)
+	case "sendgrid":
+		secret, env = os.Getenv(envSendGridAPIKey), envSendGridAPIKey
+		platform = esp.NewSendGrid(secret, splitList(list)...)
+	case "hubspot":
+		secret, env = os.Getenv(envHubSpotAccessToken), envHubSpotAccessToken
+		platform = esp.NewHubSpot(secret)
+	default:
+		return nil, errors.Errorf("unknown export '%s', expected mailchimp, sendgrid or hubspot", name)
+	}
+
+	if file == "" && secret == "" {
+		return nil, errors.Errorf("-export %s needs -export-file or a token in %s", name, env)
+	}
+
+	return platform, nil
+}
+
+// export writes emails to file in the import format of platform, or imports them through its API without file.
+func export(platform esp.Platform, file string, emails []string) error {
+	if file != "" {
+		out, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return errors.Wrap(err, "could not create export")
+		}
+
+		if err := platform.WriteCSV(out, emails); err != nil {
+			_ = out.Close()
+			return err
+		}
+
+		log.Infof("exported %d addresses to %s", len(emails), file)
+		return errors.Wrap(out.Close(), "could not write export")
+	}
+
+	// the run context may be cancelled already, the addresses checked until then are worth importing
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+	defer cancel()
+
+	if err := platform.Import(ctx, emails); err != nil {
+		return err
+	}
+
+	log.Infof("imported %d addresses", len(emails))
+	return nil
+}
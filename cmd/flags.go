@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/hazcod/mailcheck"
+	"github.com/hazcod/mailcheck/config"
+	"github.com/hazcod/mailcheck/store"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// envSMTPPassword holds the password for the smarthost, which keeps it out of the process list
+	envSMTPPassword      = "MAILCHECK_SMTP_PASSWORD"
+	envHIBPAPIKey        = "MAILCHECK_HIBP_API_KEY"
+	defaultSmarthostPort = 587
+)
+
+// globalFlags are the flags of every subcommand that checks addresses, so they behave the same everywhere.
+type globalFlags struct {
+	flags *flag.FlagSet
+	std   streams
+
+	config            string
+	level             string
+	timeoutPerAddress time.Duration
+	timeoutTotal      time.Duration
+	stageBudget       string
+	retries           int
+	backoff           time.Duration
+	jitter            bool
+	ratePerDomain     int
+	owned             bool
+	ports             string
+	dnsHosts          string
+	dnsCacheSize      int
+	maxRcpt           int
+	sessionIdle       time.Duration
+	raceMX            int
+	smarthost         string
+	smtpUser          string
+	smtpPass          string
+	smarthostInsecure bool
+	sessionLifetime   time.Duration
+	roleList          string
+	output            string
+	format            string
+	transcript        string
+	db                string
+	auditLog          string
+	execHook          string
+	execHookTimeout   time.Duration
+	useVRFY           bool
+	exchangeOnline    bool
+	fast              bool
+	dane              bool
+	inspectTLS        bool
+	dnssec            bool
+	domainBlocklists  string
+	allowDomains      string
+	blockDomains      string
+	blockTLDs         string
+	domainAge         bool
+	youngDomainAge    time.Duration
+	enrich            string
+	spamtraps         bool
+	trapDomains       string
+	hibp              bool
+	// recordTranscripts records the SMTP transcripts without -transcript, for the repl to show
+	recordTranscripts bool
+	// opened is the store of -db once opened, shared by the checker and the command
+	opened mailcheck.Store
+	// flagLimits are the limits given by the flags, which the limits of the configuration file override
+	flagLimits mailcheck.Limits
+}
+
+// newGlobalFlags defines the global flags on flags.
+func newGlobalFlags(flags *flag.FlagSet, std streams) *globalFlags {
+	g := &globalFlags{flags: flags, std: std}
+
+	flags.StringVar(&g.config, "config", "", "path to an optional yaml configuration file")
+	flags.StringVar(&g.level, "level", string(mailcheck.LevelSMTP), "how deep to check: syntax, dns, smtp or deep, which adds catch-all and disposable checks")
+	flags.DurationVar(&g.timeoutPerAddress, "timeout-per-address", time.Second*30, "maximum time to spend verifying a single address")
+	flags.DurationVar(&g.timeoutTotal, "timeout-total", 0, "maximum time for the whole run, 0 for no limit")
+	flags.StringVar(&g.stageBudget, "stage-budget", "", "comma separated time limits per address and stage, e.g. dns=5s,connect=10s,tls=5s,smtp=10s")
+	flags.IntVar(&g.retries, "retries", 0, "number of retries per stage on transient errors")
+	flags.DurationVar(&g.backoff, "backoff", time.Second*2, "delay before the first retry, doubled on every next retry")
+	flags.BoolVar(&g.jitter, "jitter", false, "randomize the retry delay")
+	flags.IntVar(&g.ratePerDomain, "rate-per-domain", defaultProbesPerMinute, "maximum probes per minute to the mail servers of a single domain, 0 for no limit, rate_per_domain in the limits of -config")
+	flags.BoolVar(&g.owned, "i-own-this-list", false, "skip the usage notice and lift the default rate limit, for lists you are responsible for")
+	flags.StringVar(&g.ports, "ports", "25,465,587", "comma separated ports to try on every mail server, in order")
+	flags.StringVar(&g.dnsHosts, "dns-hosts", "", "path to a hosts file with static entries that take precedence over DNS")
+	flags.IntVar(&g.dnsCacheSize, "dns-cache-size", 10000, "number of DNS answers to cache for as long as their TTL allows, 0 to disable the cache")
+	flags.IntVar(&g.maxRcpt, "max-rcpt-per-session", 10, "number of addresses to probe over one SMTP session, 1 to reconnect for every address, max_rcpt_per_session in the limits of -config")
+	flags.IntVar(&g.raceMX, "race-mx", 0, "number of mail servers of a domain to dial at the same time, using the first to answer, race_mx in the limits of -config")
+	flags.DurationVar(&g.sessionIdle, "session-idle-timeout", time.Second*30, "time after which an unused SMTP session is closed")
+	flags.DurationVar(&g.sessionLifetime, "session-max-lifetime", time.Minute*5, "time after which an SMTP session is closed, however busy")
+	flags.StringVar(&g.roleList, "role-list", "", "file with the local parts to classify as role accounts, one per line")
+	flags.StringVar(&g.output, "output", outputText, "result format written to stdout: text or json")
+	flags.StringVar(&g.format, "format", "", "go template to format every result with instead of -output, e.g. '{{.Email}},{{.Verdict}},{{.Code}}'")
+	flags.StringVar(&g.smarthost, "smarthost", "", "host:port of an authenticated relay to send probes through instead of the mail servers, for hosts without port 25 egress")
+	flags.StringVar(&g.smtpUser, "smtp-user", "", "username for the smarthost")
+	flags.StringVar(&g.smtpPass, "smtp-pass", "", "password for the smarthost, read from "+envSMTPPassword+" when empty")
+	flags.BoolVar(&g.smarthostInsecure, "smarthost-insecure", false, "do not verify the certificate of the smarthost, for testing")
+	flags.StringVar(&g.transcript, "transcript", "", "directory to write the SMTP transcript of every address to")
+	flags.BoolVar(&g.useVRFY, "use-vrfy", false, "ask servers advertising VRFY or EXPN about addresses RCPT TO left ambiguous")
+	flags.BoolVar(&g.exchangeOnline, "exchange-online", false, "confirm addresses accepted by Exchange Online with a probe of an address that cannot exist, as it accepts any recipient by default")
+	flags.BoolVar(&g.fast, "fast", false, "skip probing addresses at domains known to accept any address, from their provider, earlier in the run or in -db")
+	flags.BoolVar(&g.dane, "dane", false, "verify the mail servers against their TLSA records in domain checks at -level smtp and deep, not in address checks")
+	flags.BoolVar(&g.inspectTLS, "inspect-tls", false, "use STARTTLS on port 25 when offered and report the certificate of every mail server")
+	flags.BoolVar(&g.dnssec, "dnssec", false, "validate mx, txt and tlsa lookups with DNSSEC, addresses at domains failing validation are not probed")
+	flags.StringVar(&g.domainBlocklists, "domain-blocklists", "", "comma separated domain blocklists, e.g. dbl.spamhaus.org,multi.surbl.org, addresses at listed domains are not probed")
+	flags.StringVar(&g.allowDomains, "allow-domains", "", "comma separated domains, or a file with one per line, whose addresses are valid without checking, wildcards such as *.example.com allowed")
+	flags.StringVar(&g.blockDomains, "block-domains", "", "comma separated domains, or a file with one per line, whose addresses are invalid without checking")
+	flags.StringVar(&g.blockTLDs, "block-tlds", "", "comma separated top-level domains, or a file with one per line, whose addresses are invalid without checking, e.g. ru,cn")
+	flags.BoolVar(&g.domainAge, "check-domain-age", false, "look up when the domain of every address was registered, over RDAP")
+	flags.DurationVar(&g.youngDomainAge, "young-domain-age", time.Hour*24*30, "age below which -check-domain-age flags a domain as young")
+	flags.StringVar(&g.enrich, "enrich", "", "comma separated enrichments to add to every address that is not invalid: gravatar")
+	flags.BoolVar(&g.spamtraps, "spamtraps", false, "flag likely spamtraps among the addresses that are not invalid")
+	flags.StringVar(&g.trapDomains, "trap-domains", "", "comma separated known spamtrap domains, or a file with one per line, for -spamtraps")
+	flags.BoolVar(&g.hibp, "hibp", false, "look up in which breaches addresses appear on Have I Been Pwned for -spamtraps, with the api key in "+envHIBPAPIKey)
+	flags.StringVar(&g.auditLog, "audit-log", "", "file to append every probe sent to a mail server to as a JSON line, or syslog, or syslog://host:port")
+	flags.StringVar(&g.execHook, "exec-hook", "", "program to run for every result, with the result as JSON on stdin")
+	flags.DurationVar(&g.execHookTimeout, "exec-hook-timeout", time.Second*30, "time after which the -exec-hook program is killed")
+	flags.StringVar(&g.db, "db", "", "database to record every verification in: a SQLite file or a postgres:// url")
+
+	return g
+}
+
+// withTimeoutTotal returns ctx limited to -timeout-total, if set.
+func (g *globalFlags) withTimeoutTotal(ctx context.Context) (context.Context, context.CancelFunc) {
+	if g.timeoutTotal <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, g.timeoutTotal)
+}
+
+// checker returns the Checker the flags describe. Probing mail servers needs consent, which is asked for first.
+// Every error is a usage error.
+func (g *globalFlags) checker() (*mailcheck.Checker, error) {
+	level, err := mailcheck.ParseLevel(g.level)
+	if err != nil {
+		return nil, usage(err)
+	}
+
+	ports, err := parsePorts(g.ports)
+	if err != nil {
+		return nil, usage(err)
+	}
+
+	smarthost, err := parseSmarthost(g.smarthost)
+	if err != nil {
+		return nil, usage(err)
+	}
+
+	budget, err := parseStageBudget(g.stageBudget)
+	if err != nil {
+		return nil, usage(err)
+	}
+
+	policy, err := g.policy()
+	if err != nil {
+		return nil, usage(err)
+	}
+
+	trapDomains, err := readList(g.trapDomains)
+	if err != nil {
+		return nil, usage(err)
+	}
+
+	var breachLookup mailcheck.BreachLookup
+	if g.hibp {
+		key := os.Getenv(envHIBPAPIKey)
+		if key == "" {
+			return nil, usage(errors.Errorf("-hibp needs an api key in %s", envHIBPAPIKey))
+		}
+		breachLookup = mailcheck.HIBP{APIKey: key}
+	}
+
+	smtpPass := g.smtpPass
+	if smtpPass == "" {
+		smtpPass = os.Getenv(envSMTPPassword)
+	}
+
+	cfg, err := g.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	g.flagLimits = mailcheck.Limits{
+		ProbesPerMinute:   probesPerMinute(g.flags, g.ratePerDomain, g.owned),
+		RaceMailServers:   g.raceMX,
+		MaxRcptPerSession: g.maxRcpt,
+	}
+	limits := cfg.Limits.Apply(g.flagLimits)
+
+	var onResult func(mailcheck.Result)
+	if g.execHook != "" {
+		if _, err := exec.LookPath(g.execHook); err != nil {
+			return nil, usage(errors.Wrap(err, "invalid exec hook"))
+		}
+		onResult = execHook{path: g.execHook, timeout: g.execHookTimeout, stderr: g.std.stderr}.run
+	}
+
+	var hosts mailcheck.Hosts
+	if g.dnsHosts != "" {
+		if hosts, err = mailcheck.LoadHosts(g.dnsHosts); err != nil {
+			return nil, usage(err)
+		}
+	}
+
+	enrichers, err := parseEnrichers(g.enrich)
+	if err != nil {
+		return nil, usage(err)
+	}
+
+	var roleAccounts []string
+	if g.roleList != "" {
+		if roleAccounts, err = mailcheck.LoadRoleAccounts(g.roleList); err != nil {
+			return nil, usage(err)
+		}
+	}
+
+	if g.transcript != "" {
+		if err := os.MkdirAll(g.transcript, 0700); err != nil {
+			return nil, usage(errors.Wrap(err, "could not create transcript directory"))
+		}
+	}
+
+	// only probing mail servers needs consent
+	if !g.owned && (level == mailcheck.LevelSMTP || level == mailcheck.LevelDeep) {
+		if err := ensureConsent(); err != nil {
+			return nil, usage(err)
+		}
+	}
+
+	// fast mode draws on the verifications of earlier runs. The history and audit log are opened last, so that
+	// no usage error or refused consent above leaves them open.
+	var history mailcheck.Store
+	if g.fast {
+		if history, err = g.store(); err != nil {
+			return nil, err
+		}
+	}
+
+	var auditor mailcheck.Auditor
+	if g.auditLog != "" {
+		if auditor, err = openAuditLog(g.auditLog); err != nil {
+			if history != nil {
+				_ = history.Close()
+			}
+			return nil, usage(err)
+		}
+	}
+
+	return mailcheck.New(mailcheck.Options{
+		Level: level,
+		Ports: ports,
+		Retry: mailcheck.RetryPolicy{
+			Retries: g.retries,
+			Backoff: g.backoff,
+			Jitter:  g.jitter,
+		},
+		StageBudget:        budget,
+		Hosts:              hosts,
+		MXOverrides:        cfg.MailServers(),
+		Identities:         cfg.Identities,
+		Transcript:         g.transcript != "" || g.recordTranscripts,
+		Auditor:            auditor,
+		OnResult:           onResult,
+		RoleAccounts:       roleAccounts,
+		MaxRcptPerSession:  limits.MaxRcptPerSession,
+		SessionIdleTimeout: g.sessionIdle,
+		RaceMailServers:    limits.RaceMailServers,
+		Smarthost:          smarthost,
+		SMTPUser:           g.smtpUser,
+		SMTPPassword:       smtpPass,
+		SmarthostInsecure:  g.smarthostInsecure,
+		SessionMaxLifetime: g.sessionLifetime,
+		ProbesPerMinute:    limits.ProbesPerMinute,
+		ScoreWeights:       cfg.ScoreWeights,
+		UseVRFY:            g.useVRFY,
+		ExchangeOnline:     g.exchangeOnline,
+		Fast:               g.fast,
+		History:            history,
+		DANE:               g.dane,
+		InspectTLS:         g.inspectTLS,
+		DNSSEC:             g.dnssec,
+		DNSCacheSize:       dnsCacheSize(g.dnsCacheSize),
+		Policy:             policy,
+		Profiles:           cfg.DomainProfiles,
+		DomainBlocklists:   splitList(g.domainBlocklists),
+		DomainAge:          g.domainAge,
+		YoungDomainAge:     g.youngDomainAge,
+		Spamtraps:          g.spamtraps,
+		TrapDomains:        trapDomains,
+		BreachLookup:       breachLookup,
+		Enrichers:          enrichers,
+		Checks:             cfg.Checks(),
+	}), nil
+}
+
+// reloadLimits applies the limits of the configuration file to checker again, those left out of it
+// fall back to their flags.
+func (g *globalFlags) reloadLimits(checker *mailcheck.Checker) error {
+	if g.config == "" {
+		return errors.New("there is no -config to reload limits from")
+	}
+
+	cfg, err := g.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	checker.SetLimits(cfg.Limits.Apply(g.flagLimits))
+	return nil
+}
+
+// results returns the writer of results to stdout the flags describe. Every error is a usage error.
+func (g *globalFlags) results() (*resultWriter, error) {
+	results, err := newResultWriter(g.std.stdout, g.output)
+	if err != nil {
+		return nil, usage(err)
+	}
+
+	if g.format != "" {
+		if err := results.useTemplate(g.format); err != nil {
+			return nil, usage(err)
+		}
+	}
+
+	return results, nil
+}
+
+// store opens the store of -db, nil when not set.
+func (g *globalFlags) store() (mailcheck.Store, error) {
+	if g.db == "" || g.opened != nil {
+		return g.opened, nil
+	}
+
+	db, err := store.Open(g.db)
+	if err != nil {
+		return nil, usage(err)
+	}
+
+	g.opened = db
+	return db, nil
+}
+
+// policy returns the domain policy of -allow-domains, -block-domains and -block-tlds.
+func (g *globalFlags) policy() (policy mailcheck.DomainPolicy, err error) {
+	if policy.Allow, err = readList(g.allowDomains); err != nil {
+		return policy, err
+	}
+
+	if policy.Block, err = readList(g.blockDomains); err != nil {
+		return policy, err
+	}
+
+	if policy.BlockTLDs, err = readList(g.blockTLDs); err != nil {
+		return policy, err
+	}
+
+	return policy, policy.Validate()
+}
+
+// loadConfig returns the configuration file of -config, an empty one without it. Every error is a usage error.
+func (g *globalFlags) loadConfig() (*config.Config, error) {
+	if g.config == "" {
+		return &config.Config{}, nil
+	}
+
+	cfg, err := config.LoadConfig(g.config)
+	if err != nil {
+		return nil, usage(err)
+	}
+
+	return cfg, nil
+}
+
+// readList returns the entries of a comma separated list or, when value names a file, of the lines of that file,
+// ignoring blank lines and # comments.
+func readList(value string) (entries []string, err error) {
+	if info, err := os.Stat(value); err != nil || !info.Mode().IsRegular() {
+		return splitList(value), nil
+	}
+
+	contents, err := ioutil.ReadFile(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read list")
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+
+	return entries, nil
+}
+
+// parseEnrichers returns the enrichers named in a comma separated list.
+func parseEnrichers(list string) (enrichers []mailcheck.Enricher, err error) {
+	available := map[string]mailcheck.Enricher{
+		"gravatar": mailcheck.Gravatar{},
+	}
+
+	for _, name := range splitList(list) {
+		enricher, ok := available[strings.ToLower(name)]
+		if !ok {
+			return nil, errors.Errorf("unknown enrichment '%s'", name)
+		}
+		enrichers = append(enrichers, enricher)
+	}
+
+	return enrichers, nil
+}
+
+// parseSmarthost parses the host:port of -smarthost, the submission port when left out.
+func parseSmarthost(hostport string) (mailcheck.MailServer, error) {
+	if hostport == "" {
+		return mailcheck.MailServer{}, nil
+	}
+
+	if !strings.Contains(hostport, ":") {
+		return mailcheck.MailServer{Host: hostport, Port: defaultSmarthostPort}, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return mailcheck.MailServer{}, errors.Wrapf(err, "invalid smarthost '%s'", hostport)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return mailcheck.MailServer{}, errors.Errorf("invalid port in smarthost '%s'", hostport)
+	}
+
+	return mailcheck.MailServer{Host: host, Port: port}, nil
+}
+
+// parseStageBudget parses a comma separated list of stage=duration limits.
+func parseStageBudget(list string) (budget mailcheck.StageBudget, err error) {
+	limits := map[string]*time.Duration{
+		mailcheck.StageDNS:     &budget.DNS,
+		mailcheck.StageConnect: &budget.Connect,
+		mailcheck.StageTLS:     &budget.TLS,
+		mailcheck.StageSMTP:    &budget.SMTP,
+	}
+
+	for _, field := range splitList(list) {
+		parts := strings.SplitN(field, "=", 2)
+		limit, ok := limits[strings.ToLower(parts[0])]
+		if !ok || len(parts) != 2 {
+			return budget, errors.Errorf("invalid stage budget '%s', expected dns, connect, tls or smtp=duration", field)
+		}
+
+		if *limit, err = time.ParseDuration(parts[1]); err != nil || *limit <= 0 {
+			return budget, errors.Errorf("invalid duration in stage budget '%s'", field)
+		}
+	}
+
+	return budget, nil
+}
+
+// parsePorts parses a comma separated list of ports.
+func parsePorts(list string) (ports []int, err error) {
+	for _, field := range strings.Split(list, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || port < 1 || port > 65535 {
+			return nil, errors.Errorf("invalid port '%s'", field)
+		}
+		ports = append(ports, port)
+	}
+
+	return ports, nil
+}
+
+// dnsCacheSize maps the cache size flag onto Options.DNSCacheSize, where zero means the default size.
+func dnsCacheSize(size int) int {
+	if size <= 0 {
+		return -1
+	}
+
+	return size
+}
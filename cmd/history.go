@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/hazcod/mailcheck"
+	"github.com/hazcod/mailcheck/store"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// recentVerification returns the latest conclusive verification of email within maxAge, if any.
+// Unknown verdicts are not conclusive, those addresses are worth checking again.
+func recentVerification(ctx context.Context, db mailcheck.Store, email string, maxAge time.Duration) (mailcheck.Record, bool, error) {
+	records, err := db.Lookup(ctx, mailcheck.StoreQuery{
+		Email:    email,
+		Verdicts: []mailcheck.Verdict{mailcheck.VerdictValid, mailcheck.VerdictInvalid},
+		Since:    time.Now().Add(-maxAge),
+		Limit:    1,
+	})
+	if err != nil || len(records) == 0 {
+		return mailcheck.Record{}, false, err
+	}
+
+	return records[0], true, nil
+}
+
+func newHistoryCommand(std streams) *ffcli.Command {
+	flags := flag.NewFlagSet("mailcheck history", flag.ContinueOnError)
+	db := flags.String("db", "", "database written by -db: a SQLite file or a postgres:// url")
+	email := flags.String("email", "", "only show verifications of this address")
+	domain := flags.String("domain", "", "only show verifications of addresses at this domain")
+	verdicts := flags.String("verdicts", "", "comma separated verdicts to show, all by default")
+	since := flags.Duration("since", 0, "only show verifications within this duration, 0 for all")
+	limit := flags.Int("limit", 100, "maximum number of verifications to show, 0 for all")
+	purge := flags.Duration("purge-older-than", 0, "delete the verifications older than this duration instead of showing any")
+	output := flags.String("output", outputText, "result format written to stdout: text or json")
+
+	return &ffcli.Command{
+		Name:       "history",
+		ShortUsage: "mailcheck history -db <dsn> [flags]",
+		ShortHelp:  "show past verifications",
+		LongHelp: "Shows the verifications recorded with -db, latest first.\n" +
+			"The verifications table can be queried with SQL as well.",
+		FlagSet: flags,
+		Exec: func(ctx context.Context, _ []string) error {
+			if *purge > 0 {
+				return runPurge(ctx, *db, *purge)
+			}
+
+			query := mailcheck.StoreQuery{Email: *email, Domain: *domain, Limit: *limit}
+			for _, verdict := range splitList(*verdicts) {
+				query.Verdicts = append(query.Verdicts, mailcheck.Verdict(verdict))
+			}
+			if *since > 0 {
+				query.Since = time.Now().Add(-*since)
+			}
+
+			return runHistory(ctx, std.stdout, *db, query, *output)
+		},
+	}
+}
+
+// openExistingStore opens the store of dsn for reading. Opening a SQLite file creates it,
+// a typo in the path should not do that silently.
+func openExistingStore(dsn string) (mailcheck.Store, error) {
+	if dsn == "" {
+		return nil, flag.ErrHelp
+	}
+
+	if path, ok := store.SQLitePath(dsn); ok {
+		if _, err := os.Stat(path); err != nil {
+			return nil, usage(errors.Wrap(err, "could not open database"))
+		}
+	}
+
+	return store.Open(dsn)
+}
+
+// runHistory implements the history subcommand.
+func runHistory(ctx context.Context, stdout io.Writer, dsn string, query mailcheck.StoreQuery, output string) error {
+	results, err := newResultWriter(stdout, output)
+	if err != nil {
+		return usage(err)
+	}
+
+	db, err := openExistingStore(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	records, err := db.Lookup(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		verdict := string(record.Verdict)
+		if record.Reason != "" {
+			verdict += ":" + string(record.Reason)
+		}
+
+		code := ""
+		if record.Code != 0 {
+			code = strings.TrimSpace(fmt.Sprintf("%d %s", record.Code, record.EnhancedCode))
+		}
+
+		if err := results.writeLine(record, record.CheckedAt.Format(time.RFC3339), record.Email, verdict, code, record.MX); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runPurge deletes the verifications older than maxAge.
+func runPurge(ctx context.Context, dsn string, maxAge time.Duration) error {
+	db, err := openExistingStore(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	purged, err := db.Purge(ctx, time.Now().Add(-maxAge))
+	if err != nil {
+		return err
+	}
+
+	log.Infof("purged %d verifications older than %s", purged, maxAge)
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyHeader carries a key chosen by the client that makes retrying a submission safe.
+const idempotencyHeader = "Idempotency-Key"
+
+// idempotencyKeys remembers the responses to submissions made with an Idempotency-Key, so a client retrying one
+// gets the job or batch of its first attempt back instead of a duplicate that probes the same mailboxes again.
+// Keys are remembered for ttl, per api key.
+type idempotencyKeys struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	requests map[string]*idempotentRequest
+}
+
+// idempotentRequest is the first submission made with a key.
+type idempotentRequest struct {
+	// body is the hash of the request body, a retry must send the same
+	body    [sha256.Size]byte
+	expires time.Time
+	// done is set once the response is known, until then retries are refused
+	done     bool
+	status   int
+	header   http.Header
+	response []byte
+}
+
+func newIdempotencyKeys(ttl time.Duration) *idempotencyKeys {
+	return &idempotencyKeys{ttl: ttl, requests: map[string]*idempotentRequest{}}
+}
+
+// wrap makes POST requests to next with an Idempotency-Key answered once: a retry with the same key and body
+// gets the first response again, one with another body 422 Unprocessable Entity. Only successful responses are
+// remembered, a submission that failed can be retried with the same key.
+func (k *idempotencyKeys) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			writeError(w, http.StatusBadRequest, idempotencyHeader+" must be at most 255 characters")
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxJobBody))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		// keys are chosen by clients, those of different api keys must not meet
+		scope := r.URL.Path + " " + key
+		if apiKey, ok := r.Context().Value(apiKeyContextKey{}).(*apiKey); ok {
+			scope = apiKey.Name + " " + scope
+		}
+
+		request, first := k.begin(scope, sha256.Sum256(body))
+		if !first {
+			k.replay(w, request, sha256.Sum256(body))
+			return
+		}
+
+		recorder := &responseRecorder{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		k.finish(scope, recorder)
+
+		for name, values := range recorder.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(recorder.status)
+		_, _ = w.Write(recorder.body.Bytes())
+	})
+}
+
+// begin returns the request made with scope before, or records a new one and reports that it is the first.
+func (k *idempotencyKeys) begin(scope string, body [sha256.Size]byte) (idempotentRequest, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	for other, request := range k.requests {
+		if request.done && now.After(request.expires) {
+			delete(k.requests, other)
+		}
+	}
+
+	if request, ok := k.requests[scope]; ok {
+		return *request, false
+	}
+
+	k.requests[scope] = &idempotentRequest{body: body}
+	return idempotentRequest{}, true
+}
+
+// finish remembers the response to the first request made with scope, or forgets the request when it failed.
+func (k *idempotencyKeys) finish(scope string, recorder *responseRecorder) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if recorder.status < 200 || recorder.status > 299 {
+		delete(k.requests, scope)
+		return
+	}
+
+	request := k.requests[scope]
+	request.done = true
+	request.expires = time.Now().Add(k.ttl)
+	request.status, request.header, request.response = recorder.status, recorder.header, recorder.body.Bytes()
+}
+
+// replay answers a retry of request.
+func (k *idempotencyKeys) replay(w http.ResponseWriter, request idempotentRequest, body [sha256.Size]byte) {
+	switch {
+	case request.body != body:
+		writeError(w, http.StatusUnprocessableEntity, idempotencyHeader+" was used with another request body")
+	case !request.done:
+		writeError(w, http.StatusConflict, "a request with this "+idempotencyHeader+" is in progress")
+	default:
+		for name, values := range request.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(request.status)
+		_, _ = w.Write(request.response)
+	}
+}
+
+// responseRecorder keeps a response to be able to send it again.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
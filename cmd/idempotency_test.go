@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	submissions := 0
+	handler := newIdempotencyKeys(time.Hour).wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.RawQuery, "fail") {
+			writeError(w, http.StatusServiceUnavailable, "too many jobs queued, try again later")
+			return
+		}
+
+		submissions++
+		w.Header().Set("Location", "/v1/jobs/1")
+		writeJSON(w, http.StatusAccepted, struct {
+			Submission int `json:"submission"`
+		}{submissions})
+	}))
+
+	post := func(target, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(idempotencyHeader, key)
+		}
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	first := post("/v1/jobs", "retry-me", `{"emails": ["a@example.com"]}`)
+	if first.Code != http.StatusAccepted || first.Body.String() != "{\"submission\":1}\n" {
+		t.Fatalf("expected the job to be submitted, got %d %s", first.Code, first.Body)
+	}
+
+	retry := post("/v1/jobs", "retry-me", `{"emails": ["a@example.com"]}`)
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get("Location") != "/v1/jobs/1" ||
+		retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected the first response again, got %d %s %v", retry.Code, retry.Body, retry.Header())
+	}
+
+	if res := post("/v1/jobs", "retry-me", `{"emails": ["b@example.com"]}`); res.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected another body to be refused, got %d %s", res.Code, res.Body)
+	}
+
+	// keys are per path, and requests without one are never deduplicated
+	if res := post("/v1/check", "retry-me", `{"emails": ["a@example.com"]}`); res.Body.String() != "{\"submission\":2}\n" {
+		t.Errorf("expected a new submission on another path, got %s", res.Body)
+	}
+	if res := post("/v1/jobs", "", `{"emails": ["a@example.com"]}`); res.Body.String() != "{\"submission\":3}\n" {
+		t.Errorf("expected a new submission without key, got %s", res.Body)
+	}
+
+	// a failed submission can be retried with the same key
+	if res := post("/v1/jobs?fail", "failing", `{}`); res.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected the submission to fail, got %d", res.Code)
+	}
+	if res := post("/v1/jobs", "failing", `{}`); res.Code != http.StatusAccepted || res.Body.String() != "{\"submission\":4}\n" {
+		t.Errorf("expected the retry of a failed submission to go through, got %d %s", res.Code, res.Body)
+	}
+}
+
+func TestIdempotencyKeysExpire(t *testing.T) {
+	keys := newIdempotencyKeys(-time.Second)
+
+	submissions := 0
+	handler := keys.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		submissions++
+		writeJSON(w, http.StatusAccepted, nil)
+	}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/jobs", strings.NewReader(`{}`))
+		req.Header.Set(idempotencyHeader, "expiring")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if submissions != 2 {
+		t.Errorf("expected an expired key to be forgotten, got %d submissions", submissions)
+	}
+}
+
+func TestIdempotencyKeysInProgress(t *testing.T) {
+	keys := newIdempotencyKeys(time.Hour)
+	if _, first := keys.begin("/v1/jobs slow", [32]byte{1}); !first {
+		t.Fatal("expected the first request")
+	}
+
+	res := httptest.NewRecorder()
+	request, first := keys.begin("/v1/jobs slow", [32]byte{1})
+	if first {
+		t.Fatal("expected the key to be taken")
+	}
+	keys.replay(res, request, [32]byte{1})
+
+	if res.Code != http.StatusConflict {
+		t.Errorf("expected a retry while the first request runs to conflict, got %d", res.Code)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hazcod/mailcheck"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobCanceled = "canceled"
+
+	// maxJobAddresses and maxJobBody limit a single job, maxQueuedJobs the jobs waiting for a worker
+	maxJobAddresses = 1000000
+	maxJobBody      = 64 << 20
+	maxQueuedJobs   = 100
+)
+
+// job is a list of addresses checked in the background, for lists too big to check within a request.
+type job struct {
+	mu sync.Mutex
+
+	status   jobStatus
+	emails   []string
+	results  []mailcheck.Result
+	finished time.Time
+}
+
+// jobStatus is the progress of a job as reported to clients.
+type jobStatus struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Processed  int        `json:"processed"`
+	Valid      int        `json:"valid"`
+	Invalid    int        `json:"invalid"`
+	Unknown    int        `json:"unknown"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// jobQueue holds the jobs and feeds them to a pool of workers. Finished jobs are kept for retention.
+type jobQueue struct {
+	mu        sync.Mutex
+	jobs      map[string]*job
+	queue     chan *job
+	retention time.Duration
+}
+
+func newJobQueue(retention time.Duration) *jobQueue {
+	return &jobQueue{
+		jobs:      map[string]*job{},
+		queue:     make(chan *job, maxQueuedJobs),
+		retention: retention,
+	}
+}
+
+// add queues a job for emails, it returns false when the queue is full.
+func (q *jobQueue) add(id string, emails []string) (*job, bool) {
+	j := &job{
+		status: jobStatus{ID: id, Status: jobQueued, Total: len(emails), CreatedAt: time.Now().UTC()},
+		emails: emails,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.expire()
+
+	select {
+	case q.queue <- j:
+	default:
+		return nil, false
+	}
+
+	q.jobs[id] = j
+	return j, true
+}
+
+// expire forgets the jobs that finished longer than the retention ago.
+func (q *jobQueue) expire() {
+	for id, j := range q.jobs {
+		j.mu.Lock()
+		expired := !j.finished.IsZero() && time.Since(j.finished) > q.retention
+		j.mu.Unlock()
+
+		if expired {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func (q *jobQueue) get(id string) (*job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	return j, ok
+}
+
+// work runs jobs from the queue until stop is done, checking their addresses within ctx.
+// A job that is running when stop is done is finished first.
+func (q *jobQueue) work(stop, ctx context.Context, check func(context.Context, string) mailcheck.Result) {
+	for stop.Err() == nil {
+		select {
+		case <-stop.Done():
+			return
+		case j := <-q.queue:
+			j.run(ctx, check)
+		}
+	}
+}
+
+func (j *job) run(ctx context.Context, check func(context.Context, string) mailcheck.Result) {
+	j.mu.Lock()
+	j.status.Status = jobRunning
+	emails := j.emails
+	j.mu.Unlock()
+
+	log.Debugf("starting job %s with %d addresses", j.status.ID, len(emails))
+
+	for _, email := range mailcheck.GroupByDomain(emails) {
+		res := check(ctx, email)
+		if ctx.Err() != nil {
+			j.finish(jobCanceled)
+			status := j.snapshot()
+			log.Warnf("canceled job %s after %d of %d addresses", status.ID, status.Processed, status.Total)
+			return
+		}
+
+		j.mu.Lock()
+		j.results = append(j.results, res)
+		j.status.Processed++
+		switch res.Verdict {
+		case mailcheck.VerdictValid:
+			j.status.Valid++
+		case mailcheck.VerdictInvalid:
+			j.status.Invalid++
+		default:
+			j.status.Unknown++
+		}
+		j.mu.Unlock()
+	}
+
+	j.finish(jobDone)
+	log.Debugf("finished job %s", j.status.ID)
+}
+
+func (j *job) finish(status string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status.Status = status
+	j.finished = time.Now().UTC()
+	j.status.FinishedAt = &j.finished
+	j.emails = nil
+}
+
+// snapshot returns the current status of the job.
+func (j *job) snapshot() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.status
+}
+
+// handleJobs creates jobs on POST /v1/jobs.
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	var request struct {
+		Emails []string `json:"emails"`
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobBody)).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if len(request.Emails) == 0 || len(request.Emails) > maxJobAddresses {
+		writeError(w, http.StatusBadRequest, "between 1 and 1000000 emails can be checked per job")
+		return
+	}
+
+	if !s.keys.charge(w, r, len(request.Emails)) {
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	j, ok := s.jobs.add(id, request.Emails)
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, "too many jobs queued, try again later")
+		return
+	}
+
+	w.Header().Set("Location", "/v1/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, j.snapshot())
+}
+
+// handleJob serves GET /v1/jobs/{id} with the status of a job and GET /v1/jobs/{id}/results with its results
+// so far, one JSON object per line.
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/jobs/")
+	id, resource := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		id, resource = path[:i], path[i+1:]
+	}
+
+	j, ok := s.jobs.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown job")
+		return
+	}
+
+	switch resource {
+	case "":
+		writeJSON(w, http.StatusOK, j.snapshot())
+
+	case "results":
+		// results are only appended, the ones up to now can be written without holding the lock
+		j.mu.Lock()
+		results := j.results
+		j.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		for _, res := range results {
+			if err := encoder.Encode(res); err != nil {
+				log.Debugf("could not write response: %v", err)
+				return
+			}
+		}
+
+	default:
+		writeError(w, http.StatusNotFound, "unknown job resource")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// unixScheme prefixes a -listen address that is the path of a unix socket
+const unixScheme = "unix://"
+
+// listen listens on address, a host:port or unix:// followed by the path of a unix socket that is given mode.
+// A socket left behind by an earlier run is replaced.
+func listen(address string, mode os.FileMode) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixScheme) {
+		listener, err := net.Listen("tcp", address)
+		return listener, errors.Wrapf(err, "could not listen on %s", address)
+	}
+
+	path := strings.TrimPrefix(address, unixScheme)
+	if path == "" {
+		return nil, usage(errors.New("-listen unix:// needs the path of the socket"))
+	}
+
+	// anything but a socket is not ours to remove
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, errors.Wrap(err, "could not remove stale socket")
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not listen on %s", path)
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		_ = listener.Close()
+		return nil, errors.Wrap(err, "could not set the permissions of the socket")
+	}
+
+	return listener, nil
+}
+
+// parseSocketMode parses the octal permissions of -socket-mode, such as 0660.
+func parseSocketMode(mode string) (os.FileMode, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, usage(errors.Errorf("invalid socket mode '%s', expected octal permissions such as 0660", mode))
+	}
+
+	return os.FileMode(perm), nil
+}
+
+// fastCGIServer serves the api over FastCGI. Unlike http.Server it tracks the requests in progress itself,
+// so that shutting down lets them finish.
+type fastCGIServer struct {
+	handler  http.Handler
+	listener net.Listener
+
+	mu       sync.Mutex
+	draining bool
+	requests sync.WaitGroup
+}
+
+// Serve answers the requests on the listener until Shutdown, returning http.ErrServerClosed then.
+func (f *fastCGIServer) Serve() error {
+	err := fcgi.Serve(f.listener, http.HandlerFunc(f.serveHTTP))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.draining {
+		return http.ErrServerClosed
+	}
+	return err
+}
+
+func (f *fastCGIServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// web servers may keep their connections open, refuse what comes in on them while draining
+	f.mu.Lock()
+	if f.draining {
+		f.mu.Unlock()
+		writeError(w, http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+	f.requests.Add(1)
+	f.mu.Unlock()
+
+	defer f.requests.Done()
+	f.handler.ServeHTTP(w, r)
+}
+
+// Shutdown stops taking requests and waits for the ones in progress until ctx is done, like http.Server.Shutdown.
+func (f *fastCGIServer) Shutdown(ctx context.Context) error {
+	f.mu.Lock()
+	f.draining = true
+	f.mu.Unlock()
+
+	if err := f.listener.Close(); err != nil {
+		return errors.Wrap(err, "could not close listener")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		f.requests.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
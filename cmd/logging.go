@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logFlags are the logging flags, defined on every subcommand so they can go anywhere on the command line.
+type logFlags struct {
+	level  string
+	format string
+	quiet  bool
+}
+
+// register defines the logging flags on flags.
+func (l *logFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&l.level, "log-level", log.InfoLevel.String(), "minimum level of the logs on stderr: debug, info, warn or error")
+	flags.StringVar(&l.format, "log-format", logFormatText, "format of the logs on stderr: text or json")
+	flags.BoolVar(&l.quiet, "quiet", false, "only log errors, leaving just the results")
+}
+
+// apply configures logrus as the flags say.
+func (l *logFlags) apply() error {
+	level, err := log.ParseLevel(l.level)
+	if err != nil {
+		return errors.Errorf("unknown log level '%s'", l.level)
+	}
+
+	if l.quiet {
+		level = log.ErrorLevel
+	}
+	log.SetLevel(level)
+
+	switch l.format {
+	case logFormatText:
+		log.SetFormatter(&log.TextFormatter{})
+	case logFormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return errors.Errorf("unknown log format '%s'", l.format)
+	}
+
+	return nil
+}
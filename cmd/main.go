@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+	"io"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// streams are where a run reads its input from and writes to. Results alone go to stdout, so the output of
+// mailcheck can be piped into other tools, while logs, progress and any other diagnostics go to stderr.
+type streams struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// terminalFd returns the file descriptor of stream, an io.Reader or io.Writer, when it is a terminal.
+func terminalFd(stream interface{}) (int, bool) {
+	file, ok := stream.(*os.File)
+	if !ok || !term.IsTerminal(int(file.Fd())) {
+		return 0, false
+	}
+
+	return int(file.Fd()), true
+}
+
+// isTerminal reports whether stream is a terminal.
+func isTerminal(stream interface{}) bool {
+	_, ok := terminalFd(stream)
+	return ok
+}
+
+func main() {
+	rand.Seed(time.Now().UnixNano())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// stop on the first interrupt or termination, leaving already reported results intact, a second one kills us
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		signal.Stop(signals)
+		log.Warnf("%s, stopping", received)
+		cancel()
+	}()
+
+	code := run(ctx, os.Args[1:], streams{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr})
+	cancel()
+	os.Exit(code)
+}
+
+// newRootCommand returns the mailcheck command with its subcommands, all of them using std.
+func newRootCommand(std streams) *ffcli.Command {
+	flags := flag.NewFlagSet("mailcheck", flag.ContinueOnError)
+	flags.SetOutput(std.stderr)
+
+	root := &ffcli.Command{
+		ShortUsage: "mailcheck <subcommand> [flags] [<arg> ...]",
+		LongHelp:   "Run mailcheck <subcommand> -h for the flags of a subcommand.",
+		FlagSet:    flags,
+		Subcommands: []*ffcli.Command{
+			newCheckCommand(std),
+			newBatchCommand(std),
+			newWatchCommand(std),
+			newDomainCommand(std),
+			newServeCommand(std),
+			newReplCommand(std),
+			newBlcheckCommand(std),
+			newExportCorpusCommand(std),
+			newHistoryCommand(std),
+			newCacheCommand(std),
+		},
+		Exec: func(_ context.Context, args []string) error {
+			if len(args) > 0 {
+				log.Errorf("unknown subcommand '%s'", args[0])
+			}
+			return flag.ErrHelp
+		},
+	}
+
+	// usage and flag errors are diagnostics too
+	for _, command := range subcommands(root) {
+		command.FlagSet.SetOutput(std.stderr)
+	}
+
+	return root
+}
+
+// subcommands returns the subcommands of command and theirs in turn.
+func subcommands(command *ffcli.Command) (commands []*ffcli.Command) {
+	for _, sub := range command.Subcommands {
+		commands = append(append(commands, sub), subcommands(sub)...)
+	}
+
+	return commands
+}
+
+// run runs the command line args until ctx is done and returns the exit code.
+func run(ctx context.Context, args []string, std streams) int {
+	// logs never go to stdout, that is reserved for results
+	log.SetOutput(std.stderr)
+
+	root := newRootCommand(std)
+
+	logging := &logFlags{}
+	for _, command := range subcommands(root) {
+		if len(command.Subcommands) > 0 {
+			continue
+		}
+		logging.register(command.FlagSet)
+	}
+
+	// the flag package already printed what was wrong with the command line, -h is not an error
+	if err := root.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitValid
+		}
+		return exitUsage
+	}
+
+	if err := logging.apply(); err != nil {
+		log.Error(err)
+		return exitUsage
+	}
+
+	err := root.Run(ctx)
+
+	var code exitCode
+	var usageErr usageError
+	switch {
+	case err == nil:
+		return exitValid
+	case errors.As(err, &code):
+		return int(code)
+	case errors.Is(err, flag.ErrHelp):
+		return exitUsage
+	case errors.As(err, &usageErr):
+		log.Error(err)
+		return exitUsage
+	default:
+		// the run did not complete, so the outcome is unknown
+		log.Error(err)
+		return exitUnknown
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/mailcheck/mailchecktest"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe to write to from the goroutines of a run while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// lab starts an SMTP server that lab.test receives mail on, accepting valid@lab.test and rejecting any other
+// recipient, and returns the flags to check addresses against it.
+func lab(t *testing.T) (*mailchecktest.SMTPServer, []string) {
+	smtp, err := mailchecktest.NewSMTPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = smtp.Close() })
+
+	smtp.SetReply("valid@lab.test", mailchecktest.Accept)
+
+	hosts := filepath.Join(t.TempDir(), "hosts")
+	if err := ioutil.WriteFile(hosts, []byte("127.0.0.1 lab.test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return smtp, []string{"-i-own-this-list", "-dns-hosts", hosts, "-ports", strconv.Itoa(smtp.Port()),
+		"-timeout-per-address", "5s", "-log-level", "debug"}
+}
+
+// runMailcheck runs the command line args with stdin as input until ctx is done.
+func runMailcheck(ctx context.Context, stdin string, args ...string) (code int, stdout, stderr string) {
+	var out, diagnostics syncBuffer
+	code = run(ctx, args, streams{stdin: strings.NewReader(stdin), stdout: &out, stderr: &diagnostics})
+	return code, out.String(), diagnostics.String()
+}
+
+// jsonRecords decodes every line of stdout as a JSON object, failing when any line is something else, such as a log.
+func jsonRecords(t *testing.T, stdout string) []map[string]interface{} {
+	t.Helper()
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(strings.NewReader(stdout))
+	for scanner.Scan() {
+		record := map[string]interface{}{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("stdout holds something other than a result: %q", scanner.Text())
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// expectLogs fails when the run logged nothing to stderr, which would make the absence of logs on stdout meaningless.
+func expectLogs(t *testing.T, stderr string) {
+	t.Helper()
+
+	if !strings.Contains(stderr, "level=") {
+		t.Errorf("expected the logs on stderr, got %q", stderr)
+	}
+}
+
+// emails returns the email field of every record.
+func emails(records []map[string]interface{}) []string {
+	var emails []string
+	for _, record := range records {
+		emails = append(emails, fmt.Sprint(record["email"]))
+	}
+	return emails
+}
+
+func TestCheckOutput(t *testing.T) {
+	_, flags := lab(t)
+
+	code, stdout, stderr := runMailcheck(context.Background(), "nobody@lab.test\n",
+		append([]string{"check", "-output", "json"}, append(flags, "valid@lab.test", "-")...)...)
+	if code != exitInvalid {
+		t.Errorf("expected exit code %d, got %d: %s", exitInvalid, code, stderr)
+	}
+
+	if got := emails(jsonRecords(t, stdout)); strings.Join(got, ",") != "valid@lab.test,nobody@lab.test" {
+		t.Errorf("expected a result per address, got %v", got)
+	}
+	expectLogs(t, stderr)
+}
+
+func TestBatchOutput(t *testing.T) {
+	smtp, flags := lab(t)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	if err := ioutil.WriteFile(input, []byte("valid@lab.test\nnobody@lab.test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	db := filepath.Join(dir, "history.sqlite")
+
+	code, stdout, stderr := runMailcheck(context.Background(), "",
+		append([]string{"batch", "-output", "json", "-input", input, "-db", db, "-blcheck=false", "-preflight=false",
+			"-report", filepath.Join(dir, "report.json")}, flags...)...)
+	if code != exitInvalid {
+		t.Errorf("expected exit code %d, got %d: %s", exitInvalid, code, stderr)
+	}
+
+	if got := jsonRecords(t, stdout); len(got) != 2 {
+		t.Errorf("expected a result per address, got %v", emails(got))
+	}
+	expectLogs(t, stderr)
+
+	t.Run("skip-if-verified-within", func(t *testing.T) {
+		probes := len(smtp.Commands())
+
+		code, stdout, stderr := runMailcheck(context.Background(), "",
+			append([]string{"batch", "-output", "json", "-input", input, "-db", db, "-blcheck=false", "-preflight=false",
+				"-skip-if-verified-within", "1h"}, flags...)...)
+		if code != exitInvalid {
+			t.Errorf("expected exit code %d, got %d: %s", exitInvalid, code, stderr)
+		}
+
+		records := jsonRecords(t, stdout)
+		if got := emails(records); strings.Join(got, ",") != "valid@lab.test,nobody@lab.test" {
+			t.Errorf("expected the recorded result of every address, got %v", got)
+		}
+		for _, record := range records {
+			if record["checked_at"] == nil || record["verdict"] == nil {
+				t.Errorf("expected a recorded result, got %v", record)
+			}
+		}
+		if len(smtp.Commands()) != probes {
+			t.Errorf("expected no probes, got %v", smtp.Commands()[probes:])
+		}
+	})
+
+	t.Run("history", func(t *testing.T) {
+		code, stdout, stderr := runMailcheck(context.Background(), "", "history", "-output", "json", "-db", db,
+			"-log-level", "debug", "-verdicts", "valid")
+		if code != exitValid {
+			t.Errorf("expected exit code %d, got %d: %s", exitValid, code, stderr)
+		}
+
+		if got := emails(jsonRecords(t, stdout)); strings.Join(got, ",") != "valid@lab.test" {
+			t.Errorf("expected the valid address, got %v", got)
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		code, stdout, stderr := runMailcheck(context.Background(), "", "cache", "stats", "-output", "json", "-db", db)
+		if code != exitValid {
+			t.Errorf("expected exit code %d, got %d: %s", exitValid, code, stderr)
+		}
+
+		records := jsonRecords(t, stdout)
+		if len(records) != 1 || fmt.Sprint(records[0]["history"].(map[string]interface{})["verifications"]) != "2" {
+			t.Errorf("expected the stats of both verifications, got %v", records)
+		}
+
+		if code, _, stderr := runMailcheck(context.Background(), "", "cache", "purge", "-db", db); code != exitUsage {
+			t.Errorf("expected purging without -older-than to be a usage error, got %d: %s", code, stderr)
+		}
+
+		code, stdout, stderr = runMailcheck(context.Background(), "", "cache", "purge", "-output", "json", "-db", db,
+			"-older-than", "1h")
+		if code != exitValid {
+			t.Errorf("expected exit code %d, got %d: %s", exitValid, code, stderr)
+		}
+		if records := jsonRecords(t, stdout); len(records) != 1 || fmt.Sprint(records[0]["verifications"]) != "0" {
+			t.Errorf("expected no verification to be old enough, got %v", records)
+		}
+	})
+}
+
+func TestDomainOutput(t *testing.T) {
+	_, flags := lab(t)
+
+	code, stdout, stderr := runMailcheck(context.Background(), "",
+		append([]string{"domain", "-output", "json", "-level", "deep"}, append(flags, "lab.test")...)...)
+	if code != exitValid {
+		t.Errorf("expected exit code %d, got %d: %s", exitValid, code, stderr)
+	}
+
+	records := jsonRecords(t, stdout)
+	if len(records) != 1 || records[0]["domain"] != "lab.test" {
+		t.Errorf("expected the result of lab.test, got %v", records)
+	}
+}
+
+func TestWatchOutput(t *testing.T) {
+	smtp, flags := lab(t)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	if err := ioutil.WriteFile(input, []byte("valid@lab.test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	state := filepath.Join(dir, "state.json")
+
+	// a round is over once it saves the state, the next one only starts after the interval
+	round := func(previous []byte) (stdout, stderr string) {
+		_ = os.Remove(state)
+		if previous != nil {
+			if err := ioutil.WriteFile(state, previous, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		past := time.Now().Add(-time.Hour)
+		_ = os.Chtimes(state, past, past)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func() {
+			for ctx.Err() == nil {
+				if info, err := os.Stat(state); err == nil && info.ModTime().After(past.Add(time.Minute)) {
+					cancel()
+					return
+				}
+				time.Sleep(time.Millisecond * 10)
+			}
+		}()
+
+		code, stdout, stderr := runMailcheck(ctx, "",
+			append([]string{"watch", "-output", "json", "-input", input, "-state", state, "-interval", "1h"}, flags...)...)
+		if code != exitValid {
+			t.Errorf("expected exit code %d, got %d: %s", exitValid, code, stderr)
+		}
+		return stdout, stderr
+	}
+
+	// the first round only sets the baseline
+	stdout, stderr := round(nil)
+	if stdout != "" {
+		t.Errorf("expected no changes in the first round, got %q", stdout)
+	}
+	expectLogs(t, stderr)
+
+	baseline, err := ioutil.ReadFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	smtp.SetReply("valid@lab.test", mailchecktest.Reject)
+
+	stdout, _ = round(baseline)
+	if got := emails(jsonRecords(t, stdout)); strings.Join(got, ",") != "valid@lab.test" {
+		t.Errorf("expected the address that became invalid, got %v", got)
+	}
+}
+
+func TestReplOutput(t *testing.T) {
+	_, flags := lab(t)
+
+	code, stdout, stderr := runMailcheck(context.Background(), "valid@lab.test\nnobody@lab.test\n.quit\n",
+		append([]string{"repl"}, flags...)...)
+	if code != exitValid {
+		t.Errorf("expected exit code %d, got %d: %s", exitValid, code, stderr)
+	}
+
+	// the repl is meant for people, its results are text
+	for _, want := range []string{"valid@lab.test", "nobody@lab.test"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("expected the result of %s, got %q", want, stdout)
+		}
+	}
+	if strings.Contains(stdout, "level=") {
+		t.Errorf("expected no logs on stdout, got %q", stdout)
+	}
+}
+
+func TestServeOutput(t *testing.T) {
+	_, flags := lab(t)
+
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var code int
+	var stdout, stderr string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		code, stdout, stderr = runMailcheck(ctx, "",
+			append([]string{"serve", "-listen", unixScheme + socket, "-shutdown-timeout", "5s"}, flags...)...)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	var res *http.Response
+	var err error
+	for started := time.Now(); time.Since(started) < time.Second*10; time.Sleep(time.Millisecond * 20) {
+		if res, err = client.Get("http://mailcheck/v1/check?email=valid@lab.test"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		cancel()
+		<-done
+		t.Fatalf("could not reach the api: %v\n%s", err, stderr)
+	}
+
+	var result map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	_ = res.Body.Close()
+	if err != nil || result["verdict"] != "valid" {
+		t.Errorf("expected valid@lab.test to be valid, got %v (%v)", result, err)
+	}
+
+	// the dns cache of the server can be inspected while it runs
+	cacheCode, cacheStdout, cacheStderr := runMailcheck(context.Background(), "", "cache", "stats", "-output", "json",
+		"-server", unixScheme+socket)
+	if records := jsonRecords(t, cacheStdout); cacheCode != exitValid || len(records) != 1 || records[0]["dns_cache"] == nil {
+		t.Errorf("expected the dns cache stats of the server, got %d %v: %s", cacheCode, records, cacheStderr)
+	}
+
+	// clearing takes an admin key, which a server without api keys has none of
+	cacheCode, cacheStdout, cacheStderr = runMailcheck(context.Background(), "", "cache", "purge", "-output", "json",
+		"-server", unixScheme+socket)
+	if cacheCode == exitValid || cacheStdout != "" || !strings.Contains(cacheStderr, "admin key") {
+		t.Errorf("expected clearing the cache to be refused, got %d %q: %s", cacheCode, cacheStdout, cacheStderr)
+	}
+
+	cancel()
+	<-done
+
+	if code != exitValid {
+		t.Errorf("expected exit code %d, got %d: %s", exitValid, code, stderr)
+	}
+	// results go to the clients of the api
+	if stdout != "" {
+		t.Errorf("expected nothing on stdout, got %q", stdout)
+	}
+	expectLogs(t, stderr)
+}
+
+func TestBatchGroupingFollowsLimits(t *testing.T) {
+	_, flags := lab(t)
+
+	dir := t.TempDir()
+	hosts := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(hosts, []byte("127.0.0.1 lab.test other.test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "input.txt")
+	if err := ioutil.WriteFile(input, []byte("valid@lab.test\nnobody@other.test\nnobody@lab.test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(cfg, []byte("limits:\n  max_rcpt_per_session: 1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := func(extra ...string) []string {
+		args := append([]string{"batch", "-output", "json", "-input", input, "-blcheck=false", "-preflight=false"}, flags...)
+		code, stdout, stderr := runMailcheck(context.Background(), "", append(append(args, "-dns-hosts", hosts), extra...)...)
+		if code != exitInvalid {
+			t.Errorf("expected exit code %d, got %d: %s", exitInvalid, code, stderr)
+		}
+		return emails(jsonRecords(t, stdout))
+	}
+
+	// sessions are shared by default, so addresses at the same domain are checked back to back
+	if got := strings.Join(batch(), ","); got != "valid@lab.test,nobody@lab.test,nobody@other.test" {
+		t.Errorf("expected the addresses grouped by domain, got %s", got)
+	}
+
+	// the limits of -config win over the flag, one address per session leaves nothing to group
+	if got := strings.Join(batch("-config", cfg), ","); got != "valid@lab.test,nobody@other.test,nobody@lab.test" {
+		t.Errorf("expected the addresses in input order, got %s", got)
+	}
+}
+
+func TestCheckTimeoutTotal(t *testing.T) {
+	_, flags := lab(t)
+
+	// stdin stays open, so only the total deadline ends the run
+	stdin, input := io.Pipe()
+	t.Cleanup(func() { _ = input.Close() })
+	go func() { _, _ = io.WriteString(input, "valid@lab.test\n") }()
+
+	var stdout, stderr syncBuffer
+	started := time.Now()
+	code := run(context.Background(), append(append([]string{"check", "-output", "json"}, flags...), "-timeout-total", "500ms", "-"),
+		streams{stdin: stdin, stdout: &stdout, stderr: &stderr})
+	if code != exitUnknown {
+		t.Errorf("expected exit code %d, got %d: %s", exitUnknown, code, stderr.String())
+	}
+	if elapsed := time.Since(started); elapsed > time.Second*5 {
+		t.Errorf("expected the run to stop at its deadline, took %s", elapsed)
+	}
+
+	if got := emails(jsonRecords(t, stdout.String())); strings.Join(got, ",") != "valid@lab.test" {
+		t.Errorf("expected the address read before the deadline, got %v", got)
+	}
+	if !strings.Contains(stderr.String(), "stopped after 1 addresses") {
+		t.Errorf("expected the stop to be logged, got %s", stderr.String())
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/hazcod/mailcheck"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runMetrics aggregates the results of a run, for short-lived batch runs that cannot be scraped.
+// They are exported in the Prometheus text format, which both the Pushgateway and
+// the node exporter textfile collector accept.
+type runMetrics struct {
+	start    time.Time
+	results  map[string]int
+	attempts map[string]int
+	dnsCache mailcheck.DNSCacheStats
+}
+
+func newRunMetrics() *runMetrics {
+	return &runMetrics{
+		start:    time.Now(),
+		results:  map[string]int{},
+		attempts: map[string]int{},
+	}
+}
+
+// record counts a single result.
+func (m *runMetrics) record(res mailcheck.Result) {
+	m.results[fmt.Sprintf(`verdict="%s",reason="%s"`, res.Verdict, res.Reason)]++
+
+	for stage, attempts := range res.Attempts {
+		m.attempts[fmt.Sprintf(`stage="%s"`, stage)] += attempts
+	}
+}
+
+// write outputs the metrics in the Prometheus text exposition format.
+func (m *runMetrics) write(w io.Writer) error {
+	var buf bytes.Buffer
+
+	writeFamily(&buf, "mailcheck_results", "Number of checked addresses per verdict and reason.", m.results)
+	writeFamily(&buf, "mailcheck_attempts", "Number of attempts made per stage.", m.attempts)
+	writeFamily(&buf, "mailcheck_dns_cache_lookups", "Number of DNS lookups by whether the cache answered them.", map[string]int{
+		`result="hit"`:          m.dnsCache.Hits - m.dnsCache.NegativeHits,
+		`result="negative_hit"`: m.dnsCache.NegativeHits,
+		`result="miss"`:         m.dnsCache.Misses,
+	})
+
+	_, _ = fmt.Fprintf(&buf, "# HELP mailcheck_dns_cache_entries Number of DNS answers cached at the end of the run.\n# TYPE mailcheck_dns_cache_entries gauge\n")
+	_, _ = fmt.Fprintf(&buf, "mailcheck_dns_cache_entries %d\n", m.dnsCache.Entries)
+	_, _ = fmt.Fprintf(&buf, "# HELP mailcheck_run_duration_seconds Duration of the run.\n# TYPE mailcheck_run_duration_seconds gauge\n")
+	_, _ = fmt.Fprintf(&buf, "mailcheck_run_duration_seconds %f\n", time.Since(m.start).Seconds())
+	_, _ = fmt.Fprintf(&buf, "# HELP mailcheck_run_timestamp_seconds Time the run finished.\n# TYPE mailcheck_run_timestamp_seconds gauge\n")
+	_, _ = fmt.Fprintf(&buf, "mailcheck_run_timestamp_seconds %d\n", time.Now().Unix())
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeFamily(buf *bytes.Buffer, name, help string, samples map[string]int) {
+	_, _ = fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+
+	labels := make([]string, 0, len(samples))
+	for label := range samples {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		_, _ = fmt.Fprintf(buf, "%s{%s} %d\n", name, label, samples[label])
+	}
+}
+
+// push replaces the metrics of job on a Prometheus Pushgateway.
+func (m *runMetrics) push(ctx context.Context, gatewayURL, job string) error {
+	var body bytes.Buffer
+	if err := m.write(&body); err != nil {
+		return err
+	}
+
+	pushURL := strings.TrimSuffix(gatewayURL, "/") + "/metrics/job/" + url.PathEscape(job)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, pushURL, &body)
+	if err != nil {
+		return errors.Wrap(err, "could not create pushgateway request")
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not push metrics")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("pushgateway returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// writeTextfile atomically replaces path with the metrics, so the node exporter never reads a partial file.
+func (m *runMetrics) writeTextfile(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".mailcheck-*.prom")
+	if err != nil {
+		return errors.Wrap(err, "could not create metrics file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := m.write(tmp); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "could not write metrics file")
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "could not write metrics file")
+	}
+
+	// TempFile creates files readable by the owner only
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return errors.Wrap(err, "could not write metrics file")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), path), "could not write metrics file")
+}
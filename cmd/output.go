@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/mailcheck"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// resultWriter is the only place results are written. It owns the results stream (stdout),
+// while everything else, logs and progress included, goes to stderr.
+type resultWriter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+	// template formats every result instead of format when set
+	template *template.Template
+}
+
+func newResultWriter(out io.Writer, format string) (*resultWriter, error) {
+	switch format {
+	case outputText, outputJSON:
+	default:
+		return nil, errors.Errorf("unknown output format '%s'", format)
+	}
+
+	return &resultWriter{out: out, format: format}, nil
+}
+
+// useTemplate formats every result with the Go template text from now on.
+func (w *resultWriter) useTemplate(text string) error {
+	tmpl, err := template.New("format").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return errors.Wrap(err, "invalid format")
+	}
+
+	w.template = tmpl
+	return nil
+}
+
+// Write outputs a single result, one line per result regardless of the format.
+func (w *resultWriter) Write(r mailcheck.Result) error {
+	detail := r.Error
+	if detail == "" && r.Response != "" {
+		detail = fmt.Sprintf("%d %s", r.Code, strings.ReplaceAll(r.Response, "\n", " "))
+		if r.VerifiedBy != "" {
+			detail = r.VerifiedBy + " " + detail
+		}
+	}
+
+	suggestion := ""
+	if r.Suggestion != "" {
+		suggestion = fmt.Sprintf("did you mean %s?", r.Suggestion)
+	}
+
+	var kinds []string
+	if r.Role {
+		kinds = append(kinds, "role")
+	}
+	if r.FreeProvider {
+		kinds = append(kinds, "free_provider")
+	}
+	if r.Disposable {
+		kinds = append(kinds, "disposable")
+	}
+	if r.Provider != "" {
+		kinds = append(kinds, string(r.Provider))
+	}
+	if r.ProviderLimited {
+		kinds = append(kinds, "provider_limited")
+	}
+	if r.Inferred != "" {
+		kinds = append(kinds, "inferred:"+r.Inferred)
+	}
+	if r.CheckedAt != nil {
+		kinds = append(kinds, "checked:"+r.CheckedAt.Format(time.RFC3339))
+	}
+	if r.ListedOn != "" {
+		kinds = append(kinds, "listed:"+r.ListedOn)
+	}
+	if r.DNSSEC != "" {
+		kinds = append(kinds, dnssecKind(r.DNSSEC))
+	}
+	if r.YoungDomain {
+		kinds = append(kinds, "young_domain")
+	}
+	if r.Spamtrap != nil && r.Spamtrap.Likely {
+		kinds = append(kinds, "spamtrap")
+	}
+	if r.TLS != nil {
+		kinds = append(kinds, tlsKinds(*r.TLS)...)
+	}
+	for _, name := range sortedKeys(r.Enrichments) {
+		if r.Enrichments[name].Found {
+			kinds = append(kinds, name)
+		}
+	}
+
+	return w.writeLine(r, r.Email, verdictText(r), strings.Join(kinds, ","), detail, suggestion)
+}
+
+// tlsKinds flags the problems of a mail server certificate: tls_expired and tls_self_signed.
+func tlsKinds(cert mailcheck.TLSCertificate) (kinds []string) {
+	if cert.Expired {
+		kinds = append(kinds, "tls_expired")
+	}
+	if cert.SelfSigned {
+		kinds = append(kinds, "tls_self_signed")
+	}
+	return kinds
+}
+
+// verdictText is the verdict of res with its reason, as in the text output.
+func verdictText(res mailcheck.Result) string {
+	if res.Reason == "" {
+		return string(res.Verdict)
+	}
+	return string(res.Verdict) + ":" + string(res.Reason)
+}
+
+// writeLine outputs v formatted by the template, as a JSON line, or the non-empty text fields separated by tabs.
+func (w *resultWriter) writeLine(v interface{}, fields ...string) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch {
+	case w.template != nil:
+		var line bytes.Buffer
+		if err = w.template.Execute(&line, v); err == nil {
+			if !bytes.HasSuffix(line.Bytes(), []byte("\n")) {
+				line.WriteByte('\n')
+			}
+			_, err = w.out.Write(line.Bytes())
+		}
+	case w.format == outputJSON:
+		encoder := json.NewEncoder(w.out)
+		encoder.SetEscapeHTML(false)
+		err = encoder.Encode(v)
+	default:
+		var line []string
+		for _, field := range fields {
+			if field != "" {
+				line = append(line, field)
+			}
+		}
+		_, err = fmt.Fprintln(w.out, strings.Join(line, "\t"))
+	}
+
+	return errors.Wrap(err, "could not write result")
+}
+
+// sortedKeys returns the names of enrichments in order, so they are listed the same way every time.
+func sortedKeys(enrichments map[string]mailcheck.Enrichment) []string {
+	names := make([]string, 0, len(enrichments))
+	for name := range enrichments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// dnssecKind names the outcome of validating the lookups of a domain: dnssec, dns_insecure or dns_bogus.
+func dnssecKind(status mailcheck.DNSSECStatus) string {
+	if status == mailcheck.DNSSECSecure {
+		return "dnssec"
+	}
+
+	return "dns_" + string(status)
+}
+
+// report writes res to results, after saving it along with its transcript in transcriptDir when one is set
+// and recording it in db, if any.
+func report(results *resultWriter, res mailcheck.Result, transcriptDir string, db mailcheck.Store) error {
+	saveTranscript(transcriptDir, &res)
+	saveRecord(db, res)
+	return results.Write(res)
+}
+
+// saveRecord records res in db, if any. A failure is logged, the verification itself stands.
+func saveRecord(db mailcheck.Store, res mailcheck.Result) {
+	// a verdict inferred from the store would only keep itself alive
+	if db == nil || res.Inferred == mailcheck.InferredHistory {
+		return
+	}
+
+	// the check may have been interrupted, its result is worth keeping still
+	if err := db.Save(context.Background(), mailcheck.NewRecord(res)); err != nil {
+		log.Error(err)
+	}
+}
+
+// saveTranscript writes res to a file in dir, if set, and drops the transcript from res.
+func saveTranscript(dir string, res *mailcheck.Result) {
+	if dir == "" {
+		return
+	}
+
+	if err := writeTranscript(dir, *res); err != nil {
+		log.Error(err)
+	}
+
+	// transcripts are written to their own files, keep the results compact
+	res.Transcript = nil
+}
+
+// writeTranscript stores res, including its SMTP transcript, as a JSON file in dir.
+func writeTranscript(dir string, res mailcheck.Result) error {
+	// keep the address recognizable while making sure it stays a single file name
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator || r == 0 {
+			return '_'
+		}
+		return r
+	}, res.Email)
+
+	var contents bytes.Buffer
+	encoder := json.NewEncoder(&contents)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(res); err != nil {
+		return errors.Wrap(err, "could not encode transcript")
+	}
+
+	path := filepath.Join(dir, name+".json")
+	if err := ioutil.WriteFile(path, contents.Bytes(), 0600); err != nil {
+		return errors.Wrap(err, "could not write transcript")
+	}
+
+	return nil
+}
+
+// attemptFields turns the attempts per stage into log fields.
+func attemptFields(tries map[string]int) log.Fields {
+	fields := log.Fields{}
+	for stage, attempts := range tries {
+		fields[stage+"_attempts"] = attempts
+	}
+	return fields
+}
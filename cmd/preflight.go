@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"github.com/hazcod/mailcheck"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// senderPreflight checks that the identities we probe as match our egress IP in DNS, logging what does not,
+// and reports whether all of them are set up well. Mail servers reject or greylist senders that are not,
+// which turns the results of a run into noise.
+func senderPreflight(ctx context.Context, checker *mailcheck.Checker) (ok bool) {
+	ip, err := checker.EgressIP(ctx)
+	if err != nil {
+		log.Warnf("skipping sender preflight: %v", err)
+		return true
+	}
+
+	ok = true
+	for _, check := range checker.CheckSenders(ctx, ip) {
+		if check.OK() {
+			log.Debugf("sender %s from %s is set up well, spf %s", check.Identity.HELO, check.IP, check.SPF)
+			continue
+		}
+
+		log.Warnf("sender %s from %s is misconfigured, results will be skewed: %s", check.Identity.HELO, check.IP, strings.Join(check.Problems, "; "))
+		ok = false
+	}
+
+	return ok
+}
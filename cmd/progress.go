@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/mailcheck"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// progress reports how far a batch run is. On a terminal it redraws a status line after every result,
+// otherwise it writes a JSON status line every interval.
+type progress struct {
+	mu       sync.Mutex
+	out      io.Writer
+	terminal bool
+	interval time.Duration
+	start    time.Time
+	status   progressStatus
+	done     chan struct{}
+}
+
+// progressStatus is a single status line.
+type progressStatus struct {
+	Processed int     `json:"processed"`
+	Total     int     `json:"total"`
+	Valid     int     `json:"valid"`
+	Invalid   int     `json:"invalid"`
+	Unknown   int     `json:"unknown"`
+	Rate      float64 `json:"rate_per_second"`
+	ETA       float64 `json:"eta_seconds"`
+}
+
+// newProgress starts reporting the progress of checking total addresses to out.
+// Without a terminal and with a zero interval nothing is reported.
+func newProgress(out io.Writer, terminal bool, interval time.Duration, total int) *progress {
+	p := &progress{
+		out:      out,
+		terminal: terminal,
+		interval: interval,
+		start:    time.Now(),
+		status:   progressStatus{Total: total},
+		done:     make(chan struct{}),
+	}
+
+	if !terminal && interval > 0 {
+		go p.tick()
+	}
+
+	return p
+}
+
+func (p *progress) tick() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			p.writeJSON()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// record counts res.
+func (p *progress) record(res mailcheck.Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.status.Processed++
+	switch res.Verdict {
+	case mailcheck.VerdictValid:
+		p.status.Valid++
+	case mailcheck.VerdictInvalid:
+		p.status.Invalid++
+	default:
+		p.status.Unknown++
+	}
+
+	if p.terminal {
+		p.draw()
+	}
+}
+
+// snapshot returns the current status and when the run started.
+func (p *progress) snapshot() (progressStatus, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.update()
+	return p.status, p.start
+}
+
+// clear removes the status line from the terminal, so a result can be written in its place.
+func (p *progress) clear() {
+	if !p.terminal {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, _ = fmt.Fprint(p.out, "\r\033[K")
+}
+
+// stop ends the reporting with a final status line.
+func (p *progress) stop() {
+	close(p.done)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.terminal:
+		p.draw()
+		_, _ = fmt.Fprintln(p.out)
+	case p.interval > 0:
+		p.writeJSON()
+	}
+}
+
+// update computes the rate and the estimated time left.
+func (p *progress) update() {
+	elapsed := time.Since(p.start).Seconds()
+	if elapsed <= 0 || p.status.Processed == 0 {
+		return
+	}
+
+	rate := float64(p.status.Processed) / elapsed
+	p.status.Rate = math.Round(rate*100) / 100
+	p.status.ETA = math.Round(float64(p.status.Total-p.status.Processed) / rate)
+}
+
+func (p *progress) draw() {
+	p.update()
+
+	percent := 0.0
+	if p.status.Total > 0 {
+		percent = float64(p.status.Processed) / float64(p.status.Total) * 100
+	}
+
+	_, _ = fmt.Fprintf(p.out, "\r\033[K%d/%d (%.0f%%) valid %d invalid %d unknown %d, %.1f/s, eta %s",
+		p.status.Processed, p.status.Total, percent, p.status.Valid, p.status.Invalid, p.status.Unknown,
+		p.status.Rate, time.Duration(p.status.ETA)*time.Second)
+}
+
+func (p *progress) writeJSON() {
+	p.update()
+
+	line, err := json.Marshal(p.status)
+	if err != nil {
+		return
+	}
+
+	_, _ = fmt.Fprintf(p.out, "%s\n", line)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"github.com/hazcod/mailcheck"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// readinessTTL is how long a self-test is trusted, so frequent probes do not hammer the mail server it connects to
+	readinessTTL     = time.Second * 30
+	readinessTimeout = time.Second * 10
+)
+
+// readiness tells load balancers whether the server can take checks: it is not while draining,
+// or when the checker fails its self-test.
+type readiness struct {
+	checker *mailcheck.Checker
+	domain  string
+	// draining is set once shutdown started
+	draining int32
+
+	mu     sync.Mutex
+	last   mailcheck.SelfTest
+	tested time.Time
+}
+
+// selfTest returns the outcome of the last self-test, testing again once it is older than readinessTTL.
+func (r *readiness) selfTest(ctx context.Context) mailcheck.SelfTest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.tested) > readinessTTL {
+		ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		defer cancel()
+
+		r.last, r.tested = r.checker.SelfTest(ctx, r.domain), time.Now()
+	}
+
+	return r.last
+}
+
+func (r *readiness) drain() {
+	atomic.StoreInt32(&r.draining, 1)
+}
+
+// handleHealth serves GET /healthz, which only tells that the server is running.
+func (r *readiness) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{"ok"})
+}
+
+// handleReady serves GET /readyz with the outcome of the self-test, failing while draining.
+func (r *readiness) handleReady(w http.ResponseWriter, req *http.Request) {
+	if atomic.LoadInt32(&r.draining) == 1 {
+		writeError(w, http.StatusServiceUnavailable, "draining")
+		return
+	}
+
+	test := r.selfTest(req.Context())
+
+	status := http.StatusOK
+	if !test.OK() {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, struct {
+		Ready bool `json:"ready"`
+		mailcheck.SelfTest
+	}{test.OK(), test})
+}
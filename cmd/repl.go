@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/hazcod/mailcheck"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const replPrompt = "mailcheck> "
+
+const replHelp = `  <address>        check an address
+  .domain <domain> show how a domain handles mail
+  .last            show the last result in full
+  .transcript      show the SMTP conversation of the last check
+  .help            show this help
+  .quit            leave, Ctrl-C and Ctrl-D work too`
+
+// repl reads addresses one per line and prints a verdict for each, using a single Checker
+// so that SMTP sessions and caches are kept between queries.
+type repl struct {
+	checker *mailcheck.Checker
+	store   mailcheck.Store
+	timeout time.Duration
+	// terminal is nil when stdin is not a terminal, lines are then read without prompt or colors
+	terminal *term.Terminal
+	scanner  *bufio.Scanner
+	out      io.Writer
+	// domains holds every domain checked so far, for tab completion
+	domains map[string]bool
+	// last is the result of the last address checked, nil before the first
+	last *mailcheck.Result
+}
+
+func newReplCommand(std streams) *ffcli.Command {
+	flags := flag.NewFlagSet("mailcheck repl", flag.ContinueOnError)
+	global := newGlobalFlags(flags, std)
+	global.recordTranscripts = true
+
+	return &ffcli.Command{
+		Name:       "repl",
+		ShortUsage: "mailcheck repl [flags]",
+		ShortHelp:  "check addresses one by one at an interactive prompt",
+		FlagSet:    flags,
+		Exec: func(ctx context.Context, _ []string) error {
+			return runRepl(ctx, global)
+		},
+	}
+}
+
+// runRepl implements the repl subcommand, an interactive prompt to check addresses one by one.
+func runRepl(ctx context.Context, global *globalFlags) error {
+	checker, err := global.checker()
+	if err != nil {
+		return err
+	}
+	defer checker.Close()
+
+	db, err := global.store()
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	ctx, cancel := global.withTimeoutTotal(ctx)
+	defer cancel()
+
+	r := &repl{
+		checker: checker,
+		store:   db,
+		timeout: global.timeoutPerAddress,
+		out:     global.std.stdout,
+		domains: map[string]bool{},
+	}
+
+	fd, ok := terminalFd(global.std.stdin)
+	if !ok {
+		r.scanner = bufio.NewScanner(global.std.stdin)
+		r.run(ctx)
+		return nil
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return errors.Wrap(err, "could not set up terminal")
+	}
+	defer func() { _ = term.Restore(fd, state) }()
+
+	r.terminal = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{global.std.stdin, global.std.stdout}, replPrompt)
+	r.terminal.AutoCompleteCallback = r.complete
+	r.out = r.terminal
+
+	// logs stay on stderr, which shares the terminal in raw mode that no longer returns the carriage on a newline
+	if isTerminal(global.std.stderr) {
+		log.SetOutput(rawModeWriter{global.std.stderr})
+		defer log.SetOutput(global.std.stderr)
+	}
+
+	_, _ = fmt.Fprintln(r.out, "type an address to check it, tab completes domains seen before, .help lists commands")
+	r.run(ctx)
+	return nil
+}
+
+// rawModeWriter writes to a terminal in raw mode, ending every line with a carriage return as well.
+type rawModeWriter struct {
+	w io.Writer
+}
+
+func (w rawModeWriter) Write(p []byte) (int, error) {
+	if _, err := w.w.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// run handles lines until the input ends or the user quits.
+func (r *repl) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		line, err := r.readLine()
+		if err != nil {
+			if err != io.EOF {
+				log.Error(err)
+			}
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch command, args := fields[0], fields[1:]; {
+		case command == ".quit", command == ".exit":
+			return
+		case command == ".help":
+			_, _ = fmt.Fprintln(r.out, replHelp)
+		case command == ".domain" && len(args) == 1:
+			r.domain(ctx, args[0])
+		case command == ".last":
+			r.showLast()
+		case command == ".transcript":
+			r.showTranscript()
+		case strings.HasPrefix(command, "."):
+			_, _ = fmt.Fprintf(r.out, "unknown command %s, .help lists commands\n", line)
+		default:
+			r.check(ctx, strings.TrimSpace(line))
+		}
+	}
+}
+
+func (r *repl) readLine() (string, error) {
+	if r.terminal != nil {
+		return r.terminal.ReadLine()
+	}
+
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+
+	return r.scanner.Text(), nil
+}
+
+// check verifies email and prints its verdict with the details that led to it.
+func (r *repl) check(ctx context.Context, email string) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	res := r.checker.Check(ctx, email)
+	took := time.Since(start)
+
+	saveRecord(r.store, res)
+	r.last = &res
+
+	if res.Reason != mailcheck.ReasonSyntax {
+		r.domains[strings.ToLower(email[strings.LastIndex(email, "@")+1:])] = true
+	}
+
+	verdict := string(res.Verdict)
+	if res.Reason != "" {
+		verdict += ":" + string(res.Reason)
+	}
+
+	_, _ = fmt.Fprintf(r.out, "%s %s\n", r.color(res.Verdict, verdict), res.Email)
+
+	var kinds []string
+	if res.Role {
+		kinds = append(kinds, "role account")
+	}
+	if res.FreeProvider {
+		kinds = append(kinds, "free provider")
+	}
+	if res.Disposable {
+		kinds = append(kinds, "disposable")
+	}
+
+	details := [][2]string{
+		{"kind", strings.Join(kinds, ", ")},
+		{"suggestion", res.Suggestion},
+		{"error", res.Error},
+	}
+
+	if res.MX != "" {
+		details = append(details, [2]string{"server", fmt.Sprintf("%s:%d", res.MX, res.Port)})
+	}
+
+	if res.Code != 0 && res.Error == "" {
+		reply := fmt.Sprintf("%d %s", res.Code, strings.ReplaceAll(res.Response, "\n", " "))
+		if res.VerifiedBy != "" {
+			reply = res.VerifiedBy + " " + reply
+		}
+		details = append(details, [2]string{"reply", reply})
+	}
+
+	score := strconv.Itoa(res.Score)
+	if len(res.Reasons) > 0 {
+		score += " (" + strings.Join(res.Reasons, ", ") + ")"
+	}
+
+	details = append(details, [2]string{"score", score}, [2]string{"took", took.Round(time.Millisecond).String()})
+	r.printDetails(details)
+}
+
+// printDetails prints the non-empty details as an indented list of names and values.
+func (r *repl) printDetails(details [][2]string) {
+	for _, detail := range details {
+		if detail[1] != "" {
+			_, _ = fmt.Fprintf(r.out, "  %-11s %s\n", detail[0], detail[1])
+		}
+	}
+}
+
+// domain shows how domain handles mail.
+func (r *repl) domain(ctx context.Context, domain string) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	res := r.checker.CheckDomain(ctx, domain)
+	took := time.Since(start)
+
+	if res.Error == "" {
+		r.domains[strings.ToLower(domain)] = true
+	}
+
+	suggestion := ""
+	if res.Suggestion != "" {
+		suggestion = "did you mean " + res.Suggestion + "?"
+	}
+
+	_, _ = fmt.Fprintln(r.out, res.Domain)
+	r.printDetails([][2]string{
+		{"mx", strings.Join(res.MX, ", ")},
+		{"kind", strings.Join(domainKinds(res), ", ")},
+		{"error", res.Error},
+		{"suggestion", suggestion},
+		{"took", took.Round(time.Millisecond).String()},
+	})
+}
+
+// showLast prints the last result with every field, as JSON.
+func (r *repl) showLast() {
+	if r.last == nil {
+		_, _ = fmt.Fprintln(r.out, "no address checked yet")
+		return
+	}
+
+	// the transcript has its own command
+	last := *r.last
+	last.Transcript = nil
+
+	contents, err := json.MarshalIndent(last, "", "  ")
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	_, _ = fmt.Fprintln(r.out, string(contents))
+}
+
+// showTranscript prints the SMTP conversation of the last check, a line per exchange.
+func (r *repl) showTranscript() {
+	if r.last == nil || len(r.last.Transcript) == 0 {
+		_, _ = fmt.Fprintln(r.out, "no SMTP conversation to show")
+		return
+	}
+
+	for _, ex := range r.last.Transcript {
+		took := ex.Duration.Round(time.Millisecond)
+
+		switch {
+		case ex.Error != "":
+			_, _ = fmt.Fprintf(r.out, "  %s ! %s (%s)\n", ex.Server, ex.Error, took)
+			continue
+		case ex.TLS != nil:
+			_, _ = fmt.Fprintf(r.out, "  %s = %s %s (%s)\n", ex.Server, ex.TLS.Version, ex.TLS.CipherSuite, took)
+			continue
+		case ex.Command != "":
+			_, _ = fmt.Fprintf(r.out, "  %s > %s\n", ex.Server, ex.Command)
+		}
+
+		for _, line := range strings.Split(ex.Response, "\n") {
+			_, _ = fmt.Fprintf(r.out, "  %s < %d %s (%s)\n", ex.Server, ex.Code, line, took)
+		}
+	}
+}
+
+// color wraps text in the color of verdict, when writing to a terminal.
+func (r *repl) color(verdict mailcheck.Verdict, text string) string {
+	if r.terminal == nil {
+		return text
+	}
+
+	color := r.terminal.Escape.Yellow
+	switch verdict {
+	case mailcheck.VerdictValid:
+		color = r.terminal.Escape.Green
+	case mailcheck.VerdictInvalid:
+		color = r.terminal.Escape.Red
+	}
+
+	return string(color) + text + string(r.terminal.Escape.Reset)
+}
+
+// complete is the tab completion of the terminal, it completes the domain of the address
+// before the cursor to the longest prefix shared by all matching domains seen before.
+func (r *repl) complete(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	at := strings.LastIndex(line[:pos], "@")
+	if at < 0 || strings.ContainsAny(line[at:pos], " \t") {
+		return "", 0, false
+	}
+
+	typed := strings.ToLower(line[at+1 : pos])
+
+	var matches []string
+	for domain := range r.domains {
+		if strings.HasPrefix(domain, typed) {
+			matches = append(matches, domain)
+		}
+	}
+
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	sort.Strings(matches)
+	completion := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, completion) {
+			_, size := utf8.DecodeLastRuneInString(completion)
+			completion = completion[:len(completion)-size]
+		}
+	}
+
+	return line[:at+1] + completion + line[pos:], at + 1 + len(completion), true
+}
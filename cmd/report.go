@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/mailcheck"
+	"github.com/pkg/errors"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// topFailingDomains is the number of failing domains listed in a report.
+const topFailingDomains = 10
+
+// retryReasons are the unknown outcomes that may well turn out differently when checked again later.
+var retryReasons = map[mailcheck.Reason]bool{
+	mailcheck.ReasonTemporary:   true,
+	mailcheck.ReasonUnreachable: true,
+	mailcheck.ReasonSenderIssue: true,
+	mailcheck.ReasonDNSError:    true,
+	mailcheck.ReasonDNSFailed:   true,
+}
+
+// batchReport is the overview of a batch run.
+type batchReport struct {
+	Total    int                       `json:"total"`
+	Verdicts map[mailcheck.Verdict]int `json:"verdicts"`
+	// FailingDomains are the domains with the most invalid or unknown addresses.
+	FailingDomains  []domainCount `json:"failing_domains,omitempty"`
+	CatchAllDomains []string      `json:"catch_all_domains,omitempty"`
+	// MXLatency is the average time checking an address took, by the mail server that answered.
+	MXLatency []mxLatency `json:"mx_latency,omitempty"`
+	// Retry are the addresses with an unknown verdict that is worth checking again later.
+	Retry []string `json:"retry,omitempty"`
+	// DNSCache tells how many lookups the DNS cache saved.
+	DNSCache mailcheck.DNSCacheStats `json:"dns_cache"`
+}
+
+type domainCount struct {
+	Domain string `json:"domain"`
+	Count  int    `json:"count"`
+}
+
+type mxLatency struct {
+	MX      string  `json:"mx"`
+	Checks  int     `json:"checks"`
+	Average float64 `json:"average_ms"`
+}
+
+// runReport collects the results of a batch run into a batchReport.
+type runReport struct {
+	total    int
+	verdicts map[mailcheck.Verdict]int
+	failing  map[string]int
+	catchAll map[string]bool
+	checks   map[string]int
+	latency  map[string]time.Duration
+	retry    []string
+	dnsCache mailcheck.DNSCacheStats
+}
+
+func newRunReport() *runReport {
+	return &runReport{
+		verdicts: map[mailcheck.Verdict]int{},
+		failing:  map[string]int{},
+		catchAll: map[string]bool{},
+		checks:   map[string]int{},
+		latency:  map[string]time.Duration{},
+	}
+}
+
+// record adds a result that took elapsed to check.
+func (r *runReport) record(res mailcheck.Result, elapsed time.Duration) {
+	r.total++
+	r.verdicts[res.Verdict]++
+
+	domain := strings.ToLower(res.Email[strings.LastIndex(res.Email, "@")+1:])
+	if res.Verdict != mailcheck.VerdictValid {
+		r.failing[domain]++
+	}
+
+	if res.CatchAll {
+		r.catchAll[domain] = true
+	}
+
+	if res.MX != "" {
+		r.checks[res.MX]++
+		r.latency[res.MX] += elapsed
+	}
+
+	if res.Verdict == mailcheck.VerdictUnknown && retryReasons[res.Reason] {
+		r.retry = append(r.retry, res.Email)
+	}
+}
+
+// report returns the overview of the results recorded so far.
+func (r *runReport) report() batchReport {
+	report := batchReport{
+		Total:    r.total,
+		Verdicts: r.verdicts,
+		Retry:    r.retry,
+		DNSCache: r.dnsCache,
+	}
+
+	for domain := range r.catchAll {
+		report.CatchAllDomains = append(report.CatchAllDomains, domain)
+	}
+	sort.Strings(report.CatchAllDomains)
+
+	for domain, count := range r.failing {
+		report.FailingDomains = append(report.FailingDomains, domainCount{Domain: domain, Count: count})
+	}
+	sort.Slice(report.FailingDomains, func(i, j int) bool {
+		a, b := report.FailingDomains[i], report.FailingDomains[j]
+		return a.Count > b.Count || (a.Count == b.Count && a.Domain < b.Domain)
+	})
+	if len(report.FailingDomains) > topFailingDomains {
+		report.FailingDomains = report.FailingDomains[:topFailingDomains]
+	}
+
+	for mx, checks := range r.checks {
+		average := r.latency[mx] / time.Duration(checks)
+		report.MXLatency = append(report.MXLatency, mxLatency{MX: mx, Checks: checks, Average: float64(average.Microseconds()) / 1000})
+	}
+	sort.Slice(report.MXLatency, func(i, j int) bool {
+		return report.MXLatency[i].MX < report.MXLatency[j].MX
+	})
+
+	return report
+}
+
+// writeText outputs the report for people.
+func (b batchReport) writeText(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "checked %d addresses:", b.Total)
+	for _, verdict := range []mailcheck.Verdict{mailcheck.VerdictValid, mailcheck.VerdictInvalid, mailcheck.VerdictUnknown} {
+		_, _ = fmt.Fprintf(w, " %d %s", b.Verdicts[verdict], verdict)
+	}
+	_, _ = fmt.Fprintln(w)
+
+	if len(b.FailingDomains) > 0 {
+		_, _ = fmt.Fprintln(w, "top failing domains:")
+		for _, domain := range b.FailingDomains {
+			_, _ = fmt.Fprintf(w, "  %s\t%d\n", domain.Domain, domain.Count)
+		}
+	}
+
+	if len(b.CatchAllDomains) > 0 {
+		_, _ = fmt.Fprintf(w, "catch-all domains: %s\n", strings.Join(b.CatchAllDomains, ", "))
+	}
+
+	if len(b.MXLatency) > 0 {
+		_, _ = fmt.Fprintln(w, "average latency per mail server:")
+		for _, mx := range b.MXLatency {
+			_, _ = fmt.Fprintf(w, "  %s\t%.0fms over %d checks\n", mx.MX, mx.Average, mx.Checks)
+		}
+	}
+
+	if len(b.Retry) > 0 {
+		_, _ = fmt.Fprintf(w, "worth retrying later: %s\n", strings.Join(b.Retry, ", "))
+	}
+
+	if lookups := b.DNSCache.Hits + b.DNSCache.Misses; lookups > 0 {
+		_, _ = fmt.Fprintf(w, "dns cache: %d of %d lookups answered from cache, %d negative\n", b.DNSCache.Hits, lookups, b.DNSCache.NegativeHits)
+	}
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>mailcheck report</title></head>
+<body>
+<h1>Checked {{.Total}} addresses</h1>
+<table>
+{{range $verdict, $count := .Verdicts}}<tr><td>{{$verdict}}</td><td>{{$count}}</td></tr>
+{{end}}</table>
+{{with .FailingDomains}}<h2>Top failing domains</h2>
+<table>
+{{range .}}<tr><td>{{.Domain}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}{{with .CatchAllDomains}}<h2>Catch-all domains</h2>
+<ul>
+{{range .}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}{{with .MXLatency}}<h2>Average latency per mail server</h2>
+<table>
+<tr><th>Mail server</th><th>Checks</th><th>Average (ms)</th></tr>
+{{range .}}<tr><td>{{.MX}}</td><td>{{.Checks}}</td><td>{{printf "%.0f" .Average}}</td></tr>
+{{end}}</table>
+{{end}}{{with .Retry}}<h2>Worth retrying later</h2>
+<ul>
+{{range .}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}{{with .DNSCache}}<h2>DNS cache</h2>
+<table>
+<tr><td>Hits</td><td>{{.Hits}}</td></tr>
+<tr><td>Negative hits</td><td>{{.NegativeHits}}</td></tr>
+<tr><td>Misses</td><td>{{.Misses}}</td></tr>
+</table>
+{{end}}</body>
+</html>
+`))
+
+// writeFile writes the report to path, as HTML when it ends in .html and as JSON otherwise.
+func (b batchReport) writeFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "could not create report")
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		err = reportTemplate.Execute(file, b)
+	default:
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(b)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return errors.Wrap(err, "could not write report")
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"github.com/hazcod/mailcheck"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRequestAddresses limits the addresses checked in a single request
+	maxRequestAddresses = 100
+	// envWebhookSecret holds the key webhook bodies are signed with
+	envWebhookSecret = "MAILCHECK_WEBHOOK_SECRET"
+)
+
+// server answers checks over HTTP.
+type server struct {
+	checker       *mailcheck.Checker
+	timeout       time.Duration
+	transcriptDir string
+	store         mailcheck.Store
+	webhooks      *webhooks
+	jobs          *jobQueue
+	keys          *apiKeys
+	idempotency   *idempotencyKeys
+	// ctx bounds the work that continues after a request was answered, wg tracks it
+	ctx context.Context
+	wg  sync.WaitGroup
+}
+
+// serveFlags are the flags of the serve subcommand on top of the global flags.
+type serveFlags struct {
+	*globalFlags
+
+	listen          string
+	socketMode      string
+	fastCGI         bool
+	hooks           *webhooks
+	workers         int
+	retention       time.Duration
+	idempotencyTTL  time.Duration
+	shutdownTimeout time.Duration
+	readyDomain     string
+}
+
+func newServeCommand(std streams) *ffcli.Command {
+	flags := flag.NewFlagSet("mailcheck serve", flag.ContinueOnError)
+	f := &serveFlags{
+		globalFlags: newGlobalFlags(flags, std),
+		hooks:       &webhooks{secret: []byte(os.Getenv(envWebhookSecret))},
+	}
+
+	flags.StringVar(&f.listen, "listen", ":8080", "address to serve the http api on, or unix:///path/to.sock for a unix socket")
+	flags.StringVar(&f.socketMode, "socket-mode", "0660", "permissions of the unix socket of -listen")
+	flags.BoolVar(&f.fastCGI, "fastcgi", false, "serve the api over FastCGI instead of HTTP, for web servers such as nginx")
+	flags.IntVar(&f.hooks.retries, "webhook-retries", 5, "number of retries of a failed webhook delivery")
+	flags.DurationVar(&f.hooks.backoff, "webhook-backoff", time.Second*5, "delay before the first webhook retry, doubled on every next retry")
+	flags.BoolVar(&f.hooks.allowPrivate, "allow-private-callbacks", false, "let callback urls reach loopback, link-local and private addresses, for callbacks within a trusted network")
+	flags.IntVar(&f.workers, "job-workers", 2, "number of jobs checked at the same time")
+	flags.DurationVar(&f.retention, "job-retention", time.Hour*24, "how long the results of a finished job are kept")
+	flags.DurationVar(&f.idempotencyTTL, "idempotency-ttl", time.Hour*24, "how long the response to a submission with an Idempotency-Key is sent again to retries")
+	flags.DurationVar(&f.shutdownTimeout, "shutdown-timeout", time.Second*30, "how long to let checks in progress finish when stopping")
+	flags.StringVar(&f.readyDomain, "ready-domain", "gmail.com", "domain whose mail servers /readyz looks up and connects to")
+
+	return &ffcli.Command{
+		Name:       "serve",
+		ShortUsage: "mailcheck serve [flags]",
+		ShortHelp:  "serve checks over a REST api",
+		LongHelp: "GET /v1/check?email=<email> checks a single address, POST /v1/check with {\"emails\": [...]}\n" +
+			"checks up to 100 addresses and GET /v1/domain?domain=<domain> checks a domain.\n" +
+			"With a \"callback_url\" the addresses are checked in the background and their results posted to it,\n" +
+			"signed with the secret in " + envWebhookSecret + ", without which callbacks are refused.\n" +
+			"POST /v1/jobs with {\"emails\": [...]} checks a list of any size in the background, GET /v1/jobs/<id>\n" +
+			"reports its progress and GET /v1/jobs/<id>/results returns the results so far.\n" +
+			"A retried POST with the same Idempotency-Key header and body gets the first response again.\n" +
+			"With api_keys in the configuration file every request needs a key, as bearer token or in X-API-Key,\n" +
+			"and GET /v1/usage reports how much of its limits it used.\n" +
+			"GET /v1/cache reports the use of the DNS cache, DELETE /v1/cache clears it with an admin key, see\n" +
+			"mailcheck cache.\n" +
+			"GET /healthz tells the server runs and GET /readyz whether it can resolve and reach mail servers.\n" +
+			"-listen unix:///run/mailcheck.sock serves on a unix socket, -fastcgi speaks FastCGI instead of HTTP.\n" +
+			"On SIGTERM or an interrupt it stops taking requests and lets the checks in progress finish.",
+		FlagSet: flags,
+		Exec: func(ctx context.Context, _ []string) error {
+			return f.run(ctx)
+		},
+	}
+}
+
+// run implements the serve subcommand, serving until ctx is done and then draining.
+func (f *serveFlags) run(ctx context.Context) error {
+	if f.workers < 1 {
+		return usage(errors.New("at least one job worker is needed"))
+	}
+
+	socketMode, err := parseSocketMode(f.socketMode)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := f.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	f.hooks.client = callbackClient(f.hooks.allowPrivate)
+	if len(f.hooks.secret) == 0 {
+		log.Warnf("%s is not set, requests with a callback_url are refused", envWebhookSecret)
+	}
+
+	checker, err := f.checker()
+	if err != nil {
+		return err
+	}
+	defer checker.Close()
+
+	db, err := f.store()
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	ctx, cancel := f.withTimeoutTotal(ctx)
+	defer cancel()
+
+	// checks outlive ctx by up to the shutdown timeout, so the ones in progress can finish
+	workCtx, stopWork := context.WithCancel(context.Background())
+	defer stopWork()
+
+	s := &server{
+		checker:       checker,
+		timeout:       f.timeoutPerAddress,
+		transcriptDir: f.transcript,
+		store:         db,
+		webhooks:      f.hooks,
+		jobs:          newJobQueue(f.retention),
+		keys:          newAPIKeys(cfg.APIKeys),
+		idempotency:   newIdempotencyKeys(f.idempotencyTTL),
+		ctx:           workCtx,
+	}
+
+	ready := &readiness{checker: checker, domain: f.readyDomain}
+
+	for i := 0; i < f.workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.jobs.work(ctx, workCtx, s.check)
+		}()
+	}
+
+	api := http.NewServeMux()
+	api.Handle("/v1/check", s.idempotency.wrap(http.HandlerFunc(s.handleCheck)))
+	api.HandleFunc("/v1/domain", s.handleDomain)
+	api.Handle("/v1/jobs", s.idempotency.wrap(http.HandlerFunc(s.handleJobs)))
+	api.HandleFunc("/v1/jobs/", s.handleJob)
+	api.HandleFunc("/v1/usage", s.handleUsage)
+	api.HandleFunc("/v1/cache", s.handleCache)
+
+	// probes of load balancers and orchestrators go without api key
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", ready.handleHealth)
+	mux.HandleFunc("/readyz", ready.handleReady)
+	mux.Handle("/", s.keys.wrap(api))
+
+	listener, err := listen(f.listen, socketMode)
+	if err != nil {
+		return err
+	}
+
+	httpServer := &http.Server{
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return workCtx },
+	}
+
+	serve := func() error { return httpServer.Serve(listener) }
+	shutdown := httpServer.Shutdown
+	if f.fastCGI {
+		fastCGI := &fastCGIServer{handler: mux, listener: listener}
+		serve, shutdown = fastCGI.Serve, fastCGI.Shutdown
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+
+		ready.drain()
+		log.Infof("draining, waiting up to %s for the checks in progress", f.shutdownTimeout)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), f.shutdownTimeout)
+		defer cancel()
+
+		if err := shutdown(shutdownCtx); err != nil {
+			log.Errorf("could not shut down gracefully: %v", err)
+		}
+
+		// background checks get what is left of the timeout
+		background := make(chan struct{})
+		go func() {
+			s.wg.Wait()
+			close(background)
+		}()
+
+		select {
+		case <-background:
+		case <-shutdownCtx.Done():
+			log.Warn("shutdown timeout reached, abandoning the checks in progress")
+		}
+
+		stopWork()
+	}()
+
+	log.Infof("serving on %s", f.listen)
+
+	if err := serve(); err != http.ErrServerClosed {
+		return errors.Wrap(err, "could not serve")
+	}
+
+	<-drained
+	s.wg.Wait()
+
+	return nil
+}
+
+func (s *server) handleCheck(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		email := r.URL.Query().Get("email")
+		if email == "" {
+			writeError(w, http.StatusBadRequest, "missing email parameter")
+			return
+		}
+
+		if !s.keys.charge(w, r, 1) {
+			return
+		}
+
+		writeJSON(w, http.StatusOK, s.check(r.Context(), email))
+
+	case http.MethodPost:
+		var request struct {
+			Emails      []string `json:"emails"`
+			CallbackURL string   `json:"callback_url"`
+			Callback    string   `json:"callback"`
+		}
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+
+		if len(request.Emails) == 0 || len(request.Emails) > maxRequestAddresses {
+			writeError(w, http.StatusBadRequest, "between 1 and 100 emails can be checked per request")
+			return
+		}
+
+		if !s.keys.charge(w, r, len(request.Emails)) {
+			return
+		}
+
+		if request.CallbackURL != "" {
+			s.handleCallback(w, request.Emails, request.CallbackURL, request.Callback)
+			return
+		}
+
+		response := struct {
+			Results []mailcheck.Result `json:"results"`
+		}{}
+		for _, email := range mailcheck.GroupByDomain(request.Emails) {
+			response.Results = append(response.Results, s.check(r.Context(), email))
+		}
+
+		writeJSON(w, http.StatusOK, response)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "use GET or POST")
+	}
+}
+
+// handleCallback accepts emails to be checked in the background, posting the results to callbackURL
+// one by one or all at once when done.
+func (s *server) handleCallback(w http.ResponseWriter, emails []string, callbackURL, mode string) {
+	if mode == "" {
+		mode = callbackBatch
+	}
+
+	if mode != callbackEach && mode != callbackBatch {
+		writeError(w, http.StatusBadRequest, "callback must be each or batch")
+		return
+	}
+
+	// receivers could not tell our results from forged ones
+	if len(s.webhooks.secret) == 0 {
+		writeError(w, http.StatusBadRequest, "callbacks are disabled, the server has no "+envWebhookSecret+" to sign them with")
+		return
+	}
+
+	if !validCallbackURL(callbackURL) {
+		writeError(w, http.StatusBadRequest, "callback_url must be an absolute http or https url")
+		return
+	}
+
+	// names are only refused once they resolve, when delivering, but an address can be refused right away
+	if u, _ := url.Parse(callbackURL); !s.webhooks.allowPrivate {
+		if ip := net.ParseIP(u.Hostname()); ip != nil && privateIP(ip) {
+			writeError(w, http.StatusBadRequest, "callback_url may not point to a private address")
+			return
+		}
+	}
+
+	batchID, err := newID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.checkInBackground(batchID, emails, callbackURL, mode)
+	}()
+
+	writeJSON(w, http.StatusAccepted, struct {
+		BatchID string `json:"batch_id"`
+		Emails  int    `json:"emails"`
+	}{batchID, len(emails)})
+}
+
+// checkInBackground checks emails and delivers the results to callbackURL.
+func (s *server) checkInBackground(batchID string, emails []string, callbackURL, mode string) {
+	results := make([]mailcheck.Result, 0, len(emails))
+
+	for _, email := range mailcheck.GroupByDomain(emails) {
+		res := s.check(s.ctx, email)
+		if s.ctx.Err() != nil {
+			log.Warnf("abandoned batch %s after %d of %d addresses", batchID, len(results), len(emails))
+			return
+		}
+		results = append(results, res)
+
+		if mode == callbackEach {
+			if err := s.webhooks.deliver(s.ctx, callbackURL, webhookEvent{Event: "result", BatchID: batchID, Result: res}); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+
+	if mode == callbackBatch {
+		if err := s.webhooks.deliver(s.ctx, callbackURL, webhookEvent{Event: "batch", BatchID: batchID, Results: results}); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+func (s *server) handleDomain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	domain := r.URL.Query().Get("domain")
+	if domain == "" {
+		writeError(w, http.StatusBadRequest, "missing domain parameter")
+		return
+	}
+
+	if !s.keys.charge(w, r, 1) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+
+	writeJSON(w, http.StatusOK, s.checker.CheckDomain(ctx, domain))
+}
+
+// check verifies a single address within the time allowed per address.
+func (s *server) check(ctx context.Context, email string) mailcheck.Result {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	res := s.checker.Check(ctx, email)
+	log.WithFields(attemptFields(res.Attempts)).Debugf("%s is %s", email, res.Verdict)
+
+	saveTranscript(s.transcriptDir, &res)
+	saveRecord(s.store, res)
+	return res
+}
+
+// newID returns a random identifier.
+func newID() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", errors.Wrap(err, "could not generate id")
+	}
+
+	return hex.EncodeToString(random), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		log.Debugf("could not write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{message})
+}
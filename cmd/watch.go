@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/hazcod/mailcheck"
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// watchFlags are the flags of the watch subcommand on top of the global flags.
+type watchFlags struct {
+	*globalFlags
+
+	input    string
+	interval time.Duration
+	state    string
+	webhook  string
+	hooks    *webhooks
+}
+
+// watchState is the last conclusive verdict of every watched address, kept in -state between rounds and runs.
+type watchState map[string]watchEntry
+
+type watchEntry struct {
+	Verdict mailcheck.Verdict `json:"verdict"`
+	Reason  mailcheck.Reason  `json:"reason,omitempty"`
+	Checked time.Time         `json:"checked"`
+}
+
+// watchChange is an address whose verdict flipped since the previous round.
+type watchChange struct {
+	Email    string            `json:"email"`
+	Previous mailcheck.Verdict `json:"previous"`
+	Result   mailcheck.Result  `json:"result"`
+}
+
+func newWatchCommand(std streams) *ffcli.Command {
+	flags := flag.NewFlagSet("mailcheck watch", flag.ContinueOnError)
+	w := &watchFlags{
+		globalFlags: newGlobalFlags(flags, std),
+		// unlike the callback urls of api clients, -webhook is set by whoever runs mailcheck and may be private
+		hooks: &webhooks{client: &http.Client{}, secret: []byte(os.Getenv(envWebhookSecret))},
+	}
+
+	flags.StringVar(&w.input, "input", "", "file with the addresses to watch, one per line, read again every round")
+	flags.DurationVar(&w.interval, "interval", time.Hour*24, "time between the start of two rounds")
+	flags.StringVar(&w.state, "state", "mailcheck-watch.json", "file to keep the last verdict of every address in")
+	flags.StringVar(&w.webhook, "webhook", "", "url to post the changes of every round to, signed with the secret in "+envWebhookSecret)
+	flags.IntVar(&w.hooks.retries, "webhook-retries", 5, "number of retries of a failed webhook delivery")
+	flags.DurationVar(&w.hooks.backoff, "webhook-backoff", time.Second*5, "delay before the first webhook retry, doubled on every next retry")
+
+	return &ffcli.Command{
+		Name:       "watch",
+		ShortUsage: "mailcheck watch -input <file> [flags]",
+		ShortHelp:  "check a list again and again, reporting the addresses whose verdict changed",
+		LongHelp: "Checks the addresses in -input every -interval until interrupted. Only addresses whose verdict\n" +
+			"flipped between valid and invalid since their last conclusive check are written to stdout.",
+		FlagSet: flags,
+		Exec:    w.run,
+	}
+}
+
+// run implements the watch subcommand.
+func (w *watchFlags) run(ctx context.Context, _ []string) error {
+	if w.input == "" {
+		return flag.ErrHelp
+	}
+
+	if w.interval <= 0 {
+		return usage(errors.New("-interval must be positive"))
+	}
+
+	if w.webhook != "" && !validCallbackURL(w.webhook) {
+		return usage(errors.New("-webhook must be an absolute http or https url"))
+	}
+
+	if w.webhook != "" && len(w.hooks.secret) == 0 {
+		return usage(errors.Errorf("-webhook needs a secret to sign the changes with in %s", envWebhookSecret))
+	}
+
+	results, err := w.results()
+	if err != nil {
+		return err
+	}
+
+	state, err := loadWatchState(w.state)
+	if err != nil {
+		return usage(err)
+	}
+
+	checker, err := w.checker()
+	if err != nil {
+		return err
+	}
+	defer checker.Close()
+
+	db, err := w.store()
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		defer db.Close()
+	}
+
+	ctx, cancel := w.withTimeoutTotal(ctx)
+	defer cancel()
+
+	for {
+		started := time.Now()
+
+		if err := w.round(ctx, checker, db, state, results); err != nil {
+			return err
+		}
+
+		// a round may take longer than the interval, the next one then starts right away
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(started.Add(w.interval))):
+		}
+	}
+}
+
+// round checks every address of the input once, reports the changes and saves the state.
+func (w *watchFlags) round(ctx context.Context, checker *mailcheck.Checker, db mailcheck.Store, state watchState, results *resultWriter) error {
+	emails, err := readAddresses(w.input)
+	if err != nil {
+		// the list may be in the middle of being replaced, try again next round
+		log.Error(err)
+		return nil
+	}
+
+	emails, _ = mailcheck.Deduplicate(emails, mailcheck.Normalize)
+	if checker.Limits().MaxRcptPerSession > 1 {
+		emails = mailcheck.GroupByDomain(emails)
+	}
+
+	log.Infof("checking %d addresses", len(emails))
+
+	var changes []watchChange
+	for _, email := range emails {
+		addressCtx, cancel := context.WithTimeout(ctx, w.timeoutPerAddress)
+		res := checker.Check(addressCtx, email)
+		cancel()
+
+		// an interrupted check has no verdict, the state is kept as it was
+		if ctx.Err() != nil {
+			break
+		}
+
+		saveTranscript(w.transcript, &res)
+		saveRecord(db, res)
+
+		// unknown verdicts say nothing about the address, they neither count as a change nor replace a verdict
+		if res.Verdict == mailcheck.VerdictUnknown {
+			continue
+		}
+
+		previous, known := state[email]
+		state[email] = watchEntry{Verdict: res.Verdict, Reason: res.Reason, Checked: time.Now()}
+
+		// the first conclusive verdict of an address is where watching it starts
+		if !known || previous.Verdict == res.Verdict {
+			continue
+		}
+
+		change := watchChange{Email: email, Previous: previous.Verdict, Result: res}
+		changes = append(changes, change)
+
+		if err := results.writeLine(change, email, string(previous.Verdict)+" -> "+verdictText(res), res.Error); err != nil {
+			return err
+		}
+	}
+
+	checker.Close()
+
+	if err := saveWatchState(w.state, state); err != nil {
+		log.Error(err)
+	}
+
+	log.Infof("%d addresses changed", len(changes))
+
+	if w.webhook != "" && len(changes) > 0 {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+
+		if err := w.hooks.deliver(ctx, w.webhook, webhookEvent{Event: "changes", BatchID: id, Results: changes}); err != nil {
+			log.Error(err)
+		}
+	}
+
+	return nil
+}
+
+// loadWatchState reads the state at path, an empty state when it does not exist yet.
+func loadWatchState(path string) (watchState, error) {
+	state := watchState{}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read state")
+	}
+
+	if err := json.Unmarshal(contents, &state); err != nil {
+		return nil, errors.Wrap(err, "could not parse state")
+	}
+
+	return state, nil
+}
+
+// saveWatchState atomically replaces the state at path, so an interruption never leaves a partial file.
+func saveWatchState(path string, state watchState) error {
+	contents, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode state")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".mailcheck-watch-*.json")
+	if err != nil {
+		return errors.Wrap(err, "could not create state")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "could not write state")
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "could not write state")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), path), "could not write state")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	// signatureHeader holds the HMAC-SHA256 of the body, keyed with the webhook secret
+	signatureHeader = "X-Mailcheck-Signature"
+	webhookTimeout  = time.Second * 10
+
+	// callbackEach posts every result as soon as it is known, callbackBatch posts all results at once
+	callbackEach  = "each"
+	callbackBatch = "batch"
+)
+
+// privateNetworks are the networks callback urls chosen by api clients may not reach: loopback, link-local,
+// private, shared (carrier-grade NAT, also used for cloud metadata) and unspecified addresses.
+var privateNetworks = parseNetworks(
+	"127.0.0.0/8", "::1/128",
+	"169.254.0.0/16", "fe80::/10",
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
+	"100.64.0.0/10",
+	"0.0.0.0/8", "::/128",
+)
+
+// webhooks delivers results to the callback urls of clients.
+type webhooks struct {
+	client  *http.Client
+	secret  []byte
+	retries int
+	backoff time.Duration
+	// allowPrivate lets callback urls reach private addresses, see privateNetworks
+	allowPrivate bool
+}
+
+// webhookEvent is the body posted to a callback url.
+type webhookEvent struct {
+	Event   string      `json:"event"`
+	BatchID string      `json:"batch_id"`
+	Result  interface{} `json:"result,omitempty"`
+	Results interface{} `json:"results,omitempty"`
+}
+
+// validCallbackURL reports whether rawURL is an absolute http or https url.
+func validCallbackURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// privateIP reports whether ip is in one of privateNetworks.
+func privateIP(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// refusePrivate is a net.Dialer Control function that refuses connections to private addresses. It runs for
+// every address dialed once the host name is resolved, so a name that resolves to a public address when the url
+// is checked and to a private one when it is used, as with DNS rebinding, is refused all the same.
+func refusePrivate(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+		return errors.Errorf("refusing to connect to private address %s", host)
+	}
+
+	return nil
+}
+
+// callbackClient returns the client to deliver to the callback urls of api clients with. It refuses private
+// addresses unless allowPrivate, so the callbacks of the api cannot be used to reach the network of the server.
+// Proxies are not used, they would do the dialing instead.
+func callbackClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, KeepAlive: time.Second * 30}
+	if !allowPrivate {
+		dialer.Control = refusePrivate
+	}
+
+	return &http.Client{Transport: &http.Transport{
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     time.Second * 90,
+		TLSHandshakeTimeout: time.Second * 10,
+	}}
+}
+
+// sign returns the signature of body. Webhooks are only delivered with a secret to sign them with.
+func (h *webhooks) sign(body []byte) string {
+	mac := hmac.New(sha256.New, h.secret)
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts event to callbackURL, retrying with exponential backoff until it is accepted with a 2xx status.
+func (h *webhooks) deliver(ctx context.Context, callbackURL string, event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "could not encode webhook event")
+	}
+
+	delay := h.backoff
+	for attempt := 0; ; attempt++ {
+		if err = h.post(ctx, callbackURL, body); err == nil {
+			return nil
+		}
+
+		if attempt >= h.retries || ctx.Err() != nil {
+			return errors.Wrapf(err, "could not deliver %s event of batch %s", event.Event, event.BatchID)
+		}
+
+		log.Debugf("retrying %s event of batch %s in %s: %v", event.Event, event.BatchID, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "could not deliver %s event of batch %s", event.Event, event.BatchID)
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+func (h *webhooks) post(ctx context.Context, callbackURL string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not create webhook request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, h.sign(body))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
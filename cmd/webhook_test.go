@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/hazcod/mailcheck"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrivateIP(t *testing.T) {
+	for address, private := range map[string]bool{
+		"127.0.0.1":        true,
+		"::1":              true,
+		"169.254.169.254":  true,
+		"fe80::1":          true,
+		"10.1.2.3":         true,
+		"172.31.255.255":   true,
+		"192.168.0.10":     true,
+		"fd00::1":          true,
+		"100.100.100.200":  true,
+		"0.0.0.0":          true,
+		"::":               true,
+		"::ffff:127.0.0.1": true,
+		"172.32.0.1":       false,
+		"8.8.8.8":          false,
+		"2001:4860::8888":  false,
+	} {
+		if got := privateIP(net.ParseIP(address)); got != private {
+			t.Errorf("expected %s to be private: %v, got %v", address, private, got)
+		}
+	}
+}
+
+func TestCallbackClient(t *testing.T) {
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+	}))
+	defer server.Close()
+
+	// the name is only refused once it resolves to the loopback address, at dial time
+	byName := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	hooks := &webhooks{client: callbackClient(false)}
+	for _, callbackURL := range []string{server.URL, byName} {
+		err := hooks.deliver(context.Background(), callbackURL, webhookEvent{Event: "batch", BatchID: "1"})
+		if err == nil || !strings.Contains(err.Error(), "refusing to connect to private address") {
+			t.Errorf("expected %s to be refused, got %v", callbackURL, err)
+		}
+	}
+	if received != 0 {
+		t.Errorf("expected nothing to be delivered, got %d", received)
+	}
+
+	hooks = &webhooks{client: callbackClient(true)}
+	if err := hooks.deliver(context.Background(), server.URL, webhookEvent{Event: "batch", BatchID: "1"}); err != nil || received != 1 {
+		t.Errorf("expected the callback to be delivered with private callbacks allowed, got %v", err)
+	}
+}
+
+func TestHandleCallbackPrivate(t *testing.T) {
+	s := &server{webhooks: &webhooks{client: callbackClient(false), secret: []byte("secret")}}
+
+	res := httptest.NewRecorder()
+	s.handleCallback(res, []string{"jane@example.com"}, "http://169.254.169.254/latest/meta-data", callbackBatch)
+	if res.Code != http.StatusBadRequest || !strings.Contains(res.Body.String(), "private address") {
+		t.Errorf("expected a private callback url to be refused, got %d %s", res.Code, res.Body)
+	}
+}
+
+func TestHandleCallbackSigned(t *testing.T) {
+	signatures := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		_, _ = mac.Write(body)
+
+		if r.Header.Get(signatureHeader) == "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			signatures <- "valid"
+		} else {
+			signatures <- "invalid: " + r.Header.Get(signatureHeader)
+		}
+	}))
+	defer receiver.Close()
+
+	checker := mailcheck.New(mailcheck.Options{Level: mailcheck.LevelSyntax})
+	defer checker.Close()
+
+	// unsigned results could be forged by anyone who knows the callback url
+	s := &server{checker: checker, timeout: time.Second * 5, ctx: context.Background(), webhooks: &webhooks{client: callbackClient(true), allowPrivate: true}}
+
+	res := httptest.NewRecorder()
+	s.handleCallback(res, []string{"jane@example.com"}, receiver.URL, callbackBatch)
+	if res.Code != http.StatusBadRequest || !strings.Contains(res.Body.String(), envWebhookSecret) {
+		t.Errorf("expected callbacks to be refused without a secret, got %d %s", res.Code, res.Body)
+	}
+
+	s.webhooks.secret = []byte("secret")
+
+	res = httptest.NewRecorder()
+	s.handleCallback(res, []string{"jane@example.com"}, receiver.URL, callbackBatch)
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("expected the callback to be accepted, got %d %s", res.Code, res.Body)
+	}
+
+	s.wg.Wait()
+	if signature := <-signatures; signature != "valid" {
+		t.Errorf("expected the results to be signed, got %s", signature)
+	}
+}
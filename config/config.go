@@ -0,0 +1,210 @@
+package config
+
+import (
+	"github.com/hazcod/mailcheck"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// minAPIKeyLength keeps api keys from being guessable.
+const minAPIKeyLength = 16
+
+// Config is the optional configuration file of mailcheck.
+type Config struct {
+	// MXOverrides pins domains to mail servers, bypassing DNS for those domains.
+	// Every entry is a host or a host:port, without a port the configured ports are tried.
+	MXOverrides map[string][]string `yaml:"mx_overrides"`
+	// ScoreWeights overrides the penalties that make up the score, weights left out keep their default.
+	ScoreWeights mailcheck.ScoreWeights `yaml:"score_weights"`
+	// DisabledChecks are the names of the checks to leave out of the pipeline, such as catch_all or mx.
+	DisabledChecks []string `yaml:"disabled_checks"`
+	// Identities are rotated among for every session to a mail server, with a helo name, a mail from address,
+	// an optional source ip and an optional maximum number of probes each.
+	Identities []mailcheck.Identity `yaml:"identities"`
+	// APIKeys are the keys the HTTP API of serve accepts, which is open to anyone without them.
+	APIKeys []APIKey `yaml:"api_keys"`
+	// Limits override the flags of the same name, and are reloaded by a running batch on SIGHUP.
+	Limits Limits `yaml:"limits"`
+	// DomainProfiles adjust the checks of the domains they match, by exact domain or wildcard.
+	DomainProfiles []mailcheck.DomainProfile `yaml:"domain_profiles"`
+}
+
+// Limits are the rate limit and concurrency settings that can change during a run. Those left out keep the value
+// of their flag.
+type Limits struct {
+	RatePerDomain     *int `yaml:"rate_per_domain"`
+	RaceMX            *int `yaml:"race_mx"`
+	MaxRcptPerSession *int `yaml:"max_rcpt_per_session"`
+}
+
+// Apply returns limits with the ones set in l replaced.
+func (l Limits) Apply(limits mailcheck.Limits) mailcheck.Limits {
+	if l.RatePerDomain != nil {
+		limits.ProbesPerMinute = *l.RatePerDomain
+	}
+	if l.RaceMX != nil {
+		limits.RaceMailServers = *l.RaceMX
+	}
+	if l.MaxRcptPerSession != nil {
+		limits.MaxRcptPerSession = *l.MaxRcptPerSession
+	}
+
+	return limits
+}
+
+// APIKey grants access to the HTTP API of serve.
+type APIKey struct {
+	// Name identifies the key in logs and usage reports, Key is the secret sent along with every request.
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	// RatePerMinute limits the requests per minute, unlimited when zero.
+	RatePerMinute int `yaml:"rate_per_minute"`
+	// DailyQuota limits the addresses and domains checked per day, counted in UTC, unlimited when zero.
+	DailyQuota int `yaml:"daily_quota"`
+	// Admin allows reading the usage of every key rather than only its own, and clearing the caches of the server.
+	Admin bool `yaml:"admin"`
+}
+
+// LoadConfig reads and validates the configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	config := Config{ScoreWeights: mailcheck.DefaultScoreWeights}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read configuration file")
+	}
+
+	if err := yaml.UnmarshalStrict(contents, &config); err != nil {
+		return nil, errors.Wrap(err, "could not parse configuration file")
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid configuration")
+	}
+
+	return &config, nil
+}
+
+// Validate checks the configuration for errors.
+func (c *Config) Validate() error {
+	if weights := c.ScoreWeights; weights.Invalid < 0 || weights.Unknown < 0 || weights.NotProbed < 0 ||
+		weights.CatchAll < 0 || weights.Disposable < 0 || weights.Role < 0 || weights.FreeProvider < 0 ||
+		weights.NoSPF < 0 || weights.NoDMARC < 0 || weights.YoungDomain < 0 || weights.Spamtrap < 0 {
+		return errors.New("score weights cannot be negative")
+	}
+
+	for _, name := range c.DisabledChecks {
+		if name == mailcheck.CheckSyntax {
+			return errors.New("the syntax check cannot be disabled, every other check needs it")
+		}
+
+		if !isCheck(name) {
+			return errors.Errorf("unknown check '%s'", name)
+		}
+	}
+
+	for _, identity := range c.Identities {
+		if err := identity.Validate(); err != nil {
+			return err
+		}
+	}
+
+	names, keys := map[string]bool{}, map[string]bool{}
+	for _, key := range c.APIKeys {
+		switch {
+		case key.Name == "":
+			return errors.New("api key has no name")
+		case len(key.Key) < minAPIKeyLength:
+			return errors.Errorf("api key %s is shorter than %d characters", key.Name, minAPIKeyLength)
+		case names[key.Name]:
+			return errors.Errorf("api key name %s is used twice", key.Name)
+		case keys[key.Key]:
+			return errors.Errorf("api key %s is the same as another one", key.Name)
+		case key.RatePerMinute < 0 || key.DailyQuota < 0:
+			return errors.Errorf("negative limit of api key %s", key.Name)
+		}
+		names[key.Name], keys[key.Key] = true, true
+	}
+
+	for _, limit := range []*int{c.Limits.RatePerDomain, c.Limits.RaceMX} {
+		if limit != nil && *limit < 0 {
+			return errors.New("limits cannot be negative")
+		}
+	}
+	if c.Limits.MaxRcptPerSession != nil && *c.Limits.MaxRcptPerSession < 1 {
+		return errors.New("max_rcpt_per_session must be at least 1")
+	}
+
+	for _, profile := range c.DomainProfiles {
+		if err := profile.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for domain, servers := range c.MXOverrides {
+		if len(servers) == 0 {
+			return errors.Errorf("mx override for %s has no servers", domain)
+		}
+
+		for _, server := range servers {
+			if _, err := parseMailServer(server); err != nil {
+				return errors.Wrapf(err, "mx override for %s", domain)
+			}
+		}
+	}
+
+	return nil
+}
+
+// MailServers returns the mail server overrides keyed by lowercased domain.
+func (c *Config) MailServers() map[string][]mailcheck.MailServer {
+	overrides := map[string][]mailcheck.MailServer{}
+
+	for domain, entries := range c.MXOverrides {
+		domain = strings.ToLower(domain)
+
+		for _, entry := range entries {
+			// entries are validated when loading
+			server, _ := parseMailServer(entry)
+			overrides[domain] = append(overrides[domain], server)
+		}
+	}
+
+	return overrides
+}
+
+// Checks returns the default checks without the disabled ones.
+func (c *Config) Checks() []mailcheck.Check {
+	return mailcheck.WithoutChecks(mailcheck.DefaultChecks, c.DisabledChecks...)
+}
+
+func isCheck(name string) bool {
+	for _, check := range mailcheck.DefaultChecks {
+		if check.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseMailServer(entry string) (mailcheck.MailServer, error) {
+	if !strings.Contains(entry, ":") {
+		return mailcheck.MailServer{Host: entry}, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(entry)
+	if err != nil {
+		return mailcheck.MailServer{}, errors.Wrapf(err, "invalid server '%s'", entry)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return mailcheck.MailServer{}, errors.Errorf("invalid port in '%s'", entry)
+	}
+
+	return mailcheck.MailServer{Host: host, Port: port}, nil
+}
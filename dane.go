@@ -0,0 +1,160 @@
+package mailcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+const (
+	// daneTA and daneEE are the TLSA certificate usages RFC 7672 allows for SMTP,
+	// the PKIX usages are unusable since mail servers rarely have a certificate for their MX name.
+	daneTA = 2
+	daneEE = 3
+)
+
+// DANEResult tells whether a mail server is protected by DANE (RFC 7672) and whether it lives up to it.
+type DANEResult struct {
+	MX string `json:"mx"`
+	// Records is the number of usable TLSA records published for the mail server.
+	Records int `json:"records"`
+	// Protected means the records were authenticated with DNSSEC, without which they protect nothing.
+	Protected bool `json:"protected"`
+	// Valid means the certificate presented after STARTTLS matches one of the records.
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// CheckDANE looks up the TLSA records of server and, when it publishes any, verifies the certificate
+// it presents after STARTTLS against them. Servers without a fixed port are checked on port 25.
+// It makes a connection of its own: DANE is a property of the domain, Check does not verify the certificates
+// of the probes it sends against TLSA records.
+func (c *Checker) CheckDANE(ctx context.Context, server MailServer) (res DANEResult) {
+	res.MX = server.String()
+
+	port := server.Port
+	if port == 0 {
+		port = smtpPort
+	}
+
+	records, authenticated, err := c.lookupTLSA(ctx, server.Host, port)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Records = len(records)
+	if len(records) == 0 {
+		return res
+	}
+	res.Protected = authenticated
+
+	state, err := c.peerTLS(ctx, server.Host, port)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	if err := verifyTLSA(records, state.PeerCertificates, server.Host); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Valid = true
+	return res
+}
+
+// lookupTLSA returns the usable TLSA records of host for port and whether they are authenticated, by ourselves
+// with Options.DNSSEC or else by the resolver.
+func (c *Checker) lookupTLSA(ctx context.Context, host string, port int) (records []*dns.TLSA, authenticated bool, err error) {
+	name := fmt.Sprintf("_%d._tcp.%s", port, host)
+
+	var answer []dns.RR
+	if c.options.DNSSEC {
+		var status DNSSECStatus
+		answer, status, err = c.validate(ctx, name, dns.TypeTLSA)
+		authenticated = status == DNSSECSecure
+	} else {
+		var msg *dns.Msg
+		if msg, err = c.query(ctx, name, dns.TypeTLSA); err == nil {
+			answer, authenticated = msg.Answer, msg.AuthenticatedData
+		}
+	}
+	if err != nil {
+		return nil, false, errors.Wrap(err, "could not look up tlsa records")
+	}
+
+	for _, rr := range answer {
+		if tlsa, ok := rr.(*dns.TLSA); ok && (tlsa.Usage == daneTA || tlsa.Usage == daneEE) {
+			records = append(records, tlsa)
+		}
+	}
+
+	return records, authenticated, nil
+}
+
+// peerTLS returns the state of the TLS connection to host on port, after STARTTLS unless the port uses implicit TLS.
+func (c *Checker) peerTLS(ctx context.Context, host string, port int) (tls.ConnectionState, error) {
+	client, err := c.dialPort(ctx, host, port, nil)
+	if err != nil {
+		return tls.ConnectionState{}, errors.Wrap(err, "could not connect")
+	}
+	defer client.Close()
+
+	if _, ok := client.conn.(*tls.Conn); !ok {
+		if ok, _ := client.extension("STARTTLS"); !ok {
+			return tls.ConnectionState{}, errors.New("server does not offer STARTTLS")
+		}
+
+		// the certificate is judged by the caller rather than the usual certificate authorities
+		if err := client.startTLS(&tls.Config{InsecureSkipVerify: true, ServerName: host}); err != nil { //nolint:gosec
+			return tls.ConnectionState{}, err
+		}
+	}
+
+	return client.conn.(*tls.Conn).ConnectionState(), nil
+}
+
+// verifyTLSA checks the certificate chain of mx against records as RFC 7672 describes: a DANE-EE record must
+// match the server certificate, a DANE-TA record a certificate in the chain that the server certificate,
+// issued for mx, chains up to.
+func verifyTLSA(records []*dns.TLSA, chain []*x509.Certificate, mx string) error {
+	if len(chain) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	for _, record := range records {
+		if record.Usage == daneEE {
+			if record.Verify(chain[0]) == nil {
+				return nil
+			}
+			continue
+		}
+
+		for _, anchor := range chain {
+			if record.Verify(anchor) != nil {
+				continue
+			}
+
+			roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+			roots.AddCert(anchor)
+			for _, cert := range chain[1:] {
+				intermediates.AddCert(cert)
+			}
+
+			if _, err := chain[0].Verify(x509.VerifyOptions{
+				DNSName:       strings.TrimSuffix(mx, "."),
+				Roots:         roots,
+				Intermediates: intermediates,
+			}); err == nil {
+				return nil
+			}
+		}
+	}
+
+	return errors.New("certificate matches none of the tlsa records")
+}
@@ -0,0 +1,134 @@
+package mailcheck
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/miekg/dns"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// certificate issues a certificate for name, by parent or else by itself, and returns it with its key.
+func certificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	} else {
+		template.DNSNames = []string{name}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// tlsa returns a TLSA record with usage for the SHA-256 digest of the whole of cert.
+func tlsa(t *testing.T, name string, usage int, cert *x509.Certificate) *dns.TLSA {
+	t.Helper()
+
+	record := &dns.TLSA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTLSA, Class: dns.ClassINET, Ttl: 300}}
+	if err := record.Sign(usage, 0, 1, cert); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestVerifyTLSA(t *testing.T) {
+	ca, caKey := certificate(t, "Test CA", nil, nil)
+	server, _ := certificate(t, "mx.example.com", ca, caKey)
+	other, _ := certificate(t, "mx.example.com", ca, caKey)
+	otherCA, _ := certificate(t, "Other CA", nil, nil)
+
+	const name = "_25._tcp.mx.example.com."
+	chain := []*x509.Certificate{server, ca}
+
+	for _, test := range []struct {
+		name    string
+		records []*dns.TLSA
+		chain   []*x509.Certificate
+		mx      string
+		valid   bool
+	}{
+		{"dane-ee matches the server certificate", []*dns.TLSA{tlsa(t, name, daneEE, server)}, chain, "mx.example.com.", true},
+		{"dane-ee of another certificate", []*dns.TLSA{tlsa(t, name, daneEE, other)}, chain, "mx.example.com.", false},
+		{"dane-ee ignores the name", []*dns.TLSA{tlsa(t, name, daneEE, server)}, chain, "mx.example.org.", true},
+		{"dane-ta matches the issuer", []*dns.TLSA{tlsa(t, name, daneTA, ca)}, chain, "mx.example.com.", true},
+		{"dane-ta of another issuer", []*dns.TLSA{tlsa(t, name, daneTA, otherCA)}, chain, "mx.example.com.", false},
+		{"dane-ta needs the name of the mail server", []*dns.TLSA{tlsa(t, name, daneTA, ca)}, chain, "mx.example.org.", false},
+		{"dane-ta needs the anchor in the chain", []*dns.TLSA{tlsa(t, name, daneTA, ca)}, chain[:1], "mx.example.com.", false},
+		{"any record may match", []*dns.TLSA{tlsa(t, name, daneEE, other), tlsa(t, name, daneTA, ca)}, chain, "mx.example.com.", true},
+		{"no certificate", []*dns.TLSA{tlsa(t, name, daneEE, server)}, nil, "mx.example.com.", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyTLSA(test.records, test.chain, test.mx)
+			if test.valid && err != nil {
+				t.Errorf("expected the certificate to match, got %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected the certificate not to match")
+			}
+		})
+	}
+}
+
+func TestLookupTLSASkipsPKIXUsages(t *testing.T) {
+	checker, answers, zones := newSignedDNS(t)
+
+	ca, caKey := certificate(t, "Test CA", nil, nil)
+	server, _ := certificate(t, "mx.signed.test", ca, caKey)
+
+	// RFC 7672 leaves PKIX-TA (0) and PKIX-EE (1) out, mail servers rarely have a publicly trusted certificate
+	const name = "_25._tcp.mx.signed.test."
+	answers.answer(name, dns.TypeTLSA, zones["signed.test."].sign(t,
+		tlsa(t, name, 0, ca), tlsa(t, name, 1, server), tlsa(t, name, daneTA, ca), tlsa(t, name, daneEE, server))...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	records, authenticated, err := checker.lookupTLSA(ctx, "mx.signed.test.", 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !authenticated {
+		t.Error("expected the signed records to be authenticated")
+	}
+	if len(records) != 2 || records[0].Usage != daneTA || records[1].Usage != daneEE {
+		t.Errorf("expected only the dane-ta and dane-ee records, got %v", records)
+	}
+
+	// a server with only unusable records is not protected by DANE, it is not checked at all
+	const pkix = "_25._tcp.pkix.signed.test."
+	answers.answer(pkix, dns.TypeTLSA, zones["signed.test."].sign(t, tlsa(t, pkix, 1, server))...)
+
+	res := checker.CheckDANE(ctx, MailServer{Host: "pkix.signed.test."})
+	if res.Records != 0 || res.Valid || res.Error != "" {
+		t.Errorf("expected no usable records and no verdict, got %+v", res)
+	}
+}
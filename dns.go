@@ -0,0 +1,255 @@
+package mailcheck
+
+import (
+	"bufio"
+	"context"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	dnsPort = 53
+)
+
+// Hosts holds hosts-file style name to address mappings that take precedence over DNS.
+type Hosts map[string][]string
+
+// newResolver returns a resolver asking dnsServer, answering from cache where it can. cache may be nil.
+func newResolver(dialer *net.Dialer, dnsServer string, cache *dnsCache) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, "udp", dnsAddress(dnsServer))
+			if err != nil || cache == nil {
+				return conn, err
+			}
+			return &cachingConn{Conn: conn, cache: cache}, nil
+		},
+	}
+}
+
+// dnsAddress returns the host:port of dnsServer, on port 53 unless it has a port.
+func dnsAddress(dnsServer string) string {
+	if _, _, err := net.SplitHostPort(dnsServer); err == nil {
+		return dnsServer
+	}
+
+	return net.JoinHostPort(dnsServer, strconv.Itoa(dnsPort))
+}
+
+// lookupMX returns the mail servers of domain, validating the answer with Options.DNSSEC. A bogus answer is an error.
+func (c *Checker) lookupMX(ctx context.Context, domain string) (servers []string, status DNSSECStatus, err error) {
+	// a statically mapped domain is its own mail server, like the implicit MX of RFC 5321
+	if _, ok := c.options.Hosts.lookup(domain); ok {
+		return []string{domain}, "", nil
+	}
+
+	if c.options.DNSSEC {
+		return c.lookupValidatedMX(ctx, domain)
+	}
+
+	mxRecords, err := c.resolver.LookupMX(ctx, domain)
+	if err != nil {
+		return []string{}, "", err
+	}
+
+	for _, mx := range mxRecords {
+		servers = append(servers, mx.Host)
+	}
+
+	return servers, "", nil
+}
+
+// lookupValidatedMX is lookupMX with DNSSEC validation, it fails like net.Resolver.LookupMX when there are none.
+func (c *Checker) lookupValidatedMX(ctx context.Context, domain string) (servers []string, status DNSSECStatus, err error) {
+	records, status, err := c.validate(ctx, domain, dns.TypeMX)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if status == DNSSECBogus {
+		return nil, status, errors.Wrapf(errDNSSECBogus, "mx records of %s", domain)
+	}
+
+	var mxRecords []*dns.MX
+	for _, rr := range records {
+		mxRecords = append(mxRecords, rr.(*dns.MX))
+	}
+
+	if len(mxRecords) == 0 {
+		return nil, status, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+
+	sort.SliceStable(mxRecords, func(i, j int) bool {
+		return mxRecords[i].Preference < mxRecords[j].Preference
+	})
+
+	for _, mx := range mxRecords {
+		servers = append(servers, mx.Mx)
+	}
+
+	return servers, status, nil
+}
+
+// query asks the configured DNS server for the records of type qtype at name, with the DNSSEC OK bit set
+// so that the answer tells whether the resolver authenticated it. A name that does not exist is not an error.
+func (c *Checker) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.SetEdns0(4096, true)
+	msg.AuthenticatedData = true
+
+	// validating ourselves, we want the answers the resolver would reject as well
+	msg.CheckingDisabled = c.options.DNSSEC
+
+	if answer := c.dnsCache.get(msg); answer != nil {
+		return answer, nil
+	}
+
+	server := dnsAddress(c.options.DNSServer)
+	client := &dns.Client{Dialer: c.dialer}
+
+	answer, _, err := client.ExchangeContext(ctx, msg, server)
+	// answers too large for a datagram are asked again over tcp
+	if err == nil && answer.Truncated {
+		client.Net = "tcp"
+		answer, _, err = client.ExchangeContext(ctx, msg, server)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not query %s", name)
+	}
+
+	if answer.Rcode != dns.RcodeSuccess && answer.Rcode != dns.RcodeNameError {
+		return nil, errors.Errorf("could not query %s: %s", name, dns.RcodeToString[answer.Rcode])
+	}
+
+	c.dnsCache.put(msg, answer)
+
+	return answer, nil
+}
+
+// DomainAuth tells which sender authentication records a domain publishes.
+type DomainAuth struct {
+	SPF   bool `json:"spf"`
+	DMARC bool `json:"dmarc"`
+}
+
+// LookupDomainAuth looks up the SPF and DMARC records of domain. Domains that take care
+// to authenticate their mail are more likely to be real, maintained domains.
+func (c *Checker) LookupDomainAuth(ctx context.Context, domain string) (auth DomainAuth, err error) {
+	if _, ok := c.options.Hosts.lookup(domain); ok {
+		return auth, errors.Errorf("%s is statically mapped", domain)
+	}
+
+	if auth.SPF, err = c.hasTXT(ctx, domain, "v=spf1"); err != nil {
+		return auth, errors.Wrap(err, "could not look up spf record")
+	}
+
+	if auth.DMARC, err = c.hasTXT(ctx, "_dmarc."+domain, "v=DMARC1"); err != nil {
+		return auth, errors.Wrap(err, "could not look up dmarc record")
+	}
+
+	return auth, nil
+}
+
+// hasTXT reports whether name has a TXT record starting with prefix, ignoring case.
+func (c *Checker) hasTXT(ctx context.Context, name, prefix string) (bool, error) {
+	records, err := c.lookupTXT(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, record := range records {
+		if strings.HasPrefix(strings.ToLower(record), strings.ToLower(prefix)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// lookupTXT returns the TXT records of name, validating the answer with Options.DNSSEC. A bogus answer is an error.
+func (c *Checker) lookupTXT(ctx context.Context, name string) ([]string, error) {
+	if !c.options.DNSSEC {
+		return c.resolver.LookupTXT(ctx, name)
+	}
+
+	records, status, err := c.validate(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == DNSSECBogus {
+		return nil, errors.Wrapf(errDNSSECBogus, "txt records of %s", name)
+	}
+
+	var txt []string
+	for _, rr := range records {
+		txt = append(txt, strings.Join(rr.(*dns.TXT).Txt, ""))
+	}
+
+	return txt, nil
+}
+
+// LoadHosts parses a file in /etc/hosts format: an address followed by one or more names per line.
+func LoadHosts(path string) (Hosts, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open hosts file")
+	}
+	defer file.Close()
+
+	hosts := Hosts{}
+	scanner := bufio.NewScanner(file)
+
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			return nil, errors.Errorf("invalid hosts entry on line %d", lineNr)
+		}
+
+		for _, name := range fields[1:] {
+			name = canonicalHost(name)
+			hosts[name] = append(hosts[name], fields[0])
+		}
+	}
+
+	return hosts, errors.Wrap(scanner.Err(), "could not read hosts file")
+}
+
+// lookup returns the static addresses of name, if any.
+func (h Hosts) lookup(name string) ([]string, bool) {
+	addresses, ok := h[canonicalHost(name)]
+	return addresses, ok
+}
+
+// resolveAddress replaces host by its static address when there is one, leaving it to DNS otherwise.
+func (h Hosts) resolveAddress(host string) string {
+	if addresses, ok := h.lookup(host); ok {
+		return addresses[0]
+	}
+
+	return host
+}
+
+func canonicalHost(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,314 @@
+package mailcheck
+
+import (
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultDNSCacheSize is the number of answers cached unless Options.DNSCacheSize says otherwise.
+	defaultDNSCacheSize = 10000
+	// maxDNSCacheTTL caps how long an answer is cached, whatever its records say.
+	maxDNSCacheTTL = time.Hour * 24
+)
+
+// DNSCacheStats counts the lookups answered by the DNS cache of a Checker.
+type DNSCacheStats struct {
+	// Hits are the lookups answered from cache, NegativeHits the ones among them answered with a cached
+	// NXDOMAIN or empty answer.
+	Hits         int `json:"hits"`
+	NegativeHits int `json:"negative_hits"`
+	// Misses are the lookups that went out to the DNS server.
+	Misses int `json:"misses"`
+	// Entries is the number of answers cached right now.
+	Entries int `json:"entries"`
+}
+
+// dnsCache holds DNS answers for as long as their records allow, shared by all lookups of a Checker.
+// A nil *dnsCache caches nothing.
+type dnsCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]dnsCacheEntry
+	stats   DNSCacheStats
+}
+
+type dnsCacheEntry struct {
+	answer   *dns.Msg
+	stored   time.Time
+	expires  time.Time
+	negative bool
+}
+
+// newDNSCache returns a cache of size answers, nil when size is negative.
+func newDNSCache(size int) *dnsCache {
+	if size < 0 {
+		return nil
+	}
+
+	if size == 0 {
+		size = defaultDNSCacheSize
+	}
+
+	return &dnsCache{size: size, entries: map[string]dnsCacheEntry{}}
+}
+
+// dnsCacheKey identifies the answers to query, the DNSSEC flags asked for change what the answer holds.
+func dnsCacheKey(query *dns.Msg) (string, bool) {
+	if len(query.Question) != 1 || query.Opcode != dns.OpcodeQuery {
+		return "", false
+	}
+
+	q := query.Question[0]
+	var do bool
+	if opt := query.IsEdns0(); opt != nil {
+		do = opt.Do()
+	}
+
+	return strings.ToLower(q.Name) + "/" + dns.TypeToString[q.Qtype] + "/" + dns.ClassToString[q.Qclass] + "/" +
+		boolFlag(do, "do") + boolFlag(query.CheckingDisabled, "cd"), true
+}
+
+func boolFlag(set bool, flag string) string {
+	if set {
+		return flag
+	}
+	return "-"
+}
+
+// get returns the cached answer to query, with the id of query and its TTLs counting down. It is nil on a miss.
+func (d *dnsCache) get(query *dns.Msg) *dns.Msg {
+	if d == nil {
+		return nil
+	}
+
+	key, ok := dnsCacheKey(query)
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+
+	d.mu.Lock()
+	entry, ok := d.entries[key]
+	if ok && !now.Before(entry.expires) {
+		delete(d.entries, key)
+		ok = false
+	}
+
+	if !ok {
+		d.stats.Misses++
+		d.mu.Unlock()
+		return nil
+	}
+
+	d.stats.Hits++
+	if entry.negative {
+		d.stats.NegativeHits++
+	}
+	d.mu.Unlock()
+
+	answer := entry.answer.Copy()
+	answer.Id = query.Id
+	answer.Question = query.Question
+
+	age := uint32(now.Sub(entry.stored).Seconds())
+	for _, section := range [][]dns.RR{answer.Answer, answer.Ns, answer.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if rr.Header().Ttl > age {
+				rr.Header().Ttl -= age
+			} else {
+				rr.Header().Ttl = 0
+			}
+		}
+	}
+
+	return answer
+}
+
+// put caches answer to query for the lowest TTL of its records. NXDOMAIN and empty answers are cached
+// for the negative TTL of the SOA record of their zone, as in RFC 2308, and not at all without one.
+// Failures and truncated answers are never cached.
+func (d *dnsCache) put(query, answer *dns.Msg) {
+	if d == nil || answer.Truncated || answer.Id != query.Id {
+		return
+	}
+
+	key, ok := dnsCacheKey(query)
+	if !ok {
+		return
+	}
+
+	var ttl uint32
+	negative := answer.Rcode == dns.RcodeNameError || (answer.Rcode == dns.RcodeSuccess && len(answer.Answer) == 0)
+
+	switch {
+	case answer.Rcode != dns.RcodeSuccess && answer.Rcode != dns.RcodeNameError:
+		return
+	case negative:
+		var found bool
+		for _, rr := range answer.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl, found = minTTL(soa.Hdr.Ttl, soa.Minttl), true
+			}
+		}
+		if !found {
+			return
+		}
+	default:
+		ttl = answer.Answer[0].Header().Ttl
+		for _, rr := range answer.Answer {
+			ttl = minTTL(ttl, rr.Header().Ttl)
+		}
+	}
+
+	if ttl == 0 {
+		return
+	}
+
+	lifetime := time.Duration(ttl) * time.Second
+	if lifetime > maxDNSCacheTTL {
+		lifetime = maxDNSCacheTTL
+	}
+
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.entries[key]; !ok && len(d.entries) >= d.size {
+		d.evict(now)
+	}
+
+	d.entries[key] = dnsCacheEntry{answer: answer.Copy(), stored: now, expires: now.Add(lifetime), negative: negative}
+}
+
+// evict makes room for an answer, dropping the expired ones or otherwise the one that expires first.
+// The caller holds d.mu.
+func (d *dnsCache) evict(now time.Time) {
+	var first string
+	for key, entry := range d.entries {
+		if !now.Before(entry.expires) {
+			delete(d.entries, key)
+			continue
+		}
+		if first == "" || entry.expires.Before(d.entries[first].expires) {
+			first = key
+		}
+	}
+
+	if len(d.entries) >= d.size {
+		delete(d.entries, first)
+	}
+}
+
+// Stats returns the counters of the cache.
+func (d *dnsCache) Stats() DNSCacheStats {
+	if d == nil {
+		return DNSCacheStats{}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := d.stats
+	stats.Entries = len(d.entries)
+
+	return stats
+}
+
+func minTTL(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// cachingConn sits between net.Resolver and the DNS server, answering the queries it can from cache and caching
+// the answers to the others. Being a net.PacketConn, the resolver writes and reads it a message at a time.
+type cachingConn struct {
+	net.Conn
+	cache *dnsCache
+
+	// query is the last query forwarded to the DNS server, cached is the answer to read instead when it was cached
+	query  *dns.Msg
+	cached []byte
+}
+
+func (c *cachingConn) Write(b []byte) (int, error) {
+	c.query, c.cached = nil, nil
+
+	query := new(dns.Msg)
+	if err := query.Unpack(b); err != nil {
+		return c.Conn.Write(b)
+	}
+
+	if answer := c.cache.get(query); answer != nil {
+		if packed, err := answer.Pack(); err == nil {
+			c.cached = packed
+			return len(b), nil
+		}
+	}
+
+	c.query = query
+	return c.Conn.Write(b)
+}
+
+func (c *cachingConn) Read(b []byte) (int, error) {
+	if c.cached != nil {
+		n := copy(b, c.cached)
+		c.cached = nil
+		return n, nil
+	}
+
+	n, err := c.Conn.Read(b)
+	if err == nil && c.query != nil {
+		answer := new(dns.Msg)
+		if answer.Unpack(b[:n]) == nil {
+			c.cache.put(c.query, answer)
+		}
+	}
+
+	return n, err
+}
+
+func (c *cachingConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *cachingConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+// purge drops every cached answer and returns how many there were.
+func (d *dnsCache) purge() int {
+	if d == nil {
+		return 0
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	purged := len(d.entries)
+	d.entries = map[string]dnsCacheEntry{}
+
+	return purged
+}
+
+// PurgeDNSCache drops the answers in the DNS cache, so the next lookups go out to the DNS server, and returns
+// how many were dropped.
+func (c *Checker) PurgeDNSCache() int {
+	return c.dnsCache.purge()
+}
+
+// DNSCacheStats returns how many lookups the DNS cache answered so far.
+func (c *Checker) DNSCacheStats() DNSCacheStats {
+	return c.dnsCache.Stats()
+}
@@ -0,0 +1,144 @@
+package mailcheck
+
+import (
+	"github.com/miekg/dns"
+	"testing"
+	"time"
+)
+
+// ageDNSCache ages every answer in cache as if by had passed.
+func ageDNSCache(cache *dnsCache, by time.Duration) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	for key, entry := range cache.entries {
+		entry.stored, entry.expires = entry.stored.Add(-by), entry.expires.Add(-by)
+		cache.entries[key] = entry
+	}
+}
+
+// dnsExchange returns a query for name and type and an answer to it with rcode and the records, answers first.
+func dnsExchange(t *testing.T, name string, qtype uint16, rcode int, answer []string, authority ...string) (query, reply *dns.Msg) {
+	t.Helper()
+
+	query = new(dns.Msg)
+	query.SetQuestion(name, qtype)
+
+	reply = new(dns.Msg)
+	reply.SetRcode(query, rcode)
+	for _, record := range answer {
+		reply.Answer = append(reply.Answer, rr(t, record))
+	}
+	for _, record := range authority {
+		reply.Ns = append(reply.Ns, rr(t, record))
+	}
+
+	return query, reply
+}
+
+func TestDNSCacheExpiresWithLowestTTL(t *testing.T) {
+	cache := newDNSCache(0)
+	query, answer := dnsExchange(t, "example.com.", dns.TypeMX, dns.RcodeSuccess, []string{
+		"example.com. 300 IN MX 10 mx1.example.com.",
+		"example.com. 60 IN MX 20 mx2.example.com.",
+	})
+	cache.put(query, answer)
+
+	ageDNSCache(cache, time.Second*20)
+
+	// another query for the same name gets the answer with its own id and TTLs that counted down
+	again := new(dns.Msg)
+	again.SetQuestion("EXAMPLE.com.", dns.TypeMX)
+	cached := cache.get(again)
+	if cached == nil {
+		t.Fatal("expected the answer to be cached")
+	}
+	if cached.Id != again.Id || len(cached.Answer) != 2 {
+		t.Fatalf("expected the cached answer to the query, got %v", cached)
+	}
+	if ttl := cached.Answer[0].Header().Ttl; ttl != 280 {
+		t.Errorf("expected the TTL to count down to 280, got %d", ttl)
+	}
+
+	// the lowest TTL of the records decides
+	ageDNSCache(cache, time.Second*40)
+	if cache.get(query) != nil {
+		t.Error("expected the answer to expire with its lowest TTL")
+	}
+
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 0 {
+		t.Errorf("expected a hit, a miss and nothing cached, got %+v", stats)
+	}
+}
+
+func TestDNSCacheNegativeAnswers(t *testing.T) {
+	cache := newDNSCache(0)
+	soa := "example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. 1 3600 600 86400 30"
+
+	// NXDOMAIN and empty answers last as long as the lower of the TTL and minimum of the SOA
+	missing, nxdomain := dnsExchange(t, "missing.example.com.", dns.TypeMX, dns.RcodeNameError, nil, soa)
+	empty, noData := dnsExchange(t, "www.example.com.", dns.TypeMX, dns.RcodeSuccess, nil, soa)
+	cache.put(missing, nxdomain)
+	cache.put(empty, noData)
+
+	if cached := cache.get(missing); cached == nil || cached.Rcode != dns.RcodeNameError {
+		t.Errorf("expected the NXDOMAIN to be cached, got %v", cached)
+	}
+	if cache.get(empty) == nil {
+		t.Error("expected the empty answer to be cached")
+	}
+	if stats := cache.Stats(); stats.NegativeHits != 2 {
+		t.Errorf("expected two negative hits, got %+v", stats)
+	}
+
+	ageDNSCache(cache, time.Second*30)
+	if cache.get(missing) != nil || cache.get(empty) != nil {
+		t.Error("expected the negative answers to expire with the minimum of the SOA")
+	}
+
+	// without an SOA there is no negative TTL to go by
+	noSOA, bare := dnsExchange(t, "bare.example.com.", dns.TypeMX, dns.RcodeNameError, nil)
+	cache.put(noSOA, bare)
+	if cache.get(noSOA) != nil {
+		t.Error("expected an NXDOMAIN without SOA not to be cached")
+	}
+}
+
+func TestDNSCacheSkipsFailures(t *testing.T) {
+	cache := newDNSCache(0)
+
+	failed, servfail := dnsExchange(t, "example.com.", dns.TypeMX, dns.RcodeServerFailure, nil)
+	cache.put(failed, servfail)
+
+	truncated, partial := dnsExchange(t, "example.org.", dns.TypeMX, dns.RcodeSuccess, []string{"example.org. 300 IN MX 10 mx.example.org."})
+	partial.Truncated = true
+	cache.put(truncated, partial)
+
+	other, spoofed := dnsExchange(t, "example.net.", dns.TypeMX, dns.RcodeSuccess, []string{"example.net. 300 IN MX 10 mx.example.net."})
+	spoofed.Id = other.Id + 1
+	cache.put(other, spoofed)
+
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("expected failed, truncated and mismatched answers not to be cached, got %d entries", stats.Entries)
+	}
+}
+
+func TestDNSCacheLimits(t *testing.T) {
+	cache := newDNSCache(1)
+
+	long, week := dnsExchange(t, "example.com.", dns.TypeMX, dns.RcodeSuccess, []string{"example.com. 604800 IN MX 10 mx.example.com."})
+	cache.put(long, week)
+	ageDNSCache(cache, maxDNSCacheTTL)
+	if cache.get(long) != nil {
+		t.Error("expected answers to be cached for a day at most")
+	}
+
+	// a full cache makes room for a new answer
+	first, one := dnsExchange(t, "example.org.", dns.TypeMX, dns.RcodeSuccess, []string{"example.org. 300 IN MX 10 mx.example.org."})
+	second, two := dnsExchange(t, "example.net.", dns.TypeMX, dns.RcodeSuccess, []string{"example.net. 600 IN MX 10 mx.example.net."})
+	cache.put(first, one)
+	cache.put(second, two)
+	if cache.get(first) != nil || cache.get(second) == nil {
+		t.Error("expected the answer that expires first to make room")
+	}
+}
@@ -0,0 +1,481 @@
+package mailcheck
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+const (
+	// DNSSECSecure means the answer was validated from the root down.
+	DNSSECSecure DNSSECStatus = "secure"
+	// DNSSECInsecure means the answer comes from a zone that is provably not signed.
+	DNSSECInsecure DNSSECStatus = "insecure"
+	// DNSSECBogus means the answer should have been signed but failed validation, it may have been spoofed.
+	DNSSECBogus DNSSECStatus = "bogus"
+)
+
+// rootTrustAnchors are the DS records of the root key signing keys, KSK-2017 and KSK-2024.
+var rootTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBB683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+var errDNSSECBogus = errors.New("dnssec validation failed")
+
+// DNSSECStatus is the outcome of validating a DNS answer, only determined with Options.DNSSEC.
+type DNSSECStatus string
+
+// zoneTrust is the outcome of validating the keys of a zone.
+type zoneTrust struct {
+	status DNSSECStatus
+	keys   []*dns.DNSKEY
+}
+
+// validate looks up the records of type qtype at name and validates the answer up to the root trust anchors.
+// Records of a bogus answer are returned along with the status, it is up to the caller not to trust them.
+// An empty answer from a signed zone is only secure with NSEC or NSEC3 records proving there is nothing to return.
+func (c *Checker) validate(ctx context.Context, name string, qtype uint16) ([]dns.RR, DNSSECStatus, error) {
+	answer, err := c.query(ctx, name, qtype)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var records []dns.RR
+	for _, rr := range answer.Answer {
+		if rr.Header().Rrtype == qtype {
+			records = append(records, rr)
+		}
+	}
+
+	// a denial of existence is proven by the signed records in the authority section
+	section := answer.Answer
+	if len(records) == 0 {
+		section = answer.Ns
+	}
+
+	status, err := c.verifySection(ctx, section, name, "")
+	if err == nil && status == DNSSECSecure && len(records) == 0 && !provesDenial(answer.Ns, name, qtype) {
+		// signed records that prove nothing about name, such as a replayed SOA
+		status = DNSSECBogus
+	}
+
+	return records, status, err
+}
+
+// verifySection validates every record set in rrs by its signatures. An unsigned section is insecure when
+// name provably lies in an unsigned zone. With below set, only signatures of zones above it are accepted.
+func (c *Checker) verifySection(ctx context.Context, rrs []dns.RR, name, below string) (DNSSECStatus, error) {
+	sets := map[string][]dns.RR{}
+	signatures := map[string][]*dns.RRSIG{}
+
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			key := setKey(sig.Hdr.Name, sig.TypeCovered)
+			signatures[key] = append(signatures[key], sig)
+			continue
+		}
+
+		key := setKey(rr.Header().Name, rr.Header().Rrtype)
+		sets[key] = append(sets[key], rr)
+	}
+
+	if len(signatures) == 0 {
+		return c.unsignedStatus(ctx, name)
+	}
+
+	for key, set := range sets {
+		status, err := c.verifySet(ctx, set, signatures[key], below)
+		if err != nil || status != DNSSECSecure {
+			return status, err
+		}
+	}
+
+	return DNSSECSecure, nil
+}
+
+// verifySet checks whether one of signatures over set is made by a validated key of its zone.
+func (c *Checker) verifySet(ctx context.Context, set []dns.RR, signatures []*dns.RRSIG, below string) (DNSSECStatus, error) {
+	owner := set[0].Header().Name
+
+	for _, sig := range signatures {
+		// a zone signs its own records, a delegation is signed by the zone above it
+		if !dns.IsSubDomain(sig.SignerName, owner) || (below != "" && !isAbove(sig.SignerName, below)) {
+			continue
+		}
+
+		trust, err := c.zoneKeys(ctx, sig.SignerName)
+		if err != nil {
+			return "", err
+		}
+
+		if trust.status == DNSSECInsecure {
+			return DNSSECInsecure, nil
+		}
+
+		for _, key := range trust.keys {
+			if sig.KeyTag == key.KeyTag() && sig.ValidityPeriod(time.Now()) && sig.Verify(key, set) == nil {
+				return DNSSECSecure, nil
+			}
+		}
+	}
+
+	return DNSSECBogus, nil
+}
+
+// zoneKeys returns the keys of zone once they are validated through the chain of DS records up to the root.
+// Outcomes are cached for the lifetime of the Checker.
+func (c *Checker) zoneKeys(ctx context.Context, zone string) (zoneTrust, error) {
+	zone = dns.CanonicalName(zone)
+
+	c.zonesMu.Lock()
+	trust, ok := c.zones[zone]
+	c.zonesMu.Unlock()
+
+	if ok {
+		return trust, nil
+	}
+
+	trust, err := c.validateZoneKeys(ctx, zone)
+	if err != nil {
+		return trust, err
+	}
+
+	c.zonesMu.Lock()
+	c.zones[zone] = trust
+	c.zonesMu.Unlock()
+
+	return trust, nil
+}
+
+func (c *Checker) validateZoneKeys(ctx context.Context, zone string) (zoneTrust, error) {
+	var delegations []*dns.DS
+
+	if zone == "." {
+		for _, anchor := range rootTrustAnchors {
+			// the anchors are constants that parse
+			rr, _ := dns.NewRR(anchor)
+			delegations = append(delegations, rr.(*dns.DS))
+		}
+	} else {
+		records, status, err := c.lookupDS(ctx, zone)
+		if err != nil || status != DNSSECSecure {
+			return zoneTrust{status: status}, err
+		}
+
+		// the zone above proves there is no DS record, so the zone is not signed
+		if len(records) == 0 {
+			return zoneTrust{status: DNSSECInsecure}, nil
+		}
+
+		delegations = records
+	}
+
+	answer, err := c.query(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return zoneTrust{}, err
+	}
+
+	var keys []*dns.DNSKEY
+	var set []dns.RR
+	var signatures []*dns.RRSIG
+	for _, rr := range answer.Answer {
+		switch rr := rr.(type) {
+		case *dns.DNSKEY:
+			keys = append(keys, rr)
+			set = append(set, rr)
+		case *dns.RRSIG:
+			if rr.TypeCovered == dns.TypeDNSKEY {
+				signatures = append(signatures, rr)
+			}
+		}
+	}
+
+	// the key set must be signed by a key the zone above vouches for with a DS record
+	for _, key := range keys {
+		if !matchesDS(key, delegations) {
+			continue
+		}
+
+		for _, sig := range signatures {
+			if sig.KeyTag == key.KeyTag() && sig.ValidityPeriod(time.Now()) && sig.Verify(key, set) == nil {
+				return zoneTrust{status: DNSSECSecure, keys: keys}, nil
+			}
+		}
+	}
+
+	return zoneTrust{status: DNSSECBogus}, nil
+}
+
+// lookupDS returns the validated DS records of zone, none when the zone above proves they do not exist.
+func (c *Checker) lookupDS(ctx context.Context, zone string) ([]*dns.DS, DNSSECStatus, error) {
+	answer, err := c.query(ctx, zone, dns.TypeDS)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var records []*dns.DS
+	for _, rr := range answer.Answer {
+		if ds, ok := rr.(*dns.DS); ok {
+			records = append(records, ds)
+		}
+	}
+
+	section := answer.Answer
+	if len(records) == 0 {
+		section = answer.Ns
+	}
+
+	status, err := c.verifySection(ctx, section, zone, zone)
+	if err == nil && status == DNSSECSecure && len(records) == 0 && !provesDenial(answer.Ns, zone, dns.TypeDS) {
+		// without proof the missing DS records could be a downgrade to an unsigned zone
+		status = DNSSECBogus
+	}
+
+	return records, status, err
+}
+
+// provesDenial reports whether the NSEC or NSEC3 records in ns prove that there are no records of type qtype at
+// name, either because name does not exist or because it has none of that type. Their signatures are to be
+// verified by the caller.
+func provesDenial(ns []dns.RR, name string, qtype uint16) bool {
+	var nsecs []*dns.NSEC
+	var nsec3s []*dns.NSEC3
+	for _, rr := range ns {
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			nsecs = append(nsecs, rr)
+		case *dns.NSEC3:
+			nsec3s = append(nsec3s, rr)
+		}
+	}
+
+	return provesDenialNSEC(nsecs, dns.CanonicalName(name), qtype) || provesDenialNSEC3(nsec3s, dns.CanonicalName(name), qtype)
+}
+
+// provesDenialNSEC checks the proofs of RFC 4035 section 5.4: an NSEC at name without the type, or an NSEC
+// covering name along with one that rules out a wildcard at the closest encloser.
+func provesDenialNSEC(nsecs []*dns.NSEC, name string, qtype uint16) bool {
+	for _, nsec := range nsecs {
+		if strings.EqualFold(nsec.Hdr.Name, name) && deniesType(nsec.TypeBitMap, qtype) {
+			return true
+		}
+	}
+
+	for _, nsec := range nsecs {
+		if !nsecCovers(nsec, name) {
+			continue
+		}
+
+		// the closest encloser is the longest ancestor of name that exists, the owner or next name tells it
+		labels := dns.CompareDomainName(name, nsec.Hdr.Name)
+		if next := dns.CompareDomainName(name, nsec.NextDomain); next > labels {
+			labels = next
+		}
+		wildcard := "*." + strings.TrimPrefix(lastLabels(name, labels), ".")
+
+		for _, other := range nsecs {
+			if nsecCovers(other, wildcard) || (strings.EqualFold(other.Hdr.Name, wildcard) && deniesType(other.TypeBitMap, qtype)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// provesDenialNSEC3 checks the proofs of RFC 5155 section 8: an NSEC3 matching name without the type, or the
+// closest encloser proof along with one that rules out a wildcard at it. A DS record is also denied by an opt-out
+// NSEC3 covering the next closer name, the delegation is unsigned then.
+func provesDenialNSEC3(nsec3s []*dns.NSEC3, name string, qtype uint16) bool {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(name) && deniesType(nsec3.TypeBitMap, qtype) {
+			return true
+		}
+	}
+
+	for nextCloser, encloser := name, parentZone(name); nextCloser != "."; nextCloser, encloser = encloser, parentZone(encloser) {
+		match := findNSEC3(nsec3s, func(nsec3 *dns.NSEC3) bool { return nsec3.Match(encloser) })
+		if match == nil {
+			continue
+		}
+
+		// names below a delegation are not for the zone above to deny
+		if hasType(match.TypeBitMap, dns.TypeNS) && !hasType(match.TypeBitMap, dns.TypeSOA) || hasType(match.TypeBitMap, dns.TypeDNAME) {
+			return false
+		}
+
+		cover := findNSEC3(nsec3s, func(nsec3 *dns.NSEC3) bool { return nsec3.Cover(nextCloser) })
+		if cover == nil {
+			return false
+		}
+
+		if qtype == dns.TypeDS && cover.Flags&1 == 1 {
+			return true
+		}
+
+		wildcard := "*." + encloser
+		if encloser == "." {
+			wildcard = "*."
+		}
+
+		return findNSEC3(nsec3s, func(nsec3 *dns.NSEC3) bool {
+			return nsec3.Cover(wildcard) || (nsec3.Match(wildcard) && deniesType(nsec3.TypeBitMap, qtype))
+		}) != nil
+	}
+
+	return false
+}
+
+// deniesType reports whether the type bitmap of an NSEC or NSEC3 at a name proves it has no records of qtype.
+func deniesType(bitmap []uint16, qtype uint16) bool {
+	if hasType(bitmap, qtype) || hasType(bitmap, dns.TypeCNAME) {
+		return false
+	}
+
+	// the parent side of a delegation only speaks for the DS records, the apex of a zone for anything but those
+	if qtype == dns.TypeDS {
+		return !hasType(bitmap, dns.TypeSOA)
+	}
+	return hasType(bitmap, dns.TypeSOA) || !hasType(bitmap, dns.TypeNS)
+}
+
+// nsecCovers reports whether name falls between the owner and next name of nsec, so it does not exist.
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	owner, next := nsec.Hdr.Name, nsec.NextDomain
+
+	// names below a delegation are not for the zone above to deny
+	if isAbove(owner, name) && hasType(nsec.TypeBitMap, dns.TypeNS) && !hasType(nsec.TypeBitMap, dns.TypeSOA) {
+		return false
+	}
+
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+
+	// the last NSEC of a zone points back to its apex
+	return dns.IsSubDomain(next, name) && (canonicalCompare(owner, name) < 0 || canonicalCompare(name, next) < 0)
+}
+
+// canonicalCompare orders names as in RFC 4034 section 6.1, label by label from the root.
+func canonicalCompare(a, b string) int {
+	la, lb := dns.SplitDomainName(strings.ToLower(a)), dns.SplitDomainName(strings.ToLower(b))
+
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if cmp := strings.Compare(la[i], lb[j]); cmp != 0 {
+			return cmp
+		}
+	}
+
+	return len(la) - len(lb)
+}
+
+// lastLabels returns the last n labels of name as a fully qualified name.
+func lastLabels(name string, n int) string {
+	labels := dns.SplitDomainName(name)
+	if n <= 0 {
+		return "."
+	}
+	if n > len(labels) {
+		n = len(labels)
+	}
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+func findNSEC3(nsec3s []*dns.NSEC3, match func(*dns.NSEC3) bool) *dns.NSEC3 {
+	for _, nsec3 := range nsec3s {
+		if match(nsec3) {
+			return nsec3
+		}
+	}
+	return nil
+}
+
+// unsignedStatus tells whether an unsigned answer for name is to be expected, which is the case when one of the
+// zones name lies in is delegated without DS record by a signed zone above it. Otherwise the answer is bogus.
+func (c *Checker) unsignedStatus(ctx context.Context, name string) (DNSSECStatus, error) {
+	for zone := dns.CanonicalName(name); zone != "."; zone = parentZone(zone) {
+		answer, err := c.query(ctx, zone, dns.TypeDS)
+		if err != nil {
+			return "", err
+		}
+
+		var signed, delegated bool
+		for _, rr := range append(answer.Answer, answer.Ns...) {
+			switch rr := rr.(type) {
+			case *dns.DS:
+				// a signed zone cut, the answer should have been signed
+				return DNSSECBogus, nil
+			case *dns.RRSIG:
+				signed = true
+			case *dns.NSEC:
+				if strings.EqualFold(rr.Hdr.Name, zone) {
+					delegated = hasType(rr.TypeBitMap, dns.TypeNS) && !hasType(rr.TypeBitMap, dns.TypeDS)
+				}
+			case *dns.NSEC3:
+				// an opt-out span may hold unsigned delegations
+				delegated = delegated || (rr.Match(zone) && hasType(rr.TypeBitMap, dns.TypeNS) && !hasType(rr.TypeBitMap, dns.TypeDS)) ||
+					(rr.Cover(zone) && rr.Flags&1 == 1)
+			}
+		}
+
+		// the zone above is not signed either, the delegation to look for is further up
+		if !signed {
+			continue
+		}
+
+		status, err := c.verifySection(ctx, answer.Ns, zone, zone)
+		if err != nil || status != DNSSECSecure {
+			return status, err
+		}
+
+		if delegated {
+			return DNSSECInsecure, nil
+		}
+	}
+
+	// the root is signed
+	return DNSSECBogus, nil
+}
+
+// matchesDS reports whether key is one of the keys delegations refer to.
+func matchesDS(key *dns.DNSKEY, delegations []*dns.DS) bool {
+	for _, ds := range delegations {
+		if ds.KeyTag != key.KeyTag() || ds.Algorithm != key.Algorithm {
+			continue
+		}
+
+		if digest := key.ToDS(ds.DigestType); digest != nil && strings.EqualFold(digest.Digest, ds.Digest) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasType(bitmap []uint16, rrtype uint16) bool {
+	for _, t := range bitmap {
+		if t == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+// isAbove reports whether zone is an ancestor of name other than name itself.
+func isAbove(zone, name string) bool {
+	return dns.IsSubDomain(zone, name) && !strings.EqualFold(dns.Fqdn(zone), dns.Fqdn(name))
+}
+
+func parentZone(zone string) string {
+	if i, end := dns.NextLabel(zone, 0); !end {
+		return zone[i:]
+	}
+	return "."
+}
+
+func setKey(name string, rrtype uint16) string {
+	return dns.CanonicalName(name) + "/" + dns.TypeToString[rrtype]
+}
@@ -0,0 +1,266 @@
+package mailcheck
+
+import (
+	"context"
+	"crypto"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// signedZone signs the records of a zone with a single key.
+type signedZone struct {
+	name   string
+	key    *dns.DNSKEY
+	signer crypto.Signer
+}
+
+func newSignedZone(t *testing.T, name string) *signedZone {
+	t.Helper()
+
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 300},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	private, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &signedZone{name: name, key: key, signer: private.(crypto.Signer)}
+}
+
+// sign returns set along with its signature by the zone.
+func (z *signedZone) sign(t *testing.T, set ...dns.RR) []dns.RR {
+	t.Helper()
+
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: set[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 300},
+		Algorithm:  z.key.Algorithm,
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+	if err := sig.Sign(z.signer, set); err != nil {
+		t.Fatal(err)
+	}
+
+	return append(set, sig)
+}
+
+// ds returns the DS record the zone above publishes for the zone.
+func (z *signedZone) ds() *dns.DS {
+	return z.key.ToDS(dns.SHA256)
+}
+
+// signedDNS answers queries from the messages set for a name and type, anything else fails.
+// Messages may be set while it serves.
+type signedDNS struct {
+	mu       sync.Mutex
+	messages map[string]*dns.Msg
+}
+
+func (s *signedDNS) set(name string, qtype uint16, msg *dns.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages[dns.CanonicalName(name)+"/"+dns.TypeToString[qtype]] = msg
+}
+
+func (s *signedDNS) answer(name string, qtype uint16, records ...dns.RR) {
+	s.set(name, qtype, &dns.Msg{Answer: records})
+}
+
+func (s *signedDNS) deny(name string, qtype uint16, rcode int, authority ...dns.RR) {
+	msg := &dns.Msg{Ns: authority}
+	msg.Rcode = rcode
+	s.set(name, qtype, msg)
+}
+
+func (s *signedDNS) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	msg := new(dns.Msg)
+	msg.SetReply(req)
+
+	s.mu.Lock()
+	answer, ok := s.messages[dns.CanonicalName(req.Question[0].Name)+"/"+dns.TypeToString[req.Question[0].Qtype]]
+	s.mu.Unlock()
+
+	if ok {
+		msg.Rcode, msg.Answer, msg.Ns = answer.Rcode, answer.Answer, answer.Ns
+	} else {
+		msg.Rcode = dns.RcodeServerFailure
+	}
+
+	_ = w.WriteMsg(msg)
+}
+
+func rr(t *testing.T, record string) dns.RR {
+	t.Helper()
+
+	parsed, err := dns.NewRR(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+// newSignedDNS serves a signed root, the signed zones test. and signed.test., test.'s unsigned delegation
+// unsigned.test. and hashed.test., which denies existence with NSEC3. It returns a Checker validating against it.
+func newSignedDNS(t *testing.T) (*Checker, *signedDNS, map[string]*signedZone) {
+	zones := map[string]*signedZone{}
+	for _, name := range []string{".", "test.", "signed.test.", "hashed.test."} {
+		zones[name] = newSignedZone(t, name)
+	}
+	root, test, signed, hashed := zones["."], zones["test."], zones["signed.test."], zones["hashed.test."]
+
+	answers := &signedDNS{messages: map[string]*dns.Msg{}}
+	for _, zone := range zones {
+		answers.answer(zone.name, dns.TypeDNSKEY, zone.sign(t, zone.key)...)
+	}
+	answers.answer("test.", dns.TypeDS, root.sign(t, test.ds())...)
+	answers.answer("signed.test.", dns.TypeDS, test.sign(t, signed.ds())...)
+	answers.answer("hashed.test.", dns.TypeDS, test.sign(t, hashed.ds())...)
+
+	answers.answer("signed.test.", dns.TypeMX, signed.sign(t, rr(t, "signed.test. 300 IN MX 10 mx.signed.test."))...)
+
+	// signed.test. holds the apex and www, each NSEC points to the next name, the last one back to the apex
+	soa := signed.sign(t, rr(t, "signed.test. 300 IN SOA ns.signed.test. hostmaster.signed.test. 1 3600 600 86400 300"))
+	apex := signed.sign(t, rr(t, "signed.test. 300 IN NSEC www.signed.test. NS SOA MX RRSIG NSEC DNSKEY"))
+	www := signed.sign(t, rr(t, "www.signed.test. 300 IN NSEC signed.test. A RRSIG NSEC"))
+
+	answers.deny("www.signed.test.", dns.TypeMX, dns.RcodeSuccess, append(append([]dns.RR{}, soa...), www...)...)
+	answers.deny("missing.signed.test.", dns.TypeMX, dns.RcodeNameError, append(append([]dns.RR{}, soa...), apex...)...)
+
+	// the delegation to unsigned.test. has no DS record, which test. proves
+	answers.answer("unsigned.test.", dns.TypeMX, rr(t, "unsigned.test. 300 IN MX 10 mx.unsigned.test."))
+	answers.deny("unsigned.test.", dns.TypeDS, dns.RcodeSuccess,
+		append(test.sign(t, rr(t, "test. 300 IN SOA ns.test. hostmaster.test. 1 3600 600 86400 300")),
+			test.sign(t, rr(t, "unsigned.test. 300 IN NSEC zzz.test. NS RRSIG NSEC"))...)...)
+
+	// hashed.test. only holds its apex, so its one NSEC3 covers every other name
+	hash := dns.HashName("hashed.test.", dns.SHA1, 0, "")
+	nsec3 := &dns.NSEC3{
+		Hdr:        dns.RR_Header{Name: strings.ToLower(hash) + ".hashed.test.", Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300},
+		Hash:       dns.SHA1,
+		HashLength: 20,
+		NextDomain: hash,
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM},
+	}
+	hashedSOA := hashed.sign(t, rr(t, "hashed.test. 300 IN SOA ns.hashed.test. hostmaster.hashed.test. 1 3600 600 86400 300"))
+	hashedNSEC3 := hashed.sign(t, nsec3)
+	answers.deny("hashed.test.", dns.TypeMX, dns.RcodeSuccess, append(append([]dns.RR{}, hashedSOA...), hashedNSEC3...)...)
+	answers.deny("missing.hashed.test.", dns.TypeMX, dns.RcodeNameError, append(append([]dns.RR{}, hashedSOA...), hashedNSEC3...)...)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: conn, Handler: answers, NotifyStartedFunc: func() { close(started) }}
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	anchors := rootTrustAnchors
+	rootTrustAnchors = []string{root.ds().String()}
+	t.Cleanup(func() { rootTrustAnchors = anchors })
+
+	checker := New(Options{DNSServer: conn.LocalAddr().String(), DNSSEC: true})
+	t.Cleanup(checker.Close)
+
+	return checker, answers, zones
+}
+
+// expectDNSSEC fails unless validating the records of type qtype at name has status.
+func expectDNSSEC(t *testing.T, checker *Checker, name string, qtype uint16, status DNSSECStatus) []dns.RR {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	records, got, err := checker.validate(ctx, name, qtype)
+	if err != nil {
+		t.Fatalf("validate %s: %v", name, err)
+	}
+	if got != status {
+		t.Errorf("expected the %s records of %s to be %s, got %s", dns.TypeToString[qtype], name, status, got)
+	}
+	return records
+}
+
+func TestDNSSECSecure(t *testing.T) {
+	checker, _, _ := newSignedDNS(t)
+
+	if records := expectDNSSEC(t, checker, "signed.test.", dns.TypeMX, DNSSECSecure); len(records) != 1 {
+		t.Errorf("expected the mx record, got %v", records)
+	}
+
+	// proven denials are secure: no such type, no such name, with NSEC and with NSEC3
+	expectDNSSEC(t, checker, "www.signed.test.", dns.TypeMX, DNSSECSecure)
+	expectDNSSEC(t, checker, "missing.signed.test.", dns.TypeMX, DNSSECSecure)
+	expectDNSSEC(t, checker, "hashed.test.", dns.TypeMX, DNSSECSecure)
+	expectDNSSEC(t, checker, "missing.hashed.test.", dns.TypeMX, DNSSECSecure)
+}
+
+func TestDNSSECInsecure(t *testing.T) {
+	checker, _, _ := newSignedDNS(t)
+
+	if records := expectDNSSEC(t, checker, "unsigned.test.", dns.TypeMX, DNSSECInsecure); len(records) != 1 {
+		t.Errorf("expected the mx record, got %v", records)
+	}
+}
+
+func TestDNSSECBogus(t *testing.T) {
+	checker, answers, zones := newSignedDNS(t)
+
+	// a record changed after it was signed
+	tampered := zones["signed.test."].sign(t, rr(t, "bad.signed.test. 300 IN MX 10 mx.signed.test."))
+	tampered[0].(*dns.MX).Mx = "mx.attacker.test."
+	answers.answer("bad.signed.test.", dns.TypeMX, tampered...)
+	expectDNSSEC(t, checker, "bad.signed.test.", dns.TypeMX, DNSSECBogus)
+
+	// a record of a signed zone without signature
+	answers.answer("plain.signed.test.", dns.TypeMX, rr(t, "plain.signed.test. 300 IN MX 10 mx.attacker.test."))
+	answers.deny("plain.signed.test.", dns.TypeDS, dns.RcodeNameError,
+		append(zones["signed.test."].sign(t, rr(t, "signed.test. 300 IN SOA ns.signed.test. hostmaster.signed.test. 1 3600 600 86400 300")),
+			zones["signed.test."].sign(t, rr(t, "signed.test. 300 IN NSEC www.signed.test. NS SOA MX RRSIG NSEC DNSKEY"))...)...)
+	expectDNSSEC(t, checker, "plain.signed.test.", dns.TypeMX, DNSSECBogus)
+}
+
+func TestDNSSECSpoofedDenial(t *testing.T) {
+	checker, answers, zones := newSignedDNS(t)
+	signed := zones["signed.test."]
+
+	soa := signed.sign(t, rr(t, "signed.test. 300 IN SOA ns.signed.test. hostmaster.signed.test. 1 3600 600 86400 300"))
+	apex := signed.sign(t, rr(t, "signed.test. 300 IN NSEC www.signed.test. NS SOA MX RRSIG NSEC DNSKEY"))
+
+	// a replayed SOA proves nothing about the name
+	answers.deny("signed.test.", dns.TypeMX, dns.RcodeSuccess, soa...)
+	expectDNSSEC(t, checker, "signed.test.", dns.TypeMX, DNSSECBogus)
+
+	answers.deny("www.signed.test.", dns.TypeMX, dns.RcodeNameError, soa...)
+	expectDNSSEC(t, checker, "www.signed.test.", dns.TypeMX, DNSSECBogus)
+
+	// an NSEC of another name that neither matches nor covers it
+	answers.deny("www.signed.test.", dns.TypeA, dns.RcodeSuccess, append(append([]dns.RR{}, soa...), apex...)...)
+	expectDNSSEC(t, checker, "www.signed.test.", dns.TypeA, DNSSECBogus)
+
+	// the NSEC of the apex says it has MX records
+	answers.deny("signed.test.", dns.TypeMX, dns.RcodeSuccess, append(append([]dns.RR{}, soa...), apex...)...)
+	expectDNSSEC(t, checker, "signed.test.", dns.TypeMX, DNSSECBogus)
+}
+
+func TestDNSSECSpoofedDelegation(t *testing.T) {
+	checker, answers, zones := newSignedDNS(t)
+
+	// denying the DS records of a signed zone without proof would turn it into an unsigned one
+	answers.deny("signed.test.", dns.TypeDS, dns.RcodeSuccess,
+		zones["test."].sign(t, rr(t, "test. 300 IN SOA ns.test. hostmaster.test. 1 3600 600 86400 300"))...)
+	expectDNSSEC(t, checker, "signed.test.", dns.TypeMX, DNSSECBogus)
+}
@@ -0,0 +1,105 @@
+package mailcheck
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"golang.org/x/net/idna"
+	"net"
+	"strconv"
+)
+
+// DomainResult describes how a domain handles mail, regardless of any particular address.
+type DomainResult struct {
+	Domain string `json:"domain"`
+	// MX holds the mail servers of the domain, as host or host:port.
+	MX       []string `json:"mx,omitempty"`
+	Provider Provider `json:"provider,omitempty"`
+	// DNSSEC is the outcome of validating the MX records, only with Options.DNSSEC.
+	DNSSEC       DNSSECStatus `json:"dnssec,omitempty"`
+	Disposable   bool         `json:"disposable"`
+	FreeProvider bool         `json:"free_provider"`
+	// CatchAll is only determined at LevelSMTP and deeper, nil when it could not be determined.
+	CatchAll   *bool       `json:"catch_all,omitempty"`
+	Auth       *DomainAuth `json:"auth,omitempty"`
+	Suggestion string      `json:"suggestion,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	// DANE holds the outcome of verifying every mail server against its TLSA records, only with Options.DANE.
+	DANE []DANEResult `json:"dane,omitempty"`
+	// TLS describes the certificate of every mail server, only with Options.InspectTLS.
+	TLS []TLSCertificate `json:"tls,omitempty"`
+}
+
+// String returns the mail server as host, or as host:port when it has a fixed port.
+func (s MailServer) String() string {
+	if s.Port == 0 {
+		return s.Host
+	}
+
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// CheckDomain looks up the mail servers and the authentication records of domain and classifies it.
+// At LevelSMTP and deeper it also probes whether the domain accepts any address and, with Options.DANE,
+// verifies its mail servers against their TLSA records or, with Options.InspectTLS, describes their certificates.
+func (c *Checker) CheckDomain(ctx context.Context, domain string) (res DomainResult) {
+	res.Domain = domain
+
+	domain, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		res.Error = errors.Wrap(err, "invalid domain").Error()
+		return res
+	}
+
+	res.Disposable = IsDisposable(domain)
+	res.FreeProvider = freeProviderDomains[canonicalHost(domain)]
+
+	servers, _, status, err := c.lookupMailServers(ctx, domain)
+	res.DNSSEC = status
+	if err == nil && len(servers) == 0 {
+		err = errors.New("no mail servers found")
+	}
+	if err != nil {
+		res.Error = err.Error()
+		res.Suggestion, _ = SuggestDomain(domain)
+		return res
+	}
+
+	for _, server := range servers {
+		res.MX = append(res.MX, server.String())
+		if res.Provider == "" {
+			res.Provider = identifyProvider(server.Host, "")
+		}
+	}
+
+	if auth, err := c.LookupDomainAuth(ctx, domain); err == nil {
+		res.Auth = &auth
+	}
+
+	if c.options.Level != LevelSMTP && c.options.Level != LevelDeep {
+		return res
+	}
+
+	if c.options.DANE {
+		for _, server := range servers {
+			res.DANE = append(res.DANE, c.CheckDANE(ctx, server))
+		}
+	}
+
+	if c.options.InspectTLS {
+		for _, server := range servers {
+			res.TLS = append(res.TLS, c.InspectTLS(ctx, server))
+		}
+	}
+
+	if c.skipCatchAll(domain) {
+		return res
+	}
+
+	if catchAll, err := c.IsCatchAll(ctx, domain, servers); err == nil {
+		res.CatchAll = &catchAll
+	} else {
+		res.Error = errors.Wrap(err, "could not determine catch-all").Error()
+	}
+
+	return res
+}
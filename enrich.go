@@ -0,0 +1,93 @@
+package mailcheck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// gravatarURL is where the avatar of an address hash lives, d=404 makes a missing avatar a 404.
+const gravatarURL = "https://www.gravatar.com/avatar/%s?d=404"
+
+// Enricher adds a soft signal about an address to its result, such as whether someone uses it elsewhere.
+// Enrichers run after the verdict, for every address that is not invalid.
+type Enricher interface {
+	// Name is the key of the enrichment in Result.Enrichments.
+	Name() string
+	// Enrich looks up email, an error means the lookup itself failed.
+	Enrich(ctx context.Context, email string) (Enrichment, error)
+}
+
+// Enrichment is the finding of an Enricher about an address.
+type Enrichment struct {
+	Found bool `json:"found"`
+	// Detail is what was found, e.g. a profile url.
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Gravatar tells whether an address has a Gravatar, a hint that a person uses it.
+type Gravatar struct {
+	// Client does the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// Name implements Enricher.
+func (g Gravatar) Name() string {
+	return "gravatar"
+}
+
+// Enrich implements Enricher with a HEAD request for the avatar of email.
+func (g Gravatar) Enrich(ctx context.Context, email string) (Enrichment, error) {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	url := fmt.Sprintf(gravatarURL, hex.EncodeToString(hash[:]))
+
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return Enrichment{}, err
+	}
+
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return Enrichment{}, errors.Wrap(err, "could not look up gravatar")
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return Enrichment{Found: true, Detail: url}, nil
+	case http.StatusNotFound:
+		return Enrichment{}, nil
+	}
+
+	return Enrichment{}, errors.Errorf("could not look up gravatar: unexpected status %s", resp.Status)
+}
+
+// enrich runs every configured enricher for res.
+func (c *Checker) enrich(ctx context.Context, res *Result) {
+	if len(c.options.Enrichers) == 0 || res.Verdict == VerdictInvalid {
+		return
+	}
+
+	res.Enrichments = map[string]Enrichment{}
+	for _, enricher := range c.options.Enrichers {
+		enrichment, err := enricher.Enrich(ctx, res.Email)
+		if err != nil {
+			enrichment = Enrichment{Error: err.Error()}
+		}
+
+		res.Enrichments[enricher.Name()] = enrichment
+	}
+}
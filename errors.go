@@ -0,0 +1,115 @@
+package mailcheck
+
+import (
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidSyntax is matched by the errors of malformed addresses.
+	ErrInvalidSyntax = errors.New("invalid email address")
+	// ErrNoMX means the domain has no mail servers.
+	ErrNoMX = errors.New("no mail servers found")
+	// ErrPolicyBlocked means the domain is blocked by Options.Policy.
+	ErrPolicyBlocked = errors.New("domain is blocked by policy")
+)
+
+// retryAfterRegex matches the delay in replies such as "greylisted, try again in 5 minutes".
+var retryAfterRegex = regexp.MustCompile(`(?i)(?:try again|retry|wait)\D{0,20}?(\d+)\s*(seconds?|secs?|minutes?|mins?|hours?|[smh])\b`)
+
+// ErrBlocked means a mail server refused the probe because of who is probing, for instance because our IP is on a
+// blocklist or the sender was rejected, so it says nothing about the address.
+type ErrBlocked struct {
+	// Code is the reply code and MX the mail server that refused.
+	Code int
+	MX   string
+	// ListedOn is the DNSBL the mail server named, if any.
+	ListedOn string
+	err      error
+}
+
+func (e *ErrBlocked) Error() string {
+	return e.err.Error()
+}
+
+func (e *ErrBlocked) Unwrap() error {
+	return e.err
+}
+
+// ErrTemporary means a mail server asked to try again later, for instance because of greylisting.
+type ErrTemporary struct {
+	// Code is the reply code and MX the mail server that asked.
+	Code int
+	MX   string
+	// RetryAfter is the delay the server asked for, zero when it did not say.
+	RetryAfter time.Duration
+	err        error
+}
+
+func (e *ErrTemporary) Error() string {
+	return e.err.Error()
+}
+
+func (e *ErrTemporary) Unwrap() error {
+	return e.err
+}
+
+// syntaxError is a malformed address, it matches ErrInvalidSyntax while keeping the details.
+type syntaxError struct {
+	err error
+}
+
+func (e syntaxError) Error() string {
+	return e.err.Error()
+}
+
+func (e syntaxError) Unwrap() error {
+	return e.err
+}
+
+func (e syntaxError) Is(target error) bool {
+	return target == ErrInvalidSyntax
+}
+
+// fail records a verdict reached because of err.
+func (r *Result) fail(verdict Verdict, reason Reason, err error) {
+	r.Verdict, r.Reason, r.Err, r.Error = verdict, reason, err, err.Error()
+}
+
+// typedError returns err, which ended the probe recorded in res, as one of the exported error types
+// where reason calls for one, err itself otherwise.
+func typedError(res *Result, reason Reason, err error) error {
+	switch reason {
+	case ReasonSenderIssue:
+		return &ErrBlocked{Code: res.Code, MX: res.MX, ListedOn: res.ListedOn, err: err}
+	case ReasonTemporary:
+		return &ErrTemporary{Code: res.Code, MX: res.MX, RetryAfter: parseRetryAfter(res.Response), err: err}
+	default:
+		return err
+	}
+}
+
+// parseRetryAfter returns the delay a reply asks to wait for before trying again, zero when it does not say.
+func parseRetryAfter(text string) time.Duration {
+	match := retryAfterRegex.FindStringSubmatch(text)
+	if match == nil {
+		return 0
+	}
+
+	n, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0
+	}
+
+	switch unit := strings.ToLower(match[2]); {
+	case strings.HasPrefix(unit, "h"):
+		return time.Duration(n) * time.Hour
+	case strings.HasPrefix(unit, "m"):
+		return time.Duration(n) * time.Minute
+	default:
+		return time.Duration(n) * time.Second
+	}
+}
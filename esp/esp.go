@@ -0,0 +1,74 @@
+// Package esp hands checked addresses back to the email service providers and CRMs they are sent from,
+// as a file in their import format or through their APIs.
+package esp
+
+import (
+	"context"
+	"encoding/csv"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const requestTimeout = time.Second * 30
+
+// Platform is a sending platform that takes lists of addresses.
+type Platform interface {
+	// WriteCSV writes emails as a CSV file the platform imports as is.
+	WriteCSV(w io.Writer, emails []string) error
+	// Import adds emails to the platform through its API.
+	Import(ctx context.Context, emails []string) error
+}
+
+// writeCSV writes emails below a header naming the column the platform expects them in.
+func writeCSV(w io.Writer, header string, emails []string) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{header}); err != nil {
+		return errors.Wrap(err, "could not write export")
+	}
+
+	for _, email := range emails {
+		if err := writer.Write([]string{email}); err != nil {
+			return errors.Wrap(err, "could not write export")
+		}
+	}
+
+	writer.Flush()
+	return errors.Wrap(writer.Error(), "could not write export")
+}
+
+// chunks splits emails into slices of at most size addresses, the most an API takes in a single request.
+func chunks(emails []string, size int) (split [][]string) {
+	for len(emails) > size {
+		split = append(split, emails[:size])
+		emails = emails[size:]
+	}
+
+	if len(emails) > 0 {
+		split = append(split, emails)
+	}
+
+	return split
+}
+
+// do sends req and returns the response body, failing on anything but a status in the 2xx range.
+func do(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read response")
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.Errorf("%s %s returned %s", req.Method, req.URL.Path, resp.Status)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,78 @@
+package esp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+)
+
+const (
+	hubSpotUpsertAPI = "https://api.hubapi.com/crm/v3/objects/contacts/batch/upsert"
+	// hubSpotMaxContacts is the maximum number of contacts upserted in a single request
+	hubSpotMaxContacts = 100
+)
+
+// HubSpot adds addresses to the contacts of a HubSpot CRM.
+type HubSpot struct {
+	token    string
+	endpoint string
+	client   *http.Client
+}
+
+// NewHubSpot returns the contacts of the account token, the access token of a private app with the
+// crm.objects.contacts.write scope, belongs to.
+func NewHubSpot(token string) *HubSpot {
+	return &HubSpot{
+		token:    token,
+		endpoint: hubSpotUpsertAPI,
+		client:   &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// WriteCSV implements Platform.
+func (h *HubSpot) WriteCSV(w io.Writer, emails []string) error {
+	return writeCSV(w, "Email", emails)
+}
+
+// Import implements Platform. Contacts are matched on their address, so existing ones keep their properties.
+func (h *HubSpot) Import(ctx context.Context, emails []string) error {
+	if h.token == "" {
+		return errors.New("importing into hubspot needs an access token")
+	}
+
+	type input struct {
+		ID         string            `json:"id"`
+		IDProperty string            `json:"idProperty"`
+		Properties map[string]string `json:"properties"`
+	}
+
+	for _, chunk := range chunks(emails, hubSpotMaxContacts) {
+		batch := struct {
+			Inputs []input `json:"inputs"`
+		}{}
+		for _, email := range chunk {
+			batch.Inputs = append(batch.Inputs, input{ID: email, IDProperty: "email", Properties: map[string]string{"email": email}})
+		}
+
+		body, err := json.Marshal(batch)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return errors.Wrap(err, "could not create hubspot request")
+		}
+		req.Header.Set("Authorization", "Bearer "+h.token)
+		req.Header.Set("Content-Type", "application/json")
+
+		if _, err := do(h.client, req); err != nil {
+			return errors.Wrap(err, "could not import into hubspot")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package esp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	mailchimpAPI = "https://%s.api.mailchimp.com/3.0/"
+	// mailchimpMaxMembers is the maximum number of members added to an audience in a single request
+	mailchimpMaxMembers = 500
+)
+
+// Mailchimp adds addresses to a Mailchimp audience.
+type Mailchimp struct {
+	apiKey   string
+	listID   string
+	endpoint string
+	client   *http.Client
+}
+
+// NewMailchimp returns the audience listID of the account of apiKey, whose suffix such as -us6 names the data center
+// of the account. Both are only needed to import, not to write files.
+func NewMailchimp(apiKey, listID string) *Mailchimp {
+	endpoint := ""
+	if i := strings.LastIndex(apiKey, "-"); i >= 0 {
+		endpoint = fmt.Sprintf(mailchimpAPI, apiKey[i+1:])
+	}
+
+	return &Mailchimp{
+		apiKey:   apiKey,
+		listID:   listID,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// WriteCSV implements Platform.
+func (m *Mailchimp) WriteCSV(w io.Writer, emails []string) error {
+	return writeCSV(w, "Email Address", emails)
+}
+
+// Import implements Platform. New addresses are added as subscribed, existing members are left as they are
+// so that nobody who unsubscribed is signed up again.
+func (m *Mailchimp) Import(ctx context.Context, emails []string) error {
+	if m.endpoint == "" || m.listID == "" {
+		return errors.New("importing into mailchimp needs an api key with data center and an audience id")
+	}
+
+	type member struct {
+		EmailAddress string `json:"email_address"`
+		Status       string `json:"status"`
+	}
+
+	for _, chunk := range chunks(emails, mailchimpMaxMembers) {
+		batch := struct {
+			Members        []member `json:"members"`
+			UpdateExisting bool     `json:"update_existing"`
+		}{}
+		for _, email := range chunk {
+			batch.Members = append(batch.Members, member{EmailAddress: email, Status: "subscribed"})
+		}
+
+		body, err := json.Marshal(batch)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.endpoint+"lists/"+m.listID, bytes.NewReader(body))
+		if err != nil {
+			return errors.Wrap(err, "could not create mailchimp request")
+		}
+		req.SetBasicAuth("mailcheck", m.apiKey)
+		req.Header.Set("Content-Type", "application/json")
+
+		if _, err := do(m.client, req); err != nil {
+			return errors.Wrap(err, "could not import into mailchimp")
+		}
+	}
+
+	return nil
+}
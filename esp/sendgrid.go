@@ -0,0 +1,78 @@
+package esp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+)
+
+const (
+	sendGridContactsAPI = "https://api.sendgrid.com/v3/marketing/contacts"
+	// sendGridMaxContacts is the maximum number of contacts added in a single request
+	sendGridMaxContacts = 30000
+)
+
+// SendGrid adds addresses to the Marketing Campaigns contacts of a SendGrid account.
+type SendGrid struct {
+	apiKey   string
+	listIDs  []string
+	endpoint string
+	client   *http.Client
+}
+
+// NewSendGrid returns the contacts of the account of apiKey, adding imported addresses to the lists of listIDs.
+func NewSendGrid(apiKey string, listIDs ...string) *SendGrid {
+	return &SendGrid{
+		apiKey:   apiKey,
+		listIDs:  listIDs,
+		endpoint: sendGridContactsAPI,
+		client:   &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// WriteCSV implements Platform.
+func (s *SendGrid) WriteCSV(w io.Writer, emails []string) error {
+	return writeCSV(w, "email", emails)
+}
+
+// Import implements Platform. SendGrid processes the contacts after accepting them, so they show up with a delay.
+func (s *SendGrid) Import(ctx context.Context, emails []string) error {
+	if s.apiKey == "" {
+		return errors.New("importing into sendgrid needs an api key")
+	}
+
+	type contact struct {
+		Email string `json:"email"`
+	}
+
+	for _, chunk := range chunks(emails, sendGridMaxContacts) {
+		batch := struct {
+			ListIDs  []string  `json:"list_ids,omitempty"`
+			Contacts []contact `json:"contacts"`
+		}{ListIDs: s.listIDs}
+		for _, email := range chunk {
+			batch.Contacts = append(batch.Contacts, contact{Email: email})
+		}
+
+		body, err := json.Marshal(batch)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return errors.Wrap(err, "could not create sendgrid request")
+		}
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+		req.Header.Set("Content-Type", "application/json")
+
+		if _, err := do(s.client, req); err != nil {
+			return errors.Wrap(err, "could not import into sendgrid")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package mailcheck
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// historyMaxAge is how far back Options.History is trusted to tell whether a domain accepts any address.
+const historyMaxAge = time.Hour * 24 * 30
+
+// How a verdict was inferred without probing, see Result.Inferred.
+const (
+	// InferredProvider means the provider of the domain accepts any address.
+	InferredProvider = "provider"
+	// InferredRun means the domain was found to accept any address earlier in the life of the Checker.
+	InferredRun = "run"
+	// InferredHistory means Options.History has the domain as accepting any address.
+	InferredHistory = "history"
+)
+
+func checkFast(ctx context.Context, c *Checker, address *Address) bool {
+	res := address.Result
+	if !c.options.Fast || !res.Level.includes(LevelSMTP) || len(address.Servers) == 0 {
+		return false
+	}
+
+	inferred := c.inferCatchAll(ctx, address.Domain, address.Servers)
+	if inferred == "" {
+		return false
+	}
+
+	res.Verdict, res.Reason = VerdictUnknown, ReasonCatchAll
+	res.CatchAll, res.Inferred = true, inferred
+	if inferred == InferredProvider {
+		res.Provider = identifyProvider(address.Servers[0].Host, "")
+	}
+
+	return true
+}
+
+// inferCatchAll returns how domain is known to accept any address, empty when it is not.
+func (c *Checker) inferCatchAll(ctx context.Context, domain string, servers []MailServer) string {
+	for _, server := range servers {
+		if profile := identifyProvider(server.Host, "").profile(); profile != nil && profile.acceptsAll {
+			return InferredProvider
+		}
+	}
+
+	domain = strings.ToLower(domain)
+
+	c.catchAllMu.Lock()
+	catchAll := c.catchAll[domain]
+	c.catchAllMu.Unlock()
+
+	if catchAll {
+		return InferredRun
+	}
+
+	if c.historyCatchAll(ctx, domain) {
+		return InferredHistory
+	}
+
+	return ""
+}
+
+// historyCatchAll reports whether the latest verification at domain in Options.History found it catch-all,
+// cached per domain for the lifetime of the Checker. A failing lookup counts as not.
+func (c *Checker) historyCatchAll(ctx context.Context, domain string) bool {
+	if c.options.History == nil {
+		return false
+	}
+
+	c.historyMu.Lock()
+	catchAll, ok := c.history[domain]
+	c.historyMu.Unlock()
+
+	if ok {
+		return catchAll
+	}
+
+	records, err := c.options.History.Lookup(ctx, StoreQuery{Domain: domain, Since: time.Now().Add(-historyMaxAge), Limit: 1})
+	if err != nil {
+		return false
+	}
+
+	catchAll = len(records) == 1 && records[0].Reason == ReasonCatchAll
+
+	c.historyMu.Lock()
+	c.history[domain] = catchAll
+	c.historyMu.Unlock()
+
+	return catchAll
+}
+
+// rememberCatchAll records that domain accepts any address, as if probed with IsCatchAll.
+func (c *Checker) rememberCatchAll(domain string) {
+	c.catchAllMu.Lock()
+	defer c.catchAllMu.Unlock()
+
+	c.catchAll[strings.ToLower(domain)] = true
+}
@@ -3,6 +3,13 @@ module github.com/hazcod/mailcheck
 go 1.15
 
 require (
+	github.com/lib/pq v1.10.0
+	github.com/miekg/dns v1.1.41
+	github.com/peterbourgon/ff/v3 v3.0.0
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.6.0
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
+	gopkg.in/yaml.v2 v2.4.0
+	modernc.org/sqlite v1.14.1
 )
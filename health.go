@@ -0,0 +1,166 @@
+package mailcheck
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// healthWindow is the number of recent connects and probes a mail server is judged by,
+	// of which at least healthSamples are needed to judge it at all
+	healthWindow  = 20
+	healthSamples = 3
+	// slowConnect is the average time to connect and greet above which a mail server counts as unhealthy
+	slowConnect = time.Second * 3
+)
+
+// mxHealth keeps rolling statistics of the mail servers connected to during the life of the Checker, so that
+// addresses at a domain with several mail servers are not probed over and over on one that is down or refusing.
+type mxHealth struct {
+	mu    sync.Mutex
+	hosts map[string]*hostHealth
+}
+
+type hostHealth struct {
+	// connects holds whether each of the recent connects succeeded, latencies how long the successful ones took
+	connects  []bool
+	latencies []time.Duration
+	// rejections holds whether each of the recent probes was refused regardless of the recipient
+	rejections []bool
+}
+
+func newMXHealth() *mxHealth {
+	return &mxHealth{hosts: map[string]*hostHealth{}}
+}
+
+func (h *mxHealth) host(host string) *hostHealth {
+	host = canonicalHost(host)
+
+	health, ok := h.hosts[host]
+	if !ok {
+		health = &hostHealth{}
+		h.hosts[host] = health
+	}
+
+	return health
+}
+
+// observeConnect records whether connecting to host succeeded and how long it took.
+func (h *mxHealth) observeConnect(host string, ok bool, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	health := h.host(host)
+	health.connects = appendWindow(health.connects, ok)
+	if ok {
+		health.latencies = append(health.latencies, latency)
+		if len(health.latencies) > healthWindow {
+			health.latencies = health.latencies[1:]
+		}
+	}
+}
+
+// observeProbe records whether a probe over host was refused regardless of the recipient.
+func (h *mxHealth) observeProbe(host string, rejected bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	health := h.host(host)
+	health.rejections = appendWindow(health.rejections, rejected)
+}
+
+// order returns servers with the unhealthy ones moved to the end, the least unhealthy first.
+// Healthy servers keep their order, since backup mail servers often know less about the recipients.
+func (h *mxHealth) order(servers []MailServer) []MailServer {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var healthy, unhealthy []MailServer
+	scores := map[string]float64{}
+
+	for _, server := range servers {
+		score, ok := h.host(server.Host).score()
+		if ok {
+			healthy = append(healthy, server)
+			continue
+		}
+
+		scores[server.Host] = score
+		unhealthy = append(unhealthy, server)
+	}
+
+	if len(unhealthy) == 0 || len(healthy) == 0 {
+		return servers
+	}
+
+	sort.SliceStable(unhealthy, func(i, j int) bool {
+		return scores[unhealthy[i].Host] < scores[unhealthy[j].Host]
+	})
+
+	for _, server := range unhealthy {
+		log.Debugf("trying %s last, it has been failing", server.Host)
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// score tells whether the host is healthy and otherwise how badly it is doing, higher being worse.
+// A host is healthy until there are enough samples to tell otherwise.
+func (s *hostHealth) score() (score float64, healthy bool) {
+	healthy = true
+
+	if len(s.connects) >= healthSamples {
+		failureRate := 1 - rate(s.connects)
+		score += failureRate
+		healthy = healthy && failureRate < 0.5
+	}
+
+	if len(s.rejections) >= healthSamples {
+		rejectionRate := rate(s.rejections)
+		score += rejectionRate
+		healthy = healthy && rejectionRate < 0.5
+	}
+
+	if len(s.latencies) >= healthSamples {
+		var total time.Duration
+		for _, latency := range s.latencies {
+			total += latency
+		}
+
+		average := total / time.Duration(len(s.latencies))
+		score += average.Seconds() / slowConnect.Seconds()
+		healthy = healthy && average < slowConnect
+	}
+
+	return score, healthy
+}
+
+// refusesProbes reports whether a probe ended with err was refused regardless of the recipient: a rejected sender
+// or a server closing the transmission channel.
+func refusesProbes(err error) bool {
+	var reply *replyError
+	return errors.As(err, &reply) && (reply.command == cmdMailFrom || reply.code == 421)
+}
+
+// appendWindow appends outcome to outcomes, dropping the oldest beyond healthWindow.
+func appendWindow(outcomes []bool, outcome bool) []bool {
+	outcomes = append(outcomes, outcome)
+	if len(outcomes) > healthWindow {
+		outcomes = outcomes[1:]
+	}
+	return outcomes
+}
+
+// rate returns the share of outcomes that are true.
+func rate(outcomes []bool) float64 {
+	count := 0
+	for _, outcome := range outcomes {
+		if outcome {
+			count++
+		}
+	}
+	return float64(count) / float64(len(outcomes))
+}
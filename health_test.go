@@ -0,0 +1,87 @@
+package mailcheck
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serverHosts returns the hosts of servers, comma separated.
+func serverHosts(servers []MailServer) string {
+	names := make([]string, 0, len(servers))
+	for _, server := range servers {
+		names = append(names, server.Host)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestHealthOrdersUnhealthyServersLast(t *testing.T) {
+	health := newMXHealth()
+	servers := []MailServer{{Host: "a.example.com"}, {Host: "b.example.com"}, {Host: "c.example.com"}, {Host: "d.example.com"}}
+
+	// too few samples to judge a server by
+	for i := 0; i < healthSamples-1; i++ {
+		health.observeConnect("a.example.com", false, 0)
+	}
+	// b cannot be connected to, c can but answers slowly and refuses every probe, which is worse
+	for i := 0; i < healthSamples; i++ {
+		health.observeConnect("b.example.com", false, 0)
+		health.observeConnect("c.example.com", true, slowConnect*2)
+		health.observeProbe("c.example.com", true)
+		health.observeConnect("d.example.com", true, time.Millisecond)
+		health.observeProbe("d.example.com", false)
+	}
+
+	if got := serverHosts(health.order(servers)); got != "a.example.com,d.example.com,b.example.com,c.example.com" {
+		t.Errorf("expected the failing servers last, the worst at the end, got %s", got)
+	}
+
+	// b recovers once most of its recent connects succeed
+	for i := 0; i < healthSamples+1; i++ {
+		health.observeConnect("B.example.com.", true, time.Millisecond)
+	}
+	if got := serverHosts(health.order(servers)); got != "a.example.com,b.example.com,d.example.com,c.example.com" {
+		t.Errorf("expected b back in its place, got %s", got)
+	}
+
+	// old failures leave the window, c recovers once its recent samples are good
+	for i := 0; i < healthWindow; i++ {
+		health.observeConnect("c.example.com", true, time.Millisecond)
+		health.observeProbe("c.example.com", false)
+	}
+	if got := serverHosts(health.order(servers)); got != "a.example.com,b.example.com,c.example.com,d.example.com" {
+		t.Errorf("expected every server in its place, got %s", got)
+	}
+}
+
+func TestHealthKeepsOrderWhenAllFail(t *testing.T) {
+	health := newMXHealth()
+	servers := []MailServer{{Host: "a.example.com"}, {Host: "b.example.com"}}
+
+	for i := 0; i < healthSamples; i++ {
+		health.observeConnect("a.example.com", false, 0)
+		health.observeConnect("b.example.com", false, 0)
+		health.observeProbe("b.example.com", true)
+	}
+
+	if got := serverHosts(health.order(servers)); got != "a.example.com,b.example.com" {
+		t.Errorf("expected the order of the mail servers when none is healthy, got %s", got)
+	}
+}
+
+func TestRefusesProbes(t *testing.T) {
+	for _, test := range []struct {
+		err     error
+		refuses bool
+	}{
+		{&replyError{command: cmdMailFrom, code: 550, text: "sender rejected"}, true},
+		{&replyError{command: cmdRcptTo, code: 421, text: "closing connection"}, true},
+		{&replyError{command: cmdRcptTo, code: 550, text: "user unknown"}, false},
+		{errors.New("connection reset"), false},
+	} {
+		if refuses := refusesProbes(test.err); refuses != test.refuses {
+			t.Errorf("%v: expected %v, got %v", test.err, test.refuses, refuses)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package mailcheck
+
+import (
+	"github.com/pkg/errors"
+	"math/rand"
+	"net"
+	"sync"
+)
+
+// errIdentitiesExhausted means every identity in Options.Identities has sent its maximum number of probes.
+var errIdentitiesExhausted = errors.New("every probe identity has used up its probes")
+
+// Identity is who a probe appears to come from. Rotating among several makes a large run look less like one.
+type Identity struct {
+	// HELO is the name mail servers are greeted with.
+	HELO string `json:"helo" yaml:"helo"`
+	// MailFrom is the envelope sender.
+	MailFrom string `json:"mail_from" yaml:"mail_from"`
+	// SourceIP is the local address to connect from, which has to be bound to this host. Any when empty.
+	SourceIP string `json:"source_ip,omitempty" yaml:"source_ip"`
+	// MaxProbes is the number of probes sent as this identity during the life of the Checker, unlimited when zero.
+	MaxProbes int `json:"-" yaml:"max_probes"`
+}
+
+// Validate checks the identity for errors.
+func (i Identity) Validate() error {
+	if i.HELO == "" {
+		return errors.New("identity has no helo name")
+	}
+
+	if _, _, err := ParseAddress(i.MailFrom); err != nil {
+		return errors.Wrapf(err, "invalid mail from of identity %s", i.HELO)
+	}
+
+	if i.SourceIP != "" && net.ParseIP(i.SourceIP) == nil {
+		return errors.Errorf("invalid source ip of identity %s", i.HELO)
+	}
+
+	if i.MaxProbes < 0 {
+		return errors.Errorf("negative max probes of identity %s", i.HELO)
+	}
+
+	return nil
+}
+
+// identityPool hands out the identities of Options.Identities at random, keeping track of their probes.
+// Without identities it holds the one of Options.FromDomain and Options.FromEmail.
+type identityPool struct {
+	mu         sync.Mutex
+	identities []Identity
+	// probes holds the number of probes sent per identity, by index
+	probes []int
+}
+
+func newIdentityPool(identities []Identity) *identityPool {
+	return &identityPool{identities: identities, probes: make([]int, len(identities))}
+}
+
+// pick returns a random identity that has probes left.
+func (p *identityPool) pick() (Identity, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var available []int
+	for i, identity := range p.identities {
+		if identity.MaxProbes == 0 || p.probes[i] < identity.MaxProbes {
+			available = append(available, i)
+		}
+	}
+
+	if len(available) == 0 {
+		return Identity{}, errIdentitiesExhausted
+	}
+
+	return p.identities[available[rand.Intn(len(available))]], nil
+}
+
+// use counts a probe as identity, reporting false when it has none left.
+func (p *identityPool) use(identity Identity) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, candidate := range p.identities {
+		if candidate != identity {
+			continue
+		}
+
+		if candidate.MaxProbes != 0 && p.probes[i] >= candidate.MaxProbes {
+			return false
+		}
+
+		p.probes[i]++
+		return true
+	}
+
+	return true
+}
+
+// exhausted reports whether identity has no probes left.
+func (p *identityPool) exhausted(identity Identity) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, candidate := range p.identities {
+		if candidate == identity {
+			return candidate.MaxProbes != 0 && p.probes[i] >= candidate.MaxProbes
+		}
+	}
+
+	return false
+}
+
+// dialerFor returns the dialer to connect as identity, bound to its source address if it has one.
+func (c *Checker) dialerFor(identity Identity) *net.Dialer {
+	if identity.SourceIP == "" {
+		return c.dialer
+	}
+
+	dialer := *c.dialer
+	dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(identity.SourceIP)}
+	return &dialer
+}
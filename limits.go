@@ -0,0 +1,33 @@
+package mailcheck
+
+// Limits are the options of a Checker that can be changed while it is in use, with SetLimits.
+type Limits struct {
+	// ProbesPerMinute is Options.ProbesPerMinute.
+	ProbesPerMinute int `json:"probes_per_minute"`
+	// RaceMailServers is Options.RaceMailServers.
+	RaceMailServers int `json:"race_mail_servers"`
+	// MaxRcptPerSession is Options.MaxRcptPerSession.
+	MaxRcptPerSession int `json:"max_rcpt_per_session"`
+}
+
+// Limits returns the limits in effect.
+func (c *Checker) Limits() Limits {
+	c.limitsMu.RLock()
+	defer c.limitsMu.RUnlock()
+
+	return c.limits
+}
+
+// SetLimits replaces the limits in effect, for the checks started from then on. Probes already waiting for
+// the rate limit keep their turn.
+func (c *Checker) SetLimits(limits Limits) {
+	if limits.MaxRcptPerSession < 1 {
+		limits.MaxRcptPerSession = 1
+	}
+
+	c.limitsMu.Lock()
+	c.limits = limits
+	c.limitsMu.Unlock()
+
+	c.limiter.setRate(limits.ProbesPerMinute)
+}
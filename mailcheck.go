@@ -0,0 +1,545 @@
+// Package mailcheck verifies whether email addresses exist by asking their mail servers.
+package mailcheck
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"golang.org/x/net/idna"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// VerdictValid means the mail server accepted the address.
+	VerdictValid Verdict = "valid"
+	// VerdictInvalid means the address cannot receive mail.
+	VerdictInvalid Verdict = "invalid"
+	// VerdictUnknown means the address could not be verified either way.
+	VerdictUnknown Verdict = "unknown"
+
+	// LevelSyntax only checks whether the address is well-formed.
+	LevelSyntax Level = "syntax"
+	// LevelDNS also checks whether the domain has mail servers.
+	LevelDNS Level = "dns"
+	// LevelSMTP also asks a mail server whether it accepts the address.
+	LevelSMTP Level = "smtp"
+	// LevelDeep also checks whether the domain is disposable and whether it accepts any address.
+	LevelDeep Level = "deep"
+
+	defaultFromDomain  = "ironpeak.be"
+	defaultFromEmail   = "test@ironpeak.be"
+	defaultDNSServer   = "1.1.1.1"
+	defaultDialTimeout = time.Second * 5
+)
+
+// Verdict is the conclusion about an address.
+type Verdict string
+
+// Level is how deep an address is checked, every level includes the checks of the levels before it.
+type Level string
+
+var levelDepth = map[Level]int{LevelSyntax: 1, LevelDNS: 2, LevelSMTP: 3, LevelDeep: 4}
+
+// ParseLevel returns the level called name.
+func ParseLevel(name string) (Level, error) {
+	switch level := Level(name); level {
+	case LevelSyntax, LevelDNS, LevelSMTP, LevelDeep:
+		return level, nil
+	}
+
+	return "", errors.Errorf("unknown level '%s'", name)
+}
+
+// includes reports whether checking at l includes the checks of other.
+func (l Level) includes(other Level) bool {
+	return levelDepth[l] >= levelDepth[other]
+}
+
+// Result is the outcome of checking a single address.
+type Result struct {
+	Email   string  `json:"email"`
+	Verdict Verdict `json:"verdict"`
+	Reason  Reason  `json:"reason,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	// Err is the cause of Error, for errors.Is and errors.As: ErrInvalidSyntax, ErrNoMX, ErrPolicyBlocked,
+	// *ErrBlocked or *ErrTemporary where it applies.
+	Err error `json:"-"`
+	// Level is how deep the address was checked, a valid verdict only holds up to that level.
+	Level Level `json:"level"`
+	Classification
+	// Disposable and CatchAll are only determined at LevelDeep, or CatchAll for providers known to accept
+	// any address. An accepted address at a catch-all domain is reported as unknown:catch_all.
+	Disposable bool `json:"disposable,omitempty"`
+	CatchAll   bool `json:"catch_all,omitempty"`
+	// Provider is the large mail provider hosting the domain, if recognized. Its replies are read the way it means them.
+	Provider Provider `json:"provider,omitempty"`
+	// ProviderLimited means the verdict is no better than the provider lets it be, such as for Exchange Online
+	// tenants that accept any recipient, or whose acceptance could not be confirmed. Only with Options.ExchangeOnline.
+	ProviderLimited bool `json:"provider_limited,omitempty"`
+	// Inferred is set when the verdict was inferred from what is known about the domain rather than probed,
+	// with Options.Fast: InferredProvider, InferredRun or InferredHistory.
+	Inferred string `json:"inferred,omitempty"`
+	// CheckedAt is only set on results taken from a Store instead of checked again, to when they were checked.
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	// TLS describes the certificate of the mail server when the connection to it used TLS.
+	TLS *TLSCertificate `json:"tls,omitempty"`
+	// DNSSEC is the outcome of validating the MX records of the domain, only with Options.DNSSEC.
+	DNSSEC DNSSECStatus `json:"dnssec,omitempty"`
+	// DomainListed tells whether the domain is on one of Options.DomainBlocklists, nil when not looked up.
+	// DomainBlocklists holds the lookups.
+	DomainListed     *bool             `json:"domain_listed,omitempty"`
+	DomainBlocklists []BlocklistResult `json:"domain_blocklists,omitempty"`
+	// DomainCreated is when the registered domain was created, only looked up with Options.DomainAge.
+	// YoungDomain means it is younger than Options.YoungDomainAge, a strong fraud signal.
+	DomainCreated *time.Time `json:"domain_created,omitempty"`
+	YoungDomain   bool       `json:"young_domain,omitempty"`
+	// Spamtrap is how likely the address is a spamtrap, only assessed with Options.Spamtraps.
+	Spamtrap *SpamtrapRisk `json:"spamtrap,omitempty"`
+	// Enrichments holds the findings of Options.Enrichers by name.
+	Enrichments map[string]Enrichment `json:"enrichments,omitempty"`
+	// Auth holds the sender authentication records of the domain, only looked up at LevelDeep.
+	Auth *DomainAuth `json:"auth,omitempty"`
+	// Score is the deliverability of the address from 0 to 100, Reasons the findings that lowered it.
+	Score   int      `json:"score"`
+	Reasons []string `json:"reasons,omitempty"`
+	// Suggestion is a corrected address when the domain looks like a typo of a popular mail provider.
+	Suggestion string `json:"suggestion,omitempty"`
+	// Code, EnhancedCode and Response are the reply of the mail server the verdict is based on.
+	// VerifiedBy is the command that reply answered when it is not RCPT TO, VRFY or EXPN.
+	Code         int    `json:"code,omitempty"`
+	EnhancedCode string `json:"enhanced_code,omitempty"`
+	Response     string `json:"response,omitempty"`
+	VerifiedBy   string `json:"verified_by,omitempty"`
+	// ListedOn is the DNSBL the mail server said our IP is listed on, for unknown:sender_issue verdicts.
+	ListedOn string `json:"listed_on,omitempty"`
+	// MX and Port identify the mail server that answered the probe.
+	MX   string `json:"mx,omitempty"`
+	Port int    `json:"port,omitempty"`
+	// Identity is who the probe came from, only recorded with Options.Identities.
+	Identity *Identity `json:"identity,omitempty"`
+	// Attempts holds the number of attempts made per stage.
+	Attempts map[string]int `json:"attempts,omitempty"`
+	// Durations holds the time spent per stage, retries and waits for sessions included.
+	Durations map[string]time.Duration `json:"durations,omitempty"`
+	// Transcript is the SMTP conversation, only recorded when Options.Transcript is set.
+	Transcript []Exchange `json:"transcript,omitempty"`
+}
+
+// MailServer is a mail server to connect to. A zero Port means Options.Ports are tried.
+type MailServer struct {
+	Host string
+	Port int
+}
+
+// Options configures a Checker. The zero value is usable.
+type Options struct {
+	// Level is how deep addresses are checked, LevelSMTP when empty.
+	Level Level
+	// FromDomain is used to greet mail servers.
+	FromDomain string
+	// FromEmail is the envelope sender of the probes.
+	FromEmail string
+	// Identities replace FromDomain and FromEmail by a pool of identities, of which every session to a mail server
+	// uses one at random. The identity of the probe is recorded in every result.
+	Identities []Identity
+	// Ports are tried in order on every mail server, 25, 465 and 587 when empty.
+	Ports []int
+	// Retry is applied to every stage of a check.
+	Retry RetryPolicy
+	// DNSServer is the resolver used for all lookups, including the addresses of mail servers,
+	// as host or host:port. 1.1.1.1 when empty.
+	DNSServer string
+	// DNSCacheSize is the number of DNS answers cached, each for as long as its TTL allows, 10000 when zero.
+	// Negative disables the cache.
+	DNSCacheSize int
+	// DialTimeout limits connecting to a single server, 5 seconds when zero.
+	DialTimeout time.Duration
+	// StageBudget limits the time every check spends per stage, no limits besides the context when zero.
+	StageBudget StageBudget
+	// Hosts are static entries consulted before DNS.
+	Hosts Hosts
+	// MXOverrides pins lowercased domains to mail servers, bypassing DNS for those domains.
+	MXOverrides map[string][]MailServer
+	// Transcript records the SMTP conversation in every result.
+	Transcript bool
+	// Auditor records every probe sent to a mail server, none when nil.
+	Auditor Auditor
+	// OnResult is called with the result of every check that completed, before Check returns it. It is called
+	// from the goroutines calling Check, so it has to be safe for concurrent use.
+	OnResult func(Result)
+	// MaxRcptPerSession is the number of recipients probed over a single SMTP session before reconnecting.
+	// Sessions are pooled by mail server between checks, until they expire or Checker.Close is called,
+	// and reset with RSET before every reuse. One, the default, opens a new session for every check.
+	MaxRcptPerSession int
+	// Smarthost is a relay that probes are sent through instead of the mail servers of the domain, for hosts
+	// that cannot connect to port 25. It has to verify recipients with a callout to be of any use.
+	// Without a port, Ports are tried.
+	Smarthost MailServer
+	// SMTPUser and SMTPPassword log in to the Smarthost, over TLS only. No login when empty.
+	SMTPUser     string
+	SMTPPassword string
+	// SmarthostInsecure skips verifying the certificate of the Smarthost, for testing.
+	SmarthostInsecure bool
+	// RaceMailServers dials that many of the mail servers of a domain at the same time and uses the first to
+	// answer, so a dead primary does not cost a full DialTimeout. Zero or one tries them one after another.
+	RaceMailServers int
+	// SessionIdleTimeout closes pooled sessions that have not been used for that long, 30 seconds when zero.
+	SessionIdleTimeout time.Duration
+	// SessionMaxLifetime closes sessions that have been open for that long, 5 minutes when zero.
+	SessionMaxLifetime time.Duration
+	// MaxIdleSessionsPerMX is the number of sessions pooled per mail server, 2 when zero.
+	MaxIdleSessionsPerMX int
+	// ProbesPerMinute limits the probes sent to the mail servers of a single domain, zero for no limit.
+	ProbesPerMinute int
+	// ScoreWeights are the penalties that make up the score, DefaultScoreWeights when empty.
+	ScoreWeights ScoreWeights
+	// RoleAccounts are the local parts classified as role accounts, DefaultRoleAccounts when empty.
+	RoleAccounts []string
+	// UseVRFY asks mail servers that advertise VRFY or EXPN about addresses RCPT TO left ambiguous.
+	// Most servers disable both commands, so this is off by default.
+	UseVRFY bool
+	// ExchangeOnline confirms addresses accepted by Exchange Online, which accepts any recipient unless the tenant
+	// enabled directory based edge blocking, by probing an address that cannot exist at the domain. Addresses at
+	// tenants that accept it are unknown:catch_all, and verdicts that cannot be confirmed are marked ProviderLimited.
+	ExchangeOnline bool
+	// Fast skips probing addresses at domains known to accept any address: providers that do, domains found
+	// catch-all earlier in the life of the Checker and, with History, during earlier runs. Their addresses are
+	// unknown:catch_all right away, with Result.Inferred telling how that was known. It trades some accuracy,
+	// as a domain may have changed, for far fewer probes on large lists.
+	Fast bool
+	// History holds earlier verifications for Fast to draw on, none when nil.
+	History Store
+	// DANE makes CheckDomain at LevelSMTP and deeper verify the mail servers against their TLSA records.
+	// Check does not look at TLSA records, its results are the same with or without DANE.
+	DANE bool
+	// InspectTLS upgrades connections on port 25 with STARTTLS when offered, so that Result.TLS describes the
+	// certificate of the mail server, and makes CheckDomain at LevelSMTP and deeper describe those of all of them.
+	InspectTLS bool
+	// DNSSEC validates MX, TXT and TLSA answers from the root down instead of trusting the resolver.
+	// Checks of domains with bogus answers stop at the lookup, since spoofed MX records make probing meaningless.
+	DNSSEC bool
+	// Policy allows or blocks addresses by their domain without checking them any further, at every level.
+	Policy DomainPolicy
+	// Profiles adjust the checks of addresses at the domains they match, see DomainProfile. Policy goes first.
+	Profiles []DomainProfile
+	// DomainBlocklists are the domain blocklists, such as DefaultDomainBlocklists, that the domain of every address
+	// is looked up on at LevelDNS and deeper. Addresses at a listed domain are not probed. None when empty.
+	DomainBlocklists []string
+	// DomainAge looks up when the registered domain of every address was created, over RDAP at LevelDNS and deeper.
+	DomainAge bool
+	// YoungDomainAge is the age below which a domain counts as young, 30 days when zero.
+	YoungDomainAge time.Duration
+	// Spamtraps assesses how likely every address that is not invalid is a spamtrap, from TrapDomains, whether
+	// the domain has a website at LevelDNS and deeper, its age with DomainAge, and BreachLookup.
+	Spamtraps bool
+	// TrapDomains are known spamtrap domains, which may use the wildcards of DomainPolicy.
+	TrapDomains []string
+	// BreachLookup looks up the data breaches addresses appear in for Spamtraps, nil to skip it.
+	BreachLookup BreachLookup
+	// Enrichers add soft signals, such as Gravatar, to the result of every address that is not invalid.
+	Enrichers []Enricher
+	// Checks is the pipeline every address goes through, DefaultChecks when empty.
+	// Use WithoutChecks to disable checks, or add custom ones made with NewCheck.
+	Checks []Check
+}
+
+// Checker verifies email addresses. It is safe for concurrent use.
+type Checker struct {
+	options      Options
+	dialer       *net.Dialer
+	resolver     *net.Resolver
+	dnsCache     *dnsCache
+	roleAccounts map[string]bool
+	limiter      *rateLimiter
+	limitsMu     sync.RWMutex
+	limits       Limits
+	throttle     *hostThrottle
+	health       *mxHealth
+	identities   *identityPool
+
+	sessionsMu sync.Mutex
+	// sessions holds idle SMTP sessions by mail server, the most recently used last
+	sessions map[string][]*smtpClient
+
+	catchAllMu sync.Mutex
+	// catchAll caches by domain whether it accepts any address
+	catchAll map[string]bool
+
+	zonesMu sync.Mutex
+	// zones caches by zone whether its keys are validated
+	zones map[string]zoneTrust
+
+	domainListingsMu sync.Mutex
+	// domainListings caches the domain blocklist lookups by domain and zones
+	domainListings map[string][]BlocklistResult
+
+	http   *http.Client
+	rdapMu sync.Mutex
+	// rdapBootstrap holds the RDAP server by top-level domain once fetched
+	rdapBootstrap map[string]string
+	// domainCreated caches the creation dates by registered domain
+	domainCreated map[string]time.Time
+
+	webPresenceMu sync.Mutex
+	// webPresence caches by domain whether it has a website
+	webPresence map[string]bool
+
+	historyMu sync.Mutex
+	// history caches by domain whether Options.History has it as catch-all
+	history map[string]bool
+
+	prefetchedMu sync.Mutex
+	// prefetched holds the mail servers looked up with PrefetchMailServers by domain
+	prefetched map[string]prefetchedMX
+}
+
+// New returns a Checker for options, filling in defaults for unset options.
+func New(options Options) *Checker {
+	if options.Level == "" {
+		options.Level = LevelSMTP
+	}
+
+	if options.FromDomain == "" {
+		options.FromDomain = defaultFromDomain
+	}
+
+	if options.FromEmail == "" {
+		options.FromEmail = defaultFromEmail
+	}
+
+	if len(options.Ports) == 0 {
+		options.Ports = []int{smtpPort, smtpTLSPort, smtpSubmissionPort}
+	}
+
+	if options.DNSServer == "" {
+		options.DNSServer = defaultDNSServer
+	}
+
+	if options.DialTimeout == 0 {
+		options.DialTimeout = defaultDialTimeout
+	}
+
+	if options.MaxRcptPerSession < 1 {
+		options.MaxRcptPerSession = 1
+	}
+
+	if options.SessionIdleTimeout == 0 {
+		options.SessionIdleTimeout = defaultSessionIdleTimeout
+	}
+
+	if options.SessionMaxLifetime == 0 {
+		options.SessionMaxLifetime = defaultSessionMaxLifetime
+	}
+
+	if options.MaxIdleSessionsPerMX < 1 {
+		options.MaxIdleSessionsPerMX = defaultMaxIdleSessionsPerMX
+	}
+
+	if options.YoungDomainAge == 0 {
+		options.YoungDomainAge = defaultYoungDomainAge
+	}
+
+	if options.ScoreWeights == (ScoreWeights{}) {
+		options.ScoreWeights = DefaultScoreWeights
+	}
+
+	if len(options.RoleAccounts) == 0 {
+		options.RoleAccounts = DefaultRoleAccounts
+	}
+
+	if len(options.Checks) == 0 {
+		options.Checks = DefaultChecks
+	}
+
+	roleAccounts := map[string]bool{}
+	for _, role := range options.RoleAccounts {
+		roleAccounts[strings.ToLower(role)] = true
+	}
+
+	dialer := &net.Dialer{
+		Timeout: options.DialTimeout,
+	}
+
+	identities := options.Identities
+	if len(identities) == 0 {
+		identities = []Identity{{HELO: options.FromDomain, MailFrom: options.FromEmail}}
+	}
+
+	// mail servers are resolved like their MX records, the resolver itself is dialed by address
+	cache := newDNSCache(options.DNSCacheSize)
+	resolver := newResolver(dialer, options.DNSServer, cache)
+	dialer.Resolver = resolver
+
+	limits := Limits{
+		ProbesPerMinute:   options.ProbesPerMinute,
+		RaceMailServers:   options.RaceMailServers,
+		MaxRcptPerSession: options.MaxRcptPerSession,
+	}
+
+	return &Checker{
+		options:        options,
+		dialer:         dialer,
+		resolver:       resolver,
+		dnsCache:       cache,
+		roleAccounts:   roleAccounts,
+		limiter:        newRateLimiter(options.ProbesPerMinute),
+		limits:         limits,
+		throttle:       newHostThrottle(),
+		health:         newMXHealth(),
+		identities:     newIdentityPool(identities),
+		sessions:       map[string][]*smtpClient{},
+		catchAll:       map[string]bool{},
+		zones:          map[string]zoneTrust{},
+		domainListings: map[string][]BlocklistResult{},
+		http:           &http.Client{},
+		domainCreated:  map[string]time.Time{},
+		webPresence:    map[string]bool{},
+		prefetched:     map[string]prefetchedMX{},
+		history:        map[string]bool{},
+	}
+}
+
+// Check takes a single address through Options.Checks and scores the outcome.
+// Cancelling ctx aborts the check.
+func (c *Checker) Check(ctx context.Context, email string) Result {
+	res := c.run(ctx, email)
+	res.Score, res.Reasons = c.options.ScoreWeights.score(res)
+
+	// an interrupted check has no verdict to act on
+	if c.options.OnResult != nil && ctx.Err() == nil {
+		c.options.OnResult(res)
+	}
+
+	return res
+}
+
+// ParseAddress checks the syntax of email. It returns the address to probe, which has its internationalized
+// domain in punycode form, and that domain.
+func ParseAddress(email string) (recipient, domain string, err error) {
+	domain, err = extractDomain(email)
+	if err != nil {
+		return "", "", err
+	}
+
+	// internationalized domains are looked up and probed in their punycode form
+	domain, err = idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", "", syntaxError{errors.Wrap(err, "invalid domain")}
+	}
+
+	if err := validateDomain(domain); err != nil {
+		return "", "", err
+	}
+
+	return email[:strings.LastIndex(email, "@")+1] + domain, domain, nil
+}
+
+// LookupMailServers returns the mail servers of an ASCII domain, its MX override or otherwise its MX records,
+// retrying according to the retry policy. Attempts is zero for overridden domains.
+// With Options.DNSSEC a bogus answer is an error.
+func (c *Checker) LookupMailServers(ctx context.Context, domain string) (servers []MailServer, attempts int, err error) {
+	servers, attempts, _, err = c.lookupMailServers(ctx, domain)
+	return servers, attempts, err
+}
+
+// lookupMailServers is LookupMailServers, also returning the outcome of validating the MX records.
+func (c *Checker) lookupMailServers(ctx context.Context, domain string) (servers []MailServer, attempts int, status DNSSECStatus, err error) {
+	// pinned mail servers bypass DNS altogether
+	if servers, ok := c.options.MXOverrides[strings.ToLower(domain)]; ok {
+		return servers, 0, "", nil
+	}
+
+	if entry, ok := c.prefetchedMailServers(domain); ok {
+		return entry.servers, 0, entry.status, entry.err
+	}
+
+	attempts, err = c.options.Retry.do(ctx, func() error {
+		servers = nil
+		hosts, mxStatus, err := c.lookupMX(ctx, domain)
+		for _, host := range hosts {
+			servers = append(servers, MailServer{Host: host})
+		}
+		status = mxStatus
+		return err
+	})
+	if err != nil {
+		return nil, attempts, status, errors.Wrap(err, "could not retrieve mail server")
+	}
+
+	return servers, attempts, status, nil
+}
+
+// VerifyMailbox asks one of servers whether it accepts recipient, as returned by ParseAddress,
+// and records the verdict and the reply it is based on in res.
+func (c *Checker) VerifyMailbox(ctx context.Context, res *Result, recipient string, servers []MailServer) {
+	err := c.checkMailbox(ctx, res, recipient, servers, c.probeMailbox)
+
+	var reply *replyError
+	switch {
+	case errors.Is(err, errSMTPUTF8Unsupported):
+		res.fail(VerdictUnknown, ReasonSMTPUTF8Unsupported, err)
+	case err == nil:
+		res.Verdict, res.Reason = classifyProviderRecipient(res.Provider, res.Code, res.Response)
+	case errors.As(err, &reply):
+		res.setReply(reply.code, reply.text)
+
+		// a rejected sender says nothing about the recipient
+		verdict, reason := VerdictUnknown, ReasonSenderIssue
+		if reply.command != cmdMailFrom {
+			verdict, reason = classifyProviderRecipient(res.Provider, reply.code, reply.text)
+		}
+		if reason == ReasonSenderIssue {
+			res.ListedOn = listedOn(reply.text)
+		}
+		res.fail(verdict, reason, typedError(res, reason, err))
+	default:
+		res.fail(VerdictUnknown, ReasonUnreachable, err)
+	}
+
+	// some providers accept any recipient and bounce later
+	if res.Reason == ReasonCatchAll {
+		res.CatchAll = true
+	}
+}
+
+// suggest sets a corrected address when domain looks like a typo.
+func (r *Result) suggest(email, domain string) {
+	if suggestion, ok := SuggestDomain(domain); ok {
+		r.Suggestion = email[:len(email)-len(domain)] + suggestion
+	}
+}
+
+func extractDomain(email string) (domain string, err error) {
+	parts := strings.Split(email, "@")
+	switch {
+	case len(parts) != 2:
+		return "", ErrInvalidSyntax
+	case parts[0] == "":
+		return "", syntaxError{errors.New("empty local part")}
+	case parts[1] == "":
+		return "", syntaxError{errors.New("empty domain")}
+	}
+
+	return parts[1], nil
+}
+
+// maxLabelLength is the maximum length of a label of a domain, RFC 1035 section 2.3.4
+const maxLabelLength = 63
+
+// validateDomain checks the labels of an ASCII domain: none may be empty, which also rules out leading,
+// trailing and consecutive dots, nor longer than maxLabelLength.
+func validateDomain(domain string) error {
+	for _, label := range strings.Split(domain, ".") {
+		switch {
+		case label == "":
+			return syntaxError{errors.Errorf("empty label in domain '%s'", domain)}
+		case len(label) > maxLabelLength:
+			return syntaxError{errors.Errorf("label longer than %d characters in domain '%s'", maxLabelLength, domain)}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package mailcheck_test
+
+import (
+	"context"
+	"github.com/hazcod/mailcheck"
+	"github.com/hazcod/mailcheck/mailchecktest"
+	"github.com/miekg/dns"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newNetwork starts a Network that example.test receives mail on.
+func newNetwork(t *testing.T) *mailchecktest.Network {
+	network, err := mailchecktest.NewNetwork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = network.Close() })
+
+	network.AddDomain("example.test")
+	return network
+}
+
+// check checks email with a Checker using options.
+func check(t *testing.T, options mailcheck.Options, email string) mailcheck.Result {
+	t.Helper()
+
+	checker := mailcheck.New(options)
+	defer checker.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	return checker.Check(ctx, email)
+}
+
+// expectVerdict fails unless res has verdict and reason.
+func expectVerdict(t *testing.T, res mailcheck.Result, verdict mailcheck.Verdict, reason mailcheck.Reason) {
+	t.Helper()
+
+	if res.Verdict != verdict || res.Reason != reason {
+		t.Errorf("expected %s to be %s:%s, got %s:%s (%s)", res.Email, verdict, reason, res.Verdict, res.Reason, res.Error)
+	}
+}
+
+func TestCheckValid(t *testing.T) {
+	network := newNetwork(t)
+	network.SMTP.SetReply("jane@example.test", mailchecktest.Accept)
+
+	res := check(t, network.Options(), "jane@example.test")
+	expectVerdict(t, res, mailcheck.VerdictValid, "")
+
+	if res.Code != 250 || res.MX != "mx.example.test." || res.Port != network.SMTP.Port() {
+		t.Errorf("expected the reply of mx.example.test, got %d from %s:%d", res.Code, res.MX, res.Port)
+	}
+}
+
+func TestCheckInvalid(t *testing.T) {
+	network := newNetwork(t)
+
+	res := check(t, network.Options(), "nobody@example.test")
+	expectVerdict(t, res, mailcheck.VerdictInvalid, mailcheck.ReasonUserUnknown)
+
+	if res.Code != 550 || res.EnhancedCode != "5.1.1" {
+		t.Errorf("expected the 550 5.1.1 reply, got %d %s", res.Code, res.EnhancedCode)
+	}
+}
+
+func TestCheckDNSFailure(t *testing.T) {
+	network := newNetwork(t)
+	network.SMTP.SetReply("jane@example.test", mailchecktest.Accept)
+	network.DNS.Fail("example.test", dns.RcodeServerFailure)
+
+	res := check(t, network.Options(), "jane@example.test")
+	expectVerdict(t, res, mailcheck.VerdictUnknown, mailcheck.ReasonDNSError)
+	if len(network.SMTP.Commands()) != 0 {
+		t.Errorf("expected no probe without mail servers, got %v", network.SMTP.Commands())
+	}
+
+	network.DNS.Recover("example.test")
+
+	res = check(t, network.Options(), "jane@example.test")
+	expectVerdict(t, res, mailcheck.VerdictValid, "")
+}
+
+func TestCheckGreylisting(t *testing.T) {
+	network := newNetwork(t)
+	network.SMTP.SetReply("jane@example.test", mailchecktest.Accept)
+	network.SMTP.Greylist("jane@example.test", 1)
+
+	// without retries greylisting leaves the address unknown
+	res := check(t, network.Options(), "jane@example.test")
+	expectVerdict(t, res, mailcheck.VerdictUnknown, mailcheck.ReasonTemporary)
+	if res.Code != 451 {
+		t.Errorf("expected the 451 reply, got %d", res.Code)
+	}
+
+	network.SMTP.Greylist("jane@example.test", 1)
+
+	options := network.Options()
+	options.Retry = mailcheck.RetryPolicy{Retries: 1, Backoff: time.Millisecond * 10}
+
+	res = check(t, options, "jane@example.test")
+	expectVerdict(t, res, mailcheck.VerdictValid, "")
+	if res.Attempts[mailcheck.StageSMTP] != 2 {
+		t.Errorf("expected the smtp stage to be retried once, got %v attempts", res.Attempts)
+	}
+}
+
+func TestCheckTarpitting(t *testing.T) {
+	network := newNetwork(t)
+	network.SMTP.SetReply("jane@example.test", mailchecktest.Accept)
+	network.SMTP.Tarpit(time.Millisecond * 300)
+
+	options := network.Options()
+	options.StageBudget = mailcheck.StageBudget{SMTP: time.Millisecond * 500}
+
+	started := time.Now()
+	res := check(t, options, "jane@example.test")
+
+	if res.Verdict != mailcheck.VerdictUnknown {
+		t.Errorf("expected a tarpitting server to leave the address unknown, got %s:%s", res.Verdict, res.Reason)
+	}
+	if took := time.Since(started); took > time.Second*3 {
+		t.Errorf("expected the smtp budget to cut the check short, took %s", took)
+	}
+
+	// a server that is merely slow gets there within the budget
+	network.SMTP.Tarpit(time.Millisecond * 20)
+
+	res = check(t, options, "jane@example.test")
+	expectVerdict(t, res, mailcheck.VerdictValid, "")
+}
+
+func TestCheckCatchAll(t *testing.T) {
+	network := newNetwork(t)
+	network.SMTP.SetDefaultReply(mailchecktest.Accept)
+
+	options := network.Options()
+	options.Level = mailcheck.LevelDeep
+
+	res := check(t, options, "jane@example.test")
+	expectVerdict(t, res, mailcheck.VerdictUnknown, mailcheck.ReasonCatchAll)
+	if !res.CatchAll {
+		t.Error("expected the domain to be catch-all")
+	}
+
+	// below LevelDeep there is no probe of an address that cannot exist
+	res = check(t, network.Options(), "jane@example.test")
+	expectVerdict(t, res, mailcheck.VerdictValid, "")
+	if res.CatchAll {
+		t.Error("expected no catch-all probe at LevelSMTP")
+	}
+}
+
+func TestCheckPipelining(t *testing.T) {
+	network := newNetwork(t)
+	network.SMTP.SetReply("jane@example.test", mailchecktest.Accept)
+	network.SMTP.SetMailFromReply(mailchecktest.Reply{Code: 550, Message: "5.7.1 Sender rejected"})
+
+	res := check(t, network.Options(), "jane@example.test")
+
+	// the server advertises PIPELINING, so RCPT TO went out along with MAIL FROM before its reply was read
+	var mailFrom, rcptTo bool
+	for _, command := range network.SMTP.Commands() {
+		mailFrom = mailFrom || strings.HasPrefix(command, "MAIL FROM:")
+		rcptTo = rcptTo || strings.HasPrefix(command, "RCPT TO:<jane@example.test>")
+	}
+	if !mailFrom || !rcptTo {
+		t.Errorf("expected MAIL FROM and RCPT TO to be pipelined, got %v", network.SMTP.Commands())
+	}
+
+	// a refused sender says nothing about the recipient
+	expectVerdict(t, res, mailcheck.VerdictUnknown, mailcheck.ReasonSenderIssue)
+}
+
+func TestCheckSyntax(t *testing.T) {
+	options := mailcheck.Options{Level: mailcheck.LevelSyntax, Hosts: mailcheck.Hosts{"intranet": {"10.0.0.1"}}}
+
+	for _, email := range []string{
+		"@gmail.com",
+		"foo@",
+		"x@.",
+		"foo@bar..com",
+		"foo@.bar.com",
+		"foo@bar.com.",
+		"a@b",
+		"foo@" + strings.Repeat("a", 64) + ".com",
+		"foo@bar@baz.com",
+	} {
+		res := check(t, options, email)
+		expectVerdict(t, res, mailcheck.VerdictInvalid, mailcheck.ReasonSyntax)
+	}
+
+	for _, email := range []string{
+		"jane@example.com",
+		"jane@" + strings.Repeat("a", 63) + ".com",
+		"jane@bücher.example",
+		// statically mapped names are known to deliver mail
+		"jane@intranet",
+	} {
+		res := check(t, options, email)
+		expectVerdict(t, res, mailcheck.VerdictValid, "")
+	}
+}
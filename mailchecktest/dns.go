@@ -0,0 +1,156 @@
+// Package mailchecktest provides an in-process DNS server and a scriptable SMTP server, so that code using
+// mailcheck can be tested deterministically without network access.
+package mailchecktest
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// DNSServer answers queries from the records added to it, to be used as mailcheck.Options.DNSServer.
+// Names without any record do not exist. It is safe for concurrent use.
+type DNSServer struct {
+	server *dns.Server
+	addr   string
+
+	mu sync.Mutex
+	// records holds the records by lowercased fully qualified name
+	records map[string][]dns.RR
+	// failures holds the response codes names are answered with instead of their records
+	failures map[string]int
+}
+
+// NewDNSServer starts a DNS server on a random port of 127.0.0.1, serving over udp.
+func NewDNSServer() (*DNSServer, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not listen")
+	}
+
+	s := &DNSServer{
+		addr:     conn.LocalAddr().String(),
+		records:  map[string][]dns.RR{},
+		failures: map[string]int{},
+	}
+
+	// shutting down a server that has not started yet fails, so wait for it
+	started := make(chan struct{})
+	s.server = &dns.Server{PacketConn: conn, Handler: s, NotifyStartedFunc: func() { close(started) }}
+	go func() { _ = s.server.ActivateAndServe() }()
+	<-started
+
+	return s, nil
+}
+
+// Addr returns the host:port the server listens on.
+func (s *DNSServer) Addr() string {
+	return s.addr
+}
+
+// Add adds a record in zone file format, such as "example.com. 300 IN MX 10 mx.example.com.".
+func (s *DNSServer) Add(record string) error {
+	rr, err := dns.NewRR(record)
+	if err != nil {
+		return errors.Wrap(err, "invalid record")
+	}
+	if rr == nil {
+		return errors.New("empty record")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := strings.ToLower(rr.Header().Name)
+	s.records[name] = append(s.records[name], rr)
+
+	return nil
+}
+
+// AddMX points domain to hosts, preferred in the order given.
+func (s *DNSServer) AddMX(domain string, hosts ...string) {
+	for i, host := range hosts {
+		s.mustAdd(fmt.Sprintf("%s 60 IN MX %d %s", dns.Fqdn(domain), (i+1)*10, dns.Fqdn(host)))
+	}
+}
+
+// AddA points name to the IPv4 addresses.
+func (s *DNSServer) AddA(name string, addresses ...string) {
+	for _, address := range addresses {
+		s.mustAdd(fmt.Sprintf("%s 60 IN A %s", dns.Fqdn(name), address))
+	}
+}
+
+// AddTXT adds a TXT record with text to name, such as an SPF or DMARC policy.
+func (s *DNSServer) AddTXT(name, text string) {
+	s.mustAdd(fmt.Sprintf("%s 60 IN TXT %q", dns.Fqdn(name), text))
+}
+
+// Fail makes every query for name fail with rcode, such as dns.RcodeServerFailure, until Recover is called.
+func (s *DNSServer) Fail(name string, rcode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[strings.ToLower(dns.Fqdn(name))] = rcode
+}
+
+// Recover answers queries for name from its records again.
+func (s *DNSServer) Recover(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.failures, strings.ToLower(dns.Fqdn(name)))
+}
+
+// Close stops the server.
+func (s *DNSServer) Close() error {
+	return s.server.Shutdown()
+}
+
+// ServeDNS implements dns.Handler.
+func (s *DNSServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	msg := new(dns.Msg)
+	msg.SetReply(req)
+	msg.Authoritative = true
+
+	if len(req.Question) == 1 {
+		s.answer(msg, req.Question[0])
+	}
+
+	_ = w.WriteMsg(msg)
+}
+
+// answer fills in msg for question: the records of the name and type asked for, NXDOMAIN for unknown names.
+func (s *DNSServer) answer(msg *dns.Msg, question dns.Question) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := strings.ToLower(question.Name)
+
+	if rcode, ok := s.failures[name]; ok {
+		msg.Rcode = rcode
+		return
+	}
+
+	records, ok := s.records[name]
+	if !ok {
+		msg.Rcode = dns.RcodeNameError
+		return
+	}
+
+	for _, rr := range records {
+		if question.Qtype == dns.TypeANY || rr.Header().Rrtype == question.Qtype {
+			msg.Answer = append(msg.Answer, dns.Copy(rr))
+		}
+	}
+}
+
+// mustAdd adds a record built by the helpers, which cannot be invalid short of invalid arguments.
+func (s *DNSServer) mustAdd(record string) {
+	if err := s.Add(record); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,68 @@
+package mailchecktest
+
+import (
+	"github.com/miekg/dns"
+	"testing"
+)
+
+// query asks server for the records of type qtype at name.
+func query(t *testing.T, server *DNSServer, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), qtype)
+
+	res, err := dns.Exchange(req, server.Addr())
+	if err != nil {
+		t.Fatalf("query %s: %v", name, err)
+	}
+
+	return res
+}
+
+func TestDNSServer(t *testing.T) {
+	server, err := NewDNSServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	server.AddMX("Example.test", "mx1.example.test", "mx2.example.test")
+	server.AddA("mx1.example.test", "127.0.0.1")
+	server.AddTXT("example.test", "v=spf1 -all")
+
+	res := query(t, server, "example.TEST", dns.TypeMX)
+	if res.Rcode != dns.RcodeSuccess || len(res.Answer) != 2 {
+		t.Fatalf("expected both mx records, got %v", res)
+	}
+	if first := res.Answer[0].(*dns.MX); first.Mx != "mx1.example.test." || first.Preference >= res.Answer[1].(*dns.MX).Preference {
+		t.Errorf("expected mx1 to be preferred, got %v", res.Answer)
+	}
+
+	// the other records of the name are left out
+	if res := query(t, server, "example.test", dns.TypeTXT); len(res.Answer) != 1 || res.Answer[0].(*dns.TXT).Txt[0] != "v=spf1 -all" {
+		t.Errorf("expected the spf record, got %v", res.Answer)
+	}
+
+	if res := query(t, server, "mx1.example.test", dns.TypeA); len(res.Answer) != 1 || res.Answer[0].(*dns.A).A.String() != "127.0.0.1" {
+		t.Errorf("expected the address of mx1, got %v", res.Answer)
+	}
+
+	if res := query(t, server, "missing.test", dns.TypeMX); res.Rcode != dns.RcodeNameError {
+		t.Errorf("expected an unknown name not to exist, got %s", dns.RcodeToString[res.Rcode])
+	}
+
+	server.Fail("example.test", dns.RcodeServerFailure)
+	if res := query(t, server, "example.test", dns.TypeMX); res.Rcode != dns.RcodeServerFailure || len(res.Answer) != 0 {
+		t.Errorf("expected a failing name to be answered with SERVFAIL, got %v", res)
+	}
+
+	server.Recover("example.test")
+	if res := query(t, server, "example.test", dns.TypeMX); res.Rcode != dns.RcodeSuccess || len(res.Answer) != 2 {
+		t.Errorf("expected the records after recovering, got %v", res)
+	}
+
+	if err := server.Add("example.test. 60 IN BOGUS"); err == nil {
+		t.Error("expected an invalid record to be refused")
+	}
+}
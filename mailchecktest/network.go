@@ -0,0 +1,58 @@
+package mailchecktest
+
+import (
+	"github.com/hazcod/mailcheck"
+	"time"
+)
+
+// Network is a DNS server and an SMTP server that every domain added to it points to.
+type Network struct {
+	DNS  *DNSServer
+	SMTP *SMTPServer
+}
+
+// NewNetwork starts the servers of a Network.
+func NewNetwork() (*Network, error) {
+	dns, err := NewDNSServer()
+	if err != nil {
+		return nil, err
+	}
+
+	smtp, err := NewSMTPServer()
+	if err != nil {
+		_ = dns.Close()
+		return nil, err
+	}
+
+	return &Network{DNS: dns, SMTP: smtp}, nil
+}
+
+// AddDomain makes domain receive mail on the SMTP server, through the mail server mx.<domain>.
+func (n *Network) AddDomain(domain string) {
+	mx := "mx." + domain
+
+	n.DNS.AddMX(domain, mx)
+	n.DNS.AddA(mx, "127.0.0.1")
+}
+
+// Options returns Options that look up domains on the DNS server and probe the SMTP server, with a short
+// dial timeout so that failures show quickly. Set Options.Retry to get past greylisting. DNS answers are not cached,
+// so records added or failed halfway through a test take effect right away.
+func (n *Network) Options() mailcheck.Options {
+	return mailcheck.Options{
+		DNSServer:    n.DNS.Addr(),
+		DNSCacheSize: -1,
+		Ports:        []int{n.SMTP.Port()},
+		DialTimeout:  time.Second,
+	}
+}
+
+// Close stops both servers.
+func (n *Network) Close() error {
+	err := n.SMTP.Close()
+	if dnsErr := n.DNS.Close(); err == nil {
+		err = dnsErr
+	}
+
+	return err
+}
@@ -0,0 +1,36 @@
+package mailchecktest
+
+import (
+	"github.com/miekg/dns"
+	"net/textproto"
+	"reflect"
+	"testing"
+)
+
+func TestNetwork(t *testing.T) {
+	network, err := NewNetwork()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network.AddDomain("example.test")
+
+	if res := query(t, network.DNS, "example.test", dns.TypeMX); len(res.Answer) != 1 {
+		t.Errorf("expected the mx record of the domain, got %v", res.Answer)
+	}
+	if res := query(t, network.DNS, "mx.example.test", dns.TypeA); len(res.Answer) != 1 {
+		t.Errorf("expected the address of the mail server, got %v", res.Answer)
+	}
+
+	options := network.Options()
+	if options.DNSServer != network.DNS.Addr() || !reflect.DeepEqual(options.Ports, []int{network.SMTP.Port()}) {
+		t.Errorf("expected the options to point at the servers, got %+v", options)
+	}
+
+	if err := network.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+	if _, err := textproto.Dial("tcp", network.SMTP.Addr()); err == nil {
+		t.Error("expected the smtp server to be closed")
+	}
+}
@@ -0,0 +1,269 @@
+package mailchecktest
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Accept is the reply to a recipient that exists.
+	Accept = Reply{Code: 250, Message: "2.1.5 OK"}
+	// Reject is the reply to a recipient that does not exist, and to any recipient without a scripted reply.
+	Reject = Reply{Code: 550, Message: "5.1.1 No such user"}
+	// Greylisted is the reply while a recipient is greylisted.
+	Greylisted = Reply{Code: 451, Message: "4.7.1 Greylisted, try again later"}
+)
+
+// Reply is an SMTP reply. Multi-line messages are sent as a multi-line reply.
+type Reply struct {
+	Code    int
+	Message string
+}
+
+// SMTPServer is an SMTP server whose replies to RCPT TO are scripted per recipient. It never accepts mail,
+// DATA is refused. It is safe for concurrent use.
+type SMTPServer struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu sync.Mutex
+	// replies holds the scripted reply by lowercased recipient, fallback the reply to any other recipient
+	replies  map[string]Reply
+	fallback Reply
+	// mailFrom is the reply to MAIL FROM
+	mailFrom Reply
+	// greylist holds by lowercased recipient how many more times it is greylisted
+	greylist map[string]int
+	// tarpit delays every reply
+	tarpit time.Duration
+	// commands holds every command received, in order
+	commands []string
+	conns    map[net.Conn]bool
+}
+
+// NewSMTPServer starts an SMTP server on a random port of 127.0.0.1 that rejects every recipient.
+func NewSMTPServer() (*SMTPServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not listen")
+	}
+
+	s := &SMTPServer{
+		listener: listener,
+		replies:  map[string]Reply{},
+		fallback: Reject,
+		mailFrom: Reply{Code: 250, Message: "2.1.0 OK"},
+		greylist: map[string]int{},
+		conns:    map[net.Conn]bool{},
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr returns the host:port the server listens on.
+func (s *SMTPServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Port returns the port the server listens on, to be used as mailcheck.Options.Ports.
+func (s *SMTPServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// SetReply makes the server answer RCPT TO for recipient with reply.
+func (s *SMTPServer) SetReply(recipient string, reply Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replies[strings.ToLower(recipient)] = reply
+}
+
+// SetDefaultReply makes the server answer RCPT TO with reply for recipients without a scripted reply,
+// Accept for a catch-all domain for instance.
+func (s *SMTPServer) SetDefaultReply(reply Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fallback = reply
+}
+
+// SetMailFromReply makes the server answer MAIL FROM with reply, a 5xx one to refuse the sender.
+func (s *SMTPServer) SetMailFromReply(reply Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mailFrom = reply
+}
+
+// Greylist answers the next times RCPT TO for recipient with Greylisted, after which its reply is scripted as usual.
+func (s *SMTPServer) Greylist(recipient string, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.greylist[strings.ToLower(recipient)] = times
+}
+
+// Tarpit delays every reply, the greeting included, by delay. Zero stops tarpitting.
+func (s *SMTPServer) Tarpit(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tarpit = delay
+}
+
+// Commands returns the commands received so far, in order, across all connections.
+func (s *SMTPServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.commands...)
+}
+
+// Close stops the server, closing the connections still open.
+func (s *SMTPServer) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *SMTPServer) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// handle runs a single SMTP session on conn.
+func (s *SMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	if !s.reply(conn, Reply{Code: 220, Message: "mailchecktest ESMTP"}) {
+		return
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command := strings.TrimRight(line, "\r\n")
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		s.mu.Unlock()
+
+		verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0])
+		reply := s.replyTo(verb, command)
+
+		if !s.reply(conn, reply) || verb == "QUIT" {
+			return
+		}
+	}
+}
+
+// replyTo returns the reply to command, of which verb is the uppercased first word.
+func (s *SMTPServer) replyTo(verb, command string) Reply {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch verb {
+	case "EHLO":
+		return Reply{Code: 250, Message: "mailchecktest\nPIPELINING\nSMTPUTF8\n8BITMIME"}
+	case "HELO":
+		return Reply{Code: 250, Message: "mailchecktest"}
+	case "MAIL":
+		return s.mailFrom
+	case "RCPT":
+		recipient := strings.ToLower(recipientOf(command))
+		if s.greylist[recipient] > 0 {
+			s.greylist[recipient]--
+			return Greylisted
+		}
+		if reply, ok := s.replies[recipient]; ok {
+			return reply
+		}
+		return s.fallback
+	case "RSET", "NOOP":
+		return Reply{Code: 250, Message: "2.0.0 OK"}
+	case "VRFY", "EXPN":
+		return Reply{Code: 252, Message: "2.1.5 Cannot verify"}
+	case "DATA":
+		return Reply{Code: 554, Message: "5.7.1 Not accepting mail"}
+	case "QUIT":
+		return Reply{Code: 221, Message: "2.0.0 Bye"}
+	default:
+		return Reply{Code: 502, Message: "5.5.2 Command not implemented"}
+	}
+}
+
+// reply sends reply over conn after the tarpit delay, reporting whether that succeeded.
+func (s *SMTPServer) reply(conn net.Conn, reply Reply) bool {
+	s.mu.Lock()
+	delay := s.tarpit
+	s.mu.Unlock()
+
+	time.Sleep(delay)
+
+	lines := strings.Split(reply.Message, "\n")
+	var b strings.Builder
+	for i, line := range lines {
+		separator := " "
+		if i < len(lines)-1 {
+			separator = "-"
+		}
+		fmt.Fprintf(&b, "%s%s%s\r\n", strconv.Itoa(reply.Code), separator, line)
+	}
+
+	_, err := conn.Write([]byte(b.String()))
+	return err == nil
+}
+
+// recipientOf returns the address in a RCPT TO command, without angle brackets and parameters.
+func recipientOf(command string) string {
+	address := command
+	if i := strings.Index(address, ":"); i >= 0 {
+		address = address[i+1:]
+	}
+
+	address = strings.TrimSpace(address)
+	if i := strings.Index(address, ">"); i >= 0 {
+		address = address[:i]
+	}
+
+	return strings.TrimPrefix(address, "<")
+}
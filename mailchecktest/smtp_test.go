@@ -0,0 +1,123 @@
+package mailchecktest
+
+import (
+	"net/textproto"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// session dials server and reads its greeting.
+func session(t *testing.T, server *SMTPServer) *textproto.Conn {
+	t.Helper()
+
+	conn, err := textproto.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		t.Fatalf("greeting: %v", err)
+	}
+
+	return conn
+}
+
+// command sends line over conn and returns the code of the reply.
+func command(t *testing.T, conn *textproto.Conn, line string) (int, string) {
+	t.Helper()
+
+	if err := conn.PrintfLine("%s", line); err != nil {
+		t.Fatalf("%s: %v", line, err)
+	}
+
+	code, msg, err := conn.ReadResponse(0)
+	if err != nil && code == 0 {
+		t.Fatalf("%s: %v", line, err)
+	}
+
+	return code, msg
+}
+
+func TestSMTPServer(t *testing.T) {
+	server, err := NewSMTPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	server.SetReply("Jane@example.test", Accept)
+	server.SetReply("full@example.test", Reply{Code: 552, Message: "5.2.2 Mailbox full"})
+	server.Greylist("grey@example.test", 2)
+
+	conn := session(t, server)
+
+	if code, msg := command(t, conn, "EHLO mailcheck.test"); code != 250 || msg != "mailchecktest\nPIPELINING\nSMTPUTF8\n8BITMIME" {
+		t.Errorf("expected the extensions, got %d %q", code, msg)
+	}
+
+	for _, step := range []struct {
+		command string
+		code    int
+	}{
+		{"MAIL FROM:<probe@mailcheck.test>", 250},
+		{"RCPT TO:<jane@EXAMPLE.test>", 250},
+		{"RCPT TO:<full@example.test> NOTIFY=NEVER", 552},
+		{"RCPT TO:<nobody@example.test>", 550},
+		// greylisted twice, then scripted as usual: rejected
+		{"RCPT TO:<grey@example.test>", 451},
+		{"RCPT TO:<grey@example.test>", 451},
+		{"RCPT TO:<grey@example.test>", 550},
+		{"DATA", 554},
+		{"RSET", 250},
+		{"STARTTLS", 502},
+		{"QUIT", 221},
+	} {
+		if code, msg := command(t, conn, step.command); code != step.code {
+			t.Errorf("expected %s to be answered with %d, got %d %s", step.command, step.code, code, msg)
+		}
+	}
+
+	if got := server.Commands(); len(got) != 12 || got[0] != "EHLO mailcheck.test" || got[11] != "QUIT" {
+		t.Errorf("expected every command in order, got %v", got)
+	}
+}
+
+func TestSMTPServerScripting(t *testing.T) {
+	server, err := NewSMTPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	server.SetDefaultReply(Accept)
+	server.SetMailFromReply(Reply{Code: 550, Message: "5.7.1 Sender rejected"})
+
+	conn := session(t, server)
+	if code, _ := command(t, conn, "MAIL FROM:<probe@mailcheck.test>"); code != 550 {
+		t.Errorf("expected the sender to be refused, got %d", code)
+	}
+	if code, _ := command(t, conn, "RCPT TO:<anyone@example.test>"); code != 250 {
+		t.Errorf("expected a catch-all reply, got %d", code)
+	}
+
+	// every reply is delayed, the greeting included
+	server.Tarpit(time.Millisecond * 100)
+	started := time.Now()
+	session(t, server)
+	if took := time.Since(started); took < time.Millisecond*100 {
+		t.Errorf("expected the greeting to be delayed, took %s", took)
+	}
+
+	server.Tarpit(0)
+	conn = session(t, server)
+	if code, _ := command(t, conn, "NOOP"); code != 250 {
+		t.Errorf("expected NOOP to be accepted, got %d", code)
+	}
+
+	want := []string{"MAIL FROM:<probe@mailcheck.test>", "RCPT TO:<anyone@example.test>", "NOOP"}
+	if got := server.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
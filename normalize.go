@@ -0,0 +1,95 @@
+package mailcheck
+
+import (
+	"strings"
+)
+
+// aliasRules describes how providers fold the local parts of their addresses, by lowercased domain.
+var aliasRules = map[string]aliasRule{
+	"gmail.com":      {canonical: "gmail.com", ignoreDots: true, tag: '+'},
+	"googlemail.com": {canonical: "gmail.com", ignoreDots: true, tag: '+'},
+	"outlook.com":    {tag: '+'},
+	"hotmail.com":    {tag: '+'},
+	"live.com":       {tag: '+'},
+	"icloud.com":     {tag: '+'},
+	"me.com":         {tag: '+'},
+	"fastmail.com":   {tag: '+'},
+	"protonmail.com": {tag: '+'},
+	"proton.me":      {tag: '+'},
+}
+
+// aliasRule is how a provider delivers different spellings of an address to the same mailbox.
+type aliasRule struct {
+	// canonical is the domain the provider's other domains are aliases of, empty to keep the domain
+	canonical string
+	// ignoreDots means dots in the local part are insignificant
+	ignoreDots bool
+	// tag separates the local part from a subaddress that is delivered to the same mailbox
+	tag byte
+}
+
+// Normalize returns email without surrounding whitespace and with a lowercase domain. The local part is left
+// alone, it is case-sensitive as far as the standards are concerned.
+func Normalize(email string) string {
+	email = strings.TrimSpace(email)
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+
+	return email[:at+1] + strings.ToLower(email[at+1:])
+}
+
+// NormalizeAlias returns the normalized address of the mailbox email is delivered to, applying the rules of
+// providers known to ignore dots or subaddresses in the local part, e.g. j.doe+news@googlemail.com is
+// jdoe@gmail.com. The local part of these providers is case-insensitive, so it is lowercased too.
+func NormalizeAlias(email string) string {
+	email = Normalize(email)
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+
+	local, domain := email[:at], email[at+1:]
+	rule, ok := aliasRules[domain]
+	if !ok {
+		return email
+	}
+
+	local = strings.ToLower(local)
+	if i := strings.IndexByte(local, rule.tag); i > 0 {
+		local = local[:i]
+	}
+
+	if rule.ignoreDots {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+
+	if rule.canonical != "" {
+		domain = rule.canonical
+	}
+
+	return local + "@" + domain
+}
+
+// Deduplicate normalizes emails with normalize, such as Normalize or NormalizeAlias, and drops the addresses
+// that normalize to one seen before. It returns the normalized addresses in order and the number dropped.
+func Deduplicate(emails []string, normalize func(string) string) (unique []string, duplicates int) {
+	seen := map[string]bool{}
+	unique = make([]string, 0, len(emails))
+
+	for _, email := range emails {
+		email = normalize(email)
+		if seen[email] {
+			duplicates++
+			continue
+		}
+
+		seen[email] = true
+		unique = append(unique, email)
+	}
+
+	return unique, duplicates
+}
@@ -0,0 +1,59 @@
+package mailcheck_test
+
+import (
+	"github.com/hazcod/mailcheck"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	for email, expected := range map[string]string{
+		"  John.Doe@Example.COM\t": "John.Doe@example.com",
+		"j.doe+news@GMail.com":     "j.doe+news@gmail.com",
+		"@Example.com":             "@example.com",
+		"no-domain":                "no-domain",
+	} {
+		if got := mailcheck.Normalize(email); got != expected {
+			t.Errorf("%q: expected %q, got %q", email, expected, got)
+		}
+	}
+}
+
+func TestNormalizeAlias(t *testing.T) {
+	for email, expected := range map[string]string{
+		// gmail ignores dots and subaddresses, and googlemail.com is the same mailbox
+		"J.Doe+news@googlemail.com": "jdoe@gmail.com",
+		"j.d.o.e@gmail.com":         "jdoe@gmail.com",
+		// other providers only drop the subaddress, dots matter
+		"J.Doe+news@Outlook.com": "j.doe@outlook.com",
+		"j.doe+a+b@proton.me":    "j.doe@proton.me",
+		// a local part that is all tag is kept as it is
+		"+news@gmail.com": "+news@gmail.com",
+		// unknown providers keep their local part, case included
+		"J.Doe+news@example.com": "J.Doe+news@example.com",
+	} {
+		if got := mailcheck.NormalizeAlias(email); got != expected {
+			t.Errorf("%q: expected %q, got %q", email, expected, got)
+		}
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	emails := []string{
+		"jdoe@gmail.com",
+		" jdoe@GMAIL.com ",
+		"j.doe+news@googlemail.com",
+		"JDoe@example.com",
+		"jdoe@example.com",
+	}
+
+	unique, duplicates := mailcheck.Deduplicate(emails, mailcheck.Normalize)
+	if strings.Join(unique, ",") != "jdoe@gmail.com,j.doe+news@googlemail.com,JDoe@example.com,jdoe@example.com" || duplicates != 1 {
+		t.Errorf("expected only the differently spaced and cased domain to be folded, got %v and %d duplicates", unique, duplicates)
+	}
+
+	unique, duplicates = mailcheck.Deduplicate(emails, mailcheck.NormalizeAlias)
+	if strings.Join(unique, ",") != "jdoe@gmail.com,JDoe@example.com,jdoe@example.com" || duplicates != 2 {
+		t.Errorf("expected the aliases to be folded into the first, got %v and %d duplicates", unique, duplicates)
+	}
+}
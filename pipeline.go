@@ -0,0 +1,344 @@
+package mailcheck
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const (
+	// CheckSyntax parses the address and classifies its local part. Every other check needs the parsed address.
+	CheckSyntax = "syntax"
+	// CheckPolicy decides about the address by its domain alone with Options.Policy, ending the check when it does.
+	CheckPolicy = "policy"
+	// CheckDisposable flags disposable domains at LevelDeep.
+	CheckDisposable = "disposable"
+	// CheckDomainBlocklist looks up the domain on Options.DomainBlocklists, ending the check when it is listed.
+	CheckDomainBlocklist = "domain_blocklist"
+	// CheckDomainAge looks up when the domain was registered, with Options.DomainAge.
+	CheckDomainAge = "domain_age"
+	// CheckMX looks up the mail servers of the domain at LevelDNS and deeper.
+	CheckMX = "mx"
+	// CheckFast ends the check of addresses at domains known to accept any address, with Options.Fast.
+	CheckFast = "fast"
+	// CheckSMTP asks one of the mail servers whether it accepts the address at LevelSMTP and deeper.
+	CheckSMTP = "smtp"
+	// CheckExchangeOnline confirms addresses accepted by Exchange Online with a catch-all probe,
+	// with Options.ExchangeOnline.
+	CheckExchangeOnline = "exchange_online"
+	// CheckAuth looks up the SPF and DMARC records of the domain at LevelDeep.
+	CheckAuth = "auth"
+	// CheckCatchAll probes whether the domain accepts any address at LevelDeep.
+	CheckCatchAll = "catch_all"
+	// CheckVRFY asks about ambiguous addresses with VRFY and EXPN, with Options.UseVRFY.
+	CheckVRFY = "vrfy"
+	// CheckSpamtrap weighs the signs of a spamtrap, with Options.Spamtraps.
+	CheckSpamtrap = "spamtrap"
+	// CheckEnrichment runs Options.Enrichers.
+	CheckEnrichment = "enrichment"
+)
+
+// DefaultChecks is the pipeline used when Options.Checks is left empty.
+var DefaultChecks = []Check{
+	NewCheck(CheckSyntax, checkSyntax),
+	NewCheck(CheckPolicy, checkPolicy),
+	NewCheck(CheckDisposable, checkDisposable),
+	NewCheck(CheckDomainBlocklist, checkDomainBlocklist),
+	NewCheck(CheckDomainAge, checkDomainAge),
+	NewCheck(CheckMX, checkMX),
+	NewCheck(CheckFast, checkFast),
+	NewCheck(CheckSMTP, checkSMTP),
+	NewCheck(CheckExchangeOnline, checkExchangeOnline),
+	NewCheck(CheckAuth, checkAuth),
+	NewCheck(CheckCatchAll, checkCatchAll),
+	NewCheck(CheckVRFY, checkVRFY),
+	NewCheck(CheckSpamtrap, checkSpamtrap),
+	NewCheck(CheckEnrichment, checkEnrichment),
+}
+
+// Check is a stage of the pipeline an address goes through. Checks run in order on the same Address,
+// every check records its findings in the result and may end the check, typically once the verdict is final.
+type Check interface {
+	// Name identifies the check, e.g. to disable it.
+	Name() string
+	// Run checks address with c. Returning true skips the remaining checks.
+	Run(ctx context.Context, c *Checker, address *Address) (stop bool)
+}
+
+// Address is an address going through the pipeline. A result without verdict after the last check is valid,
+// as far as the checks that ran can tell.
+type Address struct {
+	Result *Result
+	// Recipient is the address to probe and Domain its domain, both with an internationalized domain
+	// in punycode form. They are set by the syntax check.
+	Recipient string
+	Domain    string
+	// Servers are the mail servers of the domain, set by the mx check.
+	Servers []MailServer
+}
+
+type checkFunc struct {
+	name string
+	run  func(ctx context.Context, c *Checker, address *Address) bool
+}
+
+// NewCheck returns a Check called name that runs run.
+func NewCheck(name string, run func(ctx context.Context, c *Checker, address *Address) (stop bool)) Check {
+	return checkFunc{name: name, run: run}
+}
+
+func (f checkFunc) Name() string {
+	return f.name
+}
+
+func (f checkFunc) Run(ctx context.Context, c *Checker, address *Address) bool {
+	return f.run(ctx, c, address)
+}
+
+// WithoutChecks returns checks without the ones called any of names.
+func WithoutChecks(checks []Check, names ...string) []Check {
+	disabled := map[string]bool{}
+	for _, name := range names {
+		disabled[name] = true
+	}
+
+	var remaining []Check
+	for _, check := range checks {
+		if !disabled[check.Name()] {
+			remaining = append(remaining, check)
+		}
+	}
+
+	return remaining
+}
+
+// run takes email through the pipeline.
+func (c *Checker) run(ctx context.Context, email string) Result {
+	res := Result{Email: email, Level: c.options.Level, Attempts: map[string]int{}}
+	address := &Address{Result: &res}
+
+	for _, check := range c.options.Checks {
+		if check.Run(ctx, c, address) {
+			break
+		}
+	}
+
+	if res.Verdict == "" {
+		res.Verdict = VerdictValid
+	}
+
+	return res
+}
+
+func checkSyntax(_ context.Context, c *Checker, address *Address) bool {
+	res := address.Result
+
+	recipient, domain, err := ParseAddress(res.Email)
+	if err != nil {
+		res.fail(VerdictInvalid, ReasonSyntax, err)
+		return true
+	}
+
+	// mail is not delivered to top-level domains, a dotless domain is a typo or a name only known locally
+	if _, ok := c.options.Hosts.lookup(domain); !ok && !strings.Contains(domain, ".") {
+		res.fail(VerdictInvalid, ReasonSyntax, syntaxError{errors.Errorf("domain '%s' has no dot", domain)})
+		return true
+	}
+
+	address.Recipient, address.Domain = recipient, domain
+	res.Classification = c.Classify(recipient)
+	return false
+}
+
+func checkPolicy(_ context.Context, c *Checker, address *Address) bool {
+	res := address.Result
+
+	verdict, reason := c.options.Policy.Decide(address.Domain)
+	if verdict == "" {
+		// the profile of a domain may settle its addresses just the same
+		if profile := c.profile(address.Domain); profile != nil && profile.Verdict != "" {
+			res.Verdict, res.Reason = profile.Verdict, ReasonProfile
+			return true
+		}
+		return false
+	}
+
+	if verdict == VerdictInvalid {
+		res.fail(verdict, reason, ErrPolicyBlocked)
+	} else {
+		res.Verdict, res.Reason = verdict, reason
+	}
+	return true
+}
+
+func checkDisposable(_ context.Context, _ *Checker, address *Address) bool {
+	if address.Result.Level == LevelDeep {
+		address.Result.Disposable = IsDisposable(address.Domain)
+	}
+	return false
+}
+
+func checkDomainBlocklist(ctx context.Context, c *Checker, address *Address) bool {
+	res := address.Result
+	if !res.Level.includes(LevelDNS) || len(c.options.DomainBlocklists) == 0 {
+		return false
+	}
+
+	listed := false
+	res.DomainBlocklists = c.CheckDomainBlocklists(ctx, address.Domain, c.options.DomainBlocklists)
+	for _, listing := range res.DomainBlocklists {
+		listed = listed || listing.Listed
+	}
+	res.DomainListed = &listed
+
+	// a known-bad domain is not worth probing
+	if listed {
+		res.Verdict, res.Reason = VerdictUnknown, ReasonDomainListed
+	}
+	return listed
+}
+
+func checkDomainAge(ctx context.Context, c *Checker, address *Address) bool {
+	res := address.Result
+	if !res.Level.includes(LevelDNS) || !c.options.DomainAge {
+		return false
+	}
+
+	if created, err := c.DomainCreated(ctx, address.Domain); err == nil {
+		res.DomainCreated = &created
+		res.YoungDomain = time.Since(created) < c.options.YoungDomainAge
+	} else {
+		log.Debugf("could not tell how old %s is: %v", address.Domain, err)
+	}
+	return false
+}
+
+func checkMX(ctx context.Context, c *Checker, address *Address) bool {
+	res := address.Result
+	if !res.Level.includes(LevelDNS) {
+		return false
+	}
+
+	dnsCtx, release := c.options.StageBudget.context(ctx, res.Durations, StageDNS)
+	start := time.Now()
+	servers, attempts, status, err := c.lookupMailServers(dnsCtx, address.Domain)
+	res.spend(StageDNS, time.Since(start))
+	if release() {
+		err = budgetError(StageDNS)
+	}
+	if attempts > 0 {
+		res.Attempts[StageDNS] = attempts
+	}
+	res.DNSSEC = status
+	if err != nil {
+		res.fail(VerdictUnknown, ReasonDNSError, err)
+		switch {
+		case errors.Is(err, errDNSSECBogus):
+			res.Reason = ReasonDNSBogus
+		case errors.Is(err, errPrefetchFailed):
+			res.Reason = ReasonDNSFailed
+		}
+		res.suggest(address.Recipient, address.Domain)
+		return true
+	}
+
+	if len(servers) == 0 {
+		res.fail(VerdictInvalid, ReasonNoMX, ErrNoMX)
+		res.suggest(address.Recipient, address.Domain)
+		return true
+	}
+
+	address.Servers = servers
+	return false
+}
+
+func checkSMTP(ctx context.Context, c *Checker, address *Address) bool {
+	if address.Result.Level.includes(LevelSMTP) && len(address.Servers) > 0 {
+		c.VerifyMailbox(ctx, address.Result, address.Recipient, address.Servers)
+
+		// providers that accept anything do so for every address at the domain
+		if address.Result.Reason == ReasonCatchAll {
+			c.rememberCatchAll(address.Domain)
+		}
+
+		profile := c.profile(address.Domain)
+		if address.Result.Verdict == VerdictValid && profile != nil && profile.AcceptIsUnknown {
+			address.Result.Verdict, address.Result.Reason = VerdictUnknown, ReasonAcceptedUnconfirmed
+		}
+	}
+	return false
+}
+
+func checkExchangeOnline(ctx context.Context, c *Checker, address *Address) bool {
+	res := address.Result
+	if !c.options.ExchangeOnline || res.Verdict != VerdictValid || !isExchangeOnline(address.Servers) ||
+		c.skipCatchAll(address.Domain) {
+		return false
+	}
+
+	// tenants without directory based edge blocking accept any recipient, an address that cannot exist tells which
+	catchAll, err := c.IsCatchAll(ctx, address.Domain, address.Servers)
+	switch {
+	case err != nil:
+		log.Debugf("could not tell whether Exchange Online tenant %s accepts any address: %v", address.Domain, err)
+		res.ProviderLimited = true
+	case catchAll:
+		res.CatchAll, res.ProviderLimited = true, true
+		res.Verdict, res.Reason = VerdictUnknown, ReasonCatchAll
+	}
+	return false
+}
+
+func checkAuth(ctx context.Context, c *Checker, address *Address) bool {
+	if address.Result.Level != LevelDeep {
+		return false
+	}
+
+	if auth, err := c.LookupDomainAuth(ctx, address.Domain); err == nil {
+		address.Result.Auth = &auth
+	} else {
+		log.Debugf("could not look up authentication records of %s: %v", address.Domain, err)
+	}
+	return false
+}
+
+func checkCatchAll(ctx context.Context, c *Checker, address *Address) bool {
+	res := address.Result
+
+	// a rejected address already proves the domain is picky
+	if res.Level != LevelDeep || res.Verdict != VerdictValid || len(address.Servers) == 0 || c.skipCatchAll(address.Domain) {
+		return false
+	}
+
+	var err error
+	if res.CatchAll, err = c.IsCatchAll(ctx, address.Domain, address.Servers); err != nil {
+		log.Debugf("could not tell whether %s is catch-all: %v", address.Domain, err)
+	}
+
+	if res.CatchAll {
+		res.Verdict, res.Reason = VerdictUnknown, ReasonCatchAll
+	}
+	return false
+}
+
+func checkVRFY(ctx context.Context, c *Checker, address *Address) bool {
+	res := address.Result
+	if c.options.UseVRFY && len(address.Servers) > 0 && res.Verdict == VerdictUnknown && ambiguousReasons[res.Reason] {
+		c.VerifyByCommand(ctx, res, address.Recipient, address.Servers)
+	}
+	return false
+}
+
+func checkSpamtrap(ctx context.Context, c *Checker, address *Address) bool {
+	if c.options.Spamtraps && address.Result.Verdict != VerdictInvalid {
+		address.Result.Spamtrap = c.assessSpamtrap(ctx, address.Result, address.Domain)
+	}
+	return false
+}
+
+func checkEnrichment(ctx context.Context, c *Checker, address *Address) bool {
+	c.enrich(ctx, address.Result)
+	return false
+}
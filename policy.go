@@ -0,0 +1,59 @@
+package mailcheck
+
+import (
+	"github.com/pkg/errors"
+	"path"
+	"strings"
+)
+
+// DomainPolicy decides about addresses by their domain alone, before anything is looked up, to enforce rules such
+// as not accepting addresses under certain top-level domains. Patterns are domains in punycode form and may use the
+// wildcards of path.Match, so *.example.com matches the subdomains of example.com but not example.com itself.
+type DomainPolicy struct {
+	// Allow are the domains whose addresses are valid without further checks, blocked or not.
+	Allow []string
+	// Block are the domains whose addresses are invalid without further checks.
+	Block []string
+	// BlockTLDs are the top-level domains, such as ru, whose addresses are invalid without further checks.
+	BlockTLDs []string
+}
+
+// Validate checks the patterns of the policy for errors.
+func (p DomainPolicy) Validate() error {
+	for _, patterns := range [][]string{p.Allow, p.Block, p.BlockTLDs} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return errors.Errorf("invalid domain pattern '%s'", pattern)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Decide returns the verdict of the policy about addresses at domain, empty when it has none.
+func (p DomainPolicy) Decide(domain string) (Verdict, Reason) {
+	domain = canonicalHost(domain)
+	tld := domain[strings.LastIndex(domain, ".")+1:]
+
+	switch {
+	case matchesAny(p.Allow, domain):
+		return VerdictValid, ReasonPolicyAllowed
+	case matchesAny(p.Block, domain), matchesAny(p.BlockTLDs, tld):
+		return VerdictInvalid, ReasonPolicyBlocked
+	default:
+		return "", ""
+	}
+}
+
+// matchesAny reports whether name matches any of patterns, ignoring case and leading or trailing dots.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.Trim(pattern, "."))
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,112 @@
+package mailcheck
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+)
+
+// errPrefetchFailed marks the mail server lookups that already failed when prefetching them.
+var errPrefetchFailed = errors.New("mail server lookup failed when prefetched")
+
+// prefetchedMX is the outcome of looking up the mail servers of a domain ahead of its addresses.
+type prefetchedMX struct {
+	servers []MailServer
+	status  DNSSECStatus
+	err     error
+}
+
+// PrefetchMailServers looks up the mail servers of every domain of emails, up to workers at a time, so that
+// checking the addresses does not wait for DNS. Until prefetched again, the addresses of the domains that failed
+// are not checked but reported as ReasonDNSFailed. It returns the failures by domain.
+func (c *Checker) PrefetchMailServers(ctx context.Context, emails []string, workers int) map[string]error {
+	if !c.options.Level.includes(LevelDNS) {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	var domains []string
+	for _, email := range emails {
+		if _, domain, err := ParseAddress(email); err == nil && !seen[strings.ToLower(domain)] {
+			seen[strings.ToLower(domain)] = true
+			domains = append(domains, strings.ToLower(domain))
+		}
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failures := map[string]error{}
+	queue := make(chan string)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for domain := range queue {
+				if err := c.prefetchMailServers(ctx, domain); err != nil {
+					mu.Lock()
+					failures[domain] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, domain := range domains {
+		queue <- domain
+	}
+	close(queue)
+	wg.Wait()
+
+	return failures
+}
+
+// prefetchMailServers looks up and keeps the mail servers of domain, warming the DNS cache with their addresses.
+func (c *Checker) prefetchMailServers(ctx context.Context, domain string) error {
+	c.prefetchedMu.Lock()
+	delete(c.prefetched, domain)
+	c.prefetchedMu.Unlock()
+
+	servers, _, status, err := c.lookupMailServers(ctx, domain)
+	// an interrupted prefetch says nothing about the domain
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err == nil && c.dnsCache != nil {
+		for _, server := range servers {
+			if _, ok := c.options.Hosts.lookup(server.Host); !ok {
+				_, _ = c.resolver.LookupIPAddr(ctx, server.Host)
+			}
+		}
+	}
+
+	entry := prefetchedMX{servers: servers, status: status, err: err}
+	// bogus answers are a finding about the domain rather than a failure
+	if err != nil && !errors.Is(err, errDNSSECBogus) {
+		entry.err = errors.Wrap(errPrefetchFailed, err.Error())
+	}
+
+	c.prefetchedMu.Lock()
+	c.prefetched[domain] = entry
+	c.prefetchedMu.Unlock()
+
+	if errors.Is(err, errDNSSECBogus) {
+		return nil
+	}
+	return err
+}
+
+// prefetchedMailServers returns the prefetched mail servers of domain, ok is false when there are none.
+func (c *Checker) prefetchedMailServers(domain string) (entry prefetchedMX, ok bool) {
+	c.prefetchedMu.Lock()
+	defer c.prefetchedMu.Unlock()
+
+	entry, ok = c.prefetched[strings.ToLower(domain)]
+	return entry, ok
+}
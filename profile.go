@@ -0,0 +1,106 @@
+package mailcheck
+
+import (
+	"github.com/pkg/errors"
+	"path"
+	"time"
+)
+
+// DomainProfile adjusts how addresses at a domain are checked, for destinations with quirks that the defaults
+// get wrong.
+type DomainProfile struct {
+	// Domain is the domain in punycode form the profile applies to, or a pattern with the wildcards of DomainPolicy.
+	Domain string `json:"domain" yaml:"domain"`
+	// Timeout replaces the connect and smtp limits of Options.StageBudget for the mail servers of the domain.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout"`
+	// Port is the only port tried on the mail servers of the domain, such as 587, Options.Ports when zero.
+	Port int `json:"port,omitempty" yaml:"port"`
+	// SkipCatchAll leaves out the catch-all probe, for servers that punish probes of addresses that cannot exist.
+	SkipCatchAll bool `json:"skip_catch_all,omitempty" yaml:"skip_catch_all"`
+	// AcceptIsUnknown makes an accepted address unknown:accepted_unconfirmed, for servers that accept any
+	// recipient and bounce later without being caught by the catch-all probe.
+	AcceptIsUnknown bool `json:"accept_is_unknown,omitempty" yaml:"accept_is_unknown"`
+	// Verdict is the verdict of every address at the domain, with ReasonProfile, which is then not checked any
+	// further. The addresses are checked when empty.
+	Verdict Verdict `json:"verdict,omitempty" yaml:"verdict"`
+}
+
+// Validate checks the profile for errors.
+func (p DomainProfile) Validate() error {
+	if p.Domain == "" {
+		return errors.New("domain profile has no domain")
+	}
+
+	if _, err := path.Match(p.Domain, ""); err != nil {
+		return errors.Errorf("invalid domain pattern '%s'", p.Domain)
+	}
+
+	switch {
+	case p.Timeout < 0:
+		return errors.Errorf("negative timeout in the profile of %s", p.Domain)
+	case p.Port < 0 || p.Port > 65535:
+		return errors.Errorf("invalid port in the profile of %s", p.Domain)
+	}
+
+	switch p.Verdict {
+	case "", VerdictValid, VerdictInvalid, VerdictUnknown:
+	default:
+		return errors.Errorf("invalid verdict '%s' in the profile of %s", p.Verdict, p.Domain)
+	}
+
+	return nil
+}
+
+// profile returns the profile of domain, nil when it has none. A profile of the domain itself takes precedence
+// over the patterns, which are tried in order.
+func (c *Checker) profile(domain string) *DomainProfile {
+	if len(c.options.Profiles) == 0 {
+		return nil
+	}
+
+	domain = canonicalHost(domain)
+
+	for i, profile := range c.options.Profiles {
+		if canonicalHost(profile.Domain) == domain {
+			return &c.options.Profiles[i]
+		}
+	}
+
+	for i, profile := range c.options.Profiles {
+		if matchesAny([]string{profile.Domain}, domain) {
+			return &c.options.Profiles[i]
+		}
+	}
+
+	return nil
+}
+
+// profileServers returns servers on the port of the profile of domain, and the stage budget for them.
+func (c *Checker) profileServers(domain string, servers []MailServer) ([]MailServer, StageBudget) {
+	budget := c.options.StageBudget
+
+	profile := c.profile(domain)
+	if profile == nil {
+		return servers, budget
+	}
+
+	if profile.Timeout > 0 {
+		budget.Connect, budget.SMTP = profile.Timeout, profile.Timeout
+	}
+
+	if profile.Port != 0 {
+		pinned := make([]MailServer, 0, len(servers))
+		for _, server := range servers {
+			pinned = append(pinned, MailServer{Host: server.Host, Port: profile.Port})
+		}
+		servers = pinned
+	}
+
+	return servers, budget
+}
+
+// skipCatchAll reports whether the profile of domain leaves out the catch-all probe.
+func (c *Checker) skipCatchAll(domain string) bool {
+	profile := c.profile(domain)
+	return profile != nil && profile.SkipCatchAll
+}
@@ -0,0 +1,168 @@
+package mailcheck
+
+import (
+	"strings"
+)
+
+// Provider is a large mail provider, recognized by the mail servers of a domain.
+// Their servers behave in well-known ways that the generic interpretation of replies gets wrong.
+type Provider string
+
+const (
+	// ProviderGoogle is Gmail and Google Workspace.
+	ProviderGoogle Provider = "google"
+	// ProviderMicrosoft is Outlook.com and Microsoft 365, including Exchange Online Protection.
+	ProviderMicrosoft Provider = "microsoft"
+	// ProviderYahoo is Yahoo Mail and AOL.
+	ProviderYahoo Provider = "yahoo"
+)
+
+// providerProfile describes how to recognize a provider and how its replies are to be read.
+type providerProfile struct {
+	provider Provider
+	// mxSuffixes and bannerTerms identify the mail servers of the provider, by host name or by greeting
+	mxSuffixes  []string
+	bannerTerms []string
+	// acceptsAll is set for providers that accept any recipient during the SMTP dialog and bounce later,
+	// so acceptance proves nothing
+	acceptsAll bool
+	// classify reads a negative reply the generic way cannot, ok is false to leave it to the generic way
+	classify func(code int, status EnhancedStatus, text string) (verdict Verdict, reason Reason, ok bool)
+}
+
+var providerProfiles = []providerProfile{
+	{
+		provider:    ProviderGoogle,
+		mxSuffixes:  []string{".google.com", ".googlemail.com"},
+		bannerTerms: []string{"mx.google.com"},
+		classify: func(code int, status EnhancedStatus, text string) (Verdict, Reason, bool) {
+			// rate limiting and spam suspicion are about us, Google words those as policy rejections
+			if containsAny(text, "unsolicited mail", "rate limited", "unusual rate", "our system has detected") {
+				return VerdictUnknown, ReasonSenderIssue, true
+			}
+			return "", "", false
+		},
+	},
+	{
+		provider:    ProviderMicrosoft,
+		mxSuffixes:  []string{".outlook.com", ".hotmail.com"},
+		bannerTerms: []string{"microsoft esmtp mail service", "outlook.com"},
+		classify: func(code int, status EnhancedStatus, text string) (Verdict, Reason, bool) {
+			switch {
+			// directory based edge blocking: the recipient is not in the tenant's directory
+			case status.Class == 5 && status.Subject == 4 && status.Detail == 1:
+				return VerdictInvalid, ReasonUserUnknown, true
+			// Exchange uses 5.1.10 for unknown recipients rather than for null MX domains
+			case status.Class == 5 && status.Subject == 1 && status.Detail == 10, containsAny(text, "recipientnotfound"):
+				return VerdictInvalid, ReasonUserUnknown, true
+			// 5.7.606 to 5.7.649 are banned sending IPs, 5.7.511 a banned sender
+			case status.Subject == 7 && (status.Detail >= 606 && status.Detail <= 649 || status.Detail == 511):
+				return VerdictUnknown, ReasonSenderIssue, true
+			case containsAny(text, "blocked using spamhaus", "part of their network is on our block list"):
+				return VerdictUnknown, ReasonSenderIssue, true
+			}
+			return "", "", false
+		},
+	},
+	{
+		provider:    ProviderYahoo,
+		mxSuffixes:  []string{".yahoodns.net", ".yahoo.com", ".aol.com"},
+		bannerTerms: []string{"yahoo", "ymail", "aol.com"},
+		acceptsAll:  true,
+		classify: func(code int, status EnhancedStatus, text string) (Verdict, Reason, bool) {
+			switch {
+			// "554 delivery error: dd This user doesn't have a yahoo.com account"
+			case containsAny(text, "delivery error: dd", "doesn't have a"):
+				return VerdictInvalid, ReasonUserUnknown, true
+			// [TSS04] and friends: deferred because of the reputation of the sending IP
+			case containsAny(text, "[ts0", "[tss0", "[ps0"):
+				return VerdictUnknown, ReasonSenderIssue, true
+			}
+			return "", "", false
+		},
+	},
+}
+
+// identifyProvider recognizes the provider of a mail server by its host name, or otherwise by its greeting.
+func identifyProvider(mx, banner string) Provider {
+	mx = "." + canonicalHost(mx)
+	banner = strings.ToLower(banner)
+
+	for _, profile := range providerProfiles {
+		for _, suffix := range profile.mxSuffixes {
+			if strings.HasSuffix(mx, suffix) {
+				return profile.provider
+			}
+		}
+	}
+
+	for _, profile := range providerProfiles {
+		if banner != "" && containsAny(banner, profile.bannerTerms...) {
+			return profile.provider
+		}
+	}
+
+	return ""
+}
+
+// exchangeOnlineSuffix ends the mail server names of Exchange Online tenants, e.g. contoso-com.mail.protection.outlook.com.
+const exchangeOnlineSuffix = ".mail.protection.outlook.com"
+
+// isExchangeOnline reports whether any of servers belongs to an Exchange Online tenant.
+func isExchangeOnline(servers []MailServer) bool {
+	for _, server := range servers {
+		if strings.HasSuffix("."+canonicalHost(server.Host), exchangeOnlineSuffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// profile returns the profile of p, nil for unknown providers.
+func (p Provider) profile() *providerProfile {
+	for i := range providerProfiles {
+		if providerProfiles[i].provider == p {
+			return &providerProfiles[i]
+		}
+	}
+
+	return nil
+}
+
+// classifyProviderRecipient maps the reply to RCPT TO onto a verdict the way provider's servers mean it,
+// falling back to the generic interpretation.
+func classifyProviderRecipient(provider Provider, code int, text string) (Verdict, Reason) {
+	profile := provider.profile()
+	if profile == nil {
+		return classifyRecipient(code, text)
+	}
+
+	if code/100 == 2 {
+		if profile.acceptsAll {
+			return VerdictUnknown, ReasonCatchAll
+		}
+		return VerdictValid, ""
+	}
+
+	status, _, ok := ParseEnhancedStatus(text)
+	if !ok || status.Class != code/100 {
+		status = EnhancedStatus{}
+	}
+
+	if verdict, reason, ok := profile.classify(code, status, text); ok {
+		return verdict, reason
+	}
+
+	return classifyRecipient(code, text)
+}
+
+// containsAny reports whether s contains any of terms, ignoring case.
+func containsAny(s string, terms ...string) bool {
+	s = strings.ToLower(s)
+	for _, term := range terms {
+		if strings.Contains(s, term) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package mailcheck
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces out the probes sent to the mail servers of a domain.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// next holds by domain the earliest time the next probe may be sent
+	next map[string]time.Time
+	// pruned is when the domains whose next probe is no longer delayed were last dropped from next
+	pruned time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	limiter := &rateLimiter{next: map[string]time.Time{}}
+	limiter.setRate(perMinute)
+
+	return limiter
+}
+
+// setRate changes the limit to perMinute probes per domain, zero for no limit.
+func (l *rateLimiter) setRate(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.interval = 0
+	if perMinute > 0 {
+		l.interval = time.Minute / time.Duration(perMinute)
+	}
+}
+
+// wait blocks until a probe of domain is allowed, or until ctx is done.
+func (l *rateLimiter) wait(ctx context.Context, domain string) error {
+	domain = strings.ToLower(domain)
+	now := time.Now()
+
+	l.mu.Lock()
+	if l.interval == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	l.prune(now)
+
+	at := l.next[domain]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[domain] = at.Add(l.interval)
+	l.mu.Unlock()
+
+	if !at.After(now) {
+		return nil
+	}
+
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// prune drops the domains that may be probed again right away, at most once a minute so waiting stays cheap.
+// Otherwise next would hold every domain ever probed. The caller holds mu.
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+
+	for domain, at := range l.next {
+		if !at.After(now) {
+			delete(l.next, domain)
+		}
+	}
+}
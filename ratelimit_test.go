@@ -0,0 +1,34 @@
+package mailcheck
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterForgetsIdleDomains(t *testing.T) {
+	limiter := newRateLimiter(6000)
+
+	if err := limiter.wait(context.Background(), "Example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := limiter.next["example.com"]; !ok {
+		t.Fatal("expected the next probe of example.com to be scheduled")
+	}
+
+	// a minute later the spacing of example.com has long passed, so probing another domain drops it
+	limiter.mu.Lock()
+	limiter.pruned = limiter.pruned.Add(-time.Minute)
+	limiter.next["example.com"] = time.Now().Add(-time.Second)
+	limiter.mu.Unlock()
+
+	if err := limiter.wait(context.Background(), "example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := limiter.next["example.com"]; ok {
+		t.Error("expected example.com to be forgotten once it may be probed again")
+	}
+	if _, ok := limiter.next["example.org"]; !ok {
+		t.Error("expected the next probe of example.org to be scheduled")
+	}
+}
@@ -0,0 +1,142 @@
+package mailcheck
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"golang.org/x/net/publicsuffix"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultYoungDomainAge = time.Hour * 24 * 30
+	// maxRDAPResponse limits the size of bootstrap files and RDAP responses
+	maxRDAPResponse = 4 << 20
+)
+
+// rdapBootstrapURL lists the RDAP servers of every top-level domain, see RFC 9224.
+var rdapBootstrapURL = "https://data.iana.org/rdap/dns.json"
+
+// DomainCreated returns when the registered domain of domain was created, according to the RDAP server of
+// its top-level domain. Creation dates are cached per registered domain for the lifetime of the Checker.
+func (c *Checker) DomainCreated(ctx context.Context, domain string) (time.Time, error) {
+	registered, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(strings.TrimSuffix(domain, ".")))
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "could not determine registered domain")
+	}
+
+	c.rdapMu.Lock()
+	created, ok := c.domainCreated[registered]
+	c.rdapMu.Unlock()
+
+	if ok {
+		return created, nil
+	}
+
+	servers, err := c.rdapServers(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	tld := registered[strings.LastIndex(registered, ".")+1:]
+	server, ok := servers[tld]
+	if !ok {
+		return time.Time{}, errors.Errorf("no rdap server for .%s", tld)
+	}
+
+	var response struct {
+		Events []struct {
+			Action string    `json:"eventAction"`
+			Date   time.Time `json:"eventDate"`
+		} `json:"events"`
+	}
+
+	if err := c.getJSON(ctx, strings.TrimSuffix(server, "/")+"/domain/"+registered, &response); err != nil {
+		return time.Time{}, errors.Wrapf(err, "could not look up %s", registered)
+	}
+
+	for _, event := range response.Events {
+		if event.Action == "registration" {
+			c.rdapMu.Lock()
+			c.domainCreated[registered] = event.Date
+			c.rdapMu.Unlock()
+
+			return event.Date, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("rdap server does not tell when %s was registered", registered)
+}
+
+// rdapServers returns the RDAP base url by top-level domain, fetching the IANA bootstrap file the first time.
+func (c *Checker) rdapServers(ctx context.Context) (map[string]string, error) {
+	c.rdapMu.Lock()
+	servers := c.rdapBootstrap
+	c.rdapMu.Unlock()
+
+	if servers != nil {
+		return servers, nil
+	}
+
+	// every service is a list of top-level domains followed by a list of urls
+	var bootstrap struct {
+		Services [][][]string `json:"services"`
+	}
+
+	if err := c.getJSON(ctx, rdapBootstrapURL, &bootstrap); err != nil {
+		return nil, errors.Wrap(err, "could not fetch rdap bootstrap file")
+	}
+
+	servers = map[string]string{}
+	for _, service := range bootstrap.Services {
+		if len(service) < 2 || len(service[1]) == 0 {
+			continue
+		}
+
+		// prefer https when both are listed
+		url := service[1][0]
+		for _, candidate := range service[1] {
+			if strings.HasPrefix(candidate, "https://") {
+				url = candidate
+				break
+			}
+		}
+
+		for _, tld := range service[0] {
+			servers[strings.ToLower(tld)] = url
+		}
+	}
+
+	c.rdapMu.Lock()
+	c.rdapBootstrap = servers
+	c.rdapMu.Unlock()
+
+	return servers, nil
+}
+
+// getJSON fetches url and decodes its JSON body into v.
+func (c *Checker) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/rdap+json, application/json")
+
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// drain the body so the connection can be reused
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxRDAPResponse))
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxRDAPResponse)).Decode(v)
+}
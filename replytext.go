@@ -0,0 +1,100 @@
+package mailcheck
+
+import (
+	"regexp"
+	"strings"
+)
+
+// textRule maps reply texts containing any of its terms onto a reason. Terms are lowercase and cover the languages
+// mail servers commonly answer in: English, German, French, Spanish, Dutch, Italian, Portuguese and Russian.
+type textRule struct {
+	reason Reason
+	terms  []string
+}
+
+// textRules are tried in order, so that a blocklisted sender wins over the "mailbox unavailable" it often comes with.
+// A phrase such as "listed in" only counts along with the DNSBL it names, see listedOn.
+var textRules = []textRule{
+	{ReasonSenderIssue, []string{
+		"blocked using", "blacklist", "blocklist", "block list", "dnsbl", "spamhaus", "spamcop", "barracuda", "sorbs",
+		"poor reputation", "ip reputation", "client host rejected", "reverse dns", "ptr record", "sender address rejected",
+		"sperrliste", "liste noire", "lista negra", "zwarte lijst", "lista nera", "черный список", "чёрный список",
+	}},
+	{ReasonRelayDenied, []string{
+		"relay access denied", "relaying denied", "relay not permitted", "not permitted to relay", "unable to relay",
+		"relaying not allowed",
+	}},
+	{ReasonMailboxFull, []string{
+		"mailbox full", "mailbox is full", "over quota", "quota exceeded", "exceeded storage", "insufficient storage",
+		"postfach voll", "postfach ist voll", "boîte pleine", "boite pleine", "quota dépassé", "buzón lleno",
+		"mailbox vol", "casella piena", "caixa cheia", "caixa postal cheia", "переполнен",
+	}},
+	{ReasonMailboxDisabled, []string{
+		"mailbox disabled", "account disabled", "account has been disabled", "mailbox is disabled", "deactivated",
+		"account is inactive", "mailbox inactive", "no longer active", "suspended",
+		"deaktiviert", "désactivé", "desactivada", "desactivado", "gedeactiveerd", "disattivat", "desativad",
+		"отключен",
+	}},
+	{ReasonUserUnknown, []string{
+		"user unknown", "unknown user", "no such user", "user not found", "recipient not found", "unknown recipient",
+		"no such recipient", "no such mailbox", "mailbox not found", "mailbox unavailable", "does not exist",
+		"doesn't exist", "invalid recipient", "invalid mailbox", "not a valid mailbox", "unrouteable address",
+		"account does not exist",
+		"unbekannt", "existiert nicht", "kein postfach", "utilisateur inconnu", "destinataire inconnu",
+		"adresse inconnue", "n'existe pas", "inexistant", "usuario desconocido", "destinatario desconocido",
+		"no existe", "onbekende gebruiker", "gebruiker onbekend", "bestaat niet", "utente sconosciuto",
+		"destinatario sconosciuto", "non esiste", "usuário desconhecido", "utilizador desconhecido", "não existe",
+		"не существует", "неизвестный пользователь", "пользователь не найден",
+	}},
+	{ReasonTemporary, []string{
+		"try again later", "greylist", "graylist", "temporarily", "please retry",
+		"später erneut", "vorübergehend", "réessayer plus tard", "temporairement", "más tarde", "temporalmente",
+		"probeer het later", "tijdelijk", "riprova più tardi", "temporaneamente", "tente novamente mais tarde",
+		"temporariamente", "попробуйте позже", "временно",
+	}},
+}
+
+var (
+	// knownDNSBLRegex matches the zones of widely used DNSBLs wherever they appear, e.g. in a lookup url.
+	knownDNSBLRegex = regexp.MustCompile(`(?i)\b((?:[a-z0-9-]+\.)*(?:spamhaus\.org|spamcop\.net|barracudacentral\.org|sorbs\.net|uceprotect\.net|mailspike\.net|abuseat\.org|spameatingmonkey\.net|psbl\.surriel\.com|s5h\.net|invaluement\.com))\b`)
+	// listedOnRegex matches the zone named in phrases such as "blocked using zen.spamhaus.org".
+	listedOnRegex = regexp.MustCompile(`(?i)\b(?:blocked using|listed (?:at|in|on|by)|rbl|dnsbl)[\s:]+([a-z0-9-]+(?:\.[a-z0-9-]+)+)`)
+)
+
+// classifyText maps the text of a negative reply onto a verdict by its wording, for replies whose codes leave the
+// cause open. ok is false when the text says nothing recognizable. The mailbox of a 4xx reply is only temporarily
+// unavailable, so those never make an address invalid.
+func classifyText(code int, text string) (verdict Verdict, reason Reason, ok bool) {
+	// naming a DNSBL is the clearest sign of all
+	if listedOn(text) != "" {
+		return VerdictUnknown, ReasonSenderIssue, true
+	}
+
+	for _, rule := range textRules {
+		if !containsAny(text, rule.terms...) {
+			continue
+		}
+
+		if rule.reason != ReasonUserUnknown && rule.reason != ReasonMailboxDisabled {
+			return VerdictUnknown, rule.reason, true
+		}
+
+		if code/100 != 5 {
+			return VerdictUnknown, ReasonTemporary, true
+		}
+		return VerdictInvalid, rule.reason, true
+	}
+
+	return "", "", false
+}
+
+// listedOn returns the DNSBL a reply says our IP is listed on, empty when it names none.
+func listedOn(text string) string {
+	for _, regex := range []*regexp.Regexp{listedOnRegex, knownDNSBLRegex} {
+		if match := regex.FindStringSubmatch(text); match != nil {
+			return strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(match[1]), "."), "www.")
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,104 @@
+package mailcheck
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"syscall"
+	"time"
+)
+
+const (
+	// StageDNS is the mail server lookup.
+	StageDNS = "dns"
+	// StageConnect is connecting to one of the mail servers.
+	StageConnect = "connect"
+	// StageTLS is the TLS handshake while connecting, only timed since it is retried along with connecting.
+	StageTLS = "tls"
+	// StageSMTP is the SMTP dialog that probes the address.
+	StageSMTP = "smtp"
+)
+
+// RetryPolicy describes how often and how fast a failing stage is retried.
+type RetryPolicy struct {
+	// Retries is the number of retries after the first attempt.
+	Retries int
+	// Backoff is the delay before the first retry, it doubles on every subsequent retry.
+	Backoff time.Duration
+	// Jitter randomizes the delay to avoid retrying in lockstep.
+	Jitter bool
+}
+
+// permanentError marks an error that must not be retried, regardless of its cause.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+// do calls fn until it succeeds, fails permanently or the retries are exhausted.
+// It returns the number of attempts made.
+func (p RetryPolicy) do(ctx context.Context, fn func() error) (attempts int, err error) {
+	delay := p.Backoff
+
+	for {
+		attempts++
+
+		err = fn()
+		if err == nil {
+			return attempts, nil
+		}
+
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			return attempts, permanent.error
+		}
+
+		if attempts > p.Retries || ctx.Err() != nil || !isTransient(err) {
+			return attempts, err
+		}
+
+		wait := delay
+		if p.Jitter && wait > 0 {
+			wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempts, err
+		case <-time.After(wait):
+		}
+
+		delay *= 2
+	}
+}
+
+// isTransient reports whether err is likely to go away when trying again later.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// 4xx replies are temporary by definition, e.g. greylisting
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code/100 == 4
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,96 @@
+package mailcheck
+
+import (
+	"fmt"
+)
+
+// DefaultScoreWeights are the score penalties used when Options.ScoreWeights is left empty.
+var DefaultScoreWeights = ScoreWeights{
+	Invalid:      100,
+	Unknown:      50,
+	NotProbed:    30,
+	CatchAll:     35,
+	Disposable:   60,
+	Role:         15,
+	FreeProvider: 5,
+	NoSPF:        5,
+	NoDMARC:      5,
+	YoungDomain:  40,
+	Spamtrap:     50,
+}
+
+// ScoreWeights are the points deducted from a perfect score of 100 for every finding about an address.
+type ScoreWeights struct {
+	// Invalid is deducted for an invalid verdict, including malformed addresses and domains without mail servers.
+	Invalid int `yaml:"invalid"`
+	// Unknown is deducted when the verdict could not be determined, catch-all domains excluded.
+	Unknown int `yaml:"unknown"`
+	// NotProbed is deducted when the level did not include asking a mail server.
+	NotProbed    int `yaml:"not_probed"`
+	CatchAll     int `yaml:"catch_all"`
+	Disposable   int `yaml:"disposable"`
+	Role         int `yaml:"role"`
+	FreeProvider int `yaml:"free_provider"`
+	// NoSPF and NoDMARC are deducted for domains without sender authentication records, looked up at LevelDeep.
+	NoSPF   int `yaml:"no_spf"`
+	NoDMARC int `yaml:"no_dmarc"`
+	// YoungDomain is deducted for recently registered domains, looked up with Options.DomainAge.
+	YoungDomain int `yaml:"young_domain"`
+	// Spamtrap is deducted for likely spamtraps, assessed with Options.Spamtraps.
+	Spamtrap int `yaml:"spamtrap"`
+}
+
+// score returns the deliverability score of res between 0 and 100, along with the findings that lowered it.
+func (w ScoreWeights) score(res Result) (score int, reasons []string) {
+	score = 100
+
+	deduct := func(points int, reason string) {
+		score -= points
+		reasons = append(reasons, reason)
+	}
+
+	switch {
+	case res.Verdict == VerdictInvalid:
+		deduct(w.Invalid, fmt.Sprintf("%s:%s", res.Verdict, res.Reason))
+	case res.Reason == ReasonCatchAll:
+		deduct(w.CatchAll, string(ReasonCatchAll))
+	case res.Verdict == VerdictUnknown:
+		deduct(w.Unknown, fmt.Sprintf("%s:%s", res.Verdict, res.Reason))
+	case res.Level == LevelSyntax || res.Level == LevelDNS:
+		deduct(w.NotProbed, "not_probed")
+	}
+
+	if res.Disposable {
+		deduct(w.Disposable, "disposable")
+	}
+
+	if res.Role {
+		deduct(w.Role, "role")
+	}
+
+	if res.FreeProvider {
+		deduct(w.FreeProvider, "free_provider")
+	}
+
+	if res.Auth != nil && !res.Auth.SPF {
+		deduct(w.NoSPF, "no_spf")
+	}
+
+	if res.Auth != nil && !res.Auth.DMARC {
+		deduct(w.NoDMARC, "no_dmarc")
+	}
+
+	if res.YoungDomain {
+		deduct(w.YoungDomain, "young_domain")
+	}
+
+	if res.Spamtrap != nil && res.Spamtrap.Likely {
+		deduct(w.Spamtrap, "spamtrap")
+	}
+
+	if score < 0 {
+		score = 0
+	}
+
+	return score, reasons
+}
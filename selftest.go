@@ -0,0 +1,55 @@
+package mailcheck
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+)
+
+// SelfTest is the outcome of testing whether a Checker can do its work, which takes resolving and reaching mail
+// servers. The fields hold what failed, they are empty when it works.
+type SelfTest struct {
+	DNS    string `json:"dns,omitempty"`
+	Egress string `json:"egress,omitempty"`
+}
+
+// OK reports whether every test passed.
+func (t SelfTest) OK() bool {
+	return t.DNS == "" && t.Egress == ""
+}
+
+// SelfTest looks up the mail servers of domain and connects to the first one on the first of Options.Ports,
+// or to Options.Smarthost when set, hanging up without saying anything.
+func (c *Checker) SelfTest(ctx context.Context, domain string) (test SelfTest) {
+	servers, _, err := c.LookupMailServers(ctx, domain)
+	switch {
+	case err != nil:
+		test.DNS = err.Error()
+	case len(servers) == 0:
+		test.DNS = errors.Errorf("%s has no mail servers", domain).Error()
+	}
+
+	target := c.options.Smarthost
+	if target.Host == "" {
+		if len(servers) == 0 {
+			test.Egress = "no mail server to connect to"
+			return test
+		}
+		target = servers[0]
+	}
+
+	if target.Port == 0 {
+		target.Port = c.options.Ports[0]
+	}
+
+	address := net.JoinHostPort(c.options.Hosts.resolveAddress(target.Host), strconv.Itoa(target.Port))
+	conn, err := c.dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		test.Egress = errors.Wrapf(err, "could not connect to %s", address).Error()
+		return test
+	}
+	_ = conn.Close()
+
+	return test
+}
@@ -0,0 +1,362 @@
+package mailcheck
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// spfLookupLimit is the number of mechanisms causing DNS lookups an SPF evaluation may use, as in RFC 7208.
+const spfLookupLimit = 10
+
+// SPF results, as defined in RFC 7208.
+const (
+	SPFPass      = "pass"
+	SPFFail      = "fail"
+	SPFSoftFail  = "softfail"
+	SPFNeutral   = "neutral"
+	SPFNone      = "none"
+	SPFPermError = "permerror"
+	SPFTempError = "temperror"
+)
+
+// SenderCheck tells whether an identity is set up the way mail servers expect of a sender. Servers distrust
+// senders whose names do not match their address, and answer them with rejections or greylisting that are
+// easily mistaken for verdicts.
+type SenderCheck struct {
+	Identity Identity `json:"identity"`
+	// IP is the address the identity connects from, its source ip or otherwise the egress ip.
+	IP string `json:"ip"`
+	// SPF is the result of the SPF record of the MAIL FROM domain for IP, such as SPFPass.
+	SPF string `json:"spf"`
+	// Problems describe what is wrong, empty when the identity is set up well.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// OK reports whether no problems were found.
+func (s SenderCheck) OK() bool {
+	return len(s.Problems) == 0
+}
+
+// CheckSenders checks every identity probes are sent as: the HELO name and the PTR record of the address it connects
+// from point to each other, the SPF record of the MAIL FROM domain passes that address and the MAIL FROM domain can
+// receive mail. egress is the public address of this host, used for identities without source ip.
+func (c *Checker) CheckSenders(ctx context.Context, egress net.IP) []SenderCheck {
+	checks := make([]SenderCheck, 0, len(c.identities.identities))
+	for _, identity := range c.identities.identities {
+		ip := egress
+		if identity.SourceIP != "" {
+			ip = net.ParseIP(identity.SourceIP)
+		}
+
+		checks = append(checks, c.checkSender(ctx, identity, ip))
+	}
+
+	return checks
+}
+
+// checkSender checks identity connecting from ip.
+func (c *Checker) checkSender(ctx context.Context, identity Identity, ip net.IP) SenderCheck {
+	check := SenderCheck{Identity: identity, IP: ip.String()}
+	helo := canonicalHost(identity.HELO)
+
+	problem := func(format string, args ...interface{}) {
+		check.Problems = append(check.Problems, fmt.Sprintf(format, args...))
+	}
+
+	names, err := c.resolver.LookupAddr(ctx, ip.String())
+	switch {
+	case isNotFound(err):
+		problem("%s has no PTR record", ip)
+	case err != nil:
+		problem("could not look up the PTR record of %s: %v", ip, err)
+	default:
+		matched := false
+		for i, name := range names {
+			names[i] = canonicalHost(name)
+			matched = matched || names[i] == helo
+		}
+		if !matched {
+			problem("the PTR record of %s is %s, not helo %s", ip, strings.Join(names, ", "), helo)
+		}
+	}
+
+	addresses, err := c.resolver.LookupIPAddr(ctx, helo)
+	switch {
+	case isNotFound(err):
+		problem("helo %s does not resolve", helo)
+	case err != nil:
+		problem("could not resolve helo %s: %v", helo, err)
+	default:
+		matched := false
+		for _, address := range addresses {
+			matched = matched || address.IP.Equal(ip)
+		}
+		if !matched {
+			problem("helo %s does not resolve to %s", helo, ip)
+		}
+	}
+
+	// the null sender is authorized by the helo name
+	domain := helo
+	if identity.MailFrom != "" {
+		if _, domain, err = ParseAddress(identity.MailFrom); err != nil {
+			problem("invalid mail from %s: %v", identity.MailFrom, err)
+			return check
+		}
+
+		if err := c.canReceive(ctx, domain); err != nil {
+			problem("%v", err)
+		}
+	}
+
+	lookups := 0
+	check.SPF = c.evaluateSPF(ctx, ip, domain, &lookups)
+	if check.SPF != SPFPass {
+		problem("the SPF record of %s does not pass %s: %s", domain, ip, check.SPF)
+	}
+
+	return check
+}
+
+// canReceive returns an error when domain has neither MX nor address records, so bounces to it go nowhere.
+func (c *Checker) canReceive(ctx context.Context, domain string) error {
+	if _, ok := c.options.Hosts.lookup(domain); ok {
+		return nil
+	}
+
+	_, err := c.resolver.LookupMX(ctx, domain)
+	if err == nil {
+		return nil
+	}
+	if !isNotFound(err) {
+		return errors.Wrapf(err, "could not look up mail from domain %s", domain)
+	}
+
+	if _, err = c.resolver.LookupHost(ctx, domain); isNotFound(err) {
+		return errors.Errorf("mail from domain %s has no MX or address records", domain)
+	}
+
+	return errors.Wrapf(err, "could not look up mail from domain %s", domain)
+}
+
+// evaluateSPF returns the SPF result of domain for ip. lookups counts the DNS lookups spent across includes and
+// redirects. Mechanisms using macros are not expanded and never match.
+func (c *Checker) evaluateSPF(ctx context.Context, ip net.IP, domain string, lookups *int) string {
+	records, err := c.lookupTXT(ctx, domain)
+	if isNotFound(err) {
+		return SPFNone
+	}
+	if err != nil {
+		return SPFTempError
+	}
+
+	var record string
+	for _, txt := range records {
+		if lower := strings.ToLower(txt); lower == "v=spf1" || strings.HasPrefix(lower, "v=spf1 ") {
+			if record != "" {
+				return SPFPermError
+			}
+			record = txt
+		}
+	}
+	if record == "" {
+		return SPFNone
+	}
+
+	var redirect string
+	for _, term := range strings.Fields(record)[1:] {
+		term = strings.ToLower(term)
+
+		if strings.HasPrefix(term, "redirect=") {
+			redirect = strings.TrimPrefix(term, "redirect=")
+			continue
+		}
+		if i := strings.IndexAny(term, "=:"); i >= 0 && term[i] == '=' {
+			// other modifiers such as exp do not affect the result
+			continue
+		}
+
+		result := SPFPass
+		switch term[0] {
+		case '+':
+			term = term[1:]
+		case '-':
+			result, term = SPFFail, term[1:]
+		case '~':
+			result, term = SPFSoftFail, term[1:]
+		case '?':
+			result, term = SPFNeutral, term[1:]
+		}
+
+		matched, failure := c.spfMechanism(ctx, ip, domain, term, lookups)
+		if failure != "" {
+			return failure
+		}
+		if matched {
+			return result
+		}
+	}
+
+	if redirect == "" {
+		return SPFNeutral
+	}
+
+	if *lookups++; *lookups > spfLookupLimit {
+		return SPFPermError
+	}
+
+	result := c.evaluateSPF(ctx, ip, redirect, lookups)
+	if result == SPFNone {
+		return SPFPermError
+	}
+
+	return result
+}
+
+// spfMechanism reports whether the mechanism of domain matches ip, or the error result that ends the evaluation.
+func (c *Checker) spfMechanism(ctx context.Context, ip net.IP, domain, mechanism string, lookups *int) (matched bool, result string) {
+	name, arg := mechanism, ""
+	if i := strings.IndexAny(mechanism, ":/"); i >= 0 {
+		name, arg = mechanism[:i], strings.TrimPrefix(mechanism[i:], ":")
+	}
+
+	if strings.Contains(arg, "%") {
+		return false, ""
+	}
+
+	switch name {
+	case "all":
+		return true, ""
+	case "ip4", "ip6":
+		// a bare address is a network of one
+		if !strings.Contains(arg, "/") && name == "ip4" {
+			arg += "/32"
+		} else if !strings.Contains(arg, "/") {
+			arg += "/128"
+		}
+		_, network, err := net.ParseCIDR(arg)
+		if err != nil {
+			return false, SPFPermError
+		}
+		return network.Contains(ip), ""
+	}
+
+	if *lookups++; *lookups > spfLookupLimit {
+		return false, SPFPermError
+	}
+
+	target, v4, v6, err := spfDomainSpec(arg, domain)
+	if err != nil {
+		return false, SPFPermError
+	}
+
+	switch name {
+	case "include":
+		switch c.evaluateSPF(ctx, ip, target, lookups) {
+		case SPFPass:
+			return true, ""
+		case SPFFail, SPFSoftFail, SPFNeutral:
+			return false, ""
+		case SPFTempError:
+			return false, SPFTempError
+		default:
+			return false, SPFPermError
+		}
+	case "a":
+		return c.spfAddressMatch(ctx, ip, []string{target}, v4, v6)
+	case "mx":
+		records, err := c.resolver.LookupMX(ctx, target)
+		if isNotFound(err) {
+			return false, ""
+		}
+		if err != nil {
+			return false, SPFTempError
+		}
+		hosts := make([]string, 0, len(records))
+		for _, mx := range records {
+			hosts = append(hosts, mx.Host)
+		}
+		return c.spfAddressMatch(ctx, ip, hosts, v4, v6)
+	case "exists":
+		_, err := c.resolver.LookupHost(ctx, target)
+		if err != nil && !isNotFound(err) {
+			return false, SPFTempError
+		}
+		return err == nil, ""
+	case "ptr":
+		names, err := c.resolver.LookupAddr(ctx, ip.String())
+		if err != nil {
+			return false, ""
+		}
+		for _, name := range names {
+			name = canonicalHost(name)
+			if name == target || strings.HasSuffix(name, "."+target) {
+				return true, ""
+			}
+		}
+		return false, ""
+	}
+
+	return false, SPFPermError
+}
+
+// spfAddressMatch reports whether ip is in the networks of the given prefix lengths around the addresses of hosts.
+func (c *Checker) spfAddressMatch(ctx context.Context, ip net.IP, hosts []string, v4, v6 int) (bool, string) {
+	for _, host := range hosts {
+		addresses, err := c.resolver.LookupIPAddr(ctx, host)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, SPFTempError
+		}
+
+		for _, address := range addresses {
+			bits, ones := 128, v6
+			if address.IP.To4() != nil {
+				bits, ones = 32, v4
+			}
+			if (&net.IPNet{IP: address.IP, Mask: net.CIDRMask(ones, bits)}).Contains(ip) {
+				return true, ""
+			}
+		}
+	}
+
+	return false, ""
+}
+
+// spfDomainSpec splits the argument of an a or mx mechanism such as "example.com/24//64" into its domain,
+// which defaults to domain, and its IPv4 and IPv6 prefix lengths.
+func spfDomainSpec(arg, domain string) (target string, v4, v6 int, err error) {
+	v4, v6 = 32, 128
+
+	if i := strings.Index(arg, "//"); i >= 0 {
+		if v6, err = strconv.Atoi(arg[i+2:]); err != nil || v6 < 0 || v6 > 128 {
+			return "", 0, 0, errors.Errorf("invalid ipv6 prefix length in '%s'", arg)
+		}
+		arg = arg[:i]
+	}
+
+	if i := strings.Index(arg, "/"); i >= 0 {
+		if v4, err = strconv.Atoi(arg[i+1:]); err != nil || v4 < 0 || v4 > 32 {
+			return "", 0, 0, errors.Errorf("invalid ipv4 prefix length in '%s'", arg)
+		}
+		arg = arg[:i]
+	}
+
+	if arg == "" {
+		arg = domain
+	}
+
+	return canonicalHost(arg), v4, v6, nil
+}
+
+// isNotFound reports whether err means the name looked up does not exist or has no records of the type asked.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
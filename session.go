@@ -0,0 +1,183 @@
+package mailcheck
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSessionIdleTimeout   = time.Second * 30
+	defaultSessionMaxLifetime   = time.Minute * 5
+	defaultMaxIdleSessionsPerMX = 2
+)
+
+// takeSession returns an idle session to one of servers that can take another recipient, if any.
+// Sessions are pooled by mail server, so domains sharing mail servers share sessions too, and all share
+// the sessions to the Smarthost when there is one.
+func (c *Checker) takeSession(ctx context.Context, servers []MailServer, transcript *[]Exchange) *smtpClient {
+	if c.options.Smarthost.Host != "" {
+		servers = []MailServer{c.options.Smarthost}
+	}
+
+	for _, server := range c.health.order(servers) {
+		for {
+			client := c.popSession(server)
+			if client == nil {
+				break
+			}
+
+			if c.identities.exhausted(client.identity) {
+				client.Close()
+				continue
+			}
+
+			client.transcript = transcript
+			client.rewatch(ctx)
+
+			// clears the previous transaction and tells whether the server is still there
+			if _, _, err := client.cmd(250, "RSET"); err != nil {
+				client.Close()
+				continue
+			}
+
+			return client
+		}
+	}
+
+	return nil
+}
+
+// popSession takes the most recently used idle session to server out of the pool, closing the expired ones.
+func (c *Checker) popSession(server MailServer) (client *smtpClient) {
+	host := canonicalHost(server.Host)
+
+	var expired []*smtpClient
+	defer func() {
+		for _, session := range expired {
+			session.Close()
+		}
+	}()
+
+	c.sessionsMu.Lock()
+	defer c.sessionsMu.Unlock()
+
+	idle := c.sessions[host]
+	for i := len(idle) - 1; i >= 0; i-- {
+		if server.Port != 0 && idle[i].port != server.Port {
+			continue
+		}
+
+		session := idle[i]
+		idle = append(idle[:i], idle[i+1:]...)
+
+		if c.expired(session) {
+			expired = append(expired, session)
+			continue
+		}
+
+		client = session
+		break
+	}
+	c.sessions[host] = idle
+
+	return client
+}
+
+// expired reports whether an idle client has been idle or alive for too long to be reused.
+func (c *Checker) expired(client *smtpClient) bool {
+	return time.Since(client.idleSince) >= c.options.SessionIdleTimeout ||
+		time.Since(client.created) >= c.options.SessionMaxLifetime
+}
+
+// releaseSession keeps client around for the next recipient when it is still usable, closing it otherwise.
+func (c *Checker) releaseSession(client *smtpClient, probeErr error) {
+	client.rcpts++
+
+	// a rejected recipient leaves the session intact, anything else may have broken it
+	var reply *replyError
+	healthy := probeErr == nil || (errors.As(probeErr, &reply) && reply.command == cmdRcptTo && reply.code/100 == 5)
+
+	if !healthy || client.rcpts >= c.Limits().MaxRcptPerSession || time.Since(client.created) >= c.options.SessionMaxLifetime {
+		client.Close()
+		return
+	}
+
+	client.stop()
+	client.stop = func() {}
+	client.transcript = nil
+	client.idleSince = time.Now()
+
+	host := canonicalHost(client.mx)
+
+	c.sessionsMu.Lock()
+	idle := append(c.sessions[host], client)
+
+	// the pool keeps the most recently used sessions
+	var closing []*smtpClient
+	if len(idle) > c.options.MaxIdleSessionsPerMX {
+		closing = append(closing, idle[:len(idle)-c.options.MaxIdleSessionsPerMX]...)
+		idle = idle[len(idle)-c.options.MaxIdleSessionsPerMX:]
+	}
+	c.sessions[host] = idle
+
+	// sessions to servers that are not used anymore would otherwise linger until Close
+	for other, sessions := range c.sessions {
+		kept := sessions[:0]
+		for _, session := range sessions {
+			if c.expired(session) {
+				closing = append(closing, session)
+			} else {
+				kept = append(kept, session)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(c.sessions, other)
+		} else {
+			c.sessions[other] = kept
+		}
+	}
+	c.sessionsMu.Unlock()
+
+	for _, session := range closing {
+		session.Close()
+	}
+}
+
+// Close ends all idle SMTP sessions kept for reuse. The Checker remains usable.
+func (c *Checker) Close() {
+	c.sessionsMu.Lock()
+	sessions := c.sessions
+	c.sessions = map[string][]*smtpClient{}
+	c.sessionsMu.Unlock()
+
+	for _, idle := range sessions {
+		for _, client := range idle {
+			client.Close()
+		}
+	}
+}
+
+// GroupByDomain orders emails so that addresses at the same domain are adjacent, which lets consecutive checks
+// share an SMTP session. Domains keep the order of their first appearance, addresses their order within a domain.
+func GroupByDomain(emails []string) []string {
+	var domains []string
+	groups := map[string][]string{}
+
+	for _, email := range emails {
+		domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+		if _, ok := groups[domain]; !ok {
+			domains = append(domains, domain)
+		}
+		groups[domain] = append(groups[domain], email)
+	}
+
+	grouped := make([]string, 0, len(emails))
+	for _, domain := range domains {
+		grouped = append(grouped, groups[domain]...)
+	}
+
+	return grouped
+}
@@ -0,0 +1,109 @@
+package mailcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// redacted stands in for credentials in transcripts.
+const redacted = "<redacted>"
+
+// dialSmarthost connects to Options.Smarthost and logs in. Credentials are only sent over TLS,
+// upgrading the connection with STARTTLS when the smarthost offers it.
+func (c *Checker) dialSmarthost(ctx context.Context, transcript *[]Exchange) (*smtpClient, error) {
+	client, err := c.dialServer(ctx, c.options.Smarthost, transcript)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not connect to smarthost")
+	}
+
+	if c.options.SMTPUser == "" {
+		return client, nil
+	}
+
+	err = func() error {
+		if _, ok := client.conn.(*tls.Conn); !ok {
+			if ok, _ := client.extension("STARTTLS"); !ok {
+				return errors.New("smarthost does not offer STARTTLS, not sending credentials in the clear")
+			}
+
+			if err := client.startTLS(&tls.Config{InsecureSkipVerify: true, ServerName: client.mx}); err != nil { //nolint:gosec
+				return err
+			}
+
+			if err := client.hello(client.identity.HELO); err != nil {
+				return err
+			}
+		}
+
+		// unlike mail servers, the smarthost gets our credentials, so it has to prove who it is
+		if !c.options.SmarthostInsecure {
+			if err := verifyPeer(client.conn.(*tls.Conn), client.mx); err != nil {
+				return err
+			}
+		}
+
+		return client.authenticate(c.options.SMTPUser, c.options.SMTPPassword)
+	}()
+	if err != nil {
+		client.Close()
+		return nil, errors.Wrap(err, "could not log in to smarthost")
+	}
+
+	return client, nil
+}
+
+// verifyPeer verifies the certificate chain presented over conn for host against the system roots.
+func verifyPeer(conn *tls.Conn, host string) error {
+	chain := conn.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := chain[0].Verify(x509.VerifyOptions{DNSName: strings.TrimSuffix(host, "."), Intermediates: intermediates})
+	return errors.Wrap(err, "invalid certificate")
+}
+
+// authenticate logs in with AUTH PLAIN, or AUTH LOGIN for servers that only offer that.
+func (c *smtpClient) authenticate(user, password string) error {
+	ok, params := c.extension("AUTH")
+	if !ok {
+		return errors.New("server does not offer AUTH")
+	}
+
+	mechanisms := map[string]bool{}
+	for _, mechanism := range strings.Fields(params) {
+		mechanisms[strings.ToUpper(mechanism)] = true
+	}
+
+	encode := base64.StdEncoding.EncodeToString
+
+	switch {
+	case mechanisms["PLAIN"]:
+		if _, _, err := c.send(235, "AUTH PLAIN "+encode([]byte("\x00"+user+"\x00"+password)), "AUTH PLAIN "+redacted); err != nil {
+			return errors.Wrap(err, "authentication failed")
+		}
+	case mechanisms["LOGIN"]:
+		if _, _, err := c.cmd(334, "AUTH LOGIN"); err != nil {
+			return errors.Wrap(err, "authentication failed")
+		}
+		if _, _, err := c.send(334, encode([]byte(user)), redacted); err != nil {
+			return errors.Wrap(err, "authentication failed")
+		}
+		if _, _, err := c.send(235, encode([]byte(password)), redacted); err != nil {
+			return errors.Wrap(err, "authentication failed")
+		}
+	default:
+		return errors.Errorf("server offers neither AUTH PLAIN nor LOGIN but %s", params)
+	}
+
+	return nil
+}
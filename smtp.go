@@ -0,0 +1,691 @@
+package mailcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	smtpPort           = 25
+	smtpTLSPort        = 465
+	smtpSubmissionPort = 587
+
+	quitTimeout = time.Second * 2
+
+	cmdMailFrom = "MAIL FROM"
+	cmdRcptTo   = "RCPT TO"
+	cmdVrfy     = "VRFY"
+	cmdExpn     = "EXPN"
+)
+
+var errSMTPUTF8Unsupported = errors.New("mail server does not support SMTPUTF8, required for the local part")
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// Exchange is a single step of an SMTP conversation. Exchanges without a command are
+// the server greeting, a TLS handshake or a failed connection attempt.
+type Exchange struct {
+	// Server is the host:port the exchange took place with.
+	Server string `json:"server"`
+	// Time is when the command was sent, or when waiting for the server started.
+	Time time.Time `json:"time"`
+	// Duration is how long it took the server to answer.
+	Duration time.Duration `json:"duration"`
+	Command  string        `json:"command,omitempty"`
+	Code     int           `json:"code,omitempty"`
+	// Response is the reply text, multi-line replies are joined by newlines.
+	Response string      `json:"response,omitempty"`
+	TLS      *TLSDetails `json:"tls,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// TLSDetails describes an established TLS connection.
+type TLSDetails struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ServerName  string `json:"server_name"`
+	// Certificates holds the subjects of the certificate chain presented by the server.
+	Certificates []string `json:"certificates,omitempty"`
+}
+
+// smtpClient is a minimal SMTP client. Unlike net/smtp it leaves every command up to the caller
+// and can record the complete conversation, including what happens after STARTTLS.
+type smtpClient struct {
+	conn       net.Conn
+	text       *textproto.Conn
+	mx         string
+	port       int
+	extensions map[string]string
+	// banner is the greeting of the server
+	banner string
+	// certificate describes what the server presented once the connection was upgraded to TLS
+	certificate *TLSCertificate
+	// handshakes is the time spent on TLS handshakes
+	handshakes time.Duration
+	// identity is who the session greeted the server as, and sends probes from
+	identity Identity
+	// transcript receives every exchange, nil when not recording
+	transcript *[]Exchange
+	stop       func()
+	// rcpts is the number of recipients probed in this session
+	rcpts int
+	// created is when the session was opened, idleSince when it was last put back in the pool
+	created   time.Time
+	idleSince time.Time
+}
+
+// record adds ex to the transcript, if one is being recorded.
+func (c *smtpClient) record(ex Exchange) {
+	if c.transcript == nil {
+		return
+	}
+
+	ex.Server = net.JoinHostPort(c.mx, strconv.Itoa(c.port))
+	*c.transcript = append(*c.transcript, ex)
+}
+
+// cmd sends a command, or nothing when format is empty, and reads the reply which must match expectCode
+// as in textproto.Reader.ReadResponse. A mismatching reply is returned as a *textproto.Error.
+func (c *smtpClient) cmd(expectCode int, format string, args ...interface{}) (code int, msg string, err error) {
+	command := ""
+	if format != "" {
+		command = fmt.Sprintf(format, args...)
+	}
+
+	return c.send(expectCode, command, command)
+}
+
+// send sends command, or nothing when it is empty, and reads the reply like cmd. The transcript shows
+// the command as shown, which keeps credentials out of it.
+func (c *smtpClient) send(expectCode int, command, shown string) (code int, msg string, err error) {
+	ex := Exchange{Time: time.Now(), Command: shown}
+
+	defer func() {
+		ex.Duration = time.Since(ex.Time)
+		ex.Code, ex.Response = code, msg
+		if err != nil && code == 0 {
+			ex.Error = err.Error()
+		}
+		c.record(ex)
+	}()
+
+	if command != "" {
+		if err := c.text.PrintfLine("%s", command); err != nil {
+			return 0, "", err
+		}
+	}
+
+	return c.text.ReadResponse(expectCode)
+}
+
+// reply is the answer of the server to a single command.
+type reply struct {
+	code int
+	msg  string
+	err  error
+}
+
+// pipeline sends commands in a single write as allowed by RFC 2920 and reads their replies in order,
+// each matching its expectCodes entry as in cmd. Once reading fails other than by a mismatching reply,
+// the remaining replies carry that error.
+func (c *smtpClient) pipeline(commands []string, expectCodes []int) []reply {
+	replies := make([]reply, len(commands))
+	start := time.Now()
+
+	var err error
+	for _, command := range commands {
+		if _, err = fmt.Fprintf(c.text.W, "%s\r\n", command); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = c.text.W.Flush()
+	}
+
+	for i, command := range commands {
+		ex := Exchange{Time: start, Command: command}
+
+		if err == nil {
+			replies[i].code, replies[i].msg, replies[i].err = c.text.ReadResponse(expectCodes[i])
+
+			// anything but a negative reply leaves the stream out of sync
+			var protoErr *textproto.Error
+			if replies[i].err != nil && !errors.As(replies[i].err, &protoErr) {
+				err = replies[i].err
+			}
+		} else {
+			replies[i].err = err
+		}
+
+		ex.Duration = time.Since(ex.Time)
+		ex.Code, ex.Response = replies[i].code, replies[i].msg
+		if replies[i].err != nil && replies[i].code == 0 {
+			ex.Error = replies[i].err.Error()
+		}
+		c.record(ex)
+	}
+
+	return replies
+}
+
+// handshake upgrades the connection to TLS.
+func (c *smtpClient) handshake(config *tls.Config) error {
+	tlsConn := tls.Client(c.conn, config)
+	ex := Exchange{Time: time.Now()}
+
+	err := tlsConn.Handshake()
+	ex.Duration = time.Since(ex.Time)
+	c.handshakes += ex.Duration
+
+	if err != nil {
+		ex.Error = err.Error()
+		c.record(ex)
+		return errors.Wrap(err, "tls handshake failed")
+	}
+
+	state := tlsConn.ConnectionState()
+	ex.TLS = &TLSDetails{
+		Version:     tlsVersions[state.Version],
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  state.ServerName,
+	}
+	for _, cert := range state.PeerCertificates {
+		ex.TLS.Certificates = append(ex.TLS.Certificates, cert.Subject.String())
+	}
+	c.record(ex)
+
+	c.certificate = inspectCertificate(state)
+	if c.certificate != nil && c.certificate.Expired {
+		log.Warnf("%s presents a certificate that expired on %s", c.mx, c.certificate.NotAfter.Format("2006-01-02"))
+	}
+	if c.certificate != nil && c.certificate.SelfSigned {
+		log.Warnf("%s presents a self-signed certificate", c.mx)
+	}
+	c.conn = tlsConn
+	c.text = textproto.NewConn(tlsConn)
+	return nil
+}
+
+// hello greets the server with EHLO, falling back to HELO for servers without ESMTP.
+func (c *smtpClient) hello(localName string) error {
+	_, msg, err := c.cmd(250, "EHLO %s", localName)
+	if err != nil {
+		if _, _, err := c.cmd(250, "HELO %s", localName); err != nil {
+			return errors.Wrap(err, "could not HELO smtp server")
+		}
+
+		c.extensions = map[string]string{}
+		return nil
+	}
+
+	// the first line is the greeting, every other line an extension with optional parameters
+	c.extensions = map[string]string{}
+	for _, line := range strings.Split(msg, "\n")[1:] {
+		fields := strings.SplitN(line, " ", 2)
+		params := ""
+		if len(fields) > 1 {
+			params = fields[1]
+		}
+		c.extensions[strings.ToUpper(fields[0])] = params
+	}
+
+	return nil
+}
+
+// extension reports whether the server advertised ext in its EHLO reply, and its parameters.
+func (c *smtpClient) extension(ext string) (bool, string) {
+	params, ok := c.extensions[strings.ToUpper(ext)]
+	return ok, params
+}
+
+// startTLS upgrades the connection using STARTTLS, after which the server needs to be greeted again.
+func (c *smtpClient) startTLS(config *tls.Config) error {
+	if _, _, err := c.cmd(220, "STARTTLS"); err != nil {
+		return errors.Wrap(err, "could not STARTTLS")
+	}
+
+	return c.handshake(config)
+}
+
+// rewatch ties the connection to ctx instead of the context it was watching before.
+func (c *smtpClient) rewatch(ctx context.Context) {
+	c.stop()
+	c.stop = watchContext(ctx, c.conn)
+}
+
+// Close ends the SMTP session and releases the connection.
+func (c *smtpClient) Close() {
+	// don't wait long for a polite goodbye, the session may be broken already
+	_ = c.conn.SetDeadline(time.Now().Add(quitTimeout))
+	_, _, _ = c.cmd(221, "QUIT")
+	_ = c.conn.Close()
+	c.stop()
+}
+
+// watchContext closes conn as soon as ctx is done, so that blocking reads and writes on a
+// stalled server return. The returned function must be called once the connection is no longer used.
+func watchContext(ctx context.Context, conn net.Conn) (stop func()) {
+	// a zero deadline clears the one of a previous context
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	// the watcher may not get to run before stop is called and ctx is done both, stopping must win then
+	var once sync.Once
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			once.Do(func() { _ = conn.Close() })
+		case <-done:
+		}
+	}()
+
+	return func() {
+		once.Do(func() {})
+		close(done)
+	}
+}
+
+// dialPort connects to mx on port and greets it. Port 465 uses implicit TLS,
+// port 587 upgrades the connection with STARTTLS, anything else is plain SMTP
+// unless Options.InspectTLS upgrades it when the server offers STARTTLS.
+func (c *Checker) dialPort(ctx context.Context, mx string, port int, transcript *[]Exchange) (*smtpClient, error) {
+	// mail servers rarely present a certificate matching their MX name, we only care about the dialog
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec
+		ServerName:         mx,
+	}
+
+	identity, err := c.identities.pick()
+	if err != nil {
+		return nil, err
+	}
+
+	client := &smtpClient{mx: mx, port: port, transcript: transcript, created: time.Now(), identity: identity}
+
+	start := time.Now()
+	conn, err := c.dialerFor(identity).DialContext(ctx, "tcp", net.JoinHostPort(c.options.Hosts.resolveAddress(mx), strconv.Itoa(port)))
+	if err != nil {
+		client.record(Exchange{Time: start, Duration: time.Since(start), Error: err.Error()})
+		return nil, err
+	}
+
+	client.conn, client.text = conn, textproto.NewConn(conn)
+	client.stop = watchContext(ctx, conn)
+
+	err = func() error {
+		if port == smtpTLSPort {
+			if err := client.handshake(tlsConfig); err != nil {
+				return err
+			}
+		}
+
+		_, banner, err := client.cmd(220, "")
+		if err != nil {
+			return errors.Wrap(err, "unexpected greeting")
+		}
+		client.banner = banner
+
+		if err := client.hello(client.identity.HELO); err != nil {
+			return err
+		}
+
+		if port == smtpTLSPort {
+			return nil
+		}
+
+		if ok, _ := client.extension("STARTTLS"); !ok {
+			if port != smtpSubmissionPort {
+				return nil
+			}
+			return errors.New("server does not offer STARTTLS")
+		}
+
+		if port != smtpSubmissionPort && !c.options.InspectTLS {
+			return nil
+		}
+
+		if err := client.startTLS(tlsConfig); err != nil {
+			return err
+		}
+
+		return client.hello(client.identity.HELO)
+	}()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// dialMailServer connects to the first reachable mail server out of servers, those that have been failing last.
+// With Options.RaceMailServers the first servers are dialed at the same time, the first to answer wins.
+func (c *Checker) dialMailServer(ctx context.Context, servers []MailServer, transcript *[]Exchange) (client *smtpClient, err error) {
+	if c.options.Smarthost.Host != "" {
+		return c.dialSmarthost(ctx, transcript)
+	}
+
+	err = errors.New("no mail servers to try")
+	servers = c.health.order(servers)
+
+	if race := c.Limits().RaceMailServers; race > 1 && len(servers) > 1 {
+		if race > len(servers) {
+			race = len(servers)
+		}
+
+		if client, err = c.raceMailServers(ctx, servers[:race], transcript); err == nil || ctx.Err() != nil {
+			return client, err
+		}
+		servers = servers[race:]
+	}
+
+	// try to find a valid mx server to use
+	for _, mx := range servers {
+		if client, err = c.dialServer(ctx, mx, transcript); err == nil {
+			return client, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if errors.Is(err, errIdentitiesExhausted) {
+			return nil, err
+		}
+	}
+
+	// if no mx server was found, error out
+	return nil, errors.Wrap(err, "no working mail servers could be found")
+}
+
+// dialServer connects to mx, trying the configured ports in order when it does not have a fixed port.
+func (c *Checker) dialServer(ctx context.Context, mx MailServer, transcript *[]Exchange) (client *smtpClient, err error) {
+	ports := c.options.Ports
+	switch {
+	case mx.Port != 0:
+		ports = []int{mx.Port}
+	// the large providers only receive mail on port 25, don't waste time on the others
+	case identifyProvider(mx.Host, "") != "" && containsPort(ports, smtpPort):
+		ports = []int{smtpPort}
+	}
+
+	for _, port := range ports {
+		start := time.Now()
+		client, err = c.dialPort(ctx, mx.Host, port, transcript)
+		if err == nil {
+			c.health.observeConnect(mx.Host, true, time.Since(start))
+			return client, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// not the fault of the server
+		if errors.Is(err, errIdentitiesExhausted) {
+			return nil, err
+		}
+
+		log.Debugf("skipping %s:%d: %v", mx.Host, port, err)
+	}
+
+	c.health.observeConnect(mx.Host, false, 0)
+	return nil, err
+}
+
+// raceMailServers dials servers at the same time and returns the first connection made, closing the others.
+// The transcript holds the attempts that failed before the winner answered, followed by the winner's.
+func (c *Checker) raceMailServers(ctx context.Context, servers []MailServer, transcript *[]Exchange) (winner *smtpClient, err error) {
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type outcome struct {
+		client     *smtpClient
+		transcript []Exchange
+		err        error
+	}
+
+	outcomes := make(chan outcome, len(servers))
+	for _, mx := range servers {
+		go func(mx MailServer) {
+			// every racer records on its own, a transcript is not safe for concurrent use
+			var own *[]Exchange
+			if transcript != nil {
+				own = &[]Exchange{}
+			}
+
+			client, err := c.dialServer(raceCtx, mx, own)
+			result := outcome{client: client, err: err}
+			if own != nil {
+				result.transcript = *own
+			}
+			outcomes <- result
+		}(mx)
+	}
+
+	for range servers {
+		result := <-outcomes
+
+		switch {
+		case winner != nil:
+			if result.client != nil {
+				result.client.Close()
+			}
+		case result.err != nil:
+			err = result.err
+			if transcript != nil {
+				*transcript = append(*transcript, result.transcript...)
+			}
+		default:
+			winner = result.client
+			if transcript != nil {
+				*transcript = append(*transcript, result.transcript...)
+			}
+			winner.transcript = transcript
+
+			// the losers are stopped, the winner lives on
+			winner.rewatch(ctx)
+			cancel()
+		}
+	}
+
+	if winner == nil {
+		return nil, err
+	}
+
+	return winner, nil
+}
+
+// probeFunc runs the SMTP dialog of a probe for checkEmail over client, recording the outcome in res.
+type probeFunc func(ctx context.Context, client *smtpClient, res *Result, checkEmail string) error
+
+// checkMailbox probes checkEmail on one of servers, retrying each stage according to the retry policy.
+// The attempts made per stage and the mail server that answered are recorded in res.
+func (c *Checker) checkMailbox(ctx context.Context, res *Result, checkEmail string, servers []MailServer, probe probeFunc) (err error) {
+	var transcript *[]Exchange
+	switch {
+	case c.options.Transcript:
+		transcript = &res.Transcript
+	case c.options.Auditor != nil:
+		// the audit log needs the conversation even when the result does not
+		transcript = &[]Exchange{}
+	}
+
+	domain := strings.ToLower(checkEmail[strings.LastIndex(checkEmail, "@")+1:])
+
+	servers, budget := c.profileServers(domain, servers)
+
+	res.Attempts[StageSMTP], err = c.options.Retry.do(ctx, func() (err error) {
+		if err := c.limiter.wait(ctx, domain); err != nil {
+			return permanentError{err}
+		}
+
+		var client *smtpClient
+		if c.options.Auditor != nil {
+			start, mark := time.Now(), len(*transcript)
+			defer func() {
+				c.audit(start, checkEmail, client, (*transcript)[mark:], err)
+			}()
+		}
+
+		client = c.takeSession(ctx, servers, transcript)
+
+		if client == nil {
+			dialCtx, releaseDial := budget.context(ctx, res.Durations, StageConnect, StageTLS)
+			start := time.Now()
+			attempts, err := c.options.Retry.do(dialCtx, func() (err error) {
+				client, err = c.dialMailServer(dialCtx, servers, transcript)
+				return err
+			})
+			res.Attempts[StageConnect] += attempts
+
+			elapsed := time.Since(start)
+			if client != nil {
+				if client.handshakes > 0 {
+					res.spend(StageTLS, client.handshakes)
+					elapsed -= client.handshakes
+				}
+				// the session outlives dialing
+				client.rewatch(ctx)
+			}
+			res.spend(StageConnect, elapsed)
+
+			if releaseDial() && err != nil {
+				err = budgetError(StageConnect)
+			}
+
+			// connecting has been retried already
+			if err != nil {
+				return permanentError{err}
+			}
+		}
+
+		// another check may have used up the last probe of the identity of the session in the meantime
+		if !c.identities.use(client.identity) {
+			c.releaseSession(client, errIdentitiesExhausted)
+			return permanentError{errIdentitiesExhausted}
+		}
+
+		res.MX, res.Port = client.mx, client.port
+		if len(c.options.Identities) > 0 {
+			identity := client.identity
+			res.Identity = &identity
+		}
+		if c.options.Smarthost.Host == "" {
+			res.Provider = identifyProvider(client.mx, client.banner)
+			res.TLS = client.certificate
+		}
+
+		release, err := c.throttle.acquire(ctx, client.mx)
+		if err != nil {
+			c.releaseSession(client, err)
+			return permanentError{err}
+		}
+
+		smtpCtx, releaseSMTP := budget.context(ctx, res.Durations, StageSMTP)
+		client.rewatch(smtpCtx)
+
+		start := time.Now()
+		err = probe(smtpCtx, client, res, checkEmail)
+		res.spend(StageSMTP, time.Since(start))
+		if !errors.Is(err, context.Canceled) {
+			c.throttle.observe(client.mx, time.Since(start), probeCode(res, err))
+			c.health.observeProbe(client.mx, refusesProbes(err))
+		}
+		release()
+
+		c.releaseSession(client, err)
+
+		if releaseSMTP() && err != nil {
+			return permanentError{budgetError(StageSMTP)}
+		}
+
+		return err
+	})
+
+	return err
+}
+
+// probeMailbox runs the SMTP dialog that checks whether checkEmail is accepted. An accepting reply
+// is recorded in res, a rejecting one is returned as a *replyError.
+func (c *Checker) probeMailbox(ctx context.Context, client *smtpClient, res *Result, checkEmail string) (err error) {
+	// a cancelled context closes the connection, so report the cancellation rather than the i/o error
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	// RFC 6531: a UTF-8 local part may only be sent to servers announcing SMTPUTF8
+	mailParams := ""
+	if !isASCII(checkEmail) {
+		if ok, _ := client.extension("SMTPUTF8"); !ok {
+			return errSMTPUTF8Unsupported
+		}
+		mailParams = " SMTPUTF8"
+	}
+
+	mailFrom := fmt.Sprintf("%s:<%s>%s", cmdMailFrom, client.identity.MailFrom, mailParams)
+	rcptTo := fmt.Sprintf("%s:<%s>", cmdRcptTo, checkEmail)
+
+	// with PIPELINING both commands share a round trip, the server answers RCPT TO even when MAIL FROM failed
+	if ok, _ := client.extension("PIPELINING"); ok {
+		replies := client.pipeline([]string{mailFrom, rcptTo}, []int{25, 2})
+		if replies[0].err != nil {
+			return newReplyError(cmdMailFrom, replies[0].code, replies[0].msg, replies[0].err)
+		}
+
+		if replies[1].err != nil {
+			return newReplyError(cmdRcptTo, replies[1].code, replies[1].msg, replies[1].err)
+		}
+
+		res.setReply(replies[1].code, replies[1].msg)
+		return nil
+	}
+
+	if code, msg, err := client.cmd(25, "%s", mailFrom); err != nil {
+		return newReplyError(cmdMailFrom, code, msg, err)
+	}
+
+	code, msg, err := client.cmd(2, "%s", rcptTo)
+	if err != nil {
+		return newReplyError(cmdRcptTo, code, msg, err)
+	}
+
+	res.setReply(code, msg)
+	return nil
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
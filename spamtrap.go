@@ -0,0 +1,178 @@
+package mailcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// hibpURL is where the breaches of an account are listed, truncated to their names.
+	hibpURL = "https://haveibeenpwned.com/api/v3/breachedaccount/%s?truncateResponse=true"
+	// hibpUserAgent identifies us, Have I Been Pwned refuses requests without one.
+	hibpUserAgent = "mailcheck"
+)
+
+const (
+	// SignalTrapDomain means the domain is one of Options.TrapDomains.
+	SignalTrapDomain = "trap_domain"
+	// SignalNoWebPresence means the domain receives mail but has no website, as pristine spamtraps often do.
+	SignalNoWebPresence = "no_web_presence"
+	// SignalBreached means the address appears in data breaches, where recycled spamtraps end up.
+	SignalBreached = "breached"
+	// SignalYoungDomain means the domain was registered recently, looked up with Options.DomainAge.
+	SignalYoungDomain = "young_domain"
+)
+
+// SpamtrapRisk is how likely an address is to be a spamtrap, an address that only exists to catch senders of
+// unsolicited mail. Mailing one can get a sender blocklisted, so likely spamtraps are better quarantined.
+type SpamtrapRisk struct {
+	// Likely is set for trap domains, or when at least two signals add up.
+	Likely bool `json:"likely"`
+	// Signals are the findings the risk is based on, such as SignalNoWebPresence.
+	Signals []string `json:"signals,omitempty"`
+	// Breaches are the names of the data breaches the address appears in, looked up with Options.BreachLookup.
+	Breaches []string `json:"breaches,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// BreachLookup lists the data breaches an address appears in.
+type BreachLookup interface {
+	// Breaches returns the names of the breaches of email, an error means the lookup itself failed.
+	Breaches(ctx context.Context, email string) ([]string, error)
+}
+
+// HIBP looks up addresses on Have I Been Pwned, which takes a paid API key.
+type HIBP struct {
+	APIKey string
+	// Client does the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// Breaches implements BreachLookup.
+func (h HIBP) Breaches(ctx context.Context, email string) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(hibpURL, url.PathEscape(email)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("hibp-api-key", h.APIKey)
+	req.Header.Set("User-Agent", hibpUserAgent)
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not look up breaches")
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil, errors.Errorf("could not look up breaches: unexpected status %s", resp.Status)
+	}
+
+	var breaches []struct {
+		Name string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&breaches); err != nil {
+		return nil, errors.Wrap(err, "could not decode breaches")
+	}
+
+	names := make([]string, 0, len(breaches))
+	for _, breach := range breaches {
+		names = append(names, breach.Name)
+	}
+
+	return names, nil
+}
+
+// assessSpamtrap weighs the spamtrap signals of the address at domain.
+func (c *Checker) assessSpamtrap(ctx context.Context, res *Result, domain string) *SpamtrapRisk {
+	risk := &SpamtrapRisk{}
+
+	if matchesAny(c.options.TrapDomains, canonicalHost(domain)) {
+		risk.Signals = append(risk.Signals, SignalTrapDomain)
+		risk.Likely = true
+	}
+
+	// the websites of free providers live elsewhere
+	if res.Level.includes(LevelDNS) && !res.FreeProvider {
+		if present, err := c.hasWebPresence(ctx, domain); err != nil {
+			risk.Error = err.Error()
+		} else if !present {
+			risk.Signals = append(risk.Signals, SignalNoWebPresence)
+		}
+	}
+
+	if res.YoungDomain {
+		risk.Signals = append(risk.Signals, SignalYoungDomain)
+	}
+
+	if c.options.BreachLookup != nil {
+		breaches, err := c.options.BreachLookup.Breaches(ctx, res.Email)
+		switch {
+		case err != nil:
+			risk.Error = err.Error()
+		case len(breaches) > 0:
+			risk.Signals = append(risk.Signals, SignalBreached)
+			risk.Breaches = breaches
+		}
+	}
+
+	if len(risk.Signals) >= 2 {
+		risk.Likely = true
+	}
+
+	return risk
+}
+
+// hasWebPresence reports whether domain or its www subdomain resolves, in Options.Hosts or DNS, cached per domain for the lifetime of
+// the Checker.
+func (c *Checker) hasWebPresence(ctx context.Context, domain string) (bool, error) {
+	domain = canonicalHost(domain)
+
+	c.webPresenceMu.Lock()
+	present, ok := c.webPresence[domain]
+	c.webPresenceMu.Unlock()
+
+	if ok {
+		return present, nil
+	}
+
+	for _, host := range []string{domain, "www." + domain} {
+		if _, ok := c.options.Hosts.lookup(host); ok {
+			present = true
+			break
+		}
+
+		_, err := c.resolver.LookupHost(ctx, host)
+		if err == nil {
+			present = true
+			break
+		}
+
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return false, errors.Wrapf(err, "could not look up %s", host)
+		}
+	}
+
+	c.webPresenceMu.Lock()
+	c.webPresence[domain] = present
+	c.webPresenceMu.Unlock()
+
+	return present, nil
+}
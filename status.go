@@ -0,0 +1,197 @@
+package mailcheck
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ReasonSyntax means the address is malformed.
+	ReasonSyntax Reason = "syntax"
+	// ReasonDNSError means the mail servers of the domain could not be looked up.
+	ReasonDNSError Reason = "dns_error"
+	// ReasonDNSFailed means the mail servers of the domain could not be looked up when prefetched with
+	// Checker.PrefetchMailServers, so the address was not checked.
+	ReasonDNSFailed Reason = "dns_failed"
+	// ReasonDNSBogus means the mail servers of the domain failed DNSSEC validation, the lookup may have been spoofed.
+	ReasonDNSBogus Reason = "dns_bogus"
+	// ReasonPolicyAllowed means the domain is allowed by Options.Policy, so the address was not checked any further.
+	ReasonPolicyAllowed Reason = "policy_allowed"
+	// ReasonPolicyBlocked means the domain is blocked by Options.Policy, so the address was not checked any further.
+	ReasonPolicyBlocked Reason = "policy_blocked"
+	// ReasonProfile means the verdict is the one of the DomainProfile of the domain, so the address was not checked.
+	ReasonProfile Reason = "profile"
+	// ReasonDomainListed means the domain is on a domain blocklist, so the address was not probed.
+	ReasonDomainListed Reason = "domain_listed"
+	// ReasonNoMX means the domain has no mail servers.
+	ReasonNoMX Reason = "no_mx"
+	// ReasonUnreachable means none of the mail servers could be talked to.
+	ReasonUnreachable Reason = "unreachable"
+	// ReasonUserUnknown means the mailbox does not exist.
+	ReasonUserUnknown Reason = "user_unknown"
+	// ReasonBadDomain means the mail server does not accept mail for the domain.
+	ReasonBadDomain Reason = "bad_domain"
+	// ReasonMailboxDisabled means the mailbox exists but does not accept mail.
+	ReasonMailboxDisabled Reason = "mailbox_disabled"
+	// ReasonMailboxFull means the mailbox is over its quota.
+	ReasonMailboxFull Reason = "mailbox_full"
+	// ReasonRelayDenied means the mail server refused to relay, it is likely not responsible for the domain.
+	ReasonRelayDenied Reason = "relay_denied"
+	// ReasonAcceptedUnconfirmed means the mail server accepted the address, which the DomainProfile of the domain
+	// says proves nothing.
+	ReasonAcceptedUnconfirmed Reason = "accepted_unconfirmed"
+	// ReasonPolicy means the probe was rejected by a policy of the mail server.
+	ReasonPolicy Reason = "policy"
+	// ReasonSenderIssue means the probe was rejected because of who is probing,
+	// for instance a blocklisted IP or a rejected sender address.
+	ReasonSenderIssue Reason = "sender_issue"
+	// ReasonTemporary means the mail server asked to try again later, e.g. greylisting.
+	ReasonTemporary Reason = "temporary"
+	// ReasonSMTPUTF8Unsupported means the address has a non-ASCII local part while
+	// the mail server does not support SMTPUTF8, so it cannot be probed.
+	ReasonSMTPUTF8Unsupported Reason = "smtputf8_unsupported"
+	// ReasonCatchAll means the mail server accepts any address at the domain, so acceptance proves nothing.
+	ReasonCatchAll Reason = "catch_all"
+	// ReasonUnrecognized means the reply of the mail server could not be interpreted.
+	ReasonUnrecognized Reason = "unrecognized"
+)
+
+// enhancedStatusRegex matches an RFC 3463 enhanced status code at the start of a reply text.
+var enhancedStatusRegex = regexp.MustCompile(`^([245])\.(\d{1,3})\.(\d{1,3})(?:\s|$)`)
+
+// Reason explains a verdict.
+type Reason string
+
+// EnhancedStatus is an RFC 3463 enhanced status code, e.g. 5.1.1 for an unknown user.
+type EnhancedStatus struct {
+	Class   int
+	Subject int
+	Detail  int
+}
+
+func (s EnhancedStatus) String() string {
+	if s.Class == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%d.%d.%d", s.Class, s.Subject, s.Detail)
+}
+
+// ParseEnhancedStatus extracts the enhanced status code that servers supporting RFC 2034 put in front of
+// their reply text. It returns the status and the text following it.
+func ParseEnhancedStatus(text string) (status EnhancedStatus, rest string, ok bool) {
+	match := enhancedStatusRegex.FindStringSubmatch(text)
+	if match == nil {
+		return EnhancedStatus{}, text, false
+	}
+
+	status.Class, _ = strconv.Atoi(match[1])
+	status.Subject, _ = strconv.Atoi(match[2])
+	status.Detail, _ = strconv.Atoi(match[3])
+
+	return status, strings.TrimSpace(text[len(match[0]):]), true
+}
+
+// replyError is a negative reply of a mail server to a command.
+type replyError struct {
+	command string
+	code    int
+	text    string
+	err     error
+}
+
+func (e *replyError) Error() string {
+	return fmt.Sprintf("%s rejected: %d %s", e.command, e.code, strings.ReplaceAll(e.text, "\n", " "))
+}
+
+func (e *replyError) Unwrap() error {
+	return e.err
+}
+
+// newReplyError wraps the error of a command, which is a replyError when the server answered.
+func newReplyError(command string, code int, text string, err error) error {
+	if code == 0 {
+		return errors.Wrapf(err, "could not %s", command)
+	}
+
+	return &replyError{command: command, code: code, text: text, err: err}
+}
+
+// setReply records the reply that led to the verdict.
+func (r *Result) setReply(code int, text string) {
+	r.Code, r.Response = code, text
+
+	// a status of another class than the reply code is bogus
+	if status, _, ok := ParseEnhancedStatus(text); ok && status.Class == code/100 {
+		r.EnhancedCode = status.String()
+	}
+}
+
+// classifyRecipient maps the reply to RCPT TO onto a verdict.
+func classifyRecipient(code int, text string) (Verdict, Reason) {
+	if code/100 == 2 {
+		return VerdictValid, ""
+	}
+
+	var verdict Verdict
+	var reason Reason
+	status, _, ok := ParseEnhancedStatus(text)
+	if ok = ok && status.Class == code/100; ok {
+		verdict, reason, ok = classifyStatus(status, text)
+	}
+
+	// the text tells what policy rejections and temporary failures are about, and what the codes leave open
+	if !ok || reason == ReasonPolicy || reason == ReasonTemporary {
+		if verdict, reason, ok := classifyText(code, text); ok {
+			return verdict, reason
+		}
+	}
+
+	if ok {
+		return verdict, reason
+	}
+
+	switch {
+	case code == 452 || code == 552:
+		return VerdictUnknown, ReasonMailboxFull
+	case code/100 == 4:
+		return VerdictUnknown, ReasonTemporary
+	case code == 554:
+		return VerdictUnknown, ReasonSenderIssue
+	case code == 550 || code == 551 || code == 553:
+		return VerdictInvalid, ReasonUserUnknown
+	}
+
+	return VerdictUnknown, ReasonUnrecognized
+}
+
+// classifyStatus maps an enhanced status code onto a verdict, ok is false for codes that say too little.
+func classifyStatus(status EnhancedStatus, text string) (verdict Verdict, reason Reason, ok bool) {
+	switch {
+	// mailbox full is temporary or permanent depending on the server, the mailbox exists either way
+	case status.Subject == 2 && status.Detail == 2:
+		return VerdictUnknown, ReasonMailboxFull, true
+	case status.Class == 4:
+		return VerdictUnknown, ReasonTemporary, true
+	case status.Subject == 1 && (status.Detail == 1 || status.Detail == 6 || status.Detail == 0):
+		return VerdictInvalid, ReasonUserUnknown, true
+	case status.Subject == 1 && (status.Detail == 2 || status.Detail == 10):
+		return VerdictInvalid, ReasonBadDomain, true
+	case status.Subject == 1 && status.Detail == 3:
+		return VerdictInvalid, ReasonSyntax, true
+	case status.Subject == 2 && status.Detail == 1:
+		return VerdictInvalid, ReasonMailboxDisabled, true
+	case strings.Contains(strings.ToLower(text), "relay"):
+		return VerdictUnknown, ReasonRelayDenied, true
+	// authentication and reverse DNS failures of our side
+	case status.Subject == 7 && status.Detail >= 23 && status.Detail <= 27:
+		return VerdictUnknown, ReasonSenderIssue, true
+	case status.Subject == 7:
+		return VerdictUnknown, ReasonPolicy, true
+	}
+
+	return "", "", false
+}
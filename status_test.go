@@ -0,0 +1,81 @@
+package mailcheck
+
+import "testing"
+
+func TestParseEnhancedStatus(t *testing.T) {
+	for _, test := range []struct {
+		text   string
+		status string
+		rest   string
+	}{
+		{"5.1.1 user unknown", "5.1.1", "user unknown"},
+		{"4.7.25 Client host rejected", "4.7.25", "Client host rejected"},
+		{"2.1.5", "2.1.5", ""},
+		{"5.1.1user unknown", "", "5.1.1user unknown"},
+		{"3.1.1 not a class", "", "3.1.1 not a class"},
+		{"user unknown", "", "user unknown"},
+	} {
+		status, rest, ok := ParseEnhancedStatus(test.text)
+		if status.String() != test.status || rest != test.rest || ok != (test.status != "") {
+			t.Errorf("%q: expected %q and %q, got %q and %q", test.text, test.status, test.rest, status, rest)
+		}
+	}
+}
+
+func TestSetReplyIgnoresStatusOfAnotherClass(t *testing.T) {
+	var res, bogus Result
+
+	res.setReply(550, "5.1.1 user unknown")
+	if res.EnhancedCode != "5.1.1" {
+		t.Errorf("expected the enhanced status, got %q", res.EnhancedCode)
+	}
+
+	bogus.setReply(550, "4.1.1 user unknown")
+	if bogus.EnhancedCode != "" {
+		t.Errorf("expected a 4.x.x status on a 550 reply to be dropped, got %q", bogus.EnhancedCode)
+	}
+}
+
+func TestClassifyRecipient(t *testing.T) {
+	for _, test := range []struct {
+		code    int
+		text    string
+		verdict Verdict
+		reason  Reason
+	}{
+		{250, "2.1.5 OK", VerdictValid, ""},
+
+		// the enhanced status decides when it is specific
+		{550, "5.1.1 The email account that you tried to reach does not exist", VerdictInvalid, ReasonUserUnknown},
+		{550, "5.1.2 Bad destination system address", VerdictInvalid, ReasonBadDomain},
+		{553, "5.1.3 Bad recipient address syntax", VerdictInvalid, ReasonSyntax},
+		{550, "5.2.1 The email account that you tried to reach is disabled", VerdictInvalid, ReasonMailboxDisabled},
+		{552, "5.2.2 The email account that you tried to reach is over quota", VerdictUnknown, ReasonMailboxFull},
+		{452, "4.2.2 The email account that you tried to reach is over quota", VerdictUnknown, ReasonMailboxFull},
+		{550, "5.7.1 Relaying denied", VerdictUnknown, ReasonRelayDenied},
+		{550, "5.7.25 The IP address sending this message does not have a PTR record setup", VerdictUnknown, ReasonSenderIssue},
+		{550, "5.7.1 Recipient address rejected: Access denied", VerdictUnknown, ReasonPolicy},
+
+		// the text tells what policy rejections and temporary failures are about
+		{554, "5.7.1 Service unavailable; Client host [192.0.2.1] blocked using zen.spamhaus.org", VerdictUnknown, ReasonSenderIssue},
+		{450, "4.7.1 Recipient address rejected: greylisted, try again later", VerdictUnknown, ReasonTemporary},
+		{450, "4.1.1 user unknown", VerdictUnknown, ReasonTemporary},
+
+		// a status of another class than the code is ignored, the text and code decide
+		{550, "4.1.1 user unknown", VerdictInvalid, ReasonUserUnknown},
+		{550, "4.4.1 requested action not taken", VerdictInvalid, ReasonUserUnknown},
+
+		// without a status the text decides, and else the code
+		{550, "Benutzer unbekannt", VerdictInvalid, ReasonUserUnknown},
+		{550, "Postfach voll", VerdictUnknown, ReasonMailboxFull},
+		{550, "Requested action not taken", VerdictInvalid, ReasonUserUnknown},
+		{554, "Transaction failed", VerdictUnknown, ReasonSenderIssue},
+		{421, "Service not available", VerdictUnknown, ReasonTemporary},
+		{500, "Syntax error, command unrecognized", VerdictUnknown, ReasonUnrecognized},
+	} {
+		verdict, reason := classifyRecipient(test.code, test.text)
+		if verdict != test.verdict || reason != test.reason {
+			t.Errorf("%d %s: expected %s:%s, got %s:%s", test.code, test.text, test.verdict, test.reason, verdict, reason)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package mailcheck
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Store persists verifications, so they can be looked up later and shared between instances.
+type Store interface {
+	// Save records a verification.
+	Save(ctx context.Context, record Record) error
+	// Lookup returns the verifications matching query, latest first.
+	Lookup(ctx context.Context, query StoreQuery) ([]Record, error)
+	// Purge deletes the verifications from before a time and returns how many were deleted.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+	// Stats counts the verifications kept.
+	Stats(ctx context.Context) (StoreStats, error)
+	Close() error
+}
+
+// Record is a single verification as kept in a Store.
+type Record struct {
+	Email        string    `json:"email"`
+	Domain       string    `json:"domain"`
+	Verdict      Verdict   `json:"verdict"`
+	Reason       Reason    `json:"reason,omitempty"`
+	Code         int       `json:"code,omitempty"`
+	EnhancedCode string    `json:"enhanced_code,omitempty"`
+	MX           string    `json:"mx,omitempty"`
+	Port         int       `json:"port,omitempty"`
+	Score        int       `json:"score"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// NewRecord returns the record of res, checked now. Addresses are kept in lower case to be able to look them up.
+func NewRecord(res Result) Record {
+	_, domain, _ := ParseAddress(res.Email)
+
+	return Record{
+		Email:        strings.ToLower(res.Email),
+		Domain:       domain,
+		Verdict:      res.Verdict,
+		Reason:       res.Reason,
+		Code:         res.Code,
+		EnhancedCode: res.EnhancedCode,
+		MX:           res.MX,
+		Port:         res.Port,
+		Score:        res.Score,
+		CheckedAt:    time.Now().UTC(),
+	}
+}
+
+// Result returns the result the record was made of, as far as it is kept, with CheckedAt set.
+func (r Record) Result() Result {
+	checkedAt := r.CheckedAt
+	return Result{
+		Email:        r.Email,
+		Verdict:      r.Verdict,
+		Reason:       r.Reason,
+		Code:         r.Code,
+		EnhancedCode: r.EnhancedCode,
+		MX:           r.MX,
+		Port:         r.Port,
+		Score:        r.Score,
+		CheckedAt:    &checkedAt,
+	}
+}
+
+// StoreStats describes the verifications kept in a Store.
+type StoreStats struct {
+	Verifications int64             `json:"verifications"`
+	Verdicts      map[Verdict]int64 `json:"verdicts"`
+	// Oldest and Newest are when the first and last verifications kept were made, zero without any.
+	Oldest time.Time `json:"oldest,omitempty"`
+	Newest time.Time `json:"newest,omitempty"`
+}
+
+// StoreQuery selects verifications in a Store, zero fields select everything.
+type StoreQuery struct {
+	Email    string
+	Domain   string
+	Verdicts []Verdict
+	Since    time.Time
+	Limit    int
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS verifications (
+	id            BIGSERIAL PRIMARY KEY,
+	email         TEXT NOT NULL,
+	domain        TEXT NOT NULL,
+	verdict       TEXT NOT NULL,
+	reason        TEXT NOT NULL,
+	code          INTEGER NOT NULL,
+	enhanced_code TEXT NOT NULL,
+	mx            TEXT NOT NULL,
+	port          INTEGER NOT NULL,
+	score         INTEGER NOT NULL,
+	checked_at    TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS verifications_email ON verifications (email, checked_at);
+CREATE INDEX IF NOT EXISTS verifications_domain ON verifications (domain, checked_at);
+`
+
+func openPostgres(dsn string) (*sqlStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open postgres database")
+	}
+
+	// instances sharing the database may all start at once, creating the schema is idempotent
+	if _, err := db.Exec(postgresSchema); err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "could not create postgres tables")
+	}
+
+	return &sqlStore{
+		db:          db,
+		placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+		timeValue: func(t time.Time) interface{} {
+			return t.UTC()
+		},
+	}, nil
+}
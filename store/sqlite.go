@@ -0,0 +1,53 @@
+package store
+
+import (
+	"database/sql"
+	"github.com/pkg/errors"
+	"time"
+	// a pure go driver, the released binaries are built without cgo
+	_ "modernc.org/sqlite"
+)
+
+// sqliteTime is the format SQLite's own date functions use, so the verifications can be queried with them.
+const sqliteTime = "2006-01-02 15:04:05"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS verifications (
+	id            INTEGER PRIMARY KEY,
+	email         TEXT NOT NULL,
+	domain        TEXT NOT NULL,
+	verdict       TEXT NOT NULL,
+	reason        TEXT NOT NULL,
+	code          INTEGER NOT NULL,
+	enhanced_code TEXT NOT NULL,
+	mx            TEXT NOT NULL,
+	port          INTEGER NOT NULL,
+	score         INTEGER NOT NULL,
+	checked_at    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS verifications_email ON verifications (email, checked_at);
+CREATE INDEX IF NOT EXISTS verifications_domain ON verifications (domain, checked_at);
+`
+
+func openSQLite(path string) (*sqlStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open sqlite database")
+	}
+
+	// SQLite allows a single writer, concurrent checks take turns
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "could not create sqlite tables")
+	}
+
+	return &sqlStore{
+		db:          db,
+		placeholder: func(int) string { return "?" },
+		timeValue: func(t time.Time) interface{} {
+			return t.UTC().Format(sqliteTime)
+		},
+	}, nil
+}
@@ -0,0 +1,202 @@
+// Package store keeps verifications in SQLite or PostgreSQL, implementing mailcheck.Store.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"github.com/hazcod/mailcheck"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Open opens the store a DSN points to: a postgres:// or postgresql:// url for PostgreSQL,
+// a sqlite:// url or a plain path for a SQLite file, which is created when needed.
+// Urls of any other scheme are an error.
+func Open(dsn string) (mailcheck.Store, error) {
+	if isPostgres(dsn) {
+		return openPostgres(dsn)
+	}
+
+	path, ok := SQLitePath(dsn)
+	if !ok {
+		// the rest of the dsn may hold a password
+		return nil, errors.Errorf("unsupported database '%s://', use a postgres:// or sqlite:// url or a file path",
+			dsn[:strings.Index(dsn, "://")])
+	}
+
+	return openSQLite(path)
+}
+
+// SQLitePath returns the file of a SQLite DSN, false when the DSN is not one.
+func SQLitePath(dsn string) (string, bool) {
+	if strings.HasPrefix(dsn, "sqlite://") {
+		return strings.TrimPrefix(dsn, "sqlite://"), true
+	}
+
+	// any other url is meant for another database
+	if isPostgres(dsn) || strings.Contains(dsn, "://") {
+		return "", false
+	}
+
+	return dsn, true
+}
+
+func isPostgres(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// sqlStore implements mailcheck.Store on top of database/sql, the dialects only differ in placeholders and schema.
+type sqlStore struct {
+	db *sql.DB
+	// placeholder returns the placeholder of the n-th argument, starting at 1
+	placeholder func(n int) string
+	// timeValue converts a time to how the database stores it
+	timeValue func(t time.Time) interface{}
+}
+
+func (s *sqlStore) Save(ctx context.Context, record mailcheck.Record) error {
+	var placeholders []string
+	for i := 1; i <= 10; i++ {
+		placeholders = append(placeholders, s.placeholder(i))
+	}
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO verifications
+		(email, domain, verdict, reason, code, enhanced_code, mx, port, score, checked_at)
+		VALUES (`+strings.Join(placeholders, ", ")+`)`,
+		record.Email, record.Domain, string(record.Verdict), string(record.Reason), record.Code, record.EnhancedCode,
+		record.MX, record.Port, record.Score, s.timeValue(record.CheckedAt))
+
+	return errors.Wrap(err, "could not save verification")
+}
+
+func (s *sqlStore) Lookup(ctx context.Context, query mailcheck.StoreQuery) (records []mailcheck.Record, err error) {
+	var where []string
+	var args []interface{}
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return s.placeholder(len(args))
+	}
+
+	if query.Email != "" {
+		where = append(where, "email = "+arg(strings.ToLower(query.Email)))
+	}
+
+	if query.Domain != "" {
+		where = append(where, "domain = "+arg(strings.ToLower(query.Domain)))
+	}
+
+	if len(query.Verdicts) > 0 {
+		var in []string
+		for _, verdict := range query.Verdicts {
+			in = append(in, arg(string(verdict)))
+		}
+		where = append(where, "verdict IN ("+strings.Join(in, ", ")+")")
+	}
+
+	if !query.Since.IsZero() {
+		where = append(where, "checked_at >= "+arg(s.timeValue(query.Since)))
+	}
+
+	statement := "SELECT email, domain, verdict, reason, code, enhanced_code, mx, port, score, checked_at FROM verifications"
+	if len(where) > 0 {
+		statement += " WHERE " + strings.Join(where, " AND ")
+	}
+	statement += " ORDER BY checked_at DESC, id DESC"
+	if query.Limit > 0 {
+		statement += " LIMIT " + strconv.Itoa(query.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not look up verifications")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var record mailcheck.Record
+		var checkedAt timeScanner
+
+		if err := rows.Scan(&record.Email, &record.Domain, &record.Verdict, &record.Reason, &record.Code,
+			&record.EnhancedCode, &record.MX, &record.Port, &record.Score, &checkedAt); err != nil {
+			return nil, errors.Wrap(err, "could not read verification")
+		}
+
+		record.CheckedAt = time.Time(checkedAt)
+		records = append(records, record)
+	}
+
+	return records, errors.Wrap(rows.Err(), "could not read verifications")
+}
+
+func (s *sqlStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM verifications WHERE checked_at < "+s.placeholder(1), s.timeValue(before))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not purge verifications")
+	}
+
+	return result.RowsAffected()
+}
+
+func (s *sqlStore) Stats(ctx context.Context) (stats mailcheck.StoreStats, err error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT verdict, COUNT(*), MIN(checked_at), MAX(checked_at) FROM verifications GROUP BY verdict")
+	if err != nil {
+		return stats, errors.Wrap(err, "could not count verifications")
+	}
+	defer rows.Close()
+
+	stats.Verdicts = map[mailcheck.Verdict]int64{}
+	for rows.Next() {
+		var verdict mailcheck.Verdict
+		var count int64
+		var oldest, newest timeScanner
+
+		if err := rows.Scan(&verdict, &count, &oldest, &newest); err != nil {
+			return stats, errors.Wrap(err, "could not read verification count")
+		}
+
+		stats.Verifications += count
+		stats.Verdicts[verdict] = count
+		if stats.Oldest.IsZero() || time.Time(oldest).Before(stats.Oldest) {
+			stats.Oldest = time.Time(oldest)
+		}
+		if time.Time(newest).After(stats.Newest) {
+			stats.Newest = time.Time(newest)
+		}
+	}
+
+	return stats, errors.Wrap(rows.Err(), "could not read verification counts")
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// timeScanner reads a time whether the driver returns it as a time or as text.
+type timeScanner time.Time
+
+func (t *timeScanner) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case time.Time:
+		*t = timeScanner(v.UTC())
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	}
+
+	return errors.Errorf("cannot read %T as a time", value)
+}
+
+func (t *timeScanner) parse(value string) error {
+	parsed, err := time.Parse(sqliteTime, value)
+	if err != nil {
+		return errors.Wrap(err, "invalid time")
+	}
+
+	*t = timeScanner(parsed)
+	return nil
+}
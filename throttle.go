@@ -0,0 +1,159 @@
+package mailcheck
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	// tarpitLatency is how slow a reply has to be to count as tarpitting
+	tarpitLatency = time.Second * 10
+	// throttleWindow is the number of recent probes of a mail server judged, of which
+	// throttleTemporary temporary failures mean it is throttling us
+	throttleWindow    = 10
+	throttleTemporary = 3
+
+	throttleDelay    = time.Second * 5
+	maxThrottleDelay = time.Minute * 2
+)
+
+// hostThrottle notices mail servers that tarpit or throttle probes and backs off from them for the rest
+// of the life of the Checker: probes of a throttling server are sent one at a time, spaced by a delay that
+// doubles every time it throttles again.
+type hostThrottle struct {
+	mu    sync.Mutex
+	hosts map[string]*throttleState
+}
+
+type throttleState struct {
+	// outcomes holds whether each of the recent probes failed temporarily
+	outcomes []bool
+	// delay is zero as long as the server is not throttling
+	delay time.Duration
+	// turn is held by the probe in flight of a throttling server
+	turn chan struct{}
+	next time.Time
+}
+
+func newHostThrottle() *hostThrottle {
+	return &hostThrottle{hosts: map[string]*throttleState{}}
+}
+
+func (t *hostThrottle) state(host string) *throttleState {
+	host = canonicalHost(host)
+
+	state, ok := t.hosts[host]
+	if !ok {
+		state = &throttleState{turn: make(chan struct{}, 1)}
+		t.hosts[host] = state
+	}
+
+	return state
+}
+
+// acquire waits until a probe of host is allowed. The returned function must be called once the probe is done.
+func (t *hostThrottle) acquire(ctx context.Context, host string) (release func(), err error) {
+	t.mu.Lock()
+	state := t.state(host)
+	throttled := state.delay > 0
+	t.mu.Unlock()
+
+	if !throttled {
+		return func() {}, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case state.turn <- struct{}{}:
+	}
+
+	release = func() { <-state.turn }
+
+	t.mu.Lock()
+	wait := time.Until(state.next)
+	t.mu.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return release, nil
+}
+
+// probeCode returns the reply code a probe ended with, zero when the server did not answer.
+func probeCode(res *Result, err error) int {
+	var reply *replyError
+	switch {
+	case err == nil:
+		return res.Code
+	case errors.As(err, &reply):
+		return reply.code
+	}
+
+	return 0
+}
+
+// observe records how long host took to answer a probe and with which reply code, and starts or increases
+// the back-off when it looks like host is tarpitting or throttling.
+func (t *hostThrottle) observe(host string, latency time.Duration, code int) {
+	// 452 is a full mailbox, a property of the recipient rather than of the server
+	temporary := code == 421 || code == 450 || code == 451
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state := t.state(host)
+	state.outcomes = append(state.outcomes, temporary)
+	if len(state.outcomes) > throttleWindow {
+		state.outcomes = state.outcomes[1:]
+	}
+
+	failures := 0
+	for _, failed := range state.outcomes {
+		if failed {
+			failures++
+		}
+	}
+
+	reason := ""
+	switch {
+	case latency >= tarpitLatency:
+		reason = fmt.Sprintf("took %s to answer", latency.Round(time.Second))
+	case temporary && failures >= throttleTemporary:
+		reason = fmt.Sprintf("failed temporarily on %d of the last %d probes", failures, len(state.outcomes))
+	default:
+		if state.delay > 0 {
+			state.next = time.Now().Add(state.delay)
+		}
+		return
+	}
+
+	switch {
+	case state.delay == 0:
+		state.delay = throttleDelay
+	case state.delay < maxThrottleDelay:
+		state.delay *= 2
+		if state.delay > maxThrottleDelay {
+			state.delay = maxThrottleDelay
+		}
+	}
+
+	// judge the server afresh at the new pace
+	state.outcomes = nil
+	state.next = time.Now().Add(state.delay)
+
+	log.Warnf("%s is throttling probes, it %s: slowing down to one probe at a time, %s apart", host, reason, state.delay)
+}
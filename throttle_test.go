@@ -0,0 +1,105 @@
+package mailcheck
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestThrottleBacksOffFromTarpits(t *testing.T) {
+	throttle := newHostThrottle()
+
+	throttle.observe("mx.example.com", time.Second, 250)
+	if delay := throttle.state("mx.example.com").delay; delay != 0 {
+		t.Fatalf("expected a quick answer not to slow down, got a delay of %s", delay)
+	}
+
+	// every slow answer doubles the delay, up to the maximum
+	for _, expected := range []time.Duration{throttleDelay, throttleDelay * 2, throttleDelay * 4} {
+		throttle.observe("MX.example.com.", tarpitLatency, 250)
+		if delay := throttle.state("mx.example.com").delay; delay != expected {
+			t.Errorf("expected a delay of %s, got %s", expected, delay)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		throttle.observe("mx.example.com", tarpitLatency, 250)
+	}
+	if delay := throttle.state("mx.example.com").delay; delay != maxThrottleDelay {
+		t.Errorf("expected the delay to stop at %s, got %s", maxThrottleDelay, delay)
+	}
+
+	if delay := throttle.state("mx.example.org").delay; delay != 0 {
+		t.Errorf("expected other mail servers to be left alone, got a delay of %s", delay)
+	}
+}
+
+func TestThrottleCountsTemporaryFailures(t *testing.T) {
+	throttle := newHostThrottle()
+
+	// full mailboxes say nothing about the server
+	for i := 0; i < throttleWindow; i++ {
+		throttle.observe("mx.example.com", time.Second, 452)
+	}
+	if delay := throttle.state("mx.example.com").delay; delay != 0 {
+		t.Fatalf("expected 452 not to count as throttling, got a delay of %s", delay)
+	}
+
+	for i := 1; i <= throttleTemporary; i++ {
+		throttle.observe("mx.example.com", time.Second, 451)
+
+		delay := throttle.state("mx.example.com").delay
+		if i < throttleTemporary && delay != 0 {
+			t.Errorf("expected %d temporary failures not to slow down yet, got a delay of %s", i, delay)
+		}
+		if i == throttleTemporary && delay != throttleDelay {
+			t.Errorf("expected %d temporary failures to slow down to %s, got %s", i, throttleDelay, delay)
+		}
+	}
+}
+
+func TestThrottleSendsOneProbeAtATime(t *testing.T) {
+	throttle := newHostThrottle()
+	throttle.observe("mx.example.com", tarpitLatency, 250)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// the first probe waits out the delay, which is shortened here
+	throttle.mu.Lock()
+	throttle.state("mx.example.com").next = time.Now().Add(time.Millisecond * 50)
+	throttle.mu.Unlock()
+
+	started := time.Now()
+	release, err := throttle.acquire(ctx, "mx.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(started); waited < time.Millisecond*40 {
+		t.Errorf("expected the probe to wait for its turn, it waited %s", waited)
+	}
+
+	// a second probe has to wait until the first is done
+	blocked, stop := context.WithTimeout(ctx, time.Millisecond*50)
+	defer stop()
+	if _, err := throttle.acquire(blocked, "mx.example.com"); err == nil {
+		t.Error("expected a second probe to wait while the first is in flight")
+	}
+
+	release()
+	throttle.mu.Lock()
+	throttle.state("mx.example.com").next = time.Now()
+	throttle.mu.Unlock()
+
+	release, err = throttle.acquire(ctx, "mx.example.com")
+	if err != nil {
+		t.Fatalf("expected the next probe to go once the first is done: %v", err)
+	}
+	release()
+
+	// servers that do not throttle are probed right away
+	release, err = throttle.acquire(ctx, "mx.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
@@ -0,0 +1,71 @@
+package mailcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"time"
+)
+
+// TLSCertificate describes the certificate a mail server presented and the TLS connection it secured.
+type TLSCertificate struct {
+	// MX is the mail server presenting it, only set in domain results.
+	MX          string `json:"mx,omitempty"`
+	Version     string `json:"version,omitempty"`
+	CipherSuite string `json:"cipher_suite,omitempty"`
+	Subject     string `json:"subject,omitempty"`
+	Issuer      string `json:"issuer,omitempty"`
+	// DNSNames are the subject alternative names.
+	DNSNames []string  `json:"dns_names,omitempty"`
+	NotAfter time.Time `json:"not_after"`
+	// Expired and SelfSigned are warning signs, of a neglected server or one not meant for the public.
+	Expired    bool   `json:"expired,omitempty"`
+	SelfSigned bool   `json:"self_signed,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// inspectCertificate describes the certificate presented on a connection in state, nil when there is none.
+func inspectCertificate(state tls.ConnectionState) *TLSCertificate {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+
+	cert := state.PeerCertificates[0]
+
+	return &TLSCertificate{
+		Version:     tlsVersions[state.Version],
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		DNSNames:    cert.DNSNames,
+		NotAfter:    cert.NotAfter,
+		Expired:     time.Now().After(cert.NotAfter),
+		// snakeoil certificates are often not marked as a CA, so only the signature is checked
+		SelfSigned: bytes.Equal(cert.RawSubject, cert.RawIssuer) &&
+			cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil,
+	}
+}
+
+// InspectTLS connects to server, upgrading the connection with STARTTLS unless its port uses implicit TLS,
+// and describes the certificate it presents. Servers without a fixed port are checked on port 25.
+func (c *Checker) InspectTLS(ctx context.Context, server MailServer) (res TLSCertificate) {
+	res.MX = server.String()
+
+	port := server.Port
+	if port == 0 {
+		port = smtpPort
+	}
+
+	state, err := c.peerTLS(ctx, server.Host, port)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	if cert := inspectCertificate(state); cert != nil {
+		res = *cert
+		res.MX = server.String()
+	}
+
+	return res
+}
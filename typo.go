@@ -0,0 +1,81 @@
+package mailcheck
+
+import (
+	"strings"
+)
+
+// maxTypoDistance is the largest edit distance still considered a typo.
+const maxTypoDistance = 2
+
+// popularDomains are the domains of popular mail providers typos are matched against, most popular first.
+var popularDomains = []string{
+	"gmail.com", "yahoo.com", "hotmail.com", "outlook.com", "icloud.com", "aol.com", "live.com",
+	"msn.com", "googlemail.com", "me.com", "mac.com", "protonmail.com", "proton.me", "zoho.com",
+	"yandex.ru", "mail.ru", "gmx.com", "gmx.de", "gmx.net", "web.de", "t-online.de",
+	"hotmail.co.uk", "yahoo.co.uk", "btinternet.com", "orange.fr", "free.fr", "libero.it",
+	"comcast.net", "verizon.net", "att.net", "sbcglobal.net", "telenet.be", "skynet.be",
+}
+
+// SuggestDomain returns the popular mail provider domain closest to domain, if it looks like a typo of one.
+func SuggestDomain(domain string) (suggestion string, ok bool) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	best := maxTypoDistance + 1
+
+	for _, candidate := range popularDomains {
+		if candidate == domain {
+			return "", false
+		}
+
+		if distance := editDistance(domain, candidate); distance < best {
+			suggestion, best = candidate, distance
+		}
+	}
+
+	return suggestion, suggestion != ""
+}
+
+// editDistance is the optimal string alignment distance between a and b: the Levenshtein distance
+// where swapping two adjacent characters, a very common typo, counts as a single edit.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	// three rolling rows suffice to look back two characters for transpositions
+	prevPrev := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = minInt(cur[j], prevPrev[j-2]+1)
+			}
+		}
+
+		prevPrev, prev, cur = prev, cur, prevPrev
+	}
+
+	return prev[len(rb)]
+}
+
+func minInt(values ...int) int {
+	smallest := values[0]
+	for _, v := range values[1:] {
+		if v < smallest {
+			smallest = v
+		}
+	}
+	return smallest
+}
@@ -0,0 +1,87 @@
+package mailcheck
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ambiguousReasons are the unknown verdicts after RCPT TO that VRFY or EXPN may settle.
+var ambiguousReasons = map[Reason]bool{
+	ReasonTemporary:    true,
+	ReasonPolicy:       true,
+	ReasonUnrecognized: true,
+	ReasonCatchAll:     true,
+	// the profile of the domain distrusts its replies to RCPT TO, not necessarily to VRFY
+	ReasonAcceptedUnconfirmed: true,
+}
+
+// VerifyByCommand asks one of servers about recipient with VRFY, or with EXPN in case it is a mailing list, as far as
+// the server advertises those commands. A conclusive answer replaces the verdict in res, anything else leaves it as is.
+func (c *Checker) VerifyByCommand(ctx context.Context, res *Result, recipient string, servers []MailServer) {
+	// a smarthost would answer about its own users
+	if c.options.Smarthost.Host != "" {
+		return
+	}
+
+	// asking again is part of the time budget of the address
+	probe := Result{Attempts: map[string]int{}, Durations: res.Durations}
+	err := c.checkMailbox(ctx, &probe, recipient, servers, c.probeVerifyCommands)
+	res.Transcript = append(res.Transcript, probe.Transcript...)
+	res.Durations = probe.Durations
+
+	if err != nil {
+		log.Debugf("could not ask about %s with VRFY or EXPN: %v", recipient, err)
+		return
+	}
+
+	if probe.VerifiedBy == "" {
+		return
+	}
+
+	res.Verdict, res.Reason = classifyRecipient(probe.Code, probe.Response)
+	res.Code, res.EnhancedCode, res.Response = probe.Code, probe.EnhancedCode, probe.Response
+	res.MX, res.Port, res.VerifiedBy = probe.MX, probe.Port, probe.VerifiedBy
+
+	res.Err, res.Error = nil, ""
+	if res.Verdict == VerdictInvalid {
+		res.fail(res.Verdict, res.Reason, &replyError{command: probe.VerifiedBy, code: probe.Code, text: probe.Response})
+	}
+}
+
+// probeVerifyCommands asks about checkEmail with VRFY and then EXPN, skipping those the server does not advertise.
+// The first reply that tells whether the address exists is recorded in res along with its command.
+func (c *Checker) probeVerifyCommands(ctx context.Context, client *smtpClient, res *Result, checkEmail string) (err error) {
+	// a cancelled context closes the connection, so report the cancellation rather than the i/o error
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	for _, command := range []string{cmdVrfy, cmdExpn} {
+		if ok, _ := client.extension(command); !ok {
+			continue
+		}
+
+		code, msg, err := client.cmd(2, "%s %s", command, checkEmail)
+		if code == 0 {
+			return errors.Wrapf(err, "could not %s", command)
+		}
+
+		// RFC 5321 3.5.3: 250 and 251 confirm the address, 550, 551 and 553 deny it, 252 and others say nothing.
+		// EXPN only expands lists, so its denial may just mean the address is a plain mailbox.
+		conclusive := code == 250 || code == 251
+		if command == cmdVrfy {
+			conclusive = conclusive || code == 550 || code == 551 || code == 553
+		}
+
+		if conclusive {
+			res.setReply(code, msg)
+			res.VerifiedBy = command
+			return nil
+		}
+	}
+
+	return nil
+}